@@ -192,3 +192,16 @@ func GetAuthMiddleware(signingKey []byte) *jwtmiddleware.JWTMiddleware {
 		SigningMethod: jwt.SigningMethodHS256,
 	})
 }
+
+// GetOptionalAuthMiddleware is like GetAuthMiddleware, but lets requests
+// without a token through so handlers can accept other forms of authorization.
+// Requests with an invalid token are still rejected.
+func GetOptionalAuthMiddleware(signingKey []byte) *jwtmiddleware.JWTMiddleware {
+	return jwtmiddleware.New(jwtmiddleware.Options{
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return signingKey, nil
+		},
+		SigningMethod:       jwt.SigningMethodHS256,
+		CredentialsOptional: true,
+	})
+}
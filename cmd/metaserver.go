@@ -13,7 +13,7 @@ import (
 var metaServerCmd = Cmd{
 	Name:        "metaserver",
 	Description: "Start a metadata server",
-	Usage:       "metaserver [-addr] [-dash]",
+	Usage:       "metaserver [-addr] [-dash] [-db mongo|embedded] [-db-addr] [-db-path] [-allow-private-providers] [-registration-limit]",
 	Run:         runMetaServer,
 }
 
@@ -22,6 +22,13 @@ func runMetaServer(args ...string) {
 	addrFlag := fs.String("addr", "", "address to run on (host:port)")
 	var showDash bool
 	fs.BoolVar(&showDash, "dash", false, "whether or not to activate the dashboard endpoint")
+	dbFlag := fs.String("db", "mongo", "storage backend to use (mongo or embedded)")
+	dbAddrFlag := fs.String("db-addr", metaserver.DefaultMongoAddr, "address of the MongoDB server")
+	dbPathFlag := fs.String("db-path", "metaserver.db", "path of the embedded database")
+	allowPrivateFlag := fs.Bool("allow-private-providers", false,
+		"allow providers at loopback and private network addresses")
+	registrationLimitFlag := fs.Int("registration-limit", metaserver.DefaultRegistrationLimit,
+		"most providers each client address can register per hour (0 for no limit)")
 	fs.Parse(args)
 
 	addr := core.DefaultMetaAddr
@@ -37,7 +44,20 @@ func runMetaServer(args ...string) {
 	defer logfile.Close()
 	logger := log.New(logfile, "", log.LstdFlags)
 
-	server := metaserver.InitServer(".", showDash, logger)
+	var db metaserver.MetaStore
+	switch *dbFlag {
+	case "mongo":
+		db, err = metaserver.NewMongoStore(*dbAddrFlag)
+	case "embedded":
+		db, err = metaserver.NewEmbeddedStore(*dbPathFlag)
+	default:
+		log.Fatalf("Unknown storage backend %q\n", *dbFlag)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open database: %s\n", err)
+	}
+
+	server := metaserver.InitServer(db, ".", showDash, *allowPrivateFlag, *registrationLimitFlag, logger)
 
 	log.Println("starting metaserver server at", addr)
 	defer server.Close()
@@ -4,8 +4,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -14,6 +16,7 @@ import (
 	"skybin/provider"
 	"skybin/util"
 	"strings"
+	"text/tabwriter"
 )
 
 const providerUsage = `provider <command>
@@ -22,15 +25,21 @@ const providerUsage = `provider <command>
 
 commands:
 
-    init   Set up a skybin provider
-    daemon Start a provider daemon
-    info   Print daemon status information
+    init          Set up a skybin provider
+    daemon        Start a provider daemon
+    info          Print daemon status information
+    migrate-store Move blocks to a different block store backend
+    db            Migrate the provider database or show its schema status
+    fsck          Check the provider database against the blocks on disk
 `
 
 var providerCmds = []*Cmd{
 	&providerInitCmd,
 	&providerDaemonCmd,
 	&providerInfoCmd,
+	&providerMigrateStoreCmd,
+	&providerDBCmd,
+	&providerFsckCmd,
 }
 
 var providerCmd = Cmd{
@@ -61,10 +70,13 @@ options:
     --local-api-addr   Local API network address in 'host:port' form
     --meta-addr        Address of the metaserver to register with
     --storage-space    Storage space to make available to renters (default 10GB)
-    --pricing-policy   Policy to determine storage rates (fixed, passive, or aggressive) default: passive
+    --pricing-policy   Policy to determine storage rates (fixed, passive, aggressive, or demand) default: passive
     --storage-rate     Storage rate to charge, in tenths of cents/1e9 bytes/30 days (ignored if policy is not fixed)
     --min-storage-rate Minimum storage rate to charge, in tenths of cents/1e9 bytes/30 days
     --max-storage-rate Maximum storage rate to charge, in tenths of cents/1e9 bytes/30 days
+    --download-rate    Rate to charge renters for downloads, in tenths of cents/1e9 bytes (default 0)
+    --block-store      Backend used to store blocks (flat, sharded, or packed) default: flat
+    --region           Region the provider is located in, e.g. us-west, for renters looking for nearby providers
 `
 
 var providerInitCmd = Cmd{
@@ -88,22 +100,32 @@ func runProviderInit(args ...string) {
 	storageRateFlag := fs.Int64("storage-rate", -1, "")
 	minStorageRateFlag := fs.Int64("min-storage-rate", -1, "")
 	maxStorageRateFlag := fs.Int64("max-storage-rate", -1, "")
+	downloadRateFlag := fs.Int64("download-rate", 0, "")
+	blockStoreFlag := fs.String("block-store", "", "")
+	regionFlag := fs.String("region", "", "")
 	fs.Parse(args)
 
 	if *pricingPolicyFlag != "" {
-		policy := provider.PricingPolicy(*pricingPolicyFlag)
-		switch policy {
-		case provider.FixedPricingPolicy:
-		case provider.PassivePricingPolicy:
-		case provider.AggressivePricingPolicy:
-		default:
+		if !provider.ValidPricingPolicy(provider.PricingPolicyName(*pricingPolicyFlag)) {
 			log.Fatal("Unrecognized pricing policy")
 		}
 	}
+	if *blockStoreFlag != "" {
+		switch provider.BlockStoreBackend(*blockStoreFlag) {
+		case provider.FlatBlockStoreBackend:
+		case provider.ShardedBlockStoreBackend:
+		case provider.PackedBlockStoreBackend:
+		default:
+			log.Fatal("Unrecognized block store backend")
+		}
+	}
 	if *minStorageRateFlag != -1 && *maxStorageRateFlag != -1 &&
 		*minStorageRateFlag > *maxStorageRateFlag {
 		log.Fatal("min-storage-rate must be less than or equal to max-storage-rate")
 	}
+	if *downloadRateFlag < 0 {
+		log.Fatal("download-rate cannot be negative")
+	}
 
 	homeDir := *homeDirFlag
 	if len(homeDir) == 0 {
@@ -122,12 +144,6 @@ func runProviderInit(args ...string) {
 	if err != nil {
 		log.Fatal("Unable to create provider directory. Error: ", err)
 	}
-	err = os.MkdirAll(path.Join(homeDir, "blocks"), 0700)
-	if err != nil {
-		os.RemoveAll(homeDir)
-		log.Fatal("Unable to create blocks directory. Error: ", err)
-	}
-
 	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		os.RemoveAll(homeDir)
@@ -164,6 +180,10 @@ func runProviderInit(args ...string) {
 		MinStorageRate: provider.DefaultMinStorageRate,
 		StorageRate:    provider.DefaultStorageRate,
 		MaxStorageRate: provider.DefaultMaxStorageRate,
+		BlockStore:     provider.DefaultBlockStoreBackend,
+	}
+	if len(*blockStoreFlag) > 0 {
+		config.BlockStore = provider.BlockStoreBackend(*blockStoreFlag)
 	}
 	if len(*metaAddrFlag) > 0 {
 		err = util.ValidateNetAddr(*metaAddrFlag)
@@ -202,18 +222,12 @@ func runProviderInit(args ...string) {
 		config.SpaceAvail = amt
 	}
 	if len(*pricingPolicyFlag) > 0 {
-		policy := provider.PricingPolicy(*pricingPolicyFlag)
-		switch policy {
-		case provider.FixedPricingPolicy:
-			fallthrough
-		case provider.PassivePricingPolicy:
-			fallthrough
-		case provider.AggressivePricingPolicy:
-			config.PricingPolicy = policy
-		default:
+		policy := provider.PricingPolicyName(*pricingPolicyFlag)
+		if !provider.ValidPricingPolicy(policy) {
 			os.RemoveAll(homeDir)
 			log.Fatal("Invalid pricing policy")
 		}
+		config.PricingPolicy = policy
 	}
 	if *storageRateFlag != -1 && config.PricingPolicy == provider.FixedPricingPolicy {
 		config.StorageRate = *storageRateFlag
@@ -241,13 +255,17 @@ func runProviderInit(args ...string) {
 	if config.MinStorageRate > config.MaxStorageRate {
 		config.MaxStorageRate = config.MinStorageRate
 	}
+	config.DownloadRate = *downloadRateFlag
+	config.Region = *regionFlag
 
 	// Register with metaserver
 	info := core.ProviderInfo{
-		PublicKey:   string(publicKeyBytes),
-		Addr:        config.PublicApiAddr,
-		SpaceAvail:  config.SpaceAvail,
-		StorageRate: config.StorageRate,
+		PublicKey:    string(publicKeyBytes),
+		Addr:         config.PublicApiAddr,
+		SpaceAvail:   config.SpaceAvail,
+		StorageRate:  config.StorageRate,
+		DownloadRate: config.DownloadRate,
+		Region:       config.Region,
 	}
 	metaClient := metaserver.NewClient(config.MetaAddr, &http.Client{})
 	updatedInfo, err := metaClient.RegisterProvider(&info)
@@ -256,6 +274,11 @@ func runProviderInit(args ...string) {
 		log.Fatal("Unable to register with metaserver. Error: ", err)
 	}
 
+	if !updatedInfo.Verified {
+		log.Printf("The metaserver couldn't reach the provider at %s yet. "+
+			"It will be listed once the daemon is running and reachable there.\n", info.Addr)
+	}
+
 	// Pull generated provider ID from info
 	config.ProviderID = updatedInfo.ID
 
@@ -296,6 +319,31 @@ func runProviderDaemon(args ...string) {
 	logger := log.New(logfile, "", log.LstdFlags)
 
 	pvdr.SetLogger(logger)
+
+	// Check that the DB matches the disks before serving renters.
+	report, err := pvdr.CheckConsistency(false)
+	if err != nil {
+		log.Fatal("Unable to check provider consistency. Error: ", err)
+	}
+	for _, problem := range report.Problems {
+		logger.Printf("fsck: %s: block %s of renter %s on %s: %s\n",
+			problem.Kind, problem.BlockID, problem.RenterID, problem.Disk, problem.Detail)
+	}
+	if len(report.Problems) > 0 {
+		log.Printf("Found %d inconsistencies between the provider DB and disks. "+
+			"Stop the daemon and run '%s provider fsck --repair' to fix them.\n",
+			len(report.Problems), os.Args[0])
+	}
+
+	// Listen before starting the background threads, so the
+	// metaserver can reach the provider when verifying its address.
+	server := provider.NewServer(pvdr, logger)
+	port := pvdr.Config.PublicApiAddr[strings.LastIndex(pvdr.Config.PublicApiAddr, ":"):]
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		log.Fatal("Unable to listen on public API address. Error: ", err)
+	}
+
 	pvdr.StartBackgroundThreads()
 
 	// Run local API
@@ -307,10 +355,8 @@ func runProviderDaemon(args ...string) {
 		}()
 	}
 
-	server := provider.NewServer(pvdr, logger)
-	port := pvdr.Config.PublicApiAddr[strings.LastIndex(pvdr.Config.PublicApiAddr, ":"):]
 	log.Println("starting public provider server at", pvdr.Config.PublicApiAddr)
-	log.Fatal(http.ListenAndServe(port, server))
+	log.Fatal(http.Serve(listener, server))
 }
 
 var providerInfoCmd = Cmd{
@@ -323,3 +369,140 @@ var providerInfoCmd = Cmd{
 func runProviderInfo(args ...string) {
 	log.Fatal("not implemented")
 }
+
+var providerMigrateStoreCmd = Cmd{
+	Name:        "migrate-store",
+	Description: "Move blocks to a different block store backend",
+	Usage:       "provider migrate-store <flat|sharded|packed>",
+	Run:         runProviderMigrateStore,
+}
+
+func runProviderMigrateStore(args ...string) {
+	if len(args) != 1 {
+		log.Fatal("usage: ", os.Args[0], " provider migrate-store <flat|sharded|packed>")
+	}
+
+	homedir, err := findProviderHomedir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The daemon must be stopped so blocks aren't added or
+	// removed while they're being copied.
+	err = provider.MigrateBlockStore(homedir, provider.BlockStoreBackend(args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Migrated blocks to the", args[0], "block store")
+}
+
+const providerDBUsage = `provider db <migrate|status>
+
+commands:
+
+    migrate       Bring the provider database to the latest schema
+    status        List the database's schema migrations and whether they've been applied
+`
+
+var providerDBCmd = Cmd{
+	Name:        "db",
+	Description: "Migrate the provider database or show its schema status",
+	Usage:       providerDBUsage,
+	Run:         runProviderDB,
+}
+
+func runProviderDB(args ...string) {
+	if len(args) != 1 || (args[0] != "migrate" && args[0] != "status") {
+		log.Fatal("usage: ", os.Args[0], " ", providerDBUsage)
+	}
+
+	homedir, err := findProviderHomedir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if args[0] == "status" {
+		statuses, err := provider.DBMigrationStatus(homedir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 5, 3, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, applied, s.Description)
+		}
+		tw.Flush()
+		return
+	}
+
+	// The daemon must be stopped so it doesn't use
+	// the database while it's being migrated.
+	applied, backupPath, err := provider.MigrateDB(homedir)
+	if backupPath != "" {
+		log.Println("Backed up database to", backupPath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if applied == 0 {
+		log.Println("Database is already up to date")
+		return
+	}
+	log.Println("Applied", applied, "migrations")
+}
+
+var providerFsckCmd = Cmd{
+	Name:        "fsck",
+	Description: "Check the provider database against the blocks on disk",
+	Usage:       "provider fsck [--repair]",
+	Run:         runProviderFsck,
+}
+
+func runProviderFsck(args ...string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	repairFlag := fs.Bool("repair", false, "Fix the problems found")
+	fs.Parse(args)
+
+	homedir, err := findProviderHomedir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The daemon must be stopped so blocks aren't added
+	// or removed while they're being checked.
+	pvdr, err := provider.LoadFromDisk(homedir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pvdr.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	report, err := pvdr.CheckConsistency(*repairFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Checked %d blocks and %d files\n", report.BlocksChecked, report.FilesChecked)
+	if len(report.Problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 5, 3, ' ', 0)
+	fmt.Fprintln(tw, "PROBLEM\tRENTER\tBLOCK\tDISK\tDETAIL\tREPAIRED")
+	repaired := 0
+	for _, p := range report.Problems {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			p.Kind, p.RenterID, p.BlockID, p.Disk, p.Detail, p.Repaired)
+		if p.Repaired {
+			repaired++
+		}
+	}
+	tw.Flush()
+	if *repairFlag {
+		fmt.Printf("Repaired %d of %d problems\n", repaired, len(report.Problems))
+	} else {
+		fmt.Println("Run with --repair to fix these problems")
+	}
+}
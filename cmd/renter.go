@@ -287,6 +287,8 @@ func runRenterInfo(args ...string) {
 	fmt.Println("Reserved Storage:", util.FormatByteAmount(info.ReservedStorage))
 	fmt.Println("Used Storage:", util.FormatByteAmount(info.UsedStorage))
 	fmt.Println("Free Storage:", util.FormatByteAmount(info.FreeStorage))
+	fmt.Println("Pending Block Deletions:", info.PendingDeletions,
+		"("+util.FormatByteAmount(info.PendingDeletionBytes)+")")
 }
 
 // Finds the renter config file and returns a renter client
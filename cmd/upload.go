@@ -1,19 +1,26 @@
 package cmd
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"path"
 	"path/filepath"
+	"skybin/renter"
 )
 
 var uploadCmd = Cmd{
 	Name:        "upload",
 	Description: "Upload a file",
-	Usage:       "upload <filename> [destination]",
+	Usage:       "upload [--dry-run] <filename> [destination]",
 	Run:         runUpload,
 }
 
 func runUpload(args ...string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	dryRunFlag := fs.Bool("dry-run", false, "Show how much storage the upload would use without uploading")
+	fs.Parse(args)
+	args = fs.Args()
 
 	if len(args) < 1 {
 		log.Fatal("Must provide filename")
@@ -36,9 +43,38 @@ func runUpload(args ...string) {
 		log.Fatal(err)
 	}
 
+	if *dryRunFlag {
+		preview, err := client.PreviewUpload(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printUploadPreview(preview)
+		return
+	}
+
 	_, err = client.Upload(filename, destpath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+func printUploadPreview(preview *renter.UploadPreview) {
+	fmt.Printf("Files:             %d\n", preview.NumFiles)
+	fmt.Printf("Folders:           %d\n", preview.NumFolders)
+	fmt.Printf("Source size:       %d bytes\n", preview.SourceSize)
+	fmt.Printf("Upload size:       %d bytes\n", preview.UploadSize)
+	fmt.Printf("Available storage: %d bytes\n", preview.AvailableStorage)
+	if preview.Shortfall == 0 {
+		fmt.Println("Enough storage is available for this upload.")
+		return
+	}
+	fmt.Printf("Shortfall:         %d bytes\n", preview.Shortfall)
+	if preview.Estimate == nil {
+		fmt.Printf("Unable to estimate the cost of more storage: %s\n", preview.EstimateError)
+		return
+	}
+	fmt.Printf("Reserving %d bytes would cost %d tenths of cents across %d contracts.\n",
+		preview.Estimate.TotalSpace, preview.Estimate.TotalCost, len(preview.Estimate.Contracts))
+	fmt.Printf("Run 'skybin reserve %d' to reserve it.\n", preview.Estimate.TotalSpace)
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Budget limits how much a renter may spend on storage contracts.
+// A limit of zero means the limit is disabled.
+type Budget struct {
+	// Maximum total contract fees per calendar month, in tenths of cents.
+	MonthlyLimit int64 `json:"monthlyLimit"`
+	// Maximum fee of any single contract, in tenths of cents.
+	MaxContractFee int64 `json:"maxContractFee"`
+	// Maximum storage rate, in tenths-of-cents/gb/month.
+	MaxStorageRate int64 `json:"maxStorageRate"`
+	// Maximum download rate, in tenths-of-cents/gb.
+	MaxDownloadRate int64 `json:"maxDownloadRate"`
+}
+
+// CheckContract returns an error if forming the given contract would
+// violate the budget. spent is the amount the renter has already paid
+// for contracts in the contract's month.
+func (b *Budget) CheckContract(contract *Contract, spent int64) error {
+	if b.MaxContractFee > 0 && contract.StorageFee > b.MaxContractFee {
+		return fmt.Errorf("contract fee %d exceeds per-contract limit of %d",
+			contract.StorageFee, b.MaxContractFee)
+	}
+	if b.MaxStorageRate > 0 {
+		rate := ContractStorageRate(contract)
+		if rate > b.MaxStorageRate {
+			return fmt.Errorf("storage rate %d exceeds maximum rate of %d",
+				rate, b.MaxStorageRate)
+		}
+	}
+	if b.MaxDownloadRate > 0 && contract.DownloadRate > b.MaxDownloadRate {
+		return fmt.Errorf("download rate %d exceeds maximum rate of %d",
+			contract.DownloadRate, b.MaxDownloadRate)
+	}
+	if b.MonthlyLimit > 0 && spent+contract.StorageFee > b.MonthlyLimit {
+		return fmt.Errorf("contract fee %d exceeds remaining monthly budget of %d",
+			contract.StorageFee, b.MonthlyLimit-spent)
+	}
+	return nil
+}
+
+// ContractStorageRate returns the storage rate implied by a contract's
+// terms, in tenths-of-cents/gb/month.
+func ContractStorageRate(contract *Contract) int64 {
+	durationDays := math.Round(contract.EndDate.Sub(contract.StartDate).Hours() / 24)
+	spaceGb := float64(contract.StorageSpace) / float64(1e9)
+	units := int64(math.Ceil(spaceGb * durationDays / float64(30)))
+	if units <= 0 {
+		return 0
+	}
+	return contract.StorageFee / units
+}
+
+// MonthStart returns the start of the calendar month (UTC) containing t.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MonthlySpending returns the total contract payments in transactions
+// made during the calendar month (UTC) containing now. Refunds for
+// cancelled contracts are taken off their contracts' payments. Egress
+// charges for downloads aren't contract fees, so they don't count.
+func MonthlySpending(transactions []Transaction, now time.Time) int64 {
+	monthStart := MonthStart(now)
+	paid := make(map[string]int64)
+	refunded := make(map[string]int64)
+	for _, t := range transactions {
+		if t.Date.Before(monthStart) {
+			continue
+		}
+		switch t.TransactionType {
+		case "payment":
+			paid[t.ContractID] += t.Amount
+		case "refund":
+			refunded[t.ContractID] += t.Amount
+		}
+	}
+	// Refunds for contracts paid for in an earlier month don't count,
+	// since neither do their payments.
+	var total int64
+	for contractID, amount := range paid {
+		if refunded[contractID] < amount {
+			total += amount - refunded[contractID]
+		}
+	}
+	return total
+}
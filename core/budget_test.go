@@ -0,0 +1,79 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetCheckContract(t *testing.T) {
+	start := time.Date(2018, time.March, 10, 0, 0, 0, 0, time.UTC)
+	contract := Contract{
+		StorageSpace: 2 * 1e9,
+		StorageFee:   40,
+		StartDate:    start,
+		EndDate:      start.AddDate(0, 0, 60),
+	}
+	if rate := ContractStorageRate(&contract); rate != 10 {
+		t.Fatal("wrong storage rate. got", rate, "expected", 10)
+	}
+
+	var budget Budget
+	if err := budget.CheckContract(&contract, 1000); err != nil {
+		t.Fatal("empty budget should not limit contracts. error: ", err)
+	}
+
+	budget = Budget{MaxContractFee: 39}
+	if err := budget.CheckContract(&contract, 0); err == nil {
+		t.Fatal("contract fee should exceed limit")
+	}
+
+	budget = Budget{MaxStorageRate: 9}
+	if err := budget.CheckContract(&contract, 0); err == nil {
+		t.Fatal("contract rate should exceed limit")
+	}
+
+	budget = Budget{MaxDownloadRate: 5}
+	if err := budget.CheckContract(&contract, 0); err != nil {
+		t.Fatal("free downloads should fit download rate limit. error: ", err)
+	}
+	contract.DownloadRate = 6
+	if err := budget.CheckContract(&contract, 0); err == nil {
+		t.Fatal("contract download rate should exceed limit")
+	}
+	contract.DownloadRate = 0
+
+	budget = Budget{MonthlyLimit: 100}
+	if err := budget.CheckContract(&contract, 60); err != nil {
+		t.Fatal("contract should fit monthly limit. error: ", err)
+	}
+	if err := budget.CheckContract(&contract, 61); err == nil {
+		t.Fatal("contract should exceed monthly limit")
+	}
+}
+
+func TestMonthlySpending(t *testing.T) {
+	now := time.Date(2018, time.March, 10, 0, 0, 0, 0, time.UTC)
+	transactions := []Transaction{
+		{TransactionType: "payment", Amount: 5, Date: now.AddDate(0, 0, -1)},
+		{TransactionType: "payment", Amount: 7, Date: now.AddDate(0, 0, -9)},
+		{TransactionType: "payment", Amount: 11, Date: now.AddDate(0, 0, -10)},
+		{TransactionType: "deposit", Amount: 13, Date: now},
+		{TransactionType: "egress", Amount: 17, Date: now},
+	}
+	if spent := MonthlySpending(transactions, now); spent != 12 {
+		t.Fatal("wrong monthly spending. got", spent, "expected", 12)
+	}
+
+	// Refunds count against their contracts' payments
+	// made in the same month.
+	transactions = []Transaction{
+		{TransactionType: "payment", ContractID: "c1", Amount: 10, Date: now.AddDate(0, 0, -2)},
+		{TransactionType: "refund", ContractID: "c1", Amount: 6, Date: now.AddDate(0, 0, -1)},
+		{TransactionType: "payment", ContractID: "c2", Amount: 10, Date: now.AddDate(0, 0, -1)},
+		{TransactionType: "payment", ContractID: "c3", Amount: 10, Date: now.AddDate(0, -1, 0)},
+		{TransactionType: "refund", ContractID: "c3", Amount: 8, Date: now},
+	}
+	if spent := MonthlySpending(transactions, now); spent != 14 {
+		t.Fatal("wrong monthly spending with refunds. got", spent, "expected", 14)
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// ReadCapability grants its holder permission to read a set of a
+// renter's blocks from providers until it expires. Renters issue
+// them to the users they share files with.
+type ReadCapability struct {
+	// The renter who owns the blocks and signed the capability
+	RenterId  string    `json:"renterId"`
+	BlockIds  []string  `json:"blockIds"`
+	Expires   time.Time `json:"expires"`
+	Signature string    `json:"signature"`
+}
+
+// A read capability without the signature field,
+// with other fields sorted by name.
+type readCapabilityTerms struct {
+	BlockIds []string  `json:"blockIds"`
+	Expires  time.Time `json:"expires"`
+	RenterId string    `json:"renterId"`
+}
+
+func hashReadCapability(c *ReadCapability) ([]byte, error) {
+	p := readCapabilityTerms{
+		BlockIds: c.BlockIds,
+		Expires:  c.Expires,
+		RenterId: c.RenterId,
+	}
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// SignReadCapability signs a read capability with the given key,
+// returning the base64 encoded signature.
+func SignReadCapability(c *ReadCapability, key *rsa.PrivateKey) (string, error) {
+	h, err := hashReadCapability(c)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), err
+}
+
+// VerifyReadCapability checks that a read capability's signature
+// matches its terms using the given key.
+func VerifyReadCapability(c *ReadCapability, key rsa.PublicKey) error {
+	h, err := hashReadCapability(c)
+	if err != nil {
+		return err
+	}
+	sb, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(&key, crypto.SHA256, h, sb)
+}
+
+// Covers returns whether the capability grants access to the given block.
+func (c *ReadCapability) Covers(blockID string) bool {
+	for _, id := range c.BlockIds {
+		if id == blockID {
+			return true
+		}
+	}
+	return false
+}
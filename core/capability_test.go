@@ -0,0 +1,52 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyReadCapability(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1 := ReadCapability{
+		RenterId: "abcdefg",
+		BlockIds: []string{"b1", "b2"},
+		Expires:  time.Now().Add(time.Hour),
+	}
+	c1.Signature, err = SignReadCapability(&c1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyReadCapability(&c1, key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c1.Covers("b2") || c1.Covers("b3") {
+		t.Fatal("capability covers wrong blocks")
+	}
+
+	c2 := c1
+	c2.BlockIds = []string{"b1", "b2", "b3"}
+	err = VerifyReadCapability(&c2, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - capability does not match original")
+	}
+
+	c2 = c1
+	c2.Expires = time.Now().Add(24 * time.Hour)
+	err = VerifyReadCapability(&c2, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - capability does not match original")
+	}
+
+	c2 = c1
+	c2.RenterId = "1"
+	err = VerifyReadCapability(&c2, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - capability does not match original")
+	}
+}
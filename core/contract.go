@@ -13,6 +13,9 @@ import (
 // A contract without the signature fields,
 // with other fields sorted by name.
 type contractTerms struct {
+	// Omitted when zero so contracts signed before download
+	// rates were added still verify.
+	DownloadRate int64     `json:"downloadRate,omitempty"`
 	EndDate      time.Time `json:"endDate"`
 	ID           string    `json:"id"`
 	ProviderId   string    `json:"providerId"`
@@ -24,6 +27,7 @@ type contractTerms struct {
 
 func makeTerms(c *Contract) contractTerms {
 	return contractTerms{
+		DownloadRate: c.DownloadRate,
 		EndDate:      c.EndDate,
 		ID:           c.ID,
 		ProviderId:   c.ProviderId,
@@ -89,5 +93,58 @@ func CompareContractTerms(c1, c2 *Contract) bool {
 		c1.StorageSpace == c2.StorageSpace &&
 		c1.StorageFee == c2.StorageFee &&
 		c1.StartDate.Equal(c2.StartDate) &&
-		c1.EndDate.Equal(c2.EndDate)
+		c1.EndDate.Equal(c2.EndDate) &&
+		c1.DownloadRate == c2.DownloadRate
+}
+
+// A termination without the signature fields,
+// with other fields sorted by name.
+type terminationTerms struct {
+	ContractID string    `json:"contractId"`
+	Date       time.Time `json:"date"`
+	ProviderId string    `json:"providerId"`
+	RenterId   string    `json:"renterId"`
+}
+
+func hashTermination(t *ContractTermination) ([]byte, error) {
+	p := terminationTerms{
+		ContractID: t.ContractID,
+		Date:       t.Date,
+		ProviderId: t.ProviderId,
+		RenterId:   t.RenterId,
+	}
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// SignTermination signs a contract termination with a given key,
+// returning the base64 encoded signature.
+func SignTermination(termination *ContractTermination, key *rsa.PrivateKey) (string, error) {
+	h, err := hashTermination(termination)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), err
+}
+
+// VerifyTerminationSignature checks that a signature matches a contract
+// termination using the given key.
+func VerifyTerminationSignature(termination *ContractTermination, signature string, key rsa.PublicKey) error {
+	h, err := hashTermination(termination)
+	if err != nil {
+		return err
+	}
+	sb, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(&key, crypto.SHA256, h, sb)
 }
@@ -80,6 +80,12 @@ func TestSignVerify(t *testing.T) {
 	if err == nil {
 		t.Fatal("verify should fail - contract does not match original")
 	}
+	c2 = c1
+	c2.DownloadRate = 5
+	err = VerifyContractSignature(&c2, sig, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - contract does not match original")
+	}
 }
 
 func TestCompare(t *testing.T) {
@@ -198,6 +204,13 @@ func TestCompareTerms(t *testing.T) {
 		t.Fatal("contracts should not match")
 	}
 
+	c2 = c1
+	c2.DownloadRate = 7
+	doMatch = CompareContractTerms(&c1, &c2)
+	if doMatch {
+		t.Fatal("contracts should not match")
+	}
+
 	c2 = c1
 	c2.StartDate = time.Now().AddDate(1, 1, 1)
 	doMatch = CompareContractTerms(&c1, &c2)
@@ -244,3 +257,38 @@ func TestSerializeDeserialize(t *testing.T) {
 		t.Fatal("contracts should match")
 	}
 }
+
+func TestSignVerifyTermination(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1 := ContractTermination{
+		ContractID: "cid",
+		RenterId:   "abcdefg",
+		ProviderId: "hijklmnop",
+		Date:       time.Now(),
+	}
+	sig, err := SignTermination(&t1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyTerminationSignature(&t1, sig, key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2 := t1
+	t2.ContractID = "1"
+	err = VerifyTerminationSignature(&t2, sig, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - termination does not match original")
+	}
+
+	t2 = t1
+	t2.Date = time.Now().Add(1 * time.Minute)
+	err = VerifyTerminationSignature(&t2, sig, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - termination does not match original")
+	}
+}
@@ -24,8 +24,24 @@ type ProviderInfo struct {
 	// Rate charged for storage, in tenths-of-cents/gb/month
 	// where 1 gb is 1e9 bytes
 	StorageRate int64 `json:"storageRate"`
+	// Rate charged for sending blocks to renters, in tenths-of-cents/gb.
+	// Zero means downloads are free.
+	DownloadRate int64 `json:"downloadRate,omitempty"`
+	// Region the provider says it's located in, e.g. "us-west".
+	// Renters can look for providers in a region.
+	Region string `json:"region,omitempty"`
 	// The provider's balance, in tenths of cents.
 	Balance int64 `json:"balance"`
+	// Computed by the metaserver from audits of the provider's blocks.
+	// Nil until the provider's blocks have been audited.
+	Reputation *Reputation `json:"reputation,omitempty"`
+	// Tracked by the metaserver from the provider's heartbeats.
+	// Nil if the provider has never been seen online.
+	Liveness *Liveness `json:"liveness,omitempty"`
+	// Whether the metaserver has checked that the provider is reachable
+	// at Addr and holds the private key for PublicKey. Providers are
+	// only listed once verified.
+	Verified bool `json:"verified"`
 }
 
 type RenterInfo struct {
@@ -36,6 +52,8 @@ type RenterInfo struct {
 	Shared    []string `json:"shared"`
 	// The renter's balance, in tenths of cents.
 	Balance int64 `json:"balance"`
+	// Spending limits enforced when the renter forms contracts.
+	Budget Budget `json:"budget"`
 }
 
 type Contract struct {
@@ -48,6 +66,21 @@ type Contract struct {
 	EndDate           time.Time `json:"endDate"`
 	RenterSignature   string    `json:"renterSignature"`
 	ProviderSignature string    `json:"providerSignature"`
+
+	// Rate the renter pays for downloading its blocks from the
+	// provider, in tenths-of-cents/gb. Zero means downloads are free.
+	DownloadRate int64 `json:"downloadRate,omitempty"`
+}
+
+// ContractTermination is an agreement between a renter and provider
+// to end a contract before its end date.
+type ContractTermination struct {
+	ContractID        string    `json:"contractId"`
+	RenterId          string    `json:"renterId"`
+	ProviderId        string    `json:"providerId"`
+	Date              time.Time `json:"date"`
+	RenterSignature   string    `json:"renterSignature"`
+	ProviderSignature string    `json:"providerSignature"`
 }
 
 // PaymentInfo contains the details describing payment data for a given
@@ -63,15 +96,30 @@ type PaymentInfo struct {
 	Balance int64 `json:"balance"`
 }
 
+// EgressInfo tracks the bytes of a renter's blocks that a provider has
+// sent under a contract which charges for downloads.
+type EgressInfo struct {
+	ContractID string `json:"contract"`
+	ProviderId string `json:"providerId"`
+	RenterId   string `json:"renterId"`
+	// Bytes sent that the renter hasn't been charged for yet.
+	UnbilledBytes int64 `json:"unbilledBytes"`
+	// Bytes sent that the renter has paid for.
+	BilledBytes int64 `json:"billedBytes"`
+}
+
 // Transaction describes a transaction involving either a renter or provider.
 type Transaction struct {
+	// Identifies the transaction. Assigned by the metaserver's database.
+	ID string `json:"id" bson:"-"`
 	// Whether the transaction involved a renter or provider.
 	UserType string `json:"userType"`
 	// The ID of the associated user.
 	UserID string `json:"userId"`
 	// The contract associated with the transaction.
 	ContractID string `json:"contractId"`
-	// Whether the transction was a payment, receipt, deposit, or withdrawal.
+	// Whether the transction was a payment, receipt, refund, cancellation,
+	// deposit, withdrawal, or egress (a renter's charge for downloads).
 	TransactionType string `json:"transactionType"`
 	// The amount transferred, in tenths of cents.
 	Amount int64 `json:"amount"`
@@ -108,7 +156,11 @@ type Block struct {
 	Sha256Hash string `json:"sha256hash"`
 	// Location of the provider where the block is stored
 	Location BlockLocation `json:"location"`
-	// Audits to be used to check the block's integrity
+	// base64 encoded root of the block's Merkle tree, which is used
+	// to check that the provider still stores the block
+	MerkleRoot string `json:"merkleRoot,omitempty"`
+	// Audits to be used to check the integrity of blocks uploaded
+	// before blocks had Merkle roots
 	Audits []BlockAudit `json:"audits"`
 	// Whether or not the last audit passed.
 	AuditPassed bool `json:"auditPassed"`
@@ -122,6 +174,8 @@ type Permission struct {
 	// The file's encryption information encrypted with the user's public key
 	AesKey string `json:"aesKey"`
 	AesIV  string `json:"aesIV"`
+	// Lets the renter read the file's blocks from providers
+	ReadCapability *ReadCapability `json:"readCapability,omitempty"`
 }
 
 type File struct {
@@ -0,0 +1,18 @@
+package core
+
+// EgressCharge returns the amount owed for sending the given number of
+// bytes at a download rate in tenths-of-cents/gb, along with the number
+// of bytes that amount pays for. The charge is limited to maxAmount.
+// Bytes that don't add up to a whole tenth of a cent are left unpaid so
+// they can be charged along with later downloads.
+func EgressCharge(bytes, rate, maxAmount int64) (amount int64, paidBytes int64) {
+	if bytes <= 0 || rate <= 0 || maxAmount <= 0 {
+		return 0, 0
+	}
+	amount = bytes * rate / 1e9
+	if amount > maxAmount {
+		amount = maxAmount
+	}
+	paidBytes = amount * 1e9 / rate
+	return amount, paidBytes
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestEgressCharge(t *testing.T) {
+	cases := []struct {
+		bytes, rate, maxAmount int64
+		amount, paidBytes      int64
+	}{
+		{bytes: 3 * 1e9, rate: 10, maxAmount: 100, amount: 30, paidBytes: 3 * 1e9},
+		// A partial tenth of a cent is left for later.
+		{bytes: 1e9 + 5e7, rate: 10, maxAmount: 100, amount: 10, paidBytes: 1e9},
+		{bytes: 5e7, rate: 10, maxAmount: 100, amount: 0, paidBytes: 0},
+		// The renter can only pay part of the charge.
+		{bytes: 3 * 1e9, rate: 10, maxAmount: 15, amount: 15, paidBytes: 15e8},
+		{bytes: 3 * 1e9, rate: 10, maxAmount: 0, amount: 0, paidBytes: 0},
+		{bytes: 3 * 1e9, rate: 0, maxAmount: 100, amount: 0, paidBytes: 0},
+	}
+	for _, c := range cases {
+		amount, paidBytes := EgressCharge(c.bytes, c.rate, c.maxAmount)
+		if amount != c.amount || paidBytes != c.paidBytes {
+			t.Fatalf("EgressCharge(%d, %d, %d): expected %d for %d bytes. Got %d for %d bytes",
+				c.bytes, c.rate, c.maxAmount, c.amount, c.paidBytes, amount, paidBytes)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Heartbeat is sent periodically by a provider to tell the metaserver
+// it's still online. It's signed with the provider's key so others
+// can't keep an offline provider listed.
+type Heartbeat struct {
+	ProviderId string    `json:"providerId"`
+	Time       time.Time `json:"time"`
+	Signature  string    `json:"signature"`
+}
+
+// A heartbeat without the signature field,
+// with other fields sorted by name.
+type heartbeatTerms struct {
+	ProviderId string    `json:"providerId"`
+	Time       time.Time `json:"time"`
+}
+
+func hashHeartbeat(hb *Heartbeat) ([]byte, error) {
+	p := heartbeatTerms{
+		ProviderId: hb.ProviderId,
+		Time:       hb.Time,
+	}
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// SignHeartbeat signs a heartbeat with the given key,
+// returning the base64 encoded signature.
+func SignHeartbeat(hb *Heartbeat, key *rsa.PrivateKey) (string, error) {
+	h, err := hashHeartbeat(hb)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), err
+}
+
+// VerifyHeartbeat checks that a heartbeat's signature
+// matches its contents using the given key.
+func VerifyHeartbeat(hb *Heartbeat, key rsa.PublicKey) error {
+	h, err := hashHeartbeat(hb)
+	if err != nil {
+		return err
+	}
+	sb, err := base64.StdEncoding.DecodeString(hb.Signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(&key, crypto.SHA256, h, sb)
+}
+
+// Liveness is the metaserver's view of whether a provider is reachable,
+// based on the provider's heartbeats.
+type Liveness struct {
+	// When the metaserver received the provider's last heartbeat.
+	LastSeen time.Time `json:"lastSeen"`
+	// Time the provider put in its last heartbeat. Heartbeats that
+	// aren't later than this are rejected as replays.
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	// Whether the provider has sent a heartbeat recently.
+	Online bool `json:"online"`
+	// Fraction of recent liveness checks the provider was online
+	// for, between 0 and 1. Recent checks count for more.
+	Uptime float64 `json:"uptime"`
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyHeartbeat(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb := Heartbeat{
+		ProviderId: "p1",
+		Time:       time.Now(),
+	}
+	hb.Signature, err = SignHeartbeat(&hb, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyHeartbeat(&hb, key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := hb
+	replayed.Time = hb.Time.Add(time.Minute)
+	err = VerifyHeartbeat(&replayed, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - heartbeat time changed")
+	}
+
+	forged := hb
+	forged.ProviderId = "p2"
+	err = VerifyHeartbeat(&forged, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - heartbeat provider changed")
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// IdentityChallenge is signed by a provider to prove to the metaserver
+// that the server at the provider's announced address holds its key.
+type IdentityChallenge struct {
+	ProviderId string `json:"providerId"`
+	// Random nonce chosen by the metaserver.
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// An identity challenge without the signature field,
+// with other fields sorted by name.
+type identityChallengeTerms struct {
+	Nonce      string `json:"nonce"`
+	ProviderId string `json:"providerId"`
+}
+
+func hashIdentityChallenge(c *IdentityChallenge) ([]byte, error) {
+	p := identityChallengeTerms{
+		Nonce:      c.Nonce,
+		ProviderId: c.ProviderId,
+	}
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// SignIdentityChallenge signs an identity challenge with the given key,
+// returning the base64 encoded signature.
+func SignIdentityChallenge(c *IdentityChallenge, key *rsa.PrivateKey) (string, error) {
+	h, err := hashIdentityChallenge(c)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), err
+}
+
+// VerifyIdentityChallenge checks that an identity challenge's signature
+// matches its contents using the given key.
+func VerifyIdentityChallenge(c *IdentityChallenge, key rsa.PublicKey) error {
+	h, err := hashIdentityChallenge(c)
+	if err != nil {
+		return err
+	}
+	sb, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(&key, crypto.SHA256, h, sb)
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestSignVerifyIdentityChallenge(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := IdentityChallenge{ProviderId: "p1", Nonce: "abc"}
+	c.Signature, err = SignIdentityChallenge(&c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyIdentityChallenge(&c, key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyIdentityChallenge(&c, other.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - signed with another key")
+	}
+
+	c2 := c
+	c2.Nonce = "abd"
+	err = VerifyIdentityChallenge(&c2, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - nonce changed")
+	}
+
+	c2 = c
+	c2.ProviderId = "p2"
+	err = VerifyIdentityChallenge(&c2, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - provider changed")
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+// Kinds of ledger accounts.
+const (
+	// A renter's wallet. Its ID is the renter's ID.
+	AccountRenter = "renter"
+	// A provider's wallet. Its ID is the provider's ID.
+	AccountProvider = "provider"
+	// Holds a contract's storage fee until it's paid to the provider or
+	// refunded to the renter. Its ID is the contract's ID.
+	AccountEscrow = "escrow"
+	// Money entering or leaving skybin through a payment gateway. It's the
+	// only account allowed a negative balance, since deposits move money
+	// out of it.
+	AccountGateway = "gateway"
+)
+
+// LedgerAccount identifies an account that holds money.
+type LedgerAccount struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func RenterAccount(renterID string) LedgerAccount {
+	return LedgerAccount{Type: AccountRenter, ID: renterID}
+}
+
+func ProviderAccount(providerID string) LedgerAccount {
+	return LedgerAccount{Type: AccountProvider, ID: providerID}
+}
+
+func EscrowAccount(contractID string) LedgerAccount {
+	return LedgerAccount{Type: AccountEscrow, ID: contractID}
+}
+
+// PaypalAccount is the gateway account for deposits and withdrawals made through PayPal.
+var PaypalAccount = LedgerAccount{Type: AccountGateway, ID: "paypal"}
+
+// LedgerEntry moves money from one account to another. Since every entry
+// takes from one account exactly what it gives to another, the balances
+// of all accounts always sum to zero.
+type LedgerEntry struct {
+	From LedgerAccount `json:"from"`
+	To   LedgerAccount `json:"to"`
+	// The amount moved, in tenths of cents.
+	Amount      int64     `json:"amount"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"description"`
+}
+
+// Validate checks that the entry moves a positive amount between two
+// different accounts of known types.
+func (e *LedgerEntry) Validate() error {
+	if e.Amount <= 0 {
+		return errors.New("ledger entry amount must be positive")
+	}
+	if e.From == e.To {
+		return errors.New("ledger entry must move money between different accounts")
+	}
+	for _, account := range []LedgerAccount{e.From, e.To} {
+		switch account.Type {
+		case AccountRenter, AccountProvider, AccountEscrow, AccountGateway:
+		default:
+			return errors.New("unknown ledger account type " + account.Type)
+		}
+		if account.ID == "" {
+			return errors.New("ledger account must have an ID")
+		}
+	}
+	return nil
+}
+
+// LedgerBalances returns the balance of each account the entries touch.
+func LedgerBalances(entries []LedgerEntry) map[LedgerAccount]int64 {
+	balances := make(map[LedgerAccount]int64)
+	for _, e := range entries {
+		balances[e.From] -= e.Amount
+		balances[e.To] += e.Amount
+	}
+	return balances
+}
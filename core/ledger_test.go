@@ -0,0 +1,49 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestLedgerEntryValidate(t *testing.T) {
+	valid := LedgerEntry{From: PaypalAccount, To: RenterAccount("r1"), Amount: 10}
+	if err := valid.Validate(); err != nil {
+		t.Fatal("valid entry rejected. error: ", err)
+	}
+	for _, e := range []LedgerEntry{
+		{From: PaypalAccount, To: RenterAccount("r1"), Amount: 0},
+		{From: PaypalAccount, To: RenterAccount("r1"), Amount: -5},
+		{From: RenterAccount("r1"), To: RenterAccount("r1"), Amount: 10},
+		{From: RenterAccount("r1"), To: LedgerAccount{Type: "bank", ID: "b1"}, Amount: 10},
+		{From: RenterAccount(""), To: ProviderAccount("p1"), Amount: 10},
+	} {
+		if err := e.Validate(); err == nil {
+			t.Fatalf("invalid entry %+v accepted", e)
+		}
+	}
+}
+
+func TestLedgerBalances(t *testing.T) {
+	entries := []LedgerEntry{
+		{From: PaypalAccount, To: RenterAccount("r1"), Amount: 100},
+		{From: RenterAccount("r1"), To: EscrowAccount("c1"), Amount: 60},
+		{From: EscrowAccount("c1"), To: ProviderAccount("p1"), Amount: 20},
+		{From: EscrowAccount("c1"), To: RenterAccount("r1"), Amount: 40},
+	}
+	balances := LedgerBalances(entries)
+	expected := map[LedgerAccount]int64{
+		PaypalAccount:         -100,
+		RenterAccount("r1"):   80,
+		EscrowAccount("c1"):   0,
+		ProviderAccount("p1"): 20,
+	}
+	total := int64(0)
+	for account, balance := range balances {
+		if balance != expected[account] {
+			t.Fatalf("%+v has balance %d. expected %d", account, balance, expected[account])
+		}
+		total += balance
+	}
+	if total != 0 {
+		t.Fatalf("balances sum to %d. expected 0", total)
+	}
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Size of the segments a block is split into to build its Merkle tree.
+	// Segments are kept small since proofs reveal them.
+	MerkleSegmentSize = 64
+
+	// Number of segments under each node of a block's Merkle tree
+	// that providers cache. It must be a power of two so that the
+	// cached nodes fall on a single level of the tree.
+	MerkleChunkSegments = 1 << 14
+
+	// Size of the part of a block under each cached node.
+	MerkleChunkSize = MerkleSegmentSize * MerkleChunkSegments
+)
+
+// MerkleProof proves that a segment is part of a block whose
+// Merkle root is known.
+type MerkleProof struct {
+	// Index of the segment in the block
+	Segment int `json:"segment"`
+	// The segment's contents
+	Data []byte `json:"data"`
+	// Hashes of the segment's siblings, from the leaves up to the root
+	Hashes [][]byte `json:"hashes"`
+}
+
+// NumMerkleSegments returns the number of leaves in the Merkle tree
+// of a block of the given size. Empty blocks have a single empty leaf.
+func NumMerkleSegments(blockSize int64) int {
+	if blockSize == 0 {
+		return 1
+	}
+	return int((blockSize + MerkleSegmentSize - 1) / MerkleSegmentSize)
+}
+
+func merkleLeafHash(segment []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(segment)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Returns the next level up of a Merkle tree. A node without a
+// sibling is promoted to the next level unchanged.
+func merkleLevel(nodes [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 < len(nodes) {
+			next = append(next, merkleNodeHash(nodes[i], nodes[i+1]))
+		} else {
+			next = append(next, nodes[i])
+		}
+	}
+	return next
+}
+
+func merkleRoot(nodes [][]byte) []byte {
+	for len(nodes) > 1 {
+		nodes = merkleLevel(nodes)
+	}
+	return nodes[0]
+}
+
+// Returns the sibling hashes needed to get from the node at idx to the root.
+func merklePath(nodes [][]byte, idx int) [][]byte {
+	path := [][]byte{}
+	for len(nodes) > 1 {
+		if idx%2 == 1 {
+			path = append(path, nodes[idx-1])
+		} else if idx+1 < len(nodes) {
+			path = append(path, nodes[idx+1])
+		}
+		nodes = merkleLevel(nodes)
+		idx /= 2
+	}
+	return path
+}
+
+func chunkLeaves(chunk []byte) [][]byte {
+	leaves := [][]byte{}
+	for off := 0; off < len(chunk); off += MerkleSegmentSize {
+		end := off + MerkleSegmentSize
+		if end > len(chunk) {
+			end = len(chunk)
+		}
+		leaves = append(leaves, merkleLeafHash(chunk[off:end]))
+	}
+	if len(leaves) == 0 {
+		leaves = append(leaves, merkleLeafHash(nil))
+	}
+	return leaves
+}
+
+// MerkleHasher computes the Merkle tree of the data written to it.
+// Only the nodes which providers cache are kept in memory.
+type MerkleHasher struct {
+	segment    []byte
+	leaves     [][]byte
+	chunkRoots [][]byte
+	finished   bool
+}
+
+func NewMerkleHasher() *MerkleHasher {
+	return &MerkleHasher{
+		segment: make([]byte, 0, MerkleSegmentSize),
+	}
+}
+
+func (h *MerkleHasher) Write(p []byte) (int, error) {
+	if h.finished {
+		return 0, errors.New("MerkleHasher: write after sum")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		need := MerkleSegmentSize - len(h.segment)
+		if need > len(p) {
+			need = len(p)
+		}
+		h.segment = append(h.segment, p[:need]...)
+		p = p[need:]
+		if len(h.segment) == MerkleSegmentSize {
+			h.addLeaf()
+		}
+	}
+	return n, nil
+}
+
+func (h *MerkleHasher) addLeaf() {
+	h.leaves = append(h.leaves, merkleLeafHash(h.segment))
+	h.segment = h.segment[:0]
+	if len(h.leaves) == MerkleChunkSegments {
+		h.chunkRoots = append(h.chunkRoots, merkleRoot(h.leaves))
+		h.leaves = nil
+	}
+}
+
+// ChunkRoots returns the roots of the subtrees over each MerkleChunkSize
+// part of the data. Nothing can be written to the hasher afterwards.
+func (h *MerkleHasher) ChunkRoots() [][]byte {
+	if !h.finished {
+		if len(h.segment) > 0 || (len(h.leaves) == 0 && len(h.chunkRoots) == 0) {
+			h.addLeaf()
+		}
+		if len(h.leaves) > 0 {
+			h.chunkRoots = append(h.chunkRoots, merkleRoot(h.leaves))
+			h.leaves = nil
+		}
+		h.finished = true
+	}
+	return h.chunkRoots
+}
+
+// Root returns the base64 encoded Merkle root of the data.
+// Nothing can be written to the hasher afterwards.
+func (h *MerkleHasher) Root() string {
+	return MerkleRootFromChunks(h.ChunkRoots())
+}
+
+// MerkleRootFromChunks returns the base64 encoded Merkle root
+// of a block given the roots returned by ChunkRoots.
+func MerkleRootFromChunks(chunkRoots [][]byte) string {
+	return base64.URLEncoding.EncodeToString(merkleRoot(chunkRoots))
+}
+
+// BuildMerkleProof builds a proof for a segment of a block given the
+// contents of the chunk of the block containing the segment and the
+// roots of all of the block's chunks.
+func BuildMerkleProof(chunk []byte, chunkRoots [][]byte, segment int) (*MerkleProof, error) {
+	chunkIdx := segment / MerkleChunkSegments
+	local := segment % MerkleChunkSegments
+	if segment < 0 || chunkIdx >= len(chunkRoots) {
+		return nil, fmt.Errorf("Segment %d is outside of block", segment)
+	}
+	leaves := chunkLeaves(chunk)
+	if local >= len(leaves) {
+		return nil, fmt.Errorf("Segment %d is outside of block", segment)
+	}
+	start := local * MerkleSegmentSize
+	end := start + MerkleSegmentSize
+	if end > len(chunk) {
+		end = len(chunk)
+	}
+	hashes := merklePath(leaves, local)
+	hashes = append(hashes, merklePath(chunkRoots, chunkIdx)...)
+	return &MerkleProof{
+		Segment: segment,
+		Data:    chunk[start:end],
+		Hashes:  hashes,
+	}, nil
+}
+
+// VerifyMerkleProof checks that a proof shows its segment is part of
+// a block of the given size with the given base64 encoded Merkle root.
+func VerifyMerkleProof(root string, blockSize int64, proof *MerkleProof) error {
+	n := NumMerkleSegments(blockSize)
+	idx := proof.Segment
+	if idx < 0 || idx >= n {
+		return fmt.Errorf("Segment %d is outside of block", idx)
+	}
+	expectedLen := int64(MerkleSegmentSize)
+	if idx == n-1 {
+		expectedLen = blockSize - int64(idx)*MerkleSegmentSize
+	}
+	if int64(len(proof.Data)) != expectedLen {
+		return errors.New("Segment has wrong length")
+	}
+	h := merkleLeafHash(proof.Data)
+	k := 0
+	for n > 1 {
+		if idx%2 == 1 || idx+1 < n {
+			if k >= len(proof.Hashes) {
+				return errors.New("Proof is too short")
+			}
+			if idx%2 == 1 {
+				h = merkleNodeHash(proof.Hashes[k], h)
+			} else {
+				h = merkleNodeHash(h, proof.Hashes[k])
+			}
+			k++
+		}
+		idx /= 2
+		n = (n + 1) / 2
+	}
+	if k != len(proof.Hashes) {
+		return errors.New("Proof is too long")
+	}
+	if base64.URLEncoding.EncodeToString(h) != root {
+		return errors.New("Proof does not match Merkle root")
+	}
+	return nil
+}
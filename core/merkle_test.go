@@ -0,0 +1,59 @@
+package core
+
+import (
+	"encoding/base64"
+	"math/rand"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for _, size := range []int{0, 1, MerkleSegmentSize, 5000, 2*MerkleChunkSize + 1000} {
+		data := make([]byte, size)
+		rand.Read(data)
+		h := NewMerkleHasher()
+		h.Write(data[:size/3])
+		h.Write(data[size/3:])
+		chunkRoots := h.ChunkRoots()
+		root := h.Root()
+
+		// Computing the tree in chunks should give the same root
+		// as computing it over all of the leaves at once.
+		if expected := base64.URLEncoding.EncodeToString(merkleRoot(chunkLeaves(data))); root != expected {
+			t.Fatalf("size %d: chunked root does not match full tree root", size)
+		}
+
+		n := NumMerkleSegments(int64(size))
+		for _, segment := range []int{0, n / 2, n - 1} {
+			chunkIdx := segment / MerkleChunkSegments
+			end := (chunkIdx + 1) * MerkleChunkSize
+			if end > size {
+				end = size
+			}
+			chunk := data[chunkIdx*MerkleChunkSize : end]
+			proof, err := BuildMerkleProof(chunk, chunkRoots, segment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = VerifyMerkleProof(root, int64(size), proof)
+			if err != nil {
+				t.Fatalf("size %d, segment %d: %s", size, segment, err)
+			}
+
+			if size == 0 {
+				continue
+			}
+			proof.Data[0] ^= 1
+			if VerifyMerkleProof(root, int64(size), proof) == nil {
+				t.Fatalf("size %d, segment %d: verify should fail - segment was modified", size, segment)
+			}
+			proof.Data[0] ^= 1
+			proof.Segment = (segment + 1) % n
+			if n > 1 && VerifyMerkleProof(root, int64(size), proof) == nil {
+				t.Fatalf("size %d, segment %d: verify should fail - wrong segment", size, segment)
+			}
+		}
+		if _, err := BuildMerkleProof(nil, chunkRoots, n); err == nil {
+			t.Fatalf("size %d: expected error proving segment past end of block", size)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// ProofRequest asks a provider to prove it stores segments of a block.
+// Proofs include the segments' data, so requests are signed with the
+// metaserver's audit key and only the metaserver can make them.
+type ProofRequest struct {
+	RenterId  string    `json:"renterId"`
+	BlockId   string    `json:"blockId"`
+	Segments  []int     `json:"segments"`
+	Time      time.Time `json:"time"`
+	Signature string    `json:"signature"`
+}
+
+// A proof request without the signature field,
+// with other fields sorted by name.
+type proofRequestTerms struct {
+	BlockId  string    `json:"blockId"`
+	RenterId string    `json:"renterId"`
+	Segments []int     `json:"segments"`
+	Time     time.Time `json:"time"`
+}
+
+func hashProofRequest(req *ProofRequest) ([]byte, error) {
+	p := proofRequestTerms{
+		BlockId:  req.BlockId,
+		RenterId: req.RenterId,
+		Segments: req.Segments,
+		Time:     req.Time,
+	}
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// SignProofRequest signs a proof request with the given key,
+// returning the base64 encoded signature.
+func SignProofRequest(req *ProofRequest, key *rsa.PrivateKey) (string, error) {
+	h, err := hashProofRequest(req)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), err
+}
+
+// VerifyProofRequest checks that a proof request's signature
+// matches its contents using the given key.
+func VerifyProofRequest(req *ProofRequest, key rsa.PublicKey) error {
+	h, err := hashProofRequest(req)
+	if err != nil {
+		return err
+	}
+	sb, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(&key, crypto.SHA256, h, sb)
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyProofRequest(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ProofRequest{
+		RenterId: "r1",
+		BlockId:  "b1",
+		Segments: []int{3, 1, 4},
+		Time:     time.Now(),
+	}
+	req.Signature, err = SignProofRequest(&req, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyProofRequest(&req, key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := req
+	forged.Segments = []int{3, 1, 5}
+	err = VerifyProofRequest(&forged, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - segments changed")
+	}
+
+	forged = req
+	forged.BlockId = "b2"
+	err = VerifyProofRequest(&forged, key.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - block changed")
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyProofRequest(&req, other.PublicKey)
+	if err == nil {
+		t.Fatal("verify should fail - signed with another key")
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// AuditRecord is the result of one audit of a block.
+type AuditRecord struct {
+	BlockID    string    `json:"blockId"`
+	FileID     string    `json:"fileId"`
+	ProviderID string    `json:"providerId"`
+	Time       time.Time `json:"time"`
+	Passed     bool      `json:"passed"`
+	// Why the audit failed, such as the provider being
+	// unreachable or sending an invalid proof.
+	Error string `json:"error,omitempty"`
+}
+
+// Reputation summarizes a provider's recent audit results.
+type Reputation struct {
+	// Weighted fraction of audits passed, between 0 and 1.
+	// Recent audits count for more than older ones.
+	Score     float64   `json:"score"`
+	Audits    int       `json:"audits"`
+	Failures  int       `json:"failures"`
+	LastAudit time.Time `json:"lastAudit"`
+}
+
+const (
+	// Audits older than this don't affect a provider's reputation.
+	ReputationWindow = 30 * 24 * time.Hour
+
+	// An audit's weight halves every ReputationHalfLife.
+	ReputationHalfLife = 7 * 24 * time.Hour
+
+	// Weight of the passed audit every provider starts with, so a
+	// single failure doesn't ruin a new provider's reputation.
+	reputationPrior = 1.0
+)
+
+// ComputeReputation scores a provider by the audits of its blocks
+// made within ReputationWindow of now.
+func ComputeReputation(audits []AuditRecord, now time.Time) Reputation {
+	rep := Reputation{}
+	passed := reputationPrior
+	total := reputationPrior
+	for _, audit := range audits {
+		age := now.Sub(audit.Time)
+		if age > ReputationWindow {
+			continue
+		}
+		if age < 0 {
+			age = 0
+		}
+		weight := math.Pow(0.5, float64(age)/float64(ReputationHalfLife))
+		total += weight
+		if audit.Passed {
+			passed += weight
+		} else {
+			rep.Failures++
+		}
+		rep.Audits++
+		if audit.Time.After(rep.LastAudit) {
+			rep.LastAudit = audit.Time
+		}
+	}
+	rep.Score = passed / total
+	return rep
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComputeReputation(t *testing.T) {
+	now := time.Now()
+
+	rep := ComputeReputation(nil, now)
+	if rep.Score != 1 || rep.Audits != 0 {
+		t.Fatalf("unaudited provider has reputation %+v", rep)
+	}
+
+	audits := []AuditRecord{
+		{Time: now.Add(-time.Hour), Passed: true},
+		{Time: now.Add(-2 * time.Hour), Passed: false},
+		{Time: now.Add(-ReputationWindow - time.Hour), Passed: false},
+	}
+	rep = ComputeReputation(audits, now)
+	if rep.Audits != 2 || rep.Failures != 1 {
+		t.Fatalf("expected 2 audits with 1 failure in window. got %+v", rep)
+	}
+	if !rep.LastAudit.Equal(audits[0].Time) {
+		t.Fatalf("last audit is %s. expected %s", rep.LastAudit, audits[0].Time)
+	}
+	if math.Abs(rep.Score-2.0/3) > 0.01 {
+		t.Fatalf("score is %f. expected about 0.67", rep.Score)
+	}
+
+	// A recent failure costs more than an old one.
+	recent := ComputeReputation([]AuditRecord{
+		{Time: now, Passed: false},
+		{Time: now.Add(-2 * ReputationHalfLife), Passed: true},
+	}, now)
+	old := ComputeReputation([]AuditRecord{
+		{Time: now, Passed: true},
+		{Time: now.Add(-2 * ReputationHalfLife), Passed: false},
+	}, now)
+	if recent.Score >= old.Score {
+		t.Fatalf("recent failure scored %f, old failure scored %f", recent.Score, old.Score)
+	}
+}
@@ -84,10 +84,12 @@ func registerProvider(client *metaserver.Client) (*core.ProviderInfo, error) {
 		panic("could not generate rsa key")
 	}
 
-	// provider that will be registered.
+	// provider that will be registered. Nothing listens at its address, so
+	// it's registered unverified. The metaserver must be run with
+	// -allow-private-providers for the loopback address to be accepted.
 	provider := core.ProviderInfo{
 		PublicKey:   publicKeyString,
-		Addr:        "foo",
+		Addr:        "127.0.0.1:1",
 		SpaceAvail:  500,
 		StorageRate: 5,
 	}
@@ -386,7 +388,9 @@ func TestUpdateProvider(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	provider.Addr = "thisisanew.address"
+	// Changing the address would make the metaserver verify the provider,
+	// which can't succeed since nothing listens at it.
+	provider.SpaceAvail = 1000
 	err = client.UpdateProvider(provider)
 	if err != nil {
 		t.Fatal(err)
@@ -554,7 +558,7 @@ func TestRenameFolder(t *testing.T) {
 
 	// Check that all of the files and folder inside the folder have been renamed.
 	expectedNames := []string{"notFoo", "notFoo/FolderRenameTest1", "notFoo/foo2/FolderRenameTest2", "notFoo/foo2"}
-	files, err := client.GetFiles(renter.ID)
+	files, err := client.GetFiles(renter.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -581,7 +585,7 @@ func TestRenameFolder(t *testing.T) {
 	}
 
 	expectedNames = []string{"notFoo", "notFoo/FolderRenameTest1", "notFoo/foo3/FolderRenameTest2", "notFoo/foo3"}
-	files, err = client.GetFiles(renter.ID)
+	files, err = client.GetFiles(renter.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -665,7 +669,7 @@ func TestRemoveFolder(t *testing.T) {
 
 	// Make sure the sub directory and its file are not in the output
 	removedNames := []string{"folderRemoveTest/foo3/FolderRemoveTest3", "folderRemoveTest/foo3"}
-	files, err := client.GetFiles(renter.ID)
+	files, err := client.GetFiles(renter.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -684,7 +688,7 @@ func TestRemoveFolder(t *testing.T) {
 	}
 
 	removedNames = []string{"folderRemoveTest", "folderRemoveTest/FolderRemoveTest1", "folderRemoveTest/foo2", "folderRemoveTest/foo2/FolderRemoveTest2"}
-	files, err = client.GetFiles(renter.ID)
+	files, err = client.GetFiles(renter.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -780,7 +784,7 @@ func TestGetFiles(t *testing.T) {
 	}
 
 	// Retrieve the files and make sure they are all present.
-	result, err := client.GetFiles(renter.ID)
+	result, err := client.GetFiles(renter.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1115,7 +1119,7 @@ func TestGetSharedFiles(t *testing.T) {
 	}
 
 	// Make sure the file shows up in the sharee's files
-	files, err := shareeClient.GetSharedFiles(sharedWith.ID)
+	files, err := shareeClient.GetSharedFiles(sharedWith.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1172,7 +1176,7 @@ func TestUnshareFile(t *testing.T) {
 	}
 
 	// Make sure the file doesn't show up in the sharee' directory.
-	files, err := shareeClient.GetSharedFiles(sharedWith.ID)
+	files, err := shareeClient.GetSharedFiles(sharedWith.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1232,7 +1236,7 @@ func TestRemoveSharedFile(t *testing.T) {
 	}
 
 	// Make sure the file doesn't show up in the user's directory.
-	files, err := shareeClient.GetSharedFiles(sharedWith.ID)
+	files, err := shareeClient.GetSharedFiles(sharedWith.ID, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1450,7 +1454,7 @@ func TestRenterGetFilesAuth(t *testing.T) {
 	}
 
 	// Attempt to access the first renter's files with the second renter.
-	_, err = otherRenterClient.GetFiles(renter.ID)
+	_, err = otherRenterClient.GetFiles(renter.ID, nil)
 	if err == nil {
 		t.Fatal("no error when accessing other renter's files")
 	}
@@ -2043,7 +2047,7 @@ func TestRenterGetSharedFilesAuth(t *testing.T) {
 	}
 
 	// Attempt to access the first renter's files with the second renter.
-	_, err = otherRenterClient.GetSharedFiles(renter.ID)
+	_, err = otherRenterClient.GetSharedFiles(renter.ID, nil)
 	if err == nil {
 		t.Fatal("no error when accessing other renter's shared files")
 	}
@@ -10,15 +10,22 @@ REPO_DIR="./repo"
 TEST_FILE_DIR="./files"
 RENTER_ALIAS="test"
 
+# Set METASERVER_DB=embedded to run the metaserver without MongoDB.
+METASERVER_DB=${METASERVER_DB:-mongo}
+
 echo "building skybin"
 cd .. && go build
 cd -
 
 echo "setting up database"
-mongo setup_db.js
+if [ "$METASERVER_DB" = "mongo" ]; then
+    mongo setup_db.js
+else
+    rm -f metaserver.db
+fi
 
 echo "starting metaserver"
-$SKYBIN_CMD metaserver -dash &
+$SKYBIN_CMD metaserver -dash -db $METASERVER_DB -allow-private-providers -registration-limit 0 &
 sleep 1
 
 echo "setting up sample skybin repo"
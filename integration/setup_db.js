@@ -14,3 +14,13 @@ db.contracts.createIndex({"id": 1}, {unique: true})
 
 // There should only be one payment object per contract.
 db.payments.createIndex({"contractid": 1}, {unique: true})
+
+// There should only be one egress record per contract.
+db.egress.createIndex({"contractid": 1}, {unique: true})
+
+// Audits are looked up by provider and time, and by block.
+db.audits.createIndex({"providerid": 1, "time": 1})
+db.audits.createIndex({"blockid": 1})
+
+// Transactions are listed by user and date.
+db.transactions.createIndex({"usertype": 1, "userid": 1, "date": 1})
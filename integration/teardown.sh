@@ -4,5 +4,9 @@ echo "killing services and removing files"
 ps -f | grep setup.sh | grep -v grep | awk '{print $2}' | xargs kill 
 rm -rf repo*
 rm -rf files
-mongo teardown_db.js
+if [ -f metaserver.db ]; then
+    rm -f metaserver.db
+else
+    mongo teardown_db.js
+fi
 
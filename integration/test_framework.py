@@ -292,7 +292,8 @@ class TestContext:
 def start_metaserver(api_addr=None, dashboard=False):
     """Start a new metaserver instance"""
     api_addr = api_addr or '127.0.0.1:{}'.format(rand_port())
-    args = [SKYBIN_CMD, 'metaserver', '-addr', api_addr]
+    args = [SKYBIN_CMD, 'metaserver', '-addr', api_addr, '-allow-private-providers',
+            '-registration-limit', '0']
     if dashboard:
         args.append('-dash')
     process = subprocess.Popen(args, stderr=subprocess.PIPE)
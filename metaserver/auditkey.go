@@ -0,0 +1,54 @@
+package metaserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"skybin/util"
+)
+
+// Name of the file in the data directory holding the key
+// the metaserver signs proof requests with.
+const auditKeyFile = "auditkey.pem"
+
+// Loads the metaserver's audit key, generating and saving
+// a new one if the data directory doesn't have one yet.
+func loadAuditKey(dataDir string) (*rsa.PrivateKey, error) {
+	keyPath := path.Join(dataDir, auditKeyFile)
+	data, err := ioutil.ReadFile(keyPath)
+	if err == nil {
+		return util.UnmarshalPrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(keyPath, util.MarshalPrivateKey(key), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+type getAuditKeyResp struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// Returns the public key providers check proof requests against.
+func (server *MetaServer) getAuditKeyHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pubKey, err := util.MarshalPublicKey(&server.auditKey.PublicKey)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		json.NewEncoder(w).Encode(&getAuditKeyResp{PublicKey: string(pubKey)})
+	})
+}
@@ -2,10 +2,15 @@ package metaserver
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
+	mrand "math/rand"
 	"net/http"
+	"skybin/core"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,6 +20,13 @@ import (
 // Currently, we can't use them from the provider package because it causes
 // an import cycle.
 
+// How long a provider has to answer an audit. A provider that doesn't
+// answer in time fails the audit, so one that hangs can't hold up an
+// audit worker.
+const auditTimeout = 30 * time.Second
+
+var auditClient = &http.Client{Timeout: auditTimeout}
+
 type postAuditParams struct {
 	Nonce string `json:"nonce"`
 }
@@ -24,14 +36,13 @@ type postAuditResp struct {
 }
 
 func auditBlock(addr, renterID, blockID, nonce string) (hash string, err error) {
-	client := http.Client{}
 	url := fmt.Sprintf("http://%s/blocks/audit?renterID=%s&blockID=%s", addr, renterID, blockID)
 	req := postAuditParams{nonce}
 	body, err := json.Marshal(&req)
 	if err != nil {
 		return "", err
 	}
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := auditClient.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return "", err
 	}
@@ -48,17 +59,136 @@ func auditBlock(addr, renterID, blockID, nonce string) (hash string, err error)
 	return respMsg.Hash, nil
 }
 
-func (server *MetaServer) startAuditRunner() {
-	// Frequency at which the runner should be triggered (should probably put this in a config file)
-	runnerFrequency := time.Minute * 5
+type postProofResp struct {
+	Proofs []*core.MerkleProof `json:"proofs"`
+}
+
+func proveBlock(addr string, req *core.ProofRequest) ([]*core.MerkleProof, error) {
+	url := fmt.Sprintf("http://%s/blocks/proof?renterID=%s&blockID=%s", addr, req.RenterId, req.BlockId)
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := auditClient.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+	var respMsg postProofResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return nil, err
+	}
+	return respMsg.Proofs, nil
+}
+
+// Number of randomly chosen segments a provider must prove it stores
+// for each audit of a block.
+const auditSegments = 4
+
+// Returned when a block can't be audited, such as a block uploaded before
+// Merkle roots were recorded with no precomputed audits left. These
+// audits aren't recorded, so they don't count against the provider.
+var errNotAuditable = errors.New("block cannot be audited")
+
+// Audits a block, returning nil if its provider still stores it
+// and otherwise why the audit failed.
+func (server *MetaServer) checkBlock(ownerID string, block *core.Block) error {
+	if block.MerkleRoot != "" {
+		return server.checkBlockProofs(ownerID, block)
+	}
+
+	// Blocks uploaded before Merkle roots were recorded
+	// fall back to their precomputed nonce audits.
+	if len(block.Audits) == 0 {
+		return errNotAuditable
+	}
+	audit := block.Audits[mrand.Intn(len(block.Audits))]
+	res, err := auditBlock(block.Location.Addr, ownerID, block.ID, audit.Nonce)
+	if err != nil {
+		return fmt.Errorf("unable to audit provider: %s", err)
+	}
+	if res != audit.ExpectedHash {
+		return errors.New("provider sent the wrong hash")
+	}
+	return nil
+}
 
+// Challenges a provider to prove it stores random segments of a block.
+// Segments are chosen with crypto/rand so providers can't predict them.
+// The request is signed with the audit key so the provider knows it's
+// from the metaserver.
+func (server *MetaServer) checkBlockProofs(ownerID string, block *core.Block) error {
+	numSegments := big.NewInt(int64(core.NumMerkleSegments(block.Size)))
+	segments := make([]int, auditSegments)
+	for i := range segments {
+		n, err := rand.Int(rand.Reader, numSegments)
+		if err != nil {
+			return errNotAuditable
+		}
+		segments[i] = int(n.Int64())
+	}
+	req := &core.ProofRequest{
+		RenterId: ownerID,
+		BlockId:  block.ID,
+		Segments: segments,
+		Time:     time.Now().UTC(),
+	}
+	signature, err := core.SignProofRequest(req, server.auditKey)
+	if err != nil {
+		return errNotAuditable
+	}
+	req.Signature = signature
+	proofs, err := proveBlock(block.Location.Addr, req)
+	if err != nil {
+		return fmt.Errorf("unable to audit provider: %s", err)
+	}
+	if len(proofs) != len(segments) {
+		return fmt.Errorf("provider sent %d proofs for %d segments", len(proofs), len(segments))
+	}
+	for i, proof := range proofs {
+		if proof == nil || proof.Segment != segments[i] {
+			return fmt.Errorf("provider sent no proof for segment %d", segments[i])
+		}
+		err = core.VerifyMerkleProof(block.MerkleRoot, block.Size, proof)
+		if err != nil {
+			return fmt.Errorf("invalid proof for segment %d: %s", segments[i], err)
+		}
+	}
+	return nil
+}
+
+const (
+	// Frequency at which the runner should be triggered.
+	auditRunnerFreq = time.Minute * 5
+
+	// Maximum number of blocks audited in each run. Blocks are sampled
+	// at random, so every block is audited eventually.
+	auditSampleSize = 500
+
+	// Number of audits run at once.
+	auditWorkers = 8
+
+	// Maximum number of audits started per second.
+	auditsPerSecond = 20
+
+	// How long audit records are kept for blocks' audit history.
+	auditHistoryRetention = 90 * 24 * time.Hour
+)
+
+func (server *MetaServer) startAuditRunner() {
 	// Ticker triggering the runner.
-	ticker := time.NewTicker(runnerFrequency)
+	ticker := time.NewTicker(auditRunnerFreq)
 
 	go func() {
 		for range ticker.C {
 			server.logger.Println("Running audits...")
 			err := server.runAudits()
+			recordRunnerRun("audit_runner", err)
 			if err != nil {
 				server.logger.Println("Error when running audits:", err)
 			}
@@ -66,54 +196,170 @@ func (server *MetaServer) startAuditRunner() {
 	}()
 }
 
+// A block chosen to be audited, along with the audit's result.
+type auditTask struct {
+	fileID  string
+	ownerID string
+	version int
+	block   *core.Block
+	err     error
+}
+
+// Makes a task for each of the sampled blocks.
+func auditTasks(blocks []sampledBlock) []*auditTask {
+	tasks := make([]*auditTask, len(blocks))
+	for i := range blocks {
+		tasks[i] = &auditTask{
+			fileID:  blocks[i].FileID,
+			ownerID: blocks[i].OwnerID,
+			version: blocks[i].Version,
+			block:   &blocks[i].Block,
+		}
+	}
+	return tasks
+}
+
+// Runs check on each task's block using the given number of workers,
+// starting at most perSecond checks each second.
+func runAuditTasks(tasks []*auditTask, workers int, perSecond int,
+	check func(ownerID string, block *core.Block) error) {
+
+	limiter := time.NewTicker(time.Second / time.Duration(perSecond))
+	defer limiter.Stop()
+
+	taskCh := make(chan *auditTask)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskCh {
+				task.err = check(task.ownerID, task.block)
+			}
+		}()
+	}
+	for _, task := range tasks {
+		<-limiter.C
+		taskCh <- task
+	}
+	close(taskCh)
+	wg.Wait()
+}
+
+// Audits a sample of the stored blocks, records the results in each
+// block's audit history, and updates the reputations of the providers
+// storing them.
 func (server *MetaServer) runAudits() error {
-	// Retrieve a list of all files on the server.
-	files, err := server.db.FindAllFiles()
+	blocks, err := server.db.SampleBlocks(auditSampleSize)
 	if err != nil {
 		return err
 	}
+	tasks := auditTasks(blocks)
+	runAuditTasks(tasks, auditWorkers, auditsPerSecond, server.checkBlock)
 
-	// For each file...
-	for _, file := range files {
-		// server.logger.Println("Auditing blocks for file", file.ID)
-
-		// Audit each version of the file...
-		for _, version := range file.Versions {
-			// server.logger.Println("Auditing version", version.Num)
+	now := time.Now()
+	audited := []*auditTask{}
+	providers := make(map[string]bool)
+	for _, task := range tasks {
+		if task.err == errNotAuditable {
+			continue
+		}
+		record := auditRecord(task.fileID, task.block, task.err, now)
+		err = server.db.InsertAuditRecord(record)
+		if err != nil {
+			return err
+		}
+		recordAuditOutcome(record.Passed)
+		audited = append(audited, task)
+		providers[record.ProviderID] = true
+	}
 
-			// Check that each block of the stored version is still stored properly.
-			for i, block := range version.Blocks {
-				// server.logger.Println("Auditing block", block.ID)
+	err = server.saveAuditResults(audited)
+	if err != nil {
+		return err
+	}
+	for providerID := range providers {
+		err = server.updateReputation(providerID, now)
+		if err != nil {
+			return err
+		}
+	}
+	return server.db.DeleteAuditsBefore(now.Add(-auditHistoryRetention))
+}
 
-				nonceToUse := rand.Intn(len(block.Audits))
-				audit := block.Audits[nonceToUse]
-				res, err := auditBlock(block.Location.Addr, file.OwnerID, block.ID, audit.Nonce)
-				if err != nil {
-					// server.logger.Println("Error auditing block:", err)
+func auditRecord(fileID string, block *core.Block, auditErr error, now time.Time) *core.AuditRecord {
+	record := &core.AuditRecord{
+		BlockID:    block.ID,
+		FileID:     fileID,
+		ProviderID: block.Location.ProviderId,
+		Time:       now,
+		Passed:     auditErr == nil,
+	}
+	if auditErr != nil {
+		record.Error = auditErr.Error()
+	}
+	return record
+}
 
-					version.Blocks[i].AuditPassed = false
-					continue
-				}
-				if res != audit.ExpectedHash {
-					// server.logger.Println("Audit failed")
-					version.Blocks[i].AuditPassed = false
-				} else {
-					// server.logger.Println("Audit passed")
-					version.Blocks[i].AuditPassed = true
+// Sets the audited blocks' AuditPassed flags. Files are read again, since
+// their owners may have changed them while the audits ran.
+func (server *MetaServer) saveAuditResults(tasks []*auditTask) error {
+	passed := make(map[string]map[string]bool)
+	for _, task := range tasks {
+		if passed[task.fileID] == nil {
+			passed[task.fileID] = make(map[string]bool)
+		}
+		passed[task.fileID][task.block.ID] = task.err == nil
+	}
+	for fileID, blocks := range passed {
+		file, err := server.db.FindFileByID(fileID)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, version := range file.Versions {
+			changed := false
+			for i, block := range version.Blocks {
+				if result, exists := blocks[block.ID]; exists {
+					version.Blocks[i].AuditPassed = result
+					changed = true
 				}
 			}
-			err := server.db.UpdateFileVersion(file.ID, &version)
-			if err != nil {
+			if !changed {
+				continue
+			}
+			err = server.db.UpdateFileVersion(file.ID, &version)
+			if err != nil && err != errNotFound {
 				return err
 			}
 		}
 	}
-
 	return nil
 }
 
+// Recomputes a provider's reputation from its recent audits.
+func (server *MetaServer) updateReputation(providerID string, now time.Time) error {
+	audits, err := server.db.FindAuditsByProvider(providerID, now.Add(-core.ReputationWindow))
+	if err != nil {
+		return err
+	}
+	reputation := core.ComputeReputation(audits, now)
+	err = server.db.UpdateProviderReputation(providerID, &reputation)
+	if err == errNotFound {
+		// The provider has been removed.
+		return nil
+	}
+	return err
+}
+
 type dashboardAuditResp struct {
 	Success bool `json:"success"`
+	// Why the audit failed.
+	Error string `json:"error,omitempty"`
+	// The block's audits, oldest first.
+	History []core.AuditRecord `json:"history"`
 }
 
 func (server *MetaServer) getDashboardAuditHandler() http.HandlerFunc {
@@ -134,23 +380,37 @@ func (server *MetaServer) getDashboardAuditHandler() http.HandlerFunc {
 		latestVersion := file.Versions[len(file.Versions)-1]
 		for i, block := range latestVersion.Blocks {
 			if block.ID == params["blockID"] {
-				nonceToUse := rand.Intn(len(block.Audits))
-				audit := block.Audits[nonceToUse]
-				res, err := auditBlock(block.Location.Addr, file.OwnerID, block.ID, audit.Nonce)
+				resp := dashboardAuditResp{}
+				auditErr := server.checkBlock(file.OwnerID, &block)
+				if auditErr == errNotAuditable {
+					writeErr(auditErr.Error(), http.StatusBadRequest, w)
+					return
+				}
+				record := auditRecord(file.ID, &block, auditErr, time.Now())
+				err = server.db.InsertAuditRecord(record)
 				if err != nil {
-					latestVersion.Blocks[i].AuditPassed = false
+					writeAndLogInternalError(err, w, server.logger)
+					return
 				}
-				if res != audit.ExpectedHash {
-					latestVersion.Blocks[i].AuditPassed = false
-				} else {
-					latestVersion.Blocks[i].AuditPassed = true
+				recordAuditOutcome(record.Passed)
+				err = server.updateReputation(record.ProviderID, record.Time)
+				if err != nil {
+					writeAndLogInternalError(err, w, server.logger)
+					return
 				}
+				latestVersion.Blocks[i].AuditPassed = record.Passed
 				err = server.db.UpdateFileVersion(file.ID, &latestVersion)
 				if err != nil {
 					writeAndLogInternalError(err, w, server.logger)
 					return
 				}
-				resp := dashboardAuditResp{latestVersion.Blocks[i].AuditPassed}
+				resp.History, err = server.db.FindAuditsByBlock(block.ID)
+				if err != nil {
+					writeAndLogInternalError(err, w, server.logger)
+					return
+				}
+				resp.Success = record.Passed
+				resp.Error = record.Error
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
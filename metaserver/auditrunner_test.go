@@ -0,0 +1,189 @@
+package metaserver
+
+import (
+	"errors"
+	"skybin/core"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testFiles() []core.File {
+	files := []core.File{}
+	for f := 0; f < 3; f++ {
+		file := core.File{ID: string(rune('a' + f)), OwnerID: "r1", Name: string(rune('a' + f))}
+		for v := 1; v <= 2; v++ {
+			version := core.Version{Num: v}
+			for b := 0; b < 4; b++ {
+				version.Blocks = append(version.Blocks, core.Block{
+					ID:       file.ID + string(rune('0'+v)) + string(rune('0'+b)),
+					Location: core.BlockLocation{ProviderId: "p" + string(rune('0'+b%2))},
+				})
+			}
+			file.Versions = append(file.Versions, version)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// Returns a task for every block of the files.
+func testTasks(files []core.File) []*auditTask {
+	blocks := []sampledBlock{}
+	for _, file := range files {
+		for _, version := range file.Versions {
+			for _, block := range version.Blocks {
+				blocks = append(blocks, sampledBlock{
+					FileID:  file.ID,
+					OwnerID: file.OwnerID,
+					Version: version.Num,
+					Block:   block,
+				})
+			}
+		}
+	}
+	return auditTasks(blocks)
+}
+
+func TestRunAuditTasks(t *testing.T) {
+	tasks := testTasks(testFiles())
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	check := func(ownerID string, block *core.Block) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if block.Location.ProviderId == "p1" {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+
+	start := time.Now()
+	runAuditTasks(tasks, 4, 200, check)
+	elapsed := time.Since(start)
+
+	// 24 audits at 200 a second take at least 24 * 5ms.
+	if elapsed < 100*time.Millisecond {
+		t.Fatalf("audits took %s. rate limit not applied", elapsed)
+	}
+	if maxRunning > 4 {
+		t.Fatalf("%d audits ran at once. expected at most 4", maxRunning)
+	}
+	for _, task := range tasks {
+		failed := task.block.Location.ProviderId == "p1"
+		if (task.err != nil) != failed {
+			t.Fatalf("block %s has audit error %v", task.block.ID, task.err)
+		}
+	}
+}
+
+func TestSaveAuditResults(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	for _, id := range []string{"p0", "p1"} {
+		err := server.db.InsertProvider(&core.ProviderInfo{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := testFiles()
+	for i := range files {
+		err := server.db.InsertFile(&files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	tasks := testTasks(files)
+	for _, task := range tasks {
+		if task.block.Location.ProviderId == "p1" {
+			task.err = errors.New("invalid proof")
+		}
+		err := server.db.InsertAuditRecord(auditRecord(task.fileID, task.block, task.err, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := server.saveAuditResults(tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"p0", "p1"} {
+		err = server.updateReputation(id, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := server.db.FindFileByID("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range file.Versions[1].Blocks {
+		expected := block.Location.ProviderId == "p0"
+		if block.AuditPassed != expected {
+			t.Fatalf("block %s has AuditPassed %v. expected %v", block.ID, block.AuditPassed, expected)
+		}
+	}
+
+	good, err := server.db.FindProviderByID("p0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := server.db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if good.Reputation == nil || bad.Reputation == nil {
+		t.Fatal("reputations not set")
+	}
+	if good.Reputation.Score != 1 || good.Reputation.Audits != 12 {
+		t.Fatalf("passing provider has reputation %+v", good.Reputation)
+	}
+	if bad.Reputation.Score > 0.1 || bad.Reputation.Failures != 12 {
+		t.Fatalf("failing provider has reputation %+v", bad.Reputation)
+	}
+
+	// Providers can't overwrite their reputations.
+	err = server.db.UpdateProvider(&core.ProviderInfo{ID: "p1", Reputation: &core.Reputation{Score: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err = server.db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad.Reputation.Score > 0.1 {
+		t.Fatal("provider update changed reputation")
+	}
+
+	history, err := server.db.FindAuditsByBlock(file.Versions[1].Blocks[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Passed || history[0].Error != "invalid proof" {
+		t.Fatalf("unexpected audit history %+v", history)
+	}
+	err = server.db.DeleteAuditsBefore(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err = server.db.FindAuditsByBlock(file.Versions[1].Blocks[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("found %d audits after deleting old audits", len(history))
+	}
+}
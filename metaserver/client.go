@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"skybin/authorization"
 	"skybin/core"
 )
@@ -34,6 +35,43 @@ func decodeError(r io.Reader) error {
 	return errors.New(respMsg.Error)
 }
 
+// Fetches one page of a file or transaction listing into out. Returns
+// the cursor for the next page, or an empty string on the last page.
+func (client *Client) getListingPage(path string, values url.Values, out interface{}) (string, error) {
+	if client.token == "" {
+		return "", errors.New("must authorize before calling this method")
+	}
+
+	u := fmt.Sprintf("http://%s%s", client.addr, path)
+	if len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", decodeError(resp.Body)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get(NextCursorHeader), nil
+}
+
 func (client *Client) IsAuthorized() bool {
 	// BUG(kincaid): Have this check if the token is expired.
 	return client.token != ""
@@ -82,26 +120,71 @@ func (client *Client) RegisterProvider(info *core.ProviderInfo) (*core.ProviderI
 	return &respMsg, nil
 }
 
-func (client *Client) GetProviders() ([]core.ProviderInfo, error) {
-	url := fmt.Sprintf("http://%s/providers", client.addr)
+// Lists every provider matching the query, fetching as many pages as
+// needed. A nil query lists all online providers.
+func (client *Client) GetProviders(query *ProviderQuery) ([]core.ProviderInfo, error) {
+	q := ProviderQuery{}
+	if query != nil {
+		q = *query
+	}
+	providers := []core.ProviderInfo{}
+	for {
+		page, next, err := client.GetProvidersPage(&q)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, page...)
+		if next == "" {
+			return providers, nil
+		}
+		q.Cursor = next
+	}
+}
+
+// Lists one page of the providers matching the query. Returns the
+// cursor for the next page, or an empty string on the last page.
+func (client *Client) GetProvidersPage(query *ProviderQuery) ([]core.ProviderInfo, string, error) {
+	url := fmt.Sprintf("http://%s/providers?%s", client.addr, query.values().Encode())
 
 	resp, err := client.client.Get(url)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+		return nil, "", decodeError(resp.Body)
 	}
 
 	var respMsg getProvidersResp
 	err = json.NewDecoder(resp.Body).Decode(&respMsg)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return respMsg.Providers, nil
+	return respMsg.Providers, respMsg.NextCursor, nil
+}
+
+// Returns the public key the metaserver signs proof requests with.
+func (client *Client) GetAuditKey() (string, error) {
+	url := fmt.Sprintf("http://%s/audit-key", client.addr)
+
+	resp, err := client.client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", decodeError(resp.Body)
+	}
+
+	var respMsg getAuditKeyResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return "", err
+	}
+	return respMsg.PublicKey, nil
 }
 
 func (client *Client) GetProvider(providerID string) (*core.ProviderInfo, error) {
@@ -159,6 +242,131 @@ func (client *Client) UpdateProvider(provider *core.ProviderInfo) error {
 	return nil
 }
 
+// Reports blocks the provider has lost. lost maps renter IDs to
+// the IDs of their lost blocks.
+func (client *Client) ReportLostBlocks(providerID string, lost map[string][]string) error {
+	if client.token == "" {
+		return errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/providers/%s/lost-blocks", client.addr, providerID)
+
+	b, err := json.Marshal(&postLostBlocksReq{Blocks: lost})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+
+	return nil
+}
+
+// Reports the bytes the provider has sent to renters. sent maps
+// renter IDs to the number of bytes of their blocks sent since
+// the provider last reported.
+func (client *Client) ReportEgress(providerID string, sent map[string]int64) error {
+	if client.token == "" {
+		return errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/providers/%s/egress", client.addr, providerID)
+
+	b, err := json.Marshal(&postEgressReq{Bytes: sent})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+
+	return nil
+}
+
+// Asks the metaserver to check that the provider is reachable at its
+// registered address and holds its key. The provider is only listed
+// once this succeeds.
+func (client *Client) VerifyProvider(providerID string) error {
+	if client.token == "" {
+		return errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/providers/%s/verify", client.addr, providerID)
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+
+	return nil
+}
+
+// Sends a signed heartbeat telling the metaserver the provider is
+// online. Heartbeats don't require authorization.
+func (client *Client) SendHeartbeat(hb *core.Heartbeat) error {
+	url := fmt.Sprintf("http://%s/providers/%s/heartbeat", client.addr, hb.ProviderId)
+
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+
+	return nil
+}
+
 func (client *Client) DeleteProvider(providerID string) error {
 	if client.token == "" {
 		return errors.New("must authorize before calling this method")
@@ -319,6 +527,70 @@ func (client *Client) DeleteRenter(renterID string) error {
 	return nil
 }
 
+func (client *Client) GetRenterBudget(renterID string) (*core.Budget, error) {
+	if client.token == "" {
+		return nil, errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/renters/%s/budget", client.addr, renterID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+
+	var budget core.Budget
+	err = json.NewDecoder(resp.Body).Decode(&budget)
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+func (client *Client) UpdateRenterBudget(renterID string, budget *core.Budget) error {
+	if client.token == "" {
+		return errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/renters/%s/budget", client.addr, renterID)
+
+	b, err := json.Marshal(budget)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+
+	return nil
+}
+
 func (client *Client) PostFile(renterID string, file *core.File) error {
 	if client.token == "" {
 		return errors.New("must authorize before calling this method")
@@ -418,37 +690,37 @@ func (client *Client) GetFile(renterID string, fileID string) (*core.File, error
 	return &file, nil
 }
 
-func (client *Client) GetFiles(renterID string) ([]*core.File, error) {
-	if client.token == "" {
-		return nil, errors.New("must authorize before calling this method")
-	}
-
-	url := fmt.Sprintf("http://%s/renters/%s/files", client.addr, renterID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	token := fmt.Sprintf("Bearer %s", client.token)
-	req.Header.Add("Authorization", token)
-
-	resp, err := client.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+// Lists every file in the renter's directory matching the query,
+// fetching as many pages as needed. A nil query lists whole files.
+func (client *Client) GetFiles(renterID string, query *FileQuery) ([]*core.File, error) {
+	q := FileQuery{}
+	if query != nil {
+		q = *query
+	}
+	files := []*core.File{}
+	for {
+		page, next, err := client.GetFilesPage(renterID, &q)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		if next == "" {
+			return files, nil
+		}
+		q.Cursor = next
 	}
+}
 
+// Lists one page of the files in the renter's directory matching the query.
+// Returns the cursor for the next page, or an empty string on the last page.
+func (client *Client) GetFilesPage(renterID string, query *FileQuery) ([]*core.File, string, error) {
 	var files []*core.File
-	err = json.NewDecoder(resp.Body).Decode(&files)
+	path := fmt.Sprintf("/renters/%s/files", renterID)
+	next, err := client.getListingPage(path, query.values(), &files)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	return files, nil
+	return files, next, nil
 }
 
 func (client *Client) DeleteFile(renterID string, fileID string) error {
@@ -705,16 +977,19 @@ func (client *Client) ShareFile(renterID string, fileID string, permission *core
 	return nil
 }
 
-// BUG(kincaid): Add methods for listing/getting/putting permissions
-
-func (client *Client) UnshareFile(renterID string, fileID string, userID string) error {
+func (client *Client) UpdateFilePermission(renterID string, fileID string, permission *core.Permission) error {
 	if client.token == "" {
 		return errors.New("must authorize before calling this method")
 	}
 
-	url := fmt.Sprintf("http://%s/renters/%s/files/%s/permissions/%s", client.addr, renterID, fileID, userID)
+	url := fmt.Sprintf("http://%s/renters/%s/files/%s/permissions/%s", client.addr, renterID, fileID, permission.RenterId)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	b, err := json.Marshal(permission)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -735,16 +1010,18 @@ func (client *Client) UnshareFile(renterID string, fileID string, userID string)
 	return nil
 }
 
-func (client *Client) GetSharedFiles(renterID string) ([]core.File, error) {
+// BUG(kincaid): Add methods for listing/getting permissions
+
+func (client *Client) UnshareFile(renterID string, fileID string, userID string) error {
 	if client.token == "" {
-		return nil, errors.New("must authorize before calling this method")
+		return errors.New("must authorize before calling this method")
 	}
 
-	url := fmt.Sprintf("http://%s/renters/%s/shared", client.addr, renterID)
+	url := fmt.Sprintf("http://%s/renters/%s/files/%s/permissions/%s", client.addr, renterID, fileID, userID)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	token := fmt.Sprintf("Bearer %s", client.token)
@@ -752,20 +1029,48 @@ func (client *Client) GetSharedFiles(renterID string) ([]core.File, error) {
 
 	resp, err := client.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+		return decodeError(resp.Body)
 	}
 
+	return nil
+}
+
+// Lists every file shared with the renter matching the query,
+// fetching as many pages as needed. A nil query lists whole files.
+func (client *Client) GetSharedFiles(renterID string, query *FileQuery) ([]core.File, error) {
+	q := FileQuery{}
+	if query != nil {
+		q = *query
+	}
+	files := []core.File{}
+	for {
+		page, next, err := client.GetSharedFilesPage(renterID, &q)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		if next == "" {
+			return files, nil
+		}
+		q.Cursor = next
+	}
+}
+
+// Lists one page of the files shared with the renter matching the query.
+// Returns the cursor for the next page, or an empty string on the last page.
+func (client *Client) GetSharedFilesPage(renterID string, query *FileQuery) ([]core.File, string, error) {
 	var files []core.File
-	err = json.NewDecoder(resp.Body).Decode(&files)
+	path := fmt.Sprintf("/renters/%s/shared", renterID)
+	next, err := client.getListingPage(path, query.values(), &files)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	return files, nil
+	return files, next, nil
 }
 
 func (client *Client) RemoveSharedFile(renterID string, fileID string) error {
@@ -923,6 +1228,45 @@ func (client *Client) DeleteContract(renterID string, contractID string) error {
 	return nil
 }
 
+// CancelContract submits a contract termination signed by both the renter
+// and provider, returning the amount refunded to the renter.
+func (client *Client) CancelContract(renterID string, termination *core.ContractTermination) (int64, error) {
+	if client.token == "" {
+		return 0, errors.New("must authorize before calling this method")
+	}
+
+	url := fmt.Sprintf("http://%s/renters/%s/contracts/%s/cancel", client.addr, renterID, termination.ContractID)
+
+	b, err := json.Marshal(termination)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+
+	token := fmt.Sprintf("Bearer %s", client.token)
+	req.Header.Add("Authorization", token)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeError(resp.Body)
+	}
+
+	var respMsg cancelContractResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return 0, err
+	}
+	return respMsg.Refund, nil
+}
+
 func (client *Client) CreatePaypalPayment(amount int64, returnURL, cancelURL string) (string, error) {
 	if client.token == "" {
 		return "", errors.New("must authorize before calling this method")
@@ -1085,68 +1429,68 @@ func (client *Client) ProviderWithdraw(providerID, email string, amount int64) e
 	return nil
 }
 
-func (client *Client) GetRenterTransactions(renterID string) ([]core.Transaction, error) {
-	if client.token == "" {
-		return nil, errors.New("must authorize before calling this method")
-	}
-
-	url := fmt.Sprintf("http://%s/renters/%s/transactions", client.addr, renterID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	token := fmt.Sprintf("Bearer %s", client.token)
-	req.Header.Add("Authorization", token)
-
-	resp, err := client.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+// Lists every transaction of the renter matching the query,
+// fetching as many pages as needed. A nil query lists all of them.
+func (client *Client) GetRenterTransactions(renterID string, query *TransactionQuery) ([]core.Transaction, error) {
+	q := TransactionQuery{}
+	if query != nil {
+		q = *query
+	}
+	transactions := []core.Transaction{}
+	for {
+		page, next, err := client.GetRenterTransactionsPage(renterID, &q)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+		if next == "" {
+			return transactions, nil
+		}
+		q.Cursor = next
 	}
+}
 
+// Lists one page of the renter's transactions matching the query. Returns
+// the cursor for the next page, or an empty string on the last page.
+func (client *Client) GetRenterTransactionsPage(renterID string, query *TransactionQuery) ([]core.Transaction, string, error) {
 	var transactions []core.Transaction
-	err = json.NewDecoder(resp.Body).Decode(&transactions)
+	path := fmt.Sprintf("/renters/%s/transactions", renterID)
+	next, err := client.getListingPage(path, query.values(), &transactions)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	return transactions, nil
+	return transactions, next, nil
 }
 
-func (client *Client) GetProviderTransactions(providerID string) ([]core.Transaction, error) {
-	if client.token == "" {
-		return nil, errors.New("must authorize before calling this method")
-	}
-
-	url := fmt.Sprintf("http://%s/providers/%s/transactions", client.addr, providerID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	token := fmt.Sprintf("Bearer %s", client.token)
-	req.Header.Add("Authorization", token)
-
-	resp, err := client.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+// Lists every transaction of the provider matching the query,
+// fetching as many pages as needed. A nil query lists all of them.
+func (client *Client) GetProviderTransactions(providerID string, query *TransactionQuery) ([]core.Transaction, error) {
+	q := TransactionQuery{}
+	if query != nil {
+		q = *query
+	}
+	transactions := []core.Transaction{}
+	for {
+		page, next, err := client.GetProviderTransactionsPage(providerID, &q)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+		if next == "" {
+			return transactions, nil
+		}
+		q.Cursor = next
 	}
+}
 
+// Lists one page of the provider's transactions matching the query. Returns
+// the cursor for the next page, or an empty string on the last page.
+func (client *Client) GetProviderTransactionsPage(providerID string, query *TransactionQuery) ([]core.Transaction, string, error) {
 	var transactions []core.Transaction
-	err = json.NewDecoder(resp.Body).Decode(&transactions)
+	path := fmt.Sprintf("/providers/%s/transactions", providerID)
+	next, err := client.getListingPage(path, query.values(), &transactions)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	return transactions, nil
+	return transactions, next, nil
 }
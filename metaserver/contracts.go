@@ -65,13 +65,6 @@ func (server *MetaServer) postContractHandler() http.HandlerFunc {
 			return
 		}
 
-		// Make sure the renter exists.
-		renter, err := server.db.FindRenterByID(params["renterID"])
-		if err != nil {
-			writeErr(err.Error(), http.StatusBadRequest, w)
-			return
-		}
-
 		if renterID, present := claims["renterID"]; !present || renterID.(string) != contract.RenterId {
 			writeErr("cannot post contracts for other users", http.StatusUnauthorized, w)
 			return
@@ -82,27 +75,46 @@ func (server *MetaServer) postContractHandler() http.HandlerFunc {
 		// MORE TEST CODE! Make the duration of the contract 1 week.
 		// contract.EndDate = time.Now().Add(time.Hour * 24 * 7)
 
+		// Hold the renter's lock until the contract's payment is recorded,
+		// so that the renter's concurrent contracts can't all pass the
+		// budget check. The renter is read under the lock so the check
+		// uses its current budget.
+		server.renterLocks.Lock(params["renterID"])
+		defer server.renterLocks.Unlock(params["renterID"])
+
+		// Make sure the renter exists.
+		renter, err := server.db.FindRenterByID(params["renterID"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
 		// Make sure the renter has enough money to pay for the contract.
 		if contract.StorageFee > renter.Balance {
 			writeErr("cannot afford contract", http.StatusBadRequest, w)
 			return
 		}
 
-		// Subtract the balance of the contract from the renter's account.
-		// TODO: Add atomic DB operations to increment and decrement renter balances.
-		renter.Balance -= contract.StorageFee
-		err = server.db.UpdateRenter(renter)
+		// Make sure the contract is within the renter's budget.
+		// Only this month's transactions count against it.
+		now := time.Now()
+		transactions, err := server.db.FindTransactionsByRenter(renter.ID, core.MonthStart(now), time.Time{})
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
 		}
+		spent := core.MonthlySpending(transactions, now)
+		err = renter.Budget.CheckContract(&contract, spent)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 
 		startTime := time.Now()
 
 		// Create a payment for the contract and insert it into the database.
 		payment := &core.PaymentInfo{
 			ContractID:      contract.ID,
-			Balance:         contract.StorageFee,
 			LastPaymentTime: startTime,
 			IsPaying:        true,
 		}
@@ -112,9 +124,30 @@ func (server *MetaServer) postContractHandler() http.HandlerFunc {
 			return
 		}
 
+		// Move the contract's fee from the renter's account into escrow.
+		err = server.transfer(core.RenterAccount(renter.ID), core.EscrowAccount(contract.ID),
+			contract.StorageFee, fmt.Sprintf("Storage fee for contract %s", contract.ID))
+		if err != nil {
+			server.db.DeletePayment(contract.ID)
+			if err == errInsufficientFunds {
+				writeErr("cannot afford contract", http.StatusBadRequest, w)
+			} else {
+				writeAndLogInternalError(err, w, server.logger)
+			}
+			return
+		}
+
 		contract.StartDate = startTime
 		err = server.db.InsertContract(&contract)
 		if err != nil {
+			// Give the renter their money back.
+			refundErr := server.transfer(core.EscrowAccount(contract.ID), core.RenterAccount(renter.ID),
+				contract.StorageFee, fmt.Sprintf("Refund for contract %s which couldn't be formed", contract.ID))
+			if refundErr != nil {
+				server.logger.Println("Unable to refund renter for unformed contract. error:", refundErr)
+			} else {
+				server.db.DeletePayment(contract.ID)
+			}
 			writeErr(err.Error(), http.StatusBadRequest, w)
 			return
 		}
@@ -322,3 +355,182 @@ func (server *MetaServer) putContractPaymentHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+type cancelContractResp struct {
+	// The amount refunded to the renter, in tenths of cents.
+	Refund int64 `json:"refund"`
+}
+
+func (server *MetaServer) cancelContractHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		var termination core.ContractTermination
+		err := json.NewDecoder(r.Body).Decode(&termination)
+		if err != nil {
+			writeErr("could not parse payload", http.StatusBadRequest, w)
+			return
+		}
+
+		// Hold the contract's lock until its payment is deleted, so the
+		// payment runner or a repeated cancellation can't pay the
+		// provider again.
+		server.contractLocks.Lock(params["contractID"])
+		defer server.contractLocks.Unlock(params["contractID"])
+
+		contract, err := server.db.FindContractByID(params["contractID"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+
+		// Make sure the person making the request is the renter who owns the contract.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if renterID, present := claims["renterID"]; !present || renterID.(string) != contract.RenterId {
+			writeErr("cannot cancel other users' contracts", http.StatusUnauthorized, w)
+			return
+		}
+
+		// Make sure both parties agreed to the termination.
+		if termination.ContractID != contract.ID ||
+			termination.RenterId != contract.RenterId ||
+			termination.ProviderId != contract.ProviderId {
+			writeErr("termination does not match contract", http.StatusBadRequest, w)
+			return
+		}
+		renterKey, err := server.getRenterPublicKey(contract.RenterId)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		err = core.VerifyTerminationSignature(&termination, termination.RenterSignature, *renterKey)
+		if err != nil {
+			writeErr("invalid renter signature", http.StatusBadRequest, w)
+			return
+		}
+		provider, err := server.db.FindProviderByID(contract.ProviderId)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		providerKey, err := util.UnmarshalPublicKey([]byte(provider.PublicKey))
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		err = core.VerifyTerminationSignature(&termination, termination.ProviderSignature, *providerKey)
+		if err != nil {
+			writeErr("invalid provider signature", http.StatusBadRequest, w)
+			return
+		}
+
+		payment, err := server.db.FindPaymentByContract(contract.ID)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		cancelTime := time.Now()
+
+		// Pay the provider for storage up to the cancellation. The contract
+		// is marked paid up to then before any money moves, so if this
+		// request fails partway, retrying it only refunds what's left.
+		var amountDue int64
+		if payment.IsPaying {
+			amountDue = calcPaymentDue(contract, payment, cancelTime)
+			payment.IsPaying = false
+			payment.LastPaymentTime = cancelTime
+			err = server.db.UpdatePayment(payment)
+			if err != nil {
+				writeAndLogInternalError(err, w, server.logger)
+				return
+			}
+		}
+		if amountDue > 0 {
+			err = server.transfer(core.EscrowAccount(contract.ID), core.ProviderAccount(provider.ID),
+				amountDue, fmt.Sprintf("Final payment for cancelled contract %s", contract.ID))
+			if err != nil {
+				writeAndLogInternalError(err, w, server.logger)
+				return
+			}
+			transaction := &core.Transaction{
+				UserType:        "provider",
+				UserID:          provider.ID,
+				ContractID:      contract.ID,
+				TransactionType: "receipt",
+				Amount:          amountDue,
+				Date:            cancelTime,
+				Description:     fmt.Sprintf("Final payment received for cancelled contract %s", contract.ID),
+			}
+			err = server.db.InsertTransaction(transaction)
+			if err != nil {
+				writeAndLogInternalError(err, w, server.logger)
+				return
+			}
+		}
+
+		// Refund the rest of the contract's balance to the renter. The payment
+		// is read again for the balance left after the final payment.
+		payment, err = server.db.FindPaymentByContract(contract.ID)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		refund := payment.Balance
+		renter, err := server.db.FindRenterByID(contract.RenterId)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		err = server.transfer(core.EscrowAccount(contract.ID), core.RenterAccount(renter.ID),
+			refund, fmt.Sprintf("Refund for cancelled contract %s", contract.ID))
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		transaction := &core.Transaction{
+			UserType:        "renter",
+			UserID:          renter.ID,
+			ContractID:      contract.ID,
+			TransactionType: "refund",
+			Amount:          refund,
+			Date:            cancelTime,
+			Description:     fmt.Sprintf("Refund for cancelled contract %s with %s", contract.ID, contract.ProviderId),
+		}
+		err = server.db.InsertTransaction(transaction)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+
+		err = server.db.DeletePayment(contract.ID)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		err = server.db.DeleteContract(contract.ID)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		transaction = &core.Transaction{
+			UserType:        "provider",
+			UserID:          provider.ID,
+			ContractID:      contract.ID,
+			TransactionType: "cancellation",
+			Amount:          0,
+			Date:            cancelTime,
+			Description:     fmt.Sprintf("Contract %s cancelled by %s", contract.ID, renter.ID),
+		}
+		err = server.db.InsertTransaction(transaction)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+
+		json.NewEncoder(w).Encode(cancelContractResp{Refund: refund})
+	})
+}
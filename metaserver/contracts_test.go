@@ -0,0 +1,197 @@
+package metaserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"skybin/core"
+	"skybin/util"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+func TestPostContractBudgetConcurrent(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	err := server.db.InsertRenter(&core.RenterInfo{
+		ID:     "r1",
+		Alias:  "alice",
+		Budget: core.Budget{MonthlyLimit: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.PaypalAccount, core.RenterAccount("r1"), 1000, "deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/renters/{renterID}/contracts", server.postContractHandler()).Methods("POST")
+	token := &jwt.Token{Claims: jwt.MapClaims{"renterID": "r1"}}
+
+	// Only one of the contracts fits in the monthly budget.
+	const numContracts = 10
+	var wg sync.WaitGroup
+	codes := make(chan int, numContracts)
+	for i := 0; i < numContracts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b, _ := json.Marshal(&core.Contract{
+				ID:         fmt.Sprintf("c%d", i),
+				RenterId:   "r1",
+				ProviderId: "p1",
+				StorageFee: 60,
+				EndDate:    time.Now().Add(24 * time.Hour),
+			})
+			req := httptest.NewRequest("POST", "/renters/r1/contracts", bytes.NewReader(b))
+			req = req.WithContext(context.WithValue(req.Context(), "user", token))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			codes <- w.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected 1 contract to be formed within the budget. %d were formed", created)
+	}
+	renter, err := server.db.FindRenterByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renter.Balance != 940 {
+		t.Fatalf("expected balance of 940. Got %d", renter.Balance)
+	}
+}
+
+func TestCancelContractPaysProviderOnce(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// The renter and provider share a key for simplicity.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice", PublicKey: string(pubKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.db.InsertProvider(&core.ProviderInfo{ID: "p1", PublicKey: string(pubKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	contract := &core.Contract{
+		ID:         "c1",
+		RenterId:   "r1",
+		ProviderId: "p1",
+		StorageFee: 1000,
+		StartDate:  now.Add(-24 * time.Hour),
+		EndDate:    now.Add(24 * time.Hour),
+	}
+	err = server.db.InsertContract(contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.db.InsertPayment(&core.PaymentInfo{
+		ContractID:      "c1",
+		LastPaymentTime: contract.StartDate,
+		IsPaying:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.PaypalAccount, core.EscrowAccount("c1"), 1000, "deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	termination := &core.ContractTermination{ContractID: "c1", RenterId: "r1", ProviderId: "p1", Date: now}
+	termination.RenterSignature, err = core.SignTermination(termination, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	termination.ProviderSignature = termination.RenterSignature
+
+	router := mux.NewRouter()
+	router.Handle("/renters/{renterID}/contracts/{contractID}/cancel", server.cancelContractHandler()).Methods("POST")
+	token := &jwt.Token{Claims: jwt.MapClaims{"renterID": "r1"}}
+	cancel := func() int {
+		b, _ := json.Marshal(termination)
+		req := httptest.NewRequest("POST", "/renters/r1/contracts/c1/cancel", bytes.NewReader(b))
+		req = req.WithContext(context.WithValue(req.Context(), "user", token))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Concurrent cancellations and payment runs only pay for the
+	// storage used before the contract was cancelled.
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			codes <- cancel()
+		}()
+		go func() {
+			defer wg.Done()
+			err := server.runPayments()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	cancelled := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			cancelled++
+		}
+	}
+	if cancelled != 1 {
+		t.Fatalf("expected the contract to be cancelled once. It was cancelled %d times", cancelled)
+	}
+
+	renter, err := server.db.FindRenterByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := server.db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renter.Balance+provider.Balance != 1000 {
+		t.Fatalf("expected the contract's 1000 to be split. Renter got %d and provider got %d",
+			renter.Balance, provider.Balance)
+	}
+	if provider.Balance < 490 || provider.Balance > 510 {
+		t.Fatalf("expected provider to be paid for half the contract. Got %d", provider.Balance)
+	}
+}
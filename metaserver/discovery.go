@@ -0,0 +1,271 @@
+package metaserver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"skybin/core"
+	"sort"
+	"strconv"
+)
+
+// ProviderSort is an order providers can be listed in.
+type ProviderSort string
+
+const (
+	// By ID. Used when no order is given.
+	SortByID ProviderSort = "id"
+
+	// Cheapest first.
+	SortByStorageRate ProviderSort = "storageRate"
+
+	// Most space available first.
+	SortBySpaceAvail ProviderSort = "spaceAvail"
+
+	// Highest uptime first.
+	SortByUptime ProviderSort = "uptime"
+
+	// Highest reputation first. Providers that haven't been
+	// audited rank with a perfect reputation.
+	SortByReputation ProviderSort = "reputation"
+)
+
+const (
+	// Providers listed per page if the query doesn't give a limit.
+	defaultProviderPageSize = 100
+
+	// Most providers listed per page.
+	maxProviderPageSize = 1000
+)
+
+// ProviderQuery selects the providers listed by GET /providers.
+// Zero values don't filter.
+type ProviderQuery struct {
+	// Minimum space available, in bytes.
+	MinSpace int64
+	// Maximum storage rate, in tenths-of-cents/gb/month.
+	MaxStorageRate int64
+	// Minimum uptime and reputation score, from 0 to 1. Providers the
+	// metaserver hasn't tracked or audited yet are given a chance.
+	MinUptime     float64
+	MinReputation float64
+	Region        string
+	// Also list providers that have missed their heartbeats.
+	IncludeOffline bool
+	// Also list providers whose address hasn't been verified, e.g. to
+	// check whether a provider storing blocks is still registered.
+	IncludeUnverified bool
+	Sort              ProviderSort
+	// Providers per page. Zero uses the default page size.
+	Limit int
+	// Where to continue listing from, as returned with the previous page.
+	Cursor string
+}
+
+// Encodes the query as GET /providers query parameters.
+func (q *ProviderQuery) values() url.Values {
+	v := url.Values{}
+	if q == nil {
+		return v
+	}
+	if q.MinSpace > 0 {
+		v.Set("minSpace", strconv.FormatInt(q.MinSpace, 10))
+	}
+	if q.MaxStorageRate > 0 {
+		v.Set("maxStorageRate", strconv.FormatInt(q.MaxStorageRate, 10))
+	}
+	if q.MinUptime > 0 {
+		v.Set("minUptime", strconv.FormatFloat(q.MinUptime, 'f', -1, 64))
+	}
+	if q.MinReputation > 0 {
+		v.Set("minReputation", strconv.FormatFloat(q.MinReputation, 'f', -1, 64))
+	}
+	if q.Region != "" {
+		v.Set("region", q.Region)
+	}
+	if q.IncludeOffline {
+		v.Set("includeOffline", "true")
+	}
+	if q.IncludeUnverified {
+		v.Set("includeUnverified", "true")
+	}
+	if q.Sort != "" {
+		v.Set("sort", string(q.Sort))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	return v
+}
+
+// Parses GET /providers query parameters.
+func parseProviderQuery(v url.Values) (*ProviderQuery, error) {
+	q := &ProviderQuery{
+		Region:            v.Get("region"),
+		IncludeOffline:    v.Get("includeOffline") == "true",
+		IncludeUnverified: v.Get("includeUnverified") == "true",
+		Sort:              ProviderSort(v.Get("sort")),
+		Cursor:            v.Get("cursor"),
+	}
+	var err error
+	ints := []struct {
+		name string
+		dest *int64
+	}{
+		{"minSpace", &q.MinSpace},
+		{"maxStorageRate", &q.MaxStorageRate},
+	}
+	for _, p := range ints {
+		if s := v.Get(p.name); s != "" {
+			*p.dest, err = strconv.ParseInt(s, 10, 64)
+			if err != nil || *p.dest < 0 {
+				return nil, fmt.Errorf("invalid %s", p.name)
+			}
+		}
+	}
+	floats := []struct {
+		name string
+		dest *float64
+	}{
+		{"minUptime", &q.MinUptime},
+		{"minReputation", &q.MinReputation},
+	}
+	for _, p := range floats {
+		if s := v.Get(p.name); s != "" {
+			*p.dest, err = strconv.ParseFloat(s, 64)
+			if err != nil || *p.dest < 0 || *p.dest > 1 {
+				return nil, fmt.Errorf("invalid %s", p.name)
+			}
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 0 {
+			return nil, errors.New("invalid limit")
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultProviderPageSize
+	}
+	if q.Limit > maxProviderPageSize {
+		q.Limit = maxProviderPageSize
+	}
+	if q.Sort == "" {
+		q.Sort = SortByID
+	}
+	switch q.Sort {
+	case SortByID, SortByStorageRate, SortBySpaceAvail, SortByUptime, SortByReputation:
+	default:
+		return nil, errors.New("invalid sort")
+	}
+	return q, nil
+}
+
+// Returns whether the provider is listed by the query.
+func (q *ProviderQuery) matches(p *core.ProviderInfo) bool {
+	if !(q.IncludeUnverified || p.Verified) || !(q.IncludeOffline || isOnline(p)) {
+		return false
+	}
+	if p.SpaceAvail < q.MinSpace {
+		return false
+	}
+	if q.MaxStorageRate > 0 && p.StorageRate > q.MaxStorageRate {
+		return false
+	}
+	if q.MinUptime > 0 && p.Liveness != nil && p.Liveness.Uptime < q.MinUptime {
+		return false
+	}
+	if q.MinReputation > 0 && p.Reputation != nil && p.Reputation.Score < q.MinReputation {
+		return false
+	}
+	if q.Region != "" && p.Region != q.Region {
+		return false
+	}
+	return true
+}
+
+// Providers are listed in order of their sort key, then their ID.
+// Scores are negated to list the highest first. Providers without a
+// score rank as if it were perfect, since new providers start there.
+func sortKey(p *core.ProviderInfo, order ProviderSort) float64 {
+	switch order {
+	case SortByStorageRate:
+		return float64(p.StorageRate)
+	case SortBySpaceAvail:
+		return -float64(p.SpaceAvail)
+	case SortByUptime:
+		if p.Liveness == nil {
+			return -1
+		}
+		return -p.Liveness.Uptime
+	case SortByReputation:
+		if p.Reputation == nil {
+			return -1
+		}
+		return -p.Reputation.Score
+	}
+	return 0
+}
+
+// A position in a listing of providers. Cursors hold the sort key and
+// ID of the last provider listed, so providers added or removed between
+// pages don't cause others to be skipped or repeated.
+type providerCursor struct {
+	Sort ProviderSort `json:"sort"`
+	Key  float64      `json:"key"`
+	ID   string       `json:"id"`
+}
+
+// Lists a page of the providers matching the query. Returns the cursor
+// for the next page, or an empty string if this is the last page.
+func listProviders(providers []core.ProviderInfo, q *ProviderQuery) ([]core.ProviderInfo, string, error) {
+	type entry struct {
+		key      float64
+		provider *core.ProviderInfo
+	}
+	entries := []entry{}
+	for i := range providers {
+		if q.matches(&providers[i]) {
+			entries = append(entries, entry{sortKey(&providers[i], q.Sort), &providers[i]})
+		}
+	}
+	before := func(key float64, id string, e entry) bool {
+		return key < e.key || (key == e.key && id < e.provider.ID)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return before(entries[i].key, entries[i].provider.ID, entries[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		var c providerCursor
+		err := decodeCursor(q.Cursor, &c)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Sort != q.Sort {
+			return nil, "", errors.New("cursor is for a different sort order")
+		}
+		start = sort.Search(len(entries), func(i int) bool {
+			return before(c.Key, c.ID, entries[i])
+		})
+	}
+	end := start + q.Limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	page := make([]core.ProviderInfo, 0, end-start)
+	for _, e := range entries[start:end] {
+		page = append(page, *e.provider)
+	}
+	next := ""
+	if end < len(entries) {
+		last := entries[end-1]
+		next = encodeCursor(&providerCursor{Sort: q.Sort, Key: last.key, ID: last.provider.ID})
+	}
+	return page, next, nil
+}
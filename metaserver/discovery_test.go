@@ -0,0 +1,169 @@
+package metaserver
+
+import (
+	"fmt"
+	"net/url"
+	"skybin/core"
+	"testing"
+)
+
+func testProviders() []core.ProviderInfo {
+	providers := []core.ProviderInfo{}
+	for i := 0; i < 10; i++ {
+		providers = append(providers, core.ProviderInfo{
+			ID:          fmt.Sprintf("p%d", i),
+			SpaceAvail:  int64(i) * 1000,
+			StorageRate: int64(10 - i),
+			Region:      []string{"us-west", "eu-central"}[i%2],
+			Verified:    true,
+			Liveness:    &core.Liveness{Online: i != 9, Uptime: float64(i) / 10},
+		})
+	}
+	providers[0].Verified = false
+	providers[3].Reputation = &core.Reputation{Score: 0.2}
+	return providers
+}
+
+func listAll(t *testing.T, providers []core.ProviderInfo, params string) []core.ProviderInfo {
+	v, err := url.ParseQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := parseProviderQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := []core.ProviderInfo{}
+	for {
+		page, next, err := listProviders(providers, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > q.Limit {
+			t.Fatalf("listed %d providers. limit is %d", len(page), q.Limit)
+		}
+		listed = append(listed, page...)
+		if next == "" {
+			return listed
+		}
+		q.Cursor = next
+	}
+}
+
+func ids(providers []core.ProviderInfo) string {
+	s := ""
+	for _, p := range providers {
+		s += p.ID + " "
+	}
+	return s
+}
+
+func TestListProviders(t *testing.T) {
+	providers := testProviders()
+	cases := []struct {
+		params   string
+		expected string
+	}{
+		// Unverified and offline providers aren't listed by default.
+		{"", "p1 p2 p3 p4 p5 p6 p7 p8 "},
+		{"includeOffline=true", "p1 p2 p3 p4 p5 p6 p7 p8 p9 "},
+		{"includeUnverified=true", "p0 p1 p2 p3 p4 p5 p6 p7 p8 "},
+		{"minSpace=5000", "p5 p6 p7 p8 "},
+		{"maxStorageRate=4", "p6 p7 p8 "},
+		{"minUptime=0.75", "p8 "},
+		{"minReputation=0.5", "p1 p2 p4 p5 p6 p7 p8 "},
+		{"region=us-west", "p2 p4 p6 p8 "},
+		{"sort=storageRate", "p8 p7 p6 p5 p4 p3 p2 p1 "},
+		{"sort=spaceAvail&limit=3", "p8 p7 p6 p5 p4 p3 p2 p1 "},
+		{"sort=uptime&limit=1", "p8 p7 p6 p5 p4 p3 p2 p1 "},
+		{"sort=reputation&limit=2", "p1 p2 p4 p5 p6 p7 p8 p3 "},
+		{"limit=3&region=eu-central&minSpace=2000", "p3 p5 p7 "},
+	}
+	for _, c := range cases {
+		listed := ids(listAll(t, providers, c.params))
+		if listed != c.expected {
+			t.Fatalf("%q listed %s. expected %s", c.params, listed, c.expected)
+		}
+	}
+}
+
+func TestListProvidersCursor(t *testing.T) {
+	providers := testProviders()
+	q, err := parseProviderQuery(url.Values{"limit": {"3"}, "sort": {"storageRate"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, next, err := listProviders(providers, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(page) != "p8 p7 p6 " || next == "" {
+		t.Fatalf("first page is %s", ids(page))
+	}
+
+	// Providers removed between pages don't cause others to be skipped.
+	q.Cursor = next
+	page, _, err = listProviders(append(providers[:6:6], providers[7:]...), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(page) != "p5 p4 p3 " {
+		t.Fatalf("second page is %s", ids(page))
+	}
+
+	q.Sort = SortByID
+	_, _, err = listProviders(providers, q)
+	if err == nil {
+		t.Fatal("used cursor with a different sort order")
+	}
+	q.Cursor = "garbage"
+	_, _, err = listProviders(providers, q)
+	if err == nil {
+		t.Fatal("used invalid cursor")
+	}
+}
+
+func TestParseProviderQuery(t *testing.T) {
+	invalid := []string{
+		"minSpace=abc",
+		"maxStorageRate=-1",
+		"minUptime=2",
+		"minReputation=x",
+		"limit=-5",
+		"sort=name",
+	}
+	for _, params := range invalid {
+		v, _ := url.ParseQuery(params)
+		_, err := parseProviderQuery(v)
+		if err == nil {
+			t.Fatalf("parsed invalid query %q", params)
+		}
+	}
+
+	q := &ProviderQuery{
+		MinSpace:          100,
+		MaxStorageRate:    5,
+		MinUptime:         0.5,
+		MinReputation:     0.25,
+		Region:            "us-west",
+		IncludeOffline:    true,
+		IncludeUnverified: true,
+		Sort:              SortByUptime,
+		Limit:             20,
+		Cursor:            "abc",
+	}
+	parsed, err := parseProviderQuery(q.values())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *q {
+		t.Fatalf("query %+v parsed as %+v", q, parsed)
+	}
+	parsed, err = parseProviderQuery(url.Values{"limit": {"100000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Limit != maxProviderPageSize {
+		t.Fatalf("limit %d above maximum", parsed.Limit)
+	}
+}
@@ -63,13 +63,26 @@ func (server *MetaServer) getFilesHandler() http.HandlerFunc {
 			writeErr(err.Error(), http.StatusNotFound, w)
 			return
 		}
+		query, err := ParseFileQuery(r.URL.Query())
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 		// Retrieve the renter's files.
-		files, err := server.db.FindFilesInRenterDirectory(renter.ID)
+		files, err := server.db.FindFilesInRenterDirectory(renter.ID, query.Prefix)
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
 		}
-		json.NewEncoder(w).Encode(files)
+		page, next, err := listFiles(files, query)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if next != "" {
+			w.Header().Set(NextCursorHeader, next)
+		}
+		json.NewEncoder(w).Encode(page)
 	})
 }
 
@@ -554,6 +567,10 @@ func (server *MetaServer) postFilePermissionHandler() http.HandlerFunc {
 
 		// Add the permission to the ACL
 		err = server.db.AddPermissionToFileACL(params["fileID"], &permission)
+		if err == errPermissionExists {
+			writeErr(err.Error(), http.StatusConflict, w)
+			return
+		}
 		if err != nil {
 			writeErr(err.Error(), http.StatusBadRequest, w)
 			return
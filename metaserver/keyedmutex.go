@@ -0,0 +1,45 @@
+package metaserver
+
+import "sync"
+
+// keyedMutex holds a separate lock for each key, e.g. each contract, so
+// updates to one record don't wait on updates to others. Its zero value
+// is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// Callers holding or waiting for the lock.
+	refs int
+}
+
+// Locks key, waiting until no one else holds it.
+func (m *keyedMutex) Lock(key string) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l, exists := m.locks[key]
+	if !exists {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+	l.Lock()
+}
+
+// Unlocks key, which must be locked.
+func (m *keyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.locks[key]
+	l.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+}
@@ -0,0 +1,230 @@
+package metaserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"skybin/core"
+	"time"
+)
+
+// Gateway account that test renters' starting balances come from.
+var testGatewayAccount = core.LedgerAccount{Type: core.AccountGateway, ID: "test"}
+
+// Gateway account that balances held before the ledger existed come from.
+var openingBalanceAccount = core.LedgerAccount{Type: core.AccountGateway, ID: "opening"}
+
+// Name of the migration that records balances held before the ledger existed.
+const openingBalancesMigration = "ledger-opening-balances"
+
+// Moves amount from one account to another, recording the movement in
+// the ledger. Moving nothing is a no-op, since ledger entries must be
+// for positive amounts.
+func (server *MetaServer) transfer(from, to core.LedgerAccount, amount int64, description string) error {
+	if amount == 0 {
+		return nil
+	}
+	entry := &core.LedgerEntry{
+		From:        from,
+		To:          to,
+		Amount:      amount,
+		Date:        time.Now(),
+		Description: description,
+	}
+	return server.db.PostLedgerEntry(entry)
+}
+
+// Kinds of problems found by reconciling the ledger.
+const (
+	// An account whose recorded balance differs from its balance
+	// according to the ledger
+	LedgerBalanceMismatch = "balance mismatch"
+
+	// An account other than a gateway with a negative balance
+	// according to the ledger
+	LedgerNegativeBalance = "negative balance"
+
+	// A ledger entry that isn't for a positive amount between two
+	// different accounts
+	LedgerInvalidEntry = "invalid entry"
+)
+
+// LedgerProblem is an inconsistency found by reconciling the ledger.
+type LedgerProblem struct {
+	Kind    string             `json:"kind"`
+	Account core.LedgerAccount `json:"account"`
+	// The balance stored with the account's renter, provider, or payment.
+	Recorded int64 `json:"recorded"`
+	// The balance according to the ledger.
+	Derived int64  `json:"derived"`
+	Detail  string `json:"detail"`
+}
+
+// LedgerReport compares the balances recorded for each account with
+// the balances derived from the ledger.
+type LedgerReport struct {
+	Entries int `json:"entries"`
+	// Total held in renter, provider, and escrow accounts.
+	Held int64 `json:"held"`
+	// Net money moved into skybin through gateways. Equals Held when
+	// no problems are found.
+	Deposited int64           `json:"deposited"`
+	Problems  []LedgerProblem `json:"problems"`
+}
+
+// Cross-checks the ledger against the balances of renters, providers, and
+// payments. Accounts whose record has been removed should be empty.
+//
+// Balances can change between reading the ledger and reading the records,
+// so a mismatch found while payments are being made should be confirmed
+// by running the check again.
+func (server *MetaServer) reconcileLedger() (*LedgerReport, error) {
+	entries, err := server.db.FindAllLedgerEntries()
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := server.recordedBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LedgerReport{Entries: len(entries), Problems: []LedgerProblem{}}
+	for i, e := range entries {
+		err = e.Validate()
+		if err != nil {
+			report.Problems = append(report.Problems, LedgerProblem{
+				Kind:   LedgerInvalidEntry,
+				Detail: fmt.Sprintf("entry %d: %s", i, err),
+			})
+		}
+	}
+
+	derived := core.LedgerBalances(entries)
+	for account, balance := range derived {
+		if account.Type == core.AccountGateway {
+			report.Deposited -= balance
+			continue
+		}
+		if _, exists := recorded[account]; !exists && balance != 0 {
+			report.Problems = append(report.Problems, LedgerProblem{
+				Kind:    LedgerBalanceMismatch,
+				Account: account,
+				Derived: balance,
+				Detail:  "account's record no longer exists",
+			})
+		}
+		if balance < 0 {
+			report.Problems = append(report.Problems, LedgerProblem{
+				Kind:    LedgerNegativeBalance,
+				Account: account,
+				Derived: balance,
+			})
+		}
+	}
+	for account, balance := range recorded {
+		report.Held += balance
+		if derived[account] != balance {
+			report.Problems = append(report.Problems, LedgerProblem{
+				Kind:     LedgerBalanceMismatch,
+				Account:  account,
+				Recorded: balance,
+				Derived:  derived[account],
+			})
+		}
+	}
+	return report, nil
+}
+
+// Returns the balances stored with renters, providers, and payments.
+func (server *MetaServer) recordedBalances() (map[core.LedgerAccount]int64, error) {
+	renters, err := server.db.FindAllRenters()
+	if err != nil {
+		return nil, err
+	}
+	providers, err := server.db.FindAllProviders()
+	if err != nil {
+		return nil, err
+	}
+	payments, err := server.db.FindAllPayments()
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[core.LedgerAccount]int64)
+	for _, renter := range renters {
+		recorded[core.RenterAccount(renter.ID)] = renter.Balance
+	}
+	for _, provider := range providers {
+		recorded[core.ProviderAccount(provider.ID)] = provider.Balance
+	}
+	for _, payment := range payments {
+		recorded[core.EscrowAccount(payment.ContractID)] = payment.Balance
+	}
+	return recorded, nil
+}
+
+// Records the balances renters, providers, and payments held before the
+// ledger existed, so they aren't reported as mismatches. Each account
+// gets an entry from openingBalanceAccount for the part of its balance
+// the ledger doesn't account for. This runs once, before the server
+// handles requests, so no balances change while it runs. If it's
+// interrupted, running it again only records what's still missing.
+func (server *MetaServer) recordOpeningBalances() error {
+	done, err := server.db.IsMigrationDone(openingBalancesMigration)
+	if err != nil || done {
+		return err
+	}
+	entries, err := server.db.FindAllLedgerEntries()
+	if err != nil {
+		return err
+	}
+	recorded, err := server.recordedBalances()
+	if err != nil {
+		return err
+	}
+	derived := core.LedgerBalances(entries)
+	for account, balance := range recorded {
+		opening := balance - derived[account]
+		if opening <= 0 {
+			continue
+		}
+		err = server.db.InsertOpeningBalance(&core.LedgerEntry{
+			From:        openingBalanceAccount,
+			To:          account,
+			Amount:      opening,
+			Date:        time.Now(),
+			Description: "opening balance",
+		})
+		if err != nil {
+			return err
+		}
+		server.logger.Printf("Recorded opening balance of %d for %s account %s\n",
+			opening, account.Type, account.ID)
+	}
+	return server.db.MarkMigrationDone(openingBalancesMigration)
+}
+
+// Runs the ledger check, logging any problems found.
+func (server *MetaServer) checkLedger() error {
+	report, err := server.reconcileLedger()
+	if err != nil {
+		return err
+	}
+	ledgerProblems.Set(float64(len(report.Problems)))
+	for _, problem := range report.Problems {
+		server.logger.Printf("ledger: %s in %s account %s (recorded %d, ledger %d) %s\n",
+			problem.Kind, problem.Account.Type, problem.Account.ID,
+			problem.Recorded, problem.Derived, problem.Detail)
+	}
+	return nil
+}
+
+func (server *MetaServer) getLedgerReportHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := server.reconcileLedger()
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,167 @@
+package metaserver
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"skybin/core"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*MetaServer, func()) {
+	dir, err := ioutil.TempDir("", "skybin_metaserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewEmbeddedStore(path.Join(dir, "meta.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	server := &MetaServer{db: db, logger: log.New(ioutil.Discard, "", 0)}
+	return server, func() {
+		db.CloseDB()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReconcileLedger(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	db := server.db
+	err := db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertProvider(&core.ProviderInfo{ID: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertPayment(&core.PaymentInfo{ContractID: "c1", IsPaying: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.PaypalAccount, core.RenterAccount("r1"), 100, "deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.RenterAccount("r1"), core.EscrowAccount("c1"), 60, "contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.EscrowAccount("c1"), core.ProviderAccount("p1"), 25, "payment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := server.reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("found problems in consistent ledger: %+v", report.Problems)
+	}
+	if report.Entries != 3 || report.Held != 100 || report.Deposited != 100 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// Money that appears without a ledger entry, and a provider removed
+	// while it still holds money, are both found.
+	err = db.InsertRenter(&core.RenterInfo{ID: "r2", Alias: "bob", Balance: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteProvider("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = server.reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[core.LedgerAccount]LedgerProblem{}
+	for _, problem := range report.Problems {
+		found[problem.Account] = problem
+	}
+	if len(found) != 2 {
+		t.Fatalf("found problems %+v. expected 2", report.Problems)
+	}
+	if p := found[core.RenterAccount("r2")]; p.Kind != LedgerBalanceMismatch || p.Recorded != 50 || p.Derived != 0 {
+		t.Fatalf("unexpected problem for unrecorded deposit: %+v", p)
+	}
+	if p := found[core.ProviderAccount("p1")]; p.Kind != LedgerBalanceMismatch || p.Derived != 25 {
+		t.Fatalf("unexpected problem for removed provider: %+v", p)
+	}
+}
+
+func TestRecordOpeningBalances(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// Balances held before the ledger existed have no entries.
+	db := server.db
+	err := db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice", Balance: 70})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertProvider(&core.ProviderInfo{ID: "p1", Balance: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.transfer(core.PaypalAccount, core.RenterAccount("r1"), 20, "deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.recordOpeningBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := server.reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("found problems after recording opening balances: %+v", report.Problems)
+	}
+	if report.Entries != 3 || report.Held != 120 || report.Deposited != 120 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// The migration only runs once, so later mismatches are still found.
+	err = db.InsertRenter(&core.RenterInfo{ID: "r2", Alias: "bob", Balance: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.recordOpeningBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = server.reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Account != core.RenterAccount("r2") {
+		t.Fatalf("expected a mismatch for r2. found %+v", report.Problems)
+	}
+}
+
+func TestTransferZeroAmount(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// Free contracts move nothing into escrow, so no entry is posted.
+	err := server.transfer(core.RenterAccount("r1"), core.EscrowAccount("c1"), 0, "free contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := server.db.FindAllLedgerEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("posted %d entries for zero amount", len(entries))
+	}
+}
@@ -0,0 +1,338 @@
+package metaserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"skybin/core"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header holding the cursor for the next page of a file or transaction
+// listing. It's only set when there are more pages.
+const NextCursorHeader = "Skybin-Next-Cursor"
+
+const (
+	// Files or transactions listed per page when a listing doesn't
+	// give a limit.
+	defaultListingPageSize = 100
+
+	// Most files or transactions listed per page.
+	maxListingPageSize = 1000
+)
+
+// Fields that can be selected when listing files. A file's ID is always
+// included. "versions" includes the versions without their blocks.
+var fileFields = map[string]bool{
+	"ownerId":         true,
+	"ownerAlias":      true,
+	"name":            true,
+	"isDir":           true,
+	"accessList":      true,
+	"aesKey":          true,
+	"aesIV":           true,
+	"versions":        true,
+	"versions.blocks": true,
+}
+
+// FileQuery selects the files listed by GET /renters/{id}/files and
+// GET /renters/{id}/shared. Files are listed by name.
+type FileQuery struct {
+	// Only list files whose names start with Prefix, e.g. a folder.
+	Prefix string
+	// Fields of each file to include. Empty includes whole files.
+	Fields []string
+	// Files per page. Zero uses the metaserver's default page size.
+	Limit int
+	// Where to continue listing from, as returned with the previous page.
+	Cursor string
+}
+
+// TransactionQuery selects the transactions listed by the renter and
+// provider transactions endpoints. Transactions are listed oldest first.
+type TransactionQuery struct {
+	// Only list transactions made at or after Since and before Until.
+	// Zero times don't filter.
+	Since time.Time
+	Until time.Time
+	// Transactions per page. Zero uses the metaserver's default page size.
+	Limit int
+	// Where to continue listing from, as returned with the previous page.
+	Cursor string
+}
+
+// Encodes the query as query parameters.
+func (q *FileQuery) values() url.Values {
+	v := url.Values{}
+	if q == nil {
+		return v
+	}
+	if q.Prefix != "" {
+		v.Set("prefix", q.Prefix)
+	}
+	if len(q.Fields) > 0 {
+		v.Set("fields", strings.Join(q.Fields, ","))
+	}
+	setPageValues(v, q.Limit, q.Cursor)
+	return v
+}
+
+// Encodes the query as query parameters.
+func (q *TransactionQuery) values() url.Values {
+	v := url.Values{}
+	if q == nil {
+		return v
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339Nano))
+	}
+	setPageValues(v, q.Limit, q.Cursor)
+	return v
+}
+
+func setPageValues(v url.Values, limit int, cursor string) {
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		v.Set("cursor", cursor)
+	}
+}
+
+func parsePageValues(v url.Values) (limit int, cursor string, err error) {
+	if s := v.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return 0, "", errors.New("invalid limit")
+		}
+	}
+	if limit == 0 {
+		limit = defaultListingPageSize
+	}
+	if limit > maxListingPageSize {
+		limit = maxListingPageSize
+	}
+	return limit, v.Get("cursor"), nil
+}
+
+// ParseFileQuery parses the query parameters of a file listing.
+func ParseFileQuery(v url.Values) (*FileQuery, error) {
+	q := &FileQuery{Prefix: v.Get("prefix")}
+	if s := v.Get("fields"); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			if field == "id" {
+				continue
+			}
+			if !fileFields[field] {
+				return nil, fmt.Errorf("invalid field %s", field)
+			}
+			q.Fields = append(q.Fields, field)
+		}
+	}
+	var err error
+	q.Limit, q.Cursor, err = parsePageValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// ParseTransactionQuery parses the query parameters of a transaction listing.
+// Times are in RFC 3339 format.
+func ParseTransactionQuery(v url.Values) (*TransactionQuery, error) {
+	q := &TransactionQuery{}
+	var err error
+	if s := v.Get("since"); s != "" {
+		q.Since, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, errors.New("invalid since")
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		q.Until, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, errors.New("invalid until")
+		}
+	}
+	q.Limit, q.Cursor, err = parsePageValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func encodeCursor(c interface{}) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, c interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	return nil
+}
+
+// Holds the name and ID of the last file listed.
+type fileCursor struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// Returns a copy of the file with only the given fields.
+func projectFile(f *core.File, fields []string) core.File {
+	if len(fields) == 0 {
+		return *f
+	}
+	selected := map[string]bool{}
+	for _, field := range fields {
+		selected[field] = true
+	}
+	p := core.File{ID: f.ID}
+	if selected["ownerId"] {
+		p.OwnerID = f.OwnerID
+	}
+	if selected["ownerAlias"] {
+		p.OwnerAlias = f.OwnerAlias
+	}
+	if selected["name"] {
+		p.Name = f.Name
+	}
+	if selected["isDir"] {
+		p.IsDir = f.IsDir
+	}
+	if selected["accessList"] {
+		p.AccessList = f.AccessList
+	}
+	if selected["aesKey"] {
+		p.AesKey = f.AesKey
+	}
+	if selected["aesIV"] {
+		p.AesIV = f.AesIV
+	}
+	if selected["versions"] || selected["versions.blocks"] {
+		p.Versions = make([]core.Version, len(f.Versions))
+		for i, v := range f.Versions {
+			if !selected["versions.blocks"] {
+				v.Blocks = nil
+			}
+			p.Versions[i] = v
+		}
+	}
+	return p
+}
+
+// Lists a page of the files matching the query. Returns the cursor
+// for the next page, or an empty string if this is the last page.
+func listFiles(files []core.File, q *FileQuery) ([]core.File, string, error) {
+	matched := []*core.File{}
+	for i := range files {
+		if strings.HasPrefix(files[i].Name, q.Prefix) {
+			matched = append(matched, &files[i])
+		}
+	}
+	before := func(name, id string, f *core.File) bool {
+		return name < f.Name || (name == f.Name && id < f.ID)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return before(matched[i].Name, matched[i].ID, matched[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		var c fileCursor
+		err := decodeCursor(q.Cursor, &c)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(matched), func(i int) bool {
+			return before(c.Name, c.ID, matched[i])
+		})
+	}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+
+	page := make([]core.File, 0, end-start)
+	for _, f := range matched[start:end] {
+		page = append(page, projectFile(f, q.Fields))
+	}
+	next := ""
+	if end < len(matched) {
+		last := matched[end-1]
+		next = encodeCursor(&fileCursor{Name: last.Name, ID: last.ID})
+	}
+	return page, next, nil
+}
+
+// Holds the date and ID of the last transaction listed.
+type transactionCursor struct {
+	Date time.Time `json:"date"`
+	ID   string    `json:"id"`
+}
+
+// Returns whether transaction a is listed before b. Transactions are
+// ordered by date, then by ID, matching the order the stores return.
+func transactionBefore(a, b *core.Transaction) bool {
+	if !a.Date.Equal(b.Date) {
+		return a.Date.Before(b.Date)
+	}
+	return a.ID < b.ID
+}
+
+// Lists a page of the transactions matching the query. Returns the cursor
+// for the next page, or an empty string if this is the last page.
+func listTransactions(transactions []core.Transaction, q *TransactionQuery) ([]core.Transaction, string, error) {
+	matched := []core.Transaction{}
+	for _, t := range transactions {
+		if !q.Since.IsZero() && t.Date.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !t.Date.Before(q.Until) {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return transactionBefore(&matched[i], &matched[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		var c transactionCursor
+		err := decodeCursor(q.Cursor, &c)
+		if err != nil {
+			return nil, "", err
+		}
+		last := &core.Transaction{Date: c.Date, ID: c.ID}
+		start = sort.Search(len(matched), func(i int) bool {
+			return transactionBefore(last, &matched[i])
+		})
+	}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+
+	page := matched[start:end]
+	next := ""
+	if end < len(matched) {
+		last := matched[end-1]
+		next = encodeCursor(&transactionCursor{Date: last.Date, ID: last.ID})
+	}
+	return page, next, nil
+}
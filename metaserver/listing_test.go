@@ -0,0 +1,204 @@
+package metaserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"skybin/core"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+func parseFileQuery(t *testing.T, params string) *FileQuery {
+	v, err := url.ParseQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParseFileQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestListFiles(t *testing.T) {
+	files := []core.File{
+		{ID: "f3", Name: "docs/b", Versions: []core.Version{{Blocks: []core.Block{{ID: "b1"}}}}},
+		{ID: "f1", Name: "docs/a", AesKey: "key"},
+		{ID: "f2", Name: "pics/a"},
+		{ID: "f5", Name: "docs/c"},
+		{ID: "f4", Name: "docs/c"},
+	}
+
+	q := parseFileQuery(t, "prefix=docs/&limit=2")
+	names := []string{}
+	for {
+		page, next, err := listFiles(files, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("page of %d files exceeds limit", len(page))
+		}
+		for _, f := range page {
+			names = append(names, f.Name+":"+f.ID)
+		}
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	expected := "[docs/a:f1 docs/b:f3 docs/c:f4 docs/c:f5]"
+	if fmt.Sprint(names) != expected {
+		t.Fatalf("expected %s, got %v", expected, names)
+	}
+
+	page, _, err := listFiles(files, parseFileQuery(t, "fields=name,versions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != len(files) {
+		t.Fatalf("expected %d files, got %d", len(files), len(page))
+	}
+	for _, f := range page {
+		if f.ID == "" || f.Name == "" {
+			t.Fatal("expected ID and name to be included")
+		}
+		if f.AesKey != "" {
+			t.Fatal("unselected field included")
+		}
+		for _, v := range f.Versions {
+			if v.Blocks != nil {
+				t.Fatal("blocks included without versions.blocks")
+			}
+		}
+	}
+
+	page, _, err = listFiles(files, parseFileQuery(t, "prefix=docs/b&fields=versions.blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || len(page[0].Versions[0].Blocks) != 1 {
+		t.Fatal("expected blocks to be included")
+	}
+}
+
+func TestGetSharedFilesPrefix(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	err := server.db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []core.File{
+		{ID: "f1", OwnerID: "r2", Name: "docs/report"},
+		{ID: "f2", OwnerID: "r2", Name: "pics/docs"},
+	} {
+		err = server.db.InsertFile(&f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.db.AddFileToRenterSharedDirectory("r1", f.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/renters/{renterID}/shared", server.getSharedFilesHandler()).Methods("GET")
+	token := &jwt.Token{Claims: jwt.MapClaims{"renterID": "r1"}}
+	req := httptest.NewRequest("GET", "/renters/r1/shared?prefix=docs", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", token))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var files []core.File
+	err = json.NewDecoder(w.Body).Decode(&files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].ID != "f1" || files[0].Name != "report" {
+		t.Fatalf("expected only docs/report, listed as report. got %+v", files)
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	base := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	transactions := []core.Transaction{}
+	for i := 0; i < 10; i++ {
+		// Pairs of transactions share a date, and are listed by ID.
+		transactions = append(transactions, core.Transaction{
+			ID:          fmt.Sprint(9 - i),
+			Description: fmt.Sprint(i),
+			Date:        base.Add(time.Duration(i/2) * time.Hour),
+		})
+	}
+
+	q := &TransactionQuery{
+		Since: base.Add(time.Hour),
+		Until: base.Add(4 * time.Hour),
+		Limit: 3,
+	}
+	listed := []string{}
+	for {
+		page, next, err := listTransactions(transactions, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range page {
+			listed = append(listed, tx.Description)
+		}
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	expected := "[3 2 5 4 7 6]"
+	if fmt.Sprint(listed) != expected {
+		t.Fatalf("expected %s, got %v", expected, listed)
+	}
+}
+
+func TestParseListingQueries(t *testing.T) {
+	invalidFiles := []string{"fields=password", "limit=-1", "limit=abc"}
+	for _, params := range invalidFiles {
+		v, _ := url.ParseQuery(params)
+		if _, err := ParseFileQuery(v); err == nil {
+			t.Errorf("expected error parsing %s", params)
+		}
+	}
+	invalidTransactions := []string{"since=yesterday", "until=2018-03-01", "limit=x"}
+	for _, params := range invalidTransactions {
+		v, _ := url.ParseQuery(params)
+		if _, err := ParseTransactionQuery(v); err == nil {
+			t.Errorf("expected error parsing %s", params)
+		}
+	}
+
+	_, _, err := listFiles(nil, &FileQuery{Cursor: "not a cursor"})
+	if err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+
+	v, _ := url.ParseQuery("limit=100000")
+	q, err := ParseFileQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != maxListingPageSize {
+		t.Errorf("expected limit to be capped at %d, got %d", maxListingPageSize, q.Limit)
+	}
+	q, err = ParseFileQuery(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != defaultListingPageSize {
+		t.Errorf("expected default limit of %d, got %d", defaultListingPageSize, q.Limit)
+	}
+}
@@ -0,0 +1,154 @@
+package metaserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"skybin/core"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How often providers are checked for missed heartbeats.
+	livenessCheckFreq = time.Minute
+
+	// Providers that haven't sent a heartbeat for this long are
+	// marked offline.
+	providerOfflineTimeout = 3 * time.Minute
+
+	// Heartbeats with times further than this from the metaserver's
+	// clock are rejected.
+	heartbeatMaxSkew = 5 * time.Minute
+
+	// Uptime is averaged over roughly this period.
+	uptimeWindow = 24 * time.Hour
+)
+
+// Returns whether the provider has sent a heartbeat recently.
+func isOnline(provider *core.ProviderInfo) bool {
+	return provider.Liveness != nil && provider.Liveness.Online
+}
+
+func (server *MetaServer) startLivenessRunner() {
+	// Ticker triggering the runner.
+	ticker := time.NewTicker(livenessCheckFreq)
+
+	go func() {
+		for range ticker.C {
+			err := server.checkLiveness(time.Now())
+			recordRunnerRun("liveness_check", err)
+			if err != nil {
+				server.logger.Println("Error when checking provider liveness:", err)
+			}
+		}
+	}()
+}
+
+// Marks providers that have missed their heartbeats as offline and
+// updates the uptime of every provider that has been seen online.
+func (server *MetaServer) checkLiveness(now time.Time) error {
+	server.livenessMu.Lock()
+	defer server.livenessMu.Unlock()
+
+	providers, err := server.db.FindAllProviders()
+	if err != nil {
+		return err
+	}
+	online := 0
+	for _, provider := range providers {
+		if provider.Liveness == nil {
+			continue
+		}
+		liveness := *provider.Liveness
+		liveness.Online = now.Sub(liveness.LastSeen) <= providerOfflineTimeout
+		if provider.Liveness.Online && !liveness.Online {
+			server.logger.Printf("Provider %s is offline. Last seen %s\n", provider.ID, liveness.LastSeen)
+		}
+		liveness.Uptime = nextUptime(liveness.Uptime, liveness.Online)
+		err = server.db.UpdateProviderLiveness(provider.ID, &liveness)
+		if err != nil {
+			return err
+		}
+		if liveness.Online {
+			online++
+		}
+	}
+	providersOnline.Set(float64(online))
+	return nil
+}
+
+// Moves uptime towards 1 if the provider is online, or towards 0 if
+// it isn't, by the fraction of uptimeWindow between liveness checks.
+func nextUptime(uptime float64, online bool) float64 {
+	const step = float64(livenessCheckFreq) / float64(uptimeWindow)
+	sample := 0.0
+	if online {
+		sample = 1
+	}
+	return uptime + (sample-uptime)*step
+}
+
+// Handles a heartbeat from a provider. Heartbeats are authenticated by
+// their signatures, so providers needn't log in to send them.
+func (server *MetaServer) postHeartbeatHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		var hb core.Heartbeat
+		err := json.NewDecoder(r.Body).Decode(&hb)
+		if err != nil {
+			writeErr("could not parse payload", http.StatusBadRequest, w)
+			return
+		}
+		if hb.ProviderId != params["id"] {
+			writeErr("heartbeat is for another provider", http.StatusBadRequest, w)
+			return
+		}
+		key, err := server.getProviderPublicKey(params["id"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+		err = core.VerifyHeartbeat(&hb, *key)
+		if err != nil {
+			writeErr("invalid heartbeat signature", http.StatusUnauthorized, w)
+			return
+		}
+		now := time.Now()
+		if hb.Time.Before(now.Add(-heartbeatMaxSkew)) || hb.Time.After(now.Add(heartbeatMaxSkew)) {
+			writeErr("heartbeat time is too far from the metaserver's clock", http.StatusBadRequest, w)
+			return
+		}
+
+		server.livenessMu.Lock()
+		defer server.livenessMu.Unlock()
+
+		provider, err := server.db.FindProviderByID(params["id"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+		// Providers start with full uptime.
+		liveness := core.Liveness{Uptime: 1}
+		if provider.Liveness != nil {
+			liveness = *provider.Liveness
+			if !hb.Time.After(liveness.LastHeartbeat) {
+				writeErr("heartbeat is older than the provider's last heartbeat", http.StatusBadRequest, w)
+				return
+			}
+		}
+		if !liveness.Online {
+			server.logger.Printf("Provider %s is online\n", provider.ID)
+		}
+		liveness.LastSeen = now
+		liveness.LastHeartbeat = hb.Time
+		liveness.Online = true
+		err = server.db.UpdateProviderLiveness(provider.ID, &liveness)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,146 @@
+package metaserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"skybin/core"
+	"skybin/util"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHeartbeats(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.db.InsertProvider(&core.ProviderInfo{ID: "p1", PublicKey: string(pubKey), Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/providers", server.getProvidersHandler()).Methods("GET")
+	router.Handle("/providers/{id}/heartbeat", server.postHeartbeatHandler()).Methods("POST")
+
+	sendHeartbeat := func(hb *core.Heartbeat) int {
+		b, err := json.Marshal(hb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/providers/p1/heartbeat", bytes.NewReader(b)))
+		return w.Code
+	}
+	listProviders := func(query string) []core.ProviderInfo {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/providers"+query, nil))
+		var resp getProvidersResp
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Providers
+	}
+
+	if len(listProviders("")) != 0 {
+		t.Fatal("listed provider before its first heartbeat")
+	}
+	if len(listProviders("?includeOffline=true")) != 1 {
+		t.Fatal("offline provider not listed with includeOffline")
+	}
+
+	hb := &core.Heartbeat{ProviderId: "p1", Time: time.Now()}
+	hb.Signature, err = core.SignHeartbeat(hb, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := sendHeartbeat(hb); code != http.StatusOK {
+		t.Fatalf("heartbeat returned %d", code)
+	}
+	if code := sendHeartbeat(hb); code != http.StatusBadRequest {
+		t.Fatalf("replayed heartbeat returned %d", code)
+	}
+	forged := &core.Heartbeat{ProviderId: "p1", Time: time.Now(), Signature: hb.Signature}
+	if code := sendHeartbeat(forged); code != http.StatusUnauthorized {
+		t.Fatalf("forged heartbeat returned %d", code)
+	}
+	stale := &core.Heartbeat{ProviderId: "p1", Time: time.Now().Add(-time.Hour)}
+	stale.Signature, err = core.SignHeartbeat(stale, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := sendHeartbeat(stale); code != http.StatusBadRequest {
+		t.Fatalf("stale heartbeat returned %d", code)
+	}
+
+	providers := listProviders("")
+	if len(providers) != 1 || !isOnline(&providers[0]) || providers[0].Liveness.Uptime != 1 {
+		t.Fatalf("expected provider online with full uptime. got %+v", providers)
+	}
+
+	// The provider stays online until it misses its heartbeats.
+	err = server.checkLiveness(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listProviders("")) != 1 {
+		t.Fatal("provider marked offline before timeout")
+	}
+	err = server.checkLiveness(time.Now().Add(providerOfflineTimeout + time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listProviders("")) != 0 {
+		t.Fatal("provider listed after missing heartbeats")
+	}
+	provider, err := server.db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Liveness.Uptime >= 1 {
+		t.Fatalf("uptime %f not lowered while offline", provider.Liveness.Uptime)
+	}
+
+	// Updating the provider doesn't change its liveness.
+	err = server.db.UpdateProvider(&core.ProviderInfo{ID: "p1", PublicKey: string(pubKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err = server.db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Liveness == nil {
+		t.Fatal("provider update cleared liveness")
+	}
+}
+
+func TestNextUptime(t *testing.T) {
+	uptime := 1.0
+	for i := 0; i < int(uptimeWindow/livenessCheckFreq); i++ {
+		uptime = nextUptime(uptime, false)
+	}
+	if uptime < 0.3 || uptime > 0.4 {
+		t.Fatalf("uptime after a window offline is %f. expected about 1/e", uptime)
+	}
+	for i := 0; i < 10; i++ {
+		uptime = nextUptime(uptime, true)
+	}
+	if uptime > 1 {
+		t.Fatalf("uptime %f above 1", uptime)
+	}
+}
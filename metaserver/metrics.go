@@ -0,0 +1,73 @@
+package metaserver
+
+import (
+	"skybin/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	auditOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "block_audits_total",
+		Help:      "Block audits by whether the provider passed.",
+	}, []string{"result"})
+
+	runnerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "runner_runs_total",
+		Help:      "Runs of the metaserver's runners by whether they succeeded.",
+	}, []string{"runner", "result"})
+
+	paymentsMade = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "payments_total",
+		Help:      "Contract payments made to providers by the payment runner.",
+	})
+
+	paymentAmount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "payment_amount_total",
+		Help:      "Total paid to providers by the payment runner, in tenths of cents.",
+	})
+
+	ledgerProblems = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "ledger_problems",
+		Help:      "Problems found by the last ledger reconciliation.",
+	})
+
+	providersOnline = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "metaserver",
+		Name:      "providers_online",
+		Help:      "Providers online at the last liveness check.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(auditOutcomes, runnerRuns, paymentsMade, paymentAmount, ledgerProblems, providersOnline)
+}
+
+func recordAuditOutcome(passed bool) {
+	if passed {
+		auditOutcomes.WithLabelValues("passed").Inc()
+	} else {
+		auditOutcomes.WithLabelValues("failed").Inc()
+	}
+}
+
+// Records the result of a run of one of the metaserver's runners.
+func recordRunnerRun(runner string, err error) {
+	if err != nil {
+		runnerRuns.WithLabelValues(runner, "error").Inc()
+		metrics.Errors.WithLabelValues("metaserver_" + runner).Inc()
+	} else {
+		runnerRuns.WithLabelValues(runner, "success").Inc()
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"regexp"
 	"skybin/core"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 )
 
 const dbName = "skybin"
-const dbAddress = "127.0.0.1"
+
+// DefaultMongoAddr is the address of the MongoDB server used by default.
+const DefaultMongoAddr = "127.0.0.1"
 
 type mongoDB struct {
 	session *mgo.Session
@@ -21,16 +24,52 @@ type fileVersion struct {
 	ID     string
 }
 
-func newMongoDB() (*mongoDB, error) {
-	session, err := mgo.Dial(dbAddress)
+func newMongoDB(addr string) (*mongoDB, error) {
+	session, err := mgo.Dial(addr)
 	if err != nil {
 		return nil, err
 	}
 
 	db := mongoDB{session: session}
+	err = db.ensureIndexes()
+	if err == nil {
+		err = db.recoverLedger()
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
 	return &db, nil
 }
 
+// Creates the indexes the store relies on if they don't exist yet.
+// These mirror the ones in integration/setup_db.js.
+func (db *mongoDB) ensureIndexes() error {
+	indexes := []struct {
+		collection string
+		index      mgo.Index
+	}{
+		// There's one egress record per contract. AddEgress relies on
+		// this to keep a contract's egress under its limit.
+		{"egress", mgo.Index{Key: []string{"contractid"}, Unique: true}},
+		// Audits are looked up by provider and time when computing
+		// reputations, and by block for a block's audit history.
+		{"audits", mgo.Index{Key: []string{"providerid", "time"}}},
+		{"audits", mgo.Index{Key: []string{"blockid"}}},
+		// Transactions are listed by user and date.
+		{"transactions", mgo.Index{Key: []string{"usertype", "userid", "date"}}},
+	}
+	session := db.session.Copy()
+	defer session.Close()
+	for _, i := range indexes {
+		err := session.DB(dbName).C(i.collection).EnsureIndex(i.index)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *mongoDB) CloseDB() {
 	db.session.Close()
 }
@@ -120,6 +159,23 @@ func (db *mongoDB) deleteInCollectionByID(collection string, ID string) error {
 	return nil
 }
 
+// Returns the fields of doc other than the excluded ones.
+func withoutFields(doc interface{}, excluded ...string) (bson.M, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	err = bson.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range excluded {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
 // Renter operations
 //==================
 
@@ -175,7 +231,12 @@ func (db *mongoDB) InsertRenter(renter *core.RenterInfo) error {
 
 // Update the provided renter in the databse.
 func (db *mongoDB) UpdateRenter(renter *core.RenterInfo) error {
-	err := db.updateInCollection("renters", renter.ID, renter)
+	// Only ledger entries change balances.
+	fields, err := withoutFields(renter, "balance")
+	if err != nil {
+		return err
+	}
+	err = db.updateInCollection("renters", renter.ID, bson.M{"$set": fields})
 	if err != nil {
 		return err
 	}
@@ -228,7 +289,13 @@ func (db *mongoDB) InsertProvider(provider *core.ProviderInfo) error {
 
 // Update the given provider in the databse.
 func (db *mongoDB) UpdateProvider(provider *core.ProviderInfo) error {
-	err := db.updateInCollection("providers", provider.ID, provider)
+	// Only ledger entries change balances, only audits change
+	// reputations, and only heartbeats change liveness.
+	fields, err := withoutFields(provider, "balance", "reputation", "liveness")
+	if err != nil {
+		return err
+	}
+	err = db.updateInCollection("providers", provider.ID, bson.M{"$set": fields})
 	if err != nil {
 		return err
 	}
@@ -244,6 +311,16 @@ func (db *mongoDB) DeleteProvider(providerID string) error {
 	return nil
 }
 
+// Set the provider's reputation.
+func (db *mongoDB) UpdateProviderReputation(providerID string, reputation *core.Reputation) error {
+	return db.updateInCollection("providers", providerID, bson.M{"$set": bson.M{"reputation": reputation}})
+}
+
+// Set the provider's liveness.
+func (db *mongoDB) UpdateProviderLiveness(providerID string, liveness *core.Liveness) error {
+	return db.updateInCollection("providers", providerID, bson.M{"$set": bson.M{"liveness": liveness}})
+}
+
 // File operations
 //====================
 
@@ -270,8 +347,8 @@ func (db *mongoDB) FindFileByID(fileID string) (*core.File, error) {
 	return &result, nil
 }
 
-// Return a map of paths to files present in the renter's directory.
-func (db *mongoDB) FindFilesInRenterDirectory(renterID string) ([]core.File, error) {
+// Return the files in the renter's directory whose names start with prefix.
+func (db *mongoDB) FindFilesInRenterDirectory(renterID string, prefix string) ([]core.File, error) {
 	session := db.session.Copy()
 	defer session.Close()
 
@@ -289,6 +366,9 @@ func (db *mongoDB) FindFilesInRenterDirectory(renterID string) ([]core.File, err
 	// Retrieve files from collection.
 	files := session.DB(dbName).C("files")
 	fileSelector := bson.M{"id": bson.M{"$in": filesToFind}}
+	if prefix != "" {
+		fileSelector["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	}
 	var foundFiles []core.File
 	err = files.Find(fileSelector).All(&foundFiles)
 	if err != nil {
@@ -301,8 +381,8 @@ func (db *mongoDB) FindFilesInRenterDirectory(renterID string) ([]core.File, err
 	return foundFiles, nil
 }
 
-// Return a map of names to files shared with a given renter.
-func (db *mongoDB) FindFilesSharedWithRenter(renterID string) ([]core.File, error) {
+// Return the files shared with a given renter whose names start with prefix.
+func (db *mongoDB) FindFilesSharedWithRenter(renterID string, prefix string) ([]core.File, error) {
 	session := db.session.Copy()
 	defer session.Close()
 
@@ -320,6 +400,9 @@ func (db *mongoDB) FindFilesSharedWithRenter(renterID string) ([]core.File, erro
 	// Retrieve files from collection.
 	files := session.DB(dbName).C("files")
 	fileSelector := bson.M{"id": bson.M{"$in": filesToFind}}
+	if prefix != "" {
+		fileSelector["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	}
 	var foundFiles []core.File
 	err = files.Find(fileSelector).All(&foundFiles)
 	if err != nil {
@@ -401,7 +484,7 @@ func (db *mongoDB) FindFilesByOwner(renterID string) ([]core.File, error) {
 	defer session.Close()
 
 	var result []core.File
-	selector := struct{ ownerID string }{ownerID: renterID}
+	selector := bson.M{"ownerid": renterID}
 	err = c.Find(selector).All(&result)
 	if err != nil {
 		return nil, err
@@ -568,6 +651,17 @@ func (db *mongoDB) AddPermissionToFileACL(fileID string, permission *core.Permis
 	selector := bson.M{"id": fileID, "accesslist.renterid": bson.M{"$ne": permission.RenterId}}
 	push := bson.M{"$push": bson.M{"accesslist": permission}}
 	err := files.Update(selector, push)
+	if err == mgo.ErrNotFound {
+		// Either the file doesn't exist or it's already shared with the renter.
+		n, err := files.Find(bson.M{"id": fileID}).Count()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return errPermissionExists
+		}
+		return errNotFound
+	}
 	if err != nil {
 		return err
 	}
@@ -702,7 +796,7 @@ func (db *mongoDB) FindPaymentByContract(contractID string) (*core.PaymentInfo,
 
 	c := session.DB(dbName).C("payments")
 
-	selector := bson.M{"contract": contractID}
+	selector := bson.M{"contractid": contractID}
 	var result core.PaymentInfo
 	err := c.Find(selector).One(&result)
 	if err != nil {
@@ -726,9 +820,14 @@ func (db *mongoDB) UpdatePayment(payment *core.PaymentInfo) error {
 	session := db.session.Copy()
 	defer session.Close()
 
+	// Only ledger entries change balances.
+	fields, err := withoutFields(payment, "balance")
+	if err != nil {
+		return err
+	}
 	c := session.DB(dbName).C("payments")
 	selector := bson.M{"contractid": payment.ContractID}
-	err := c.Update(selector, payment)
+	err = c.Update(selector, bson.M{"$set": fields})
 	if err != nil {
 		return err
 	}
@@ -741,7 +840,7 @@ func (db *mongoDB) DeletePayment(contractID string) error {
 	defer session.Close()
 
 	c := session.DB(dbName).C("payments")
-	selector := bson.M{"contract": contractID}
+	selector := bson.M{"contractid": contractID}
 	err := c.Remove(selector)
 	if err != nil {
 		return err
@@ -754,53 +853,526 @@ func (db *mongoDB) DeletePayment(contractID string) error {
 
 // Return a list of all transactions in the database.
 func (db *mongoDB) FindAllTransactions() ([]core.Transaction, error) {
-	result := make([]core.Transaction, 0)
-	err := db.findAllFromCollection("transactions", &result)
-	if err != nil {
-		return nil, err
+	return db.findTransactions(bson.M{})
+}
+
+// Find the transactions associated with given renter made at or
+// after since and before until. Zero times don't filter.
+func (db *mongoDB) FindTransactionsByRenter(renterID string, since, until time.Time) ([]core.Transaction, error) {
+	selector := bson.M{"usertype": "renter", "userid": renterID}
+	if date := dateRange(since, until); len(date) > 0 {
+		selector["date"] = date
 	}
-	return result, nil
+	return db.findTransactions(selector)
 }
 
-// Find the transactions associated with given renter.
-func (db *mongoDB) FindTransactionsByRenter(renterID string) ([]core.Transaction, error) {
+// Find the transactions associated with given provider made at or
+// after since and before until. Zero times don't filter.
+func (db *mongoDB) FindTransactionsByProvider(providerID string, since, until time.Time) ([]core.Transaction, error) {
+	selector := bson.M{"usertype": "provider", "userid": providerID}
+	if date := dateRange(since, until); len(date) > 0 {
+		selector["date"] = date
+	}
+	return db.findTransactions(selector)
+}
+
+// mongoTransaction is a transaction as it's stored in MongoDB. The
+// transaction's ID is the hex form of its _id.
+type mongoTransaction struct {
+	ID               bson.ObjectId `bson:"_id"`
+	core.Transaction `bson:",inline"`
+}
+
+// Finds the transactions matching selector, ordered by date and then _id
+// so that listings can page through them with a stable cursor.
+func (db *mongoDB) findTransactions(selector bson.M) ([]core.Transaction, error) {
 	session := db.session.Copy()
 	defer session.Close()
 
 	c := session.DB(dbName).C("transactions")
 
-	selector := bson.M{"usertype": "renter", "userid": renterID}
-	result := make([]core.Transaction, 0)
-	err := c.Find(selector).All(&result)
+	var docs []mongoTransaction
+	err := c.Find(selector).Sort("date", "_id").All(&docs)
 	if err != nil {
 		return nil, err
 	}
+	result := make([]core.Transaction, 0, len(docs))
+	for _, doc := range docs {
+		transaction := doc.Transaction
+		transaction.ID = doc.ID.Hex()
+		result = append(result, transaction)
+	}
+	return result, nil
+}
+
+// Returns a selector matching dates at or after since and before until.
+// Zero times don't filter.
+func dateRange(since, until time.Time) bson.M {
+	date := bson.M{}
+	if !since.IsZero() {
+		date["$gte"] = since
+	}
+	if !until.IsZero() {
+		date["$lt"] = until
+	}
+	return date
+}
+
+// Insert the given transaction into the database.
+func (db *mongoDB) InsertTransaction(transaction *core.Transaction) error {
+	err := db.insertIntoCollection("transactions", transaction)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Egress operations
+//==================
 
+// Return the egress records of all contracts that charge for downloads.
+func (db *mongoDB) FindAllEgress() ([]core.EgressInfo, error) {
+	result := make([]core.EgressInfo, 0)
+	err := db.findAllFromCollection("egress", &result)
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
-// Find the transactions associated with given provider.
-func (db *mongoDB) FindTransactionsByProvider(providerID string) ([]core.Transaction, error) {
+// Record bytes sent under the given contract, creating its egress
+// record if it doesn't exist yet. The record is only updated if it
+// hasn't changed since it was read, so concurrent reports can't
+// together take the contract's egress above the limit.
+func (db *mongoDB) AddEgress(contract *core.Contract, bytes int64, limit int64) (int64, error) {
 	session := db.session.Copy()
 	defer session.Close()
 
-	c := session.DB(dbName).C("transactions")
+	c := session.DB(dbName).C("egress")
+	_, err := c.Upsert(bson.M{"contractid": contract.ID}, bson.M{
+		"$setOnInsert": bson.M{
+			"providerid":    contract.ProviderId,
+			"renterid":      contract.RenterId,
+			"unbilledbytes": 0,
+			"billedbytes":   0,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var egress core.EgressInfo
+		err = c.Find(bson.M{"contractid": contract.ID}).One(&egress)
+		if err != nil {
+			return 0, err
+		}
+		added := egressAllowed(&egress, bytes, limit)
+		if added == 0 {
+			return 0, nil
+		}
+		selector := bson.M{
+			"contractid":    contract.ID,
+			"unbilledbytes": egress.UnbilledBytes,
+			"billedbytes":   egress.BilledBytes,
+		}
+		err = c.Update(selector, bson.M{"$inc": bson.M{"unbilledbytes": added}})
+		if err == mgo.ErrNotFound {
+			// Another report changed the record. Try again.
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return added, nil
+	}
+}
 
-	selector := bson.M{"usertype": "provider", "userid": providerID}
-	result := make([]core.Transaction, 0)
-	err := c.Find(selector).All(&result)
+// Move bytes of the contract's egress from unbilled to billed.
+func (db *mongoDB) BillEgress(contractID string, bytes int64) error {
+	session := db.session.Copy()
+	defer session.Close()
+
+	c := session.DB(dbName).C("egress")
+	selector := bson.M{"contractid": contractID}
+	update := bson.M{"$inc": bson.M{"unbilledbytes": -bytes, "billedbytes": bytes}}
+	return c.Update(selector, update)
+}
+
+// Ledger operations
+//==================
+
+// Returns the collection and selector of the record holding an
+// account's balance, or an empty collection name for gateway
+// accounts, which have no record.
+func ledgerAccountRecord(account core.LedgerAccount) (string, bson.M) {
+	switch account.Type {
+	case core.AccountRenter:
+		return "renters", bson.M{"id": account.ID}
+	case core.AccountProvider:
+		return "providers", bson.M{"id": account.ID}
+	case core.AccountEscrow:
+		return "payments", bson.M{"contractid": account.ID}
+	}
+	return "", nil
+}
+
+// States of a ledger entry while it's posted. A pending entry may have
+// been applied to some of its accounts' balances. A committed entry has
+// been applied to all of them, but its accounts may still list it as
+// pending. A done entry is fully posted.
+const (
+	ledgerPending   = "pending"
+	ledgerCommitted = "committed"
+	ledgerDone      = "done"
+)
+
+// mongoLedgerEntry is a ledger entry as it's stored in MongoDB. Entries
+// posted before entries had states have no state, and count as done.
+type mongoLedgerEntry struct {
+	ID               bson.ObjectId `bson:"_id"`
+	State            string        `bson:"state"`
+	core.LedgerEntry `bson:",inline"`
+}
+
+// Adds amount to the account's balance as part of the entry with the
+// given ID. Balances are never taken below zero. An account's record
+// lists the pending entries applied to it, so applying an entry again
+// has no effect.
+func (db *mongoDB) applyToBalance(entryID bson.ObjectId, account core.LedgerAccount, amount int64) error {
+	collection, selector := ledgerAccountRecord(account)
+	if collection == "" {
+		return nil
+	}
+	c, session, err := db.getMongoCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	guarded := bson.M{"balance": bson.M{"$gte": -amount}, "pendingledger": bson.M{"$ne": entryID}}
+	applied := bson.M{"pendingledger": entryID}
+	for k, v := range selector {
+		guarded[k] = v
+		applied[k] = v
+	}
+	update := bson.M{
+		"$inc":  bson.M{"balance": amount},
+		"$push": bson.M{"pendingledger": entryID},
+	}
+	err = c.Update(guarded, update)
+	if err == mgo.ErrNotFound {
+		n, err := c.Find(applied).Count()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		n, err = c.Find(selector).Count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errNotFound
+		}
+		return errInsufficientFunds
+	}
+	return err
+}
+
+// Undoes applyToBalance. Does nothing if the entry wasn't applied to
+// the account.
+func (db *mongoDB) revertBalance(entryID bson.ObjectId, account core.LedgerAccount, amount int64) error {
+	collection, selector := ledgerAccountRecord(account)
+	if collection == "" {
+		return nil
+	}
+	c, session, err := db.getMongoCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	applied := bson.M{"pendingledger": entryID}
+	for k, v := range selector {
+		applied[k] = v
+	}
+	update := bson.M{
+		"$inc":  bson.M{"balance": -amount},
+		"$pull": bson.M{"pendingledger": entryID},
+	}
+	err = c.Update(applied, update)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Removes a committed entry from the account's pending entries.
+func (db *mongoDB) clearPendingEntry(entryID bson.ObjectId, account core.LedgerAccount) error {
+	collection, selector := ledgerAccountRecord(account)
+	if collection == "" {
+		return nil
+	}
+	c, session, err := db.getMongoCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	err = c.Update(selector, bson.M{"$pull": bson.M{"pendingledger": entryID}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Move the entry's amount between its accounts and record it.
+//
+// MongoDB can't update several documents atomically, so the entry is
+// posted in steps. It's recorded as pending, applied to each account's
+// balance, marked committed, and then marked done once its accounts no
+// longer list it as pending. Each step can be repeated safely, so
+// recoverLedger can finish or undo entries left partway through.
+func (db *mongoDB) PostLedgerEntry(entry *core.LedgerEntry) error {
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+	doc := mongoLedgerEntry{
+		ID:          bson.NewObjectId(),
+		State:       ledgerPending,
+		LedgerEntry: *entry,
+	}
+	err = db.insertIntoCollection("ledger", &doc)
+	if err != nil {
+		return err
+	}
+	err = db.applyToBalance(doc.ID, doc.From, -doc.Amount)
+	if err == nil {
+		err = db.applyToBalance(doc.ID, doc.To, doc.Amount)
+	}
+	if err != nil {
+		// If the entry can't be cancelled now, recoverLedger cancels it
+		// when the metaserver next starts.
+		db.cancelLedgerEntry(&doc)
+		return err
+	}
+	return db.commitLedgerEntry(&doc)
+}
+
+// Undoes a pending entry's changes to its accounts' balances and
+// deletes it.
+func (db *mongoDB) cancelLedgerEntry(doc *mongoLedgerEntry) error {
+	err := db.revertBalance(doc.ID, doc.To, doc.Amount)
+	if err != nil {
+		return err
+	}
+	err = db.revertBalance(doc.ID, doc.From, -doc.Amount)
+	if err != nil {
+		return err
+	}
+	c, session, err := db.getMongoCollection("ledger")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	err = c.Remove(bson.M{"_id": doc.ID, "state": ledgerPending})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Marks an entry that's been applied to all its accounts as committed,
+// and then as done once its accounts no longer list it as pending.
+func (db *mongoDB) commitLedgerEntry(doc *mongoLedgerEntry) error {
+	c, session, err := db.getMongoCollection("ledger")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	err = c.Update(bson.M{"_id": doc.ID, "state": ledgerPending},
+		bson.M{"$set": bson.M{"state": ledgerCommitted}})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	err = db.clearPendingEntry(doc.ID, doc.From)
+	if err != nil {
+		return err
+	}
+	err = db.clearPendingEntry(doc.ID, doc.To)
+	if err != nil {
+		return err
+	}
+	return c.Update(bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"state": ledgerDone}})
+}
+
+// Finishes posting the entries the metaserver stopped partway through.
+// Pending entries are cancelled, since their callers never saw them
+// succeed, and committed entries are marked done. It must run before
+// any entries are posted.
+func (db *mongoDB) recoverLedger() error {
+	c, session, err := db.getMongoCollection("ledger")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var unfinished []mongoLedgerEntry
+	selector := bson.M{"state": bson.M{"$in": []string{ledgerPending, ledgerCommitted}}}
+	err = c.Find(selector).All(&unfinished)
+	if err != nil {
+		return err
+	}
+	for i := range unfinished {
+		doc := &unfinished[i]
+		if doc.State == ledgerPending {
+			err = db.cancelLedgerEntry(doc)
+		} else {
+			err = db.commitLedgerEntry(doc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record an entry without changing any balances.
+func (db *mongoDB) InsertOpeningBalance(entry *core.LedgerEntry) error {
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+	doc := mongoLedgerEntry{
+		ID:          bson.NewObjectId(),
+		State:       ledgerDone,
+		LedgerEntry: *entry,
+	}
+	return db.insertIntoCollection("ledger", &doc)
+}
+
+// Return all ledger entries in the order they were posted.
+// Pending entries haven't been posted yet, so they're left out.
+func (db *mongoDB) FindAllLedgerEntries() ([]core.LedgerEntry, error) {
+	c, session, err := db.getMongoCollection("ledger")
 	if err != nil {
 		return nil, err
 	}
+	defer session.Close()
 
+	result := make([]core.LedgerEntry, 0)
+	err = c.Find(bson.M{"state": bson.M{"$ne": ledgerPending}}).All(&result)
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
-// Insert the given transaction into the database.
-func (db *mongoDB) InsertTransaction(transaction *core.Transaction) error {
-	err := db.insertIntoCollection("transactions", transaction)
+// Migration operations
+//=====================
+
+// Return whether the named migration has been run.
+func (db *mongoDB) IsMigrationDone(name string) (bool, error) {
+	c, session, err := db.getMongoCollection("migrations")
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	n, err := c.Find(bson.M{"name": name}).Count()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Record that the named migration has been run.
+func (db *mongoDB) MarkMigrationDone(name string) error {
+	c, session, err := db.getMongoCollection("migrations")
 	if err != nil {
 		return err
 	}
-	return nil
+	defer session.Close()
+
+	_, err = c.Upsert(bson.M{"name": name}, bson.M{"$set": bson.M{"name": name}})
+	return err
+}
+
+// Audit operations
+//=================
+
+// Return up to n blocks chosen at random. The blocks are sampled by
+// MongoDB, so files aren't loaded into the metaserver.
+func (db *mongoDB) SampleBlocks(n int) ([]sampledBlock, error) {
+	c, session, err := db.getMongoCollection("files")
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	pipeline := []bson.M{
+		{"$unwind": "$versions"},
+		{"$unwind": "$versions.blocks"},
+		{"$sample": bson.M{"size": n}},
+		{"$project": bson.M{
+			"_id":     0,
+			"fileid":  "$id",
+			"ownerid": "$ownerid",
+			"version": "$versions.num",
+			"block":   "$versions.blocks",
+		}},
+	}
+	result := make([]sampledBlock, 0)
+	err = c.Pipe(pipeline).All(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Insert the result of an audit.
+func (db *mongoDB) InsertAuditRecord(record *core.AuditRecord) error {
+	return db.insertIntoCollection("audits", record)
+}
+
+// Return the audits of a provider's blocks made at or after since.
+func (db *mongoDB) FindAuditsByProvider(providerID string, since time.Time) ([]core.AuditRecord, error) {
+	session := db.session.Copy()
+	defer session.Close()
+
+	c := session.DB(dbName).C("audits")
+	selector := bson.M{"providerid": providerID, "time": bson.M{"$gte": since}}
+	result := make([]core.AuditRecord, 0)
+	err := c.Find(selector).Sort("time").All(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Return the audits of a block.
+func (db *mongoDB) FindAuditsByBlock(blockID string) ([]core.AuditRecord, error) {
+	session := db.session.Copy()
+	defer session.Close()
+
+	c := session.DB(dbName).C("audits")
+	result := make([]core.AuditRecord, 0)
+	err := c.Find(bson.M{"blockid": blockID}).Sort("time").All(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Delete audits made before t.
+func (db *mongoDB) DeleteAuditsBefore(t time.Time) error {
+	session := db.session.Copy()
+	defer session.Close()
+
+	c := session.DB(dbName).C("audits")
+	_, err := c.RemoveAll(bson.M{"time": bson.M{"$lt": t}})
+	return err
 }
@@ -19,9 +19,15 @@ func (server *MetaServer) startPaymentRunner() {
 		for range ticker.C {
 			server.logger.Println("Running payments...")
 			err := server.runPayments()
+			recordRunnerRun("payment_runner", err)
 			if err != nil {
 				server.logger.Println("Error when running payments:", err)
 			}
+			err = server.checkLedger()
+			recordRunnerRun("ledger_check", err)
+			if err != nil {
+				server.logger.Println("Error when checking ledger:", err)
+			}
 		}
 	}()
 }
@@ -33,89 +39,181 @@ func (server *MetaServer) runPayments() error {
 		return err
 	}
 
-	// Retrieve a list of all the payments on the server.
-	payments, err := server.db.FindAllPayments()
+	for i := range contracts {
+		err = server.payContract(&contracts[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return server.chargeEgress(contracts)
+}
+
+// Pays the provider of a contract for the time since its last payment.
+func (server *MetaServer) payContract(contract *core.Contract) error {
+	// Cancellations pay the provider too, so they hold the same lock.
+	server.contractLocks.Lock(contract.ID)
+	defer server.contractLocks.Unlock(contract.ID)
+
+	// The payment is read under the lock, since the contract
+	// may have been cancelled since it was listed.
+	paymentInfo, err := server.db.FindPaymentByContract(contract.ID)
+	if err == errNotFound {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// Put the payments in a map so we can retrieve them a bit faster.
-	contractToPayment := make(map[string]core.PaymentInfo)
-	for _, item := range payments {
-		contractToPayment[item.ContractID] = item
+	// If we're not currently paying down the contract, skip it.
+	if !paymentInfo.IsPaying {
+		return nil
 	}
 
-	// For each contract...
-	for _, item := range contracts {
-		// server.logger.Println("Payment for contract", item.ID)
+	now := time.Now()
+	amountToPay := calcPaymentDue(contract, paymentInfo, now)
 
-		// Payment information associated with this contract.
-		paymentInfo := contractToPayment[item.ID]
+	// If the amount to pay is 0, we probably don't have anything to pay
+	// due to integer truncation and floating point errors (basically, we
+	// don't have enough to pay), so we should just wait until we do.
+	if amountToPay == 0 {
+		return nil
+	}
 
-		// If we're not currently paying down the contract, skip it.
-		if !paymentInfo.IsPaying {
-			continue
-		}
+	// Transfer the amount from the contract to the provider.
+	err = server.transfer(core.EscrowAccount(contract.ID), core.ProviderAccount(contract.ProviderId),
+		amountToPay, fmt.Sprintf("Payment for contract %s", contract.ID))
+	if err != nil {
+		return err
+	}
+	paymentInfo.Balance -= amountToPay
+	paymentInfo.LastPaymentTime = now
 
-		// Determine the portion of the contract price that should be paid.
-		totalContractTime := item.EndDate.Sub(item.StartDate)
-		timeSinceLastPayment := time.Now().Sub(paymentInfo.LastPaymentTime)
-		portionToPay := float64(timeSinceLastPayment.Nanoseconds()) / float64(totalContractTime.Nanoseconds())
-		amountToPay := int64(portionToPay * float64(item.StorageFee))
-		// server.logger.Println("Portion to pay:", portionToPay, "Amount:", amountToPay)
-
-		// If the amount to pay is 0, we probably don't have anything to pay
-		// due to integer truncation and floating point errors (basically, we
-		// don't have enough to pay), so we should just wait until we do.
-		if amountToPay == 0 {
-			continue
-		}
+	// If the balance is now 0, mark the contract as not being paid.
+	if paymentInfo.Balance == 0 {
+		paymentInfo.IsPaying = false
+	}
 
-		// If that portion is greater than the remaining contract balance, just pay the remaining balance.
-		if amountToPay > paymentInfo.Balance {
-			amountToPay = paymentInfo.Balance
+	// Update the payment information.
+	err = server.db.UpdatePayment(paymentInfo)
+	if err != nil {
+		return err
+	}
+
+	// Create a transaction showing the payment.
+	transaction := &core.Transaction{
+		UserType:        "provider",
+		UserID:          contract.ProviderId,
+		ContractID:      contract.ID,
+		TransactionType: "receipt",
+		Amount:          amountToPay,
+		Date:            now,
+		Description:     fmt.Sprintf("Payment received for contract %s", contract.ID),
+	}
+	err = server.db.InsertTransaction(transaction)
+	if err != nil {
+		return err
+	}
+	paymentsMade.Inc()
+	paymentAmount.Add(float64(amountToPay))
+	return nil
+}
+
+// Charges renters for the blocks providers have sent them under
+// contracts with a download rate, paying the charges to the providers.
+// Renters are only charged what their balance covers, and the rest
+// of their downloads are charged once they add funds.
+func (server *MetaServer) chargeEgress(contracts []core.Contract) error {
+	egress, err := server.db.FindAllEgress()
+	if err != nil {
+		return err
+	}
+
+	contractsByID := make(map[string]*core.Contract)
+	for i := range contracts {
+		contractsByID[contracts[i].ID] = &contracts[i]
+	}
+
+	for _, item := range egress {
+		contract, exists := contractsByID[item.ContractID]
+		if !exists || item.UnbilledBytes <= 0 {
+			continue
 		}
 
-		// Transfer the amount from the contract to the provider.
-		// TODO: Again, we should add atomic increment and decrement db methods so race conditions don't happen.
-		provider, err := server.db.FindProviderByID(item.ProviderId)
+		renter, err := server.db.FindRenterByID(item.RenterId)
 		if err != nil {
 			return err
 		}
-		provider.Balance += amountToPay
-		err = server.db.UpdateProvider(provider)
+		amount, bytes := core.EgressCharge(item.UnbilledBytes, contract.DownloadRate, renter.Balance)
+		if amount == 0 {
+			continue
+		}
+		err = server.transfer(core.RenterAccount(item.RenterId), core.ProviderAccount(item.ProviderId),
+			amount, fmt.Sprintf("Download of %d bytes under contract %s", bytes, item.ContractID))
+		if err == errInsufficientFunds {
+			// The renter spent their balance since it was read.
+			// The downloads will be charged on a later run.
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		paymentInfo.Balance -= amountToPay
-		paymentInfo.LastPaymentTime = time.Now()
-
-		// If the balance is now 0, mark the contract as not being paid.
-		if paymentInfo.Balance == 0 {
-			paymentInfo.IsPaying = false
-		}
-
-		// Update the payment information.
-		err = server.db.UpdatePayment(&paymentInfo)
+		err = server.db.BillEgress(item.ContractID, bytes)
 		if err != nil {
 			return err
 		}
 
-		// Create a transaction showing the payment.
-		transaction := &core.Transaction{
-			UserType:        "provider",
-			UserID:          provider.ID,
-			ContractID:      item.ID,
-			TransactionType: "receipt",
-			Amount:          amountToPay,
-			Date:            time.Now(),
-			Description:     fmt.Sprintf("Payment received for contract %s", item.ID),
+		// Record the charge for both sides.
+		now := time.Now()
+		transactions := []*core.Transaction{
+			{
+				UserType:        "renter",
+				UserID:          item.RenterId,
+				ContractID:      item.ContractID,
+				TransactionType: "egress",
+				Amount:          amount,
+				Date:            now,
+				Description: fmt.Sprintf("Download of %d bytes from provider %s at rate %d",
+					bytes, item.ProviderId, contract.DownloadRate),
+			},
+			{
+				UserType:        "provider",
+				UserID:          item.ProviderId,
+				ContractID:      item.ContractID,
+				TransactionType: "receipt",
+				Amount:          amount,
+				Date:            now,
+				Description: fmt.Sprintf("Payment received for %d bytes downloaded under contract %s",
+					bytes, item.ContractID),
+			},
 		}
-		err = server.db.InsertTransaction(transaction)
-		if err != nil {
-			return err
+		for _, transaction := range transactions {
+			err = server.db.InsertTransaction(transaction)
+			if err != nil {
+				return err
+			}
 		}
+		paymentsMade.Inc()
+		paymentAmount.Add(float64(amount))
 	}
 
 	return nil
 }
+
+// Returns the portion of a contract's price owed to the provider for the
+// time between the last payment and now, capped at the remaining balance.
+func calcPaymentDue(contract *core.Contract, paymentInfo *core.PaymentInfo, now time.Time) int64 {
+	totalContractTime := contract.EndDate.Sub(contract.StartDate)
+	timeSinceLastPayment := now.Sub(paymentInfo.LastPaymentTime)
+	if totalContractTime <= 0 || timeSinceLastPayment <= 0 {
+		return 0
+	}
+	portionToPay := float64(timeSinceLastPayment.Nanoseconds()) / float64(totalContractTime.Nanoseconds())
+	amountToPay := int64(portionToPay * float64(contract.StorageFee))
+
+	// If that portion is greater than the remaining contract balance, just pay the remaining balance.
+	if amountToPay > paymentInfo.Balance {
+		amountToPay = paymentInfo.Balance
+	}
+	return amountToPay
+}
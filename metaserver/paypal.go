@@ -144,7 +144,6 @@ func (server *MetaServer) getExecutePaypalPaymentHandler() http.HandlerFunc {
 			return
 		}
 
-		// BUG(kincaid): Possible race condition here. Add DB operation for atomically incrementing wallet balance.
 		server.logger.Println(resp.Transactions[0].Amount.Total)
 		amountInCents, err := strconv.ParseInt(
 			strings.Replace(resp.Transactions[0].Amount.Total, ".", "", 1),
@@ -158,8 +157,8 @@ func (server *MetaServer) getExecutePaypalPaymentHandler() http.HandlerFunc {
 		amountInTenthsOfCents := amountInCents * 10
 
 		// The renter balance is in tenths of cents, so convert accordingly.
-		renter.Balance += amountInTenthsOfCents
-		err = server.db.UpdateRenter(renter)
+		err = server.transfer(core.PaypalAccount, core.RenterAccount(renter.ID),
+			amountInTenthsOfCents, "Paypal deposit")
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
@@ -243,11 +242,17 @@ func (server *MetaServer) getRenterPaypalWithdrawHandler() http.HandlerFunc {
 			return
 		}
 
-		// Fail if the user tries to withdraw more than they currently have.
-		if payload.Amount*10 > renter.Balance {
+		// Take the amount from the user's balance before paying it out, which
+		// fails if the user tries to withdraw more than they currently have.
+		err = server.transfer(core.RenterAccount(renter.ID), core.PaypalAccount, payload.Amount*10, "Withdrawal")
+		if err == errInsufficientFunds {
 			writeErr("Cannot withdraw more than balance", http.StatusBadRequest, w)
 			return
 		}
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
 
 		dollars := payload.Amount / 100
 		cents := payload.Amount % 100
@@ -270,20 +275,18 @@ func (server *MetaServer) getRenterPaypalWithdrawHandler() http.HandlerFunc {
 			},
 		})
 		if err != nil {
+			// Return the amount to the user's balance.
+			reverseErr := server.transfer(core.PaypalAccount, core.RenterAccount(renter.ID), payload.Amount*10,
+				"Reversal of failed withdrawal")
+			if reverseErr != nil {
+				server.logger.Println("Unable to reverse failed withdrawal. error:", reverseErr)
+			}
 			writeAndLogInternalError(err, w, server.logger)
 			errorResp := err.(*paypalsdk.ErrorResponse)
 			server.logger.Printf("%+v", errorResp.Details)
 			return
 		}
 
-		// BUG(kincaid): Possible race condition here. Add DB operation for atomically decrementing wallet balance.
-		renter.Balance -= payload.Amount * 10
-		err = server.db.UpdateRenter(renter)
-		if err != nil {
-			writeAndLogInternalError(err, w, server.logger)
-			return
-		}
-
 		// Create a transaction showing the withdrawal.
 		transaction := &core.Transaction{
 			UserType:        "renter",
@@ -362,11 +365,17 @@ func (server *MetaServer) getProviderPaypalWithdrawHandler() http.HandlerFunc {
 			return
 		}
 
-		// Fail if the user tries to withdraw more than they currently have.
-		if payload.Amount*10 > provider.Balance {
+		// Take the amount from the user's balance before paying it out, which
+		// fails if the user tries to withdraw more than they currently have.
+		err = server.transfer(core.ProviderAccount(provider.ID), core.PaypalAccount, payload.Amount*10, "Withdrawal")
+		if err == errInsufficientFunds {
 			writeErr("Cannot withdraw more than balance", http.StatusBadRequest, w)
 			return
 		}
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
 
 		dollars := payload.Amount / 100
 		cents := payload.Amount % 100
@@ -389,20 +398,18 @@ func (server *MetaServer) getProviderPaypalWithdrawHandler() http.HandlerFunc {
 			},
 		})
 		if err != nil {
+			// Return the amount to the user's balance.
+			reverseErr := server.transfer(core.PaypalAccount, core.ProviderAccount(provider.ID), payload.Amount*10,
+				"Reversal of failed withdrawal")
+			if reverseErr != nil {
+				server.logger.Println("Unable to reverse failed withdrawal. error:", reverseErr)
+			}
 			writeAndLogInternalError(err, w, server.logger)
 			errorResp := err.(*paypalsdk.ErrorResponse)
 			server.logger.Printf("%+v", errorResp.Details)
 			return
 		}
 
-		// BUG(kincaid): Possible race condition here. Add DB operation for atomically decrementing wallet balance.
-		provider.Balance -= payload.Amount * 10
-		err = server.db.UpdateProvider(provider)
-		if err != nil {
-			writeAndLogInternalError(err, w, server.logger)
-			return
-		}
-
 		// Create a transaction showing the withdrawal.
 		transaction := &core.Transaction{
 			UserType:        "provider",
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"skybin/core"
+	"time"
 
 	"crypto/rsa"
 	"skybin/util"
@@ -26,17 +27,32 @@ func (server *MetaServer) getProviderPublicKey(providerID string) (*rsa.PublicKe
 
 type getProvidersResp struct {
 	Providers []core.ProviderInfo `json:"providers"`
+	// Cursor for the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
+// Lists a page of the verified providers that are online and match
+// the filters in the query parameters. See ProviderQuery.
 func (server *MetaServer) getProvidersHandler() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseProviderQuery(r.URL.Query())
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 		providers, err := server.db.FindAllProviders()
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
 		}
+		page, next, err := listProviders(providers, query)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 		resp := getProvidersResp{
-			Providers: providers,
+			Providers:  page,
+			NextCursor: next,
 		}
 		json.NewEncoder(w).Encode(resp)
 	})
@@ -63,13 +79,44 @@ func (server *MetaServer) postProviderHandler() http.HandlerFunc {
 			return
 		}
 
-		_, err = util.UnmarshalPublicKey([]byte(provider.PublicKey))
+		key, err := util.UnmarshalPublicKey([]byte(provider.PublicKey))
 		if err != nil {
 			writeErr("invalid RSA public key", http.StatusBadRequest, w)
 			return
 		}
+		if provider.Addr == "" {
+			writeErr("must specify provider address", http.StatusBadRequest, w)
+			return
+		}
+		err = checkProviderAddr(provider.Addr, server.allowPrivateProviders)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if !server.registrations.allow(clientIP(r), time.Now()) {
+			writeErr("too many provider registrations. Try again later", http.StatusTooManyRequests, w)
+			return
+		}
 
 		provider.ID = util.FingerprintKey([]byte(provider.PublicKey))
+
+		// Providers usually register before their daemon is started, so
+		// an unreachable provider is registered but not listed until it
+		// asks to be verified. A reachable server that fails the identity
+		// check is rejected, since the address belongs to someone else.
+		err = server.verifyProvider(&provider, key)
+		if _, unreachable := err.(*unreachableError); err != nil && !unreachable {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		provider.Verified = err == nil
+
+		// Balances, reputations, and liveness are set by the metaserver.
+		// The provider is listed once it sends its first heartbeat.
+		provider.Balance = 0
+		provider.Reputation = nil
+		provider.Liveness = nil
+
 		err = server.db.InsertProvider(&provider)
 		if err != nil {
 			writeErr(err.Error(), http.StatusBadRequest, w)
@@ -135,7 +182,26 @@ func (server *MetaServer) putProviderHandler() http.HandlerFunc {
 			writeErr("must not change balance", http.StatusUnauthorized, w)
 			return
 		}
-		// Put the new provider into the database.
+		// A new address must be checked before renters are sent there.
+		updatedProvider.Verified = provider.Verified
+		if updatedProvider.Addr != provider.Addr {
+			key, err := util.UnmarshalPublicKey([]byte(provider.PublicKey))
+			if err != nil {
+				writeAndLogInternalError(err, w, server.logger)
+				return
+			}
+			err = server.verifyProvider(&updatedProvider, key)
+			if err != nil {
+				writeErr(err.Error(), http.StatusBadRequest, w)
+				return
+			}
+			updatedProvider.Verified = true
+		}
+		// Put the new provider into the database. The provider's
+		// reputation and liveness are kept, since only audits and
+		// heartbeats change them.
+		updatedProvider.Reputation = provider.Reputation
+		updatedProvider.Liveness = provider.Liveness
 		err = server.db.UpdateProvider(&updatedProvider)
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
@@ -171,3 +237,157 @@ func (server *MetaServer) deleteProviderHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+type postLostBlocksReq struct {
+	// Maps renter IDs to the IDs of their lost blocks
+	Blocks map[string][]string `json:"blocks"`
+}
+
+// Handles a provider reporting blocks it has lost, e.g. to a disk failure
+// or corruption found while scrubbing.
+// The blocks are marked as failing their audits so renters repair them.
+func (server *MetaServer) postLostBlocksHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// Make sure the person making the request is the provider.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if providerID, present := claims["providerID"]; !present || providerID.(string) != params["id"] {
+			writeErr("cannot report blocks for other providers", http.StatusUnauthorized, w)
+			return
+		}
+
+		var req postLostBlocksReq
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeErr("could not parse payload", http.StatusBadRequest, w)
+			return
+		}
+
+		for renterID, blockIDs := range req.Blocks {
+			lost := map[string]bool{}
+			for _, blockID := range blockIDs {
+				lost[blockID] = true
+			}
+			files, err := server.db.FindFilesByOwner(renterID)
+			if err != nil {
+				continue
+			}
+			for _, file := range files {
+				for _, version := range file.Versions {
+					changed := false
+					for i, block := range version.Blocks {
+						if block.Location.ProviderId == params["id"] && lost[block.ID] {
+							version.Blocks[i].AuditPassed = false
+							changed = true
+						}
+					}
+					if !changed {
+						continue
+					}
+					err = server.db.UpdateFileVersion(file.ID, &version)
+					if err != nil {
+						writeAndLogInternalError(err, w, server.logger)
+						return
+					}
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+type postEgressReq struct {
+	// Maps renter IDs to the number of bytes of their blocks
+	// sent since the provider last reported
+	Bytes map[string]int64 `json:"bytes"`
+}
+
+// Handles a provider reporting the bytes it has sent to renters.
+// The bytes are added to the renters' contracts with the provider
+// and charged for by the payment runner.
+func (server *MetaServer) postEgressHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// Make sure the person making the request is the provider.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if providerID, present := claims["providerID"]; !present || providerID.(string) != params["id"] {
+			writeErr("cannot report egress for other providers", http.StatusUnauthorized, w)
+			return
+		}
+
+		var req postEgressReq
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeErr("could not parse payload", http.StatusBadRequest, w)
+			return
+		}
+
+		for renterID, bytes := range req.Bytes {
+			if bytes <= 0 {
+				continue
+			}
+			contracts, err := server.db.FindContractsByRenter(renterID)
+			if err != nil {
+				continue
+			}
+			contract := egressContract(contracts, params["id"], time.Now())
+			if contract == nil || contract.DownloadRate == 0 {
+				// Downloads are free.
+				continue
+			}
+			limit := contract.StorageSpace * maxEgressMultiple
+			added, err := server.db.AddEgress(contract, bytes, limit)
+			if err != nil {
+				writeAndLogInternalError(err, w, server.logger)
+				return
+			}
+			if added < bytes {
+				server.logger.Printf("Provider %s reported %d bytes sent to renter %s over contract %s's egress limit\n",
+					params["id"], bytes-added, renterID, contract.ID)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Most egress renters can be charged for under a contract, as a multiple
+// of the contract's storage space. Providers report egress themselves,
+// so this limits how much a provider can overcharge a renter.
+const maxEgressMultiple = 10
+
+// Returns the contract that downloads from the given provider are
+// charged under. If the renter has several contracts running with the
+// provider, the one with the lowest download rate is used. If none are
+// running, the one that ended last is used. Returns nil if the renter
+// has no contracts with the provider.
+func egressContract(contracts []core.Contract, providerID string, now time.Time) *core.Contract {
+	var active, latest *core.Contract
+	for i := range contracts {
+		c := &contracts[i]
+		if c.ProviderId != providerID {
+			continue
+		}
+		if !now.Before(c.StartDate) && now.Before(c.EndDate) {
+			if active == nil || c.DownloadRate < active.DownloadRate {
+				active = c
+			}
+		}
+		if latest == nil || c.EndDate.After(latest.EndDate) {
+			latest = c
+		}
+	}
+	if active != nil {
+		return active
+	}
+	return latest
+}
@@ -0,0 +1,73 @@
+package metaserver
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Period over which each client's provider registrations are counted.
+const registrationWindow = time.Hour
+
+// Default for the most providers a client can register per
+// registrationWindow. Each registration makes the metaserver connect to
+// the announced address, so this keeps it from being used to probe
+// other hosts.
+const DefaultRegistrationLimit = 10
+
+// registrationLimiter limits how often each client can register providers.
+type registrationLimiter struct {
+	// Most registrations per client per registrationWindow.
+	// Zero or less allows any number.
+	limit int
+
+	mu sync.Mutex
+	// Times of each client's registrations within the last window.
+	recent    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// Records a registration by client at now, returning false without
+// recording it if the client has reached its limit.
+func (l *registrationLimiter) allow(client string, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.recent == nil {
+		l.recent = make(map[string][]time.Time)
+	}
+	cutoff := now.Add(-registrationWindow)
+
+	// Forget clients that haven't registered recently.
+	if l.lastSweep.Before(cutoff) {
+		for c, times := range l.recent {
+			if times[len(times)-1].Before(cutoff) {
+				delete(l.recent, c)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	times := l.recent[client]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+	if len(times) >= l.limit {
+		l.recent[client] = times
+		return false
+	}
+	l.recent[client] = append(times, now)
+	return true
+}
+
+// Returns the IP address a request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -43,11 +43,14 @@ func (server *MetaServer) postRenterHandler() http.HandlerFunc {
 		renter = core.RenterInfo{
 			PublicKey: renter.PublicKey,
 			Alias:     renter.Alias,
+			Budget:    renter.Budget,
 		}
 
+		// Test renters are given a starting balance once they're registered.
+		var testBalance int64
 		if constants.BuildMode == constants.BuildModeTest {
 			if strings.HasPrefix(renter.Alias, "test") {
-				renter.Balance = constants.DefaultTestRenterBalance
+				testBalance = constants.DefaultTestRenterBalance
 			}
 		}
 
@@ -73,6 +76,12 @@ func (server *MetaServer) postRenterHandler() http.HandlerFunc {
 			writeErr(err.Error(), http.StatusBadRequest, w)
 			return
 		}
+		err = server.transfer(testGatewayAccount, core.RenterAccount(renter.ID), testBalance, "Test balance")
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		renter.Balance = testBalance
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(renter)
 	})
@@ -171,6 +180,8 @@ func (server *MetaServer) putRenterHandler() http.HandlerFunc {
 			writeErr("must not change balance", http.StatusUnauthorized, w)
 			return
 		}
+		// Budget changes go through the budget endpoint.
+		updatedRenter.Budget = renter.Budget
 
 		// Put the new renter into the database.
 		err = server.db.UpdateRenter(&updatedRenter)
@@ -208,3 +219,69 @@ func (server *MetaServer) deleteRenterHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+func (server *MetaServer) getRenterBudgetHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// Make sure the person making the request is the renter.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if renterID, present := claims["renterID"]; !present || renterID.(string) != params["renterID"] {
+			writeErr("cannot access other renters' budgets", http.StatusUnauthorized, w)
+			return
+		}
+
+		renter, err := server.db.FindRenterByID(params["renterID"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+		json.NewEncoder(w).Encode(renter.Budget)
+	})
+}
+
+func (server *MetaServer) putRenterBudgetHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// Make sure the person making the request is the renter.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if renterID, present := claims["renterID"]; !present || renterID.(string) != params["renterID"] {
+			writeErr("cannot modify other renters' budgets", http.StatusUnauthorized, w)
+			return
+		}
+
+		var budget core.Budget
+		err = json.NewDecoder(r.Body).Decode(&budget)
+		if err != nil {
+			writeErr("unable to parse payload", http.StatusBadRequest, w)
+			return
+		}
+		if budget.MonthlyLimit < 0 || budget.MaxContractFee < 0 || budget.MaxStorageRate < 0 ||
+			budget.MaxDownloadRate < 0 {
+			writeErr("budget limits must not be negative", http.StatusBadRequest, w)
+			return
+		}
+
+		renter, err := server.db.FindRenterByID(params["renterID"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+		renter.Budget = budget
+		err = server.db.UpdateRenter(renter)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		json.NewEncoder(w).Encode(renter.Budget)
+	})
+}
@@ -1,6 +1,7 @@
 package metaserver
 
 import (
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"log"
@@ -9,6 +10,8 @@ import (
 	"runtime"
 	"skybin/authorization"
 	"skybin/core"
+	"skybin/metrics"
+	"sync"
 
 	"github.com/gorilla/mux"
 )
@@ -21,15 +24,15 @@ func getStaticPath() (string, error) {
 	return path.Join(path.Dir(filename), "static"), nil
 }
 
-// InitServer prepares a handler for the server.
-func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaServer {
+// InitServer prepares a handler for the server, keeping its records in db.
+// Unless allowPrivateProviders is set, providers must announce addresses
+// on the public internet.
+// registrationLimit is the most providers each client address can register
+// per hour. Zero or less allows any number.
+func InitServer(db MetaStore, dataDirectory string, showDash bool, allowPrivateProviders bool,
+	registrationLimit int, logger *log.Logger) *MetaServer {
 	router := mux.NewRouter()
 
-	db, err := newMongoDB()
-	if err != nil {
-		panic(err)
-	}
-
 	server := &MetaServer{
 		dataDir:    dataDirectory,
 		db:         db,
@@ -37,6 +40,24 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 		logger:     logger,
 		authorizer: authorization.NewAuthorizer(logger),
 		signingKey: []byte("secret"),
+
+		allowPrivateProviders: allowPrivateProviders,
+		registrations:         registrationLimiter{limit: registrationLimit},
+	}
+
+	auditKey, err := loadAuditKey(dataDirectory)
+	if err != nil {
+		server.logger.Fatal("Unable to load audit key. error: ", err)
+	}
+	server.auditKey = auditKey
+
+	err = server.recordOpeningBalances()
+	if err != nil {
+		server.logger.Fatal("Unable to record opening ledger balances. error: ", err)
+	}
+	err = server.verifyExistingProviders()
+	if err != nil {
+		server.logger.Fatal("Unable to verify existing providers. error: ", err)
 	}
 
 	authMiddleware := authorization.GetAuthMiddleware(server.signingKey)
@@ -49,12 +70,18 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 	router.Handle("/auth/renter", server.authorizer.GetRespondAuthChallengeHandler(
 		"renterID", server.signingKey, server.getRenterPublicKey)).Methods("POST")
 
+	router.Handle("/audit-key", server.getAuditKeyHandler()).Methods("GET")
+
 	router.Handle("/providers", server.getProvidersHandler()).Methods("GET")
 	router.Handle("/providers", server.postProviderHandler()).Methods("POST")
 	router.Handle("/providers/{id}", server.getProviderHandler()).Methods("GET")
 	router.Handle("/providers/{id}", authMiddleware.Handler(server.putProviderHandler())).Methods("PUT")
 	router.Handle("/providers/{id}", authMiddleware.Handler(server.deleteProviderHandler())).Methods("DELETE")
 
+	router.Handle("/providers/{id}/lost-blocks", authMiddleware.Handler(server.postLostBlocksHandler())).Methods("POST")
+	router.Handle("/providers/{id}/egress", authMiddleware.Handler(server.postEgressHandler())).Methods("POST")
+	router.Handle("/providers/{id}/heartbeat", server.postHeartbeatHandler()).Methods("POST")
+	router.Handle("/providers/{id}/verify", authMiddleware.Handler(server.postVerifyProviderHandler())).Methods("POST")
 	router.Handle("/providers/{providerID}/transactions", authMiddleware.Handler(server.getProviderTransactionsHandler())).Methods("GET")
 
 	router.Handle("/renters", server.postRenterHandler()).Methods("POST")
@@ -65,6 +92,9 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 
 	router.Handle("/renters/{renterID}/transactions", authMiddleware.Handler(server.getRenterTransactionsHandler())).Methods("GET")
 
+	router.Handle("/renters/{renterID}/budget", authMiddleware.Handler(server.getRenterBudgetHandler())).Methods("GET")
+	router.Handle("/renters/{renterID}/budget", authMiddleware.Handler(server.putRenterBudgetHandler())).Methods("PUT")
+
 	router.Handle("/renters/{renterID}/contracts", authMiddleware.Handler(server.getContractsHandler())).Methods("GET")
 	router.Handle("/renters/{renterID}/contracts", authMiddleware.Handler(server.postContractHandler())).Methods("POST")
 	router.Handle("/renters/{renterID}/contracts/{contractID}", authMiddleware.Handler(server.getContractHandler())).Methods("GET")
@@ -72,6 +102,7 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 	router.Handle("/renters/{renterID}/contracts/{contractID}", authMiddleware.Handler(server.deleteContractHandler())).Methods("DELETE")
 	router.Handle("/renters/{renterID}/contracts/{contractID}/payment", authMiddleware.Handler(server.getContractPaymentHandler())).Methods("GET")
 	router.Handle("/renters/{renterID}/contracts/{contractID}/payment", authMiddleware.Handler(server.putContractPaymentHandler())).Methods("PUT")
+	router.Handle("/renters/{renterID}/contracts/{contractID}/cancel", authMiddleware.Handler(server.cancelContractHandler())).Methods("POST")
 
 	router.Handle("/renters/{renterID}/files", authMiddleware.Handler(server.getFilesHandler())).Methods("GET")
 	router.Handle("/renters/{renterID}/files", authMiddleware.Handler(server.postFileHandler())).Methods("POST")
@@ -100,9 +131,12 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 	router.Handle("/paypal/renter-withdraw", authMiddleware.Handler(server.getRenterPaypalWithdrawHandler())).Methods("POST")
 	router.Handle("/paypal/provider-withdraw", authMiddleware.Handler(server.getProviderPaypalWithdrawHandler())).Methods("POST")
 
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+
 	if showDash {
 		router.Handle("/dashboard.json", server.getDashboardDataHandler()).Methods("GET")
 		router.Handle("/dashboard/audit/{fileID}/{blockID}", server.getDashboardAuditHandler()).Methods("POST")
+		router.Handle("/ledger/report", server.getLedgerReportHandler()).Methods("GET")
 
 		staticPath, err := getStaticPath()
 		if err != nil {
@@ -111,20 +145,40 @@ func InitServer(dataDirectory string, showDash bool, logger *log.Logger) *MetaSe
 		router.Handle("/{someFile}", http.FileServer(http.Dir(staticPath)))
 	}
 
+	metrics.Instrument("metaserver", router)
 	server.startPaymentRunner()
+	server.startAuditRunner()
+	server.startLivenessRunner()
 
 	return server
 }
 
 type MetaServer struct {
 	dataDir    string
-	db         *mongoDB
+	db         MetaStore
 	providers  []core.ProviderInfo
 	renters    []core.RenterInfo
 	logger     *log.Logger
 	router     *mux.Router
 	authorizer authorization.Authorizer
 	signingKey []byte
+	// Signs the proof requests the audit runner sends providers.
+	auditKey *rsa.PrivateKey
+	// Whether providers may register loopback and private network
+	// addresses, e.g. when every service runs on one machine.
+	allowPrivateProviders bool
+	// Limits how often each client can register providers.
+	registrations registrationLimiter
+
+	// Serializes updates to providers' liveness.
+	livenessMu sync.Mutex
+	// Serializes each renter's contract formation, so a renter's budget
+	// check and the payment it allows can't be interleaved with another
+	// of its contracts'.
+	renterLocks keyedMutex
+	// Serializes payments out of each contract's escrow, so the payment
+	// runner and cancellations can't pay for the same time twice.
+	contractLocks keyedMutex
 }
 
 type errorResp struct {
@@ -33,8 +33,14 @@ func (server *MetaServer) getSharedFilesHandler() http.HandlerFunc {
 			return
 		}
 
+		query, err := ParseFileQuery(r.URL.Query())
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
 		// Retrieve the renter's shared files.
-		files, err := server.db.FindFilesSharedWithRenter(renter.ID)
+		files, err := server.db.FindFilesSharedWithRenter(renter.ID, query.Prefix)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			server.logger.Println(err)
@@ -42,10 +48,22 @@ func (server *MetaServer) getSharedFilesHandler() http.HandlerFunc {
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
-		for i, _ := range files {
-			files[i].Name = path.Base(files[i].Name)
+		// The prefix applies to the files' full names, so names are only
+		// shortened to the last element once the page is selected.
+		page, next, err := listFiles(files, query)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		for i := range page {
+			if page[i].Name != "" {
+				page[i].Name = path.Base(page[i].Name)
+			}
+		}
+		if next != "" {
+			w.Header().Set(NextCursorHeader, next)
 		}
-		json.NewEncoder(w).Encode(files)
+		json.NewEncoder(w).Encode(page)
 	})
 }
 
@@ -0,0 +1,1180 @@
+package metaserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"skybin/core"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" //sqlite library
+)
+
+// sqliteDB is a MetaStore kept in a single SQLite file. Records are
+// stored as JSON documents alongside the fields they're looked up by.
+type sqliteDB struct {
+	db *sql.DB
+
+	// Serializes writes, since many of them read a record,
+	// modify it, and write it back.
+	mu sync.Mutex
+}
+
+var sqliteTables = []string{
+	`CREATE TABLE IF NOT EXISTS renters (
+		ID TEXT PRIMARY KEY,
+		Alias TEXT UNIQUE,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS providers (
+		ID TEXT PRIMARY KEY,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		ID TEXT PRIMARY KEY,
+		OwnerID TEXT,
+		Name TEXT,
+		Doc TEXT,
+		UNIQUE (Name, OwnerID))`,
+	`CREATE TABLE IF NOT EXISTS file_versions (
+		FileID TEXT PRIMARY KEY,
+		Number INTEGER)`,
+	`CREATE TABLE IF NOT EXISTS contracts (
+		ID TEXT PRIMARY KEY,
+		RenterID TEXT,
+		Doc TEXT)`,
+	`CREATE INDEX IF NOT EXISTS renterid_contracts ON contracts (RenterID)`,
+	`CREATE TABLE IF NOT EXISTS payments (
+		ContractID TEXT PRIMARY KEY,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS transactions (
+		id INTEGER PRIMARY KEY,
+		UserType TEXT,
+		UserID TEXT,
+		Date INTEGER,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS egress (
+		ContractID TEXT PRIMARY KEY,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS ledger (
+		id INTEGER PRIMARY KEY,
+		Doc TEXT)`,
+	`CREATE TABLE IF NOT EXISTS migrations (
+		Name TEXT PRIMARY KEY)`,
+	`CREATE TABLE IF NOT EXISTS audits (
+		id INTEGER PRIMARY KEY,
+		BlockID TEXT,
+		ProviderID TEXT,
+		Time INTEGER,
+		Doc TEXT)`,
+	`CREATE INDEX IF NOT EXISTS blockid_audits ON audits (BlockID)`,
+	`CREATE INDEX IF NOT EXISTS providerid_audits ON audits (ProviderID, Time)`,
+}
+
+// Indexes created once the tables have been migrated,
+// since they may cover columns older tables don't have.
+var sqliteIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS user_transactions ON transactions (UserType, UserID, Date)`,
+}
+
+func newSqliteDB(path string) (*sqliteDB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection keeps in-memory DBs alive between
+	// queries and avoids SQLite's locking errors.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range sqliteTables {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to create tables. error: %s", err)
+		}
+	}
+	err = addTransactionDates(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to migrate transactions. error: %s", err)
+	}
+	for _, stmt := range sqliteIndexes {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to create indexes. error: %s", err)
+		}
+	}
+	return &sqliteDB{db: db}, nil
+}
+
+// Adds the Date column to transactions tables created without it,
+// filling it in from each transaction's document.
+func addTransactionDates(db *sql.DB) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('transactions') WHERE name='Date'`).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE transactions ADD COLUMN Date INTEGER`)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT id, Doc FROM transactions`)
+	if err != nil {
+		return err
+	}
+	dates := map[int64]int64{}
+	for rows.Next() {
+		var id int64
+		var doc string
+		err = rows.Scan(&id, &doc)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		var transaction core.Transaction
+		err = json.Unmarshal([]byte(doc), &transaction)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		dates[id] = transactionDate(&transaction)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, date := range dates {
+		_, err = db.Exec(`UPDATE transactions SET Date=? WHERE id=?`, date, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the transaction's date as stored in the Date column.
+// Transactions without a date are stored as the Unix epoch.
+func transactionDate(transaction *core.Transaction) int64 {
+	if transaction.Date.IsZero() {
+		return 0
+	}
+	return transaction.Date.UnixNano()
+}
+
+func (db *sqliteDB) CloseDB() {
+	db.db.Close()
+}
+
+// Decodes the document selected by query into result.
+func (db *sqliteDB) findOne(result interface{}, query string, args ...interface{}) error {
+	var doc string
+	err := db.db.QueryRow(query, args...).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(doc), result)
+}
+
+// Decodes the documents selected by query into result,
+// which must be a pointer to a slice.
+func (db *sqliteDB) findAll(result interface{}, query string, args ...interface{}) error {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	docs := []string{}
+	for rows.Next() {
+		var doc string
+		err = rows.Scan(&doc)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte("["+strings.Join(docs, ",")+"]"), result)
+}
+
+// Runs a statement that should change exactly one record.
+func (db *sqliteDB) execOne(query string, args ...interface{}) error {
+	res, err := db.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func encodeDoc(doc interface{}) (string, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Renter operations
+//==================
+
+func (db *sqliteDB) FindAllRenters() ([]core.RenterInfo, error) {
+	var result []core.RenterInfo
+	err := db.findAll(&result, `SELECT Doc FROM renters ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindRenterByID(renterID string) (*core.RenterInfo, error) {
+	var result core.RenterInfo
+	err := db.findOne(&result, `SELECT Doc FROM renters WHERE ID=?`, renterID)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (db *sqliteDB) FindRenterByAlias(alias string) (*core.RenterInfo, error) {
+	var result core.RenterInfo
+	err := db.findOne(&result, `SELECT Doc FROM renters WHERE Alias=?`, alias)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (db *sqliteDB) InsertRenter(renter *core.RenterInfo) error {
+	doc, err := encodeDoc(renter)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO renters (ID, Alias, Doc) VALUES (?, ?, ?)`,
+		renter.ID, renter.Alias, doc)
+	return err
+}
+
+func (db *sqliteDB) updateRenter(renter *core.RenterInfo) error {
+	doc, err := encodeDoc(renter)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE renters SET Alias=?, Doc=? WHERE ID=?`, renter.Alias, doc, renter.ID)
+}
+
+func (db *sqliteDB) UpdateRenter(renter *core.RenterInfo) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, err := db.FindRenterByID(renter.ID)
+	if err != nil {
+		return err
+	}
+	updated := *renter
+	updated.Balance = current.Balance
+	return db.updateRenter(&updated)
+}
+
+func (db *sqliteDB) DeleteRenter(renterID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`DELETE FROM renters WHERE ID=?`, renterID)
+}
+
+// Applies fn to the renter and saves the result.
+func (db *sqliteDB) modifyRenter(renterID string, fn func(renter *core.RenterInfo)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	renter, err := db.FindRenterByID(renterID)
+	if err != nil {
+		return err
+	}
+	fn(renter)
+	return db.updateRenter(renter)
+}
+
+func addToSet(set []string, item string) []string {
+	for _, s := range set {
+		if s == item {
+			return set
+		}
+	}
+	return append(set, item)
+}
+
+func pull(set []string, item string) []string {
+	result := []string{}
+	for _, s := range set {
+		if s != item {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (db *sqliteDB) AddFileToRenterDirectory(renterID string, fileID string) error {
+	return db.modifyRenter(renterID, func(renter *core.RenterInfo) {
+		renter.Files = addToSet(renter.Files, fileID)
+	})
+}
+
+func (db *sqliteDB) AddFileToRenterSharedDirectory(renterID string, fileID string) error {
+	return db.modifyRenter(renterID, func(renter *core.RenterInfo) {
+		renter.Shared = addToSet(renter.Shared, fileID)
+	})
+}
+
+func (db *sqliteDB) RemoveFileFromRenterDirectory(renterID string, fileID string) error {
+	return db.modifyRenter(renterID, func(renter *core.RenterInfo) {
+		renter.Files = pull(renter.Files, fileID)
+	})
+}
+
+func (db *sqliteDB) RemoveFileFromRenterSharedDirectory(renterID string, fileID string) error {
+	return db.modifyRenter(renterID, func(renter *core.RenterInfo) {
+		renter.Shared = pull(renter.Shared, fileID)
+	})
+}
+
+// Provider operations
+//====================
+
+func (db *sqliteDB) FindAllProviders() ([]core.ProviderInfo, error) {
+	var result []core.ProviderInfo
+	err := db.findAll(&result, `SELECT Doc FROM providers ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindProviderByID(providerID string) (*core.ProviderInfo, error) {
+	var result core.ProviderInfo
+	err := db.findOne(&result, `SELECT Doc FROM providers WHERE ID=?`, providerID)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (db *sqliteDB) InsertProvider(provider *core.ProviderInfo) error {
+	doc, err := encodeDoc(provider)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO providers (ID, Doc) VALUES (?, ?)`, provider.ID, doc)
+	return err
+}
+
+func (db *sqliteDB) UpdateProvider(provider *core.ProviderInfo) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, err := db.FindProviderByID(provider.ID)
+	if err != nil {
+		return err
+	}
+	updated := *provider
+	updated.Balance = current.Balance
+	updated.Reputation = current.Reputation
+	updated.Liveness = current.Liveness
+	doc, err := encodeDoc(&updated)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE providers SET Doc=? WHERE ID=?`, doc, provider.ID)
+}
+
+func (db *sqliteDB) DeleteProvider(providerID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`DELETE FROM providers WHERE ID=?`, providerID)
+}
+
+func (db *sqliteDB) UpdateProviderReputation(providerID string, reputation *core.Reputation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	provider, err := db.FindProviderByID(providerID)
+	if err != nil {
+		return err
+	}
+	provider.Reputation = reputation
+	doc, err := encodeDoc(provider)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE providers SET Doc=? WHERE ID=?`, doc, providerID)
+}
+
+func (db *sqliteDB) UpdateProviderLiveness(providerID string, liveness *core.Liveness) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	provider, err := db.FindProviderByID(providerID)
+	if err != nil {
+		return err
+	}
+	provider.Liveness = liveness
+	doc, err := encodeDoc(provider)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE providers SET Doc=? WHERE ID=?`, doc, providerID)
+}
+
+// File operations
+//================
+
+func (db *sqliteDB) FindAllFiles() ([]core.File, error) {
+	var result []core.File
+	err := db.findAll(&result, `SELECT Doc FROM files ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindFileByID(fileID string) (*core.File, error) {
+	var result core.File
+	err := db.findOne(&result, `SELECT Doc FROM files WHERE ID=?`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Returns the files with the given IDs whose names start with prefix.
+func (db *sqliteDB) findFilesByID(fileIDs []string, prefix string) ([]core.File, error) {
+	result := []core.File{}
+	if len(fileIDs) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(fileIDs))
+	for i, id := range fileIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(fileIDs)), ",")
+	query := fmt.Sprintf(`SELECT Doc FROM files WHERE ID IN (%s) AND instr(Name, ?)=1 ORDER BY rowid`,
+		placeholders)
+	err := db.findAll(&result, query, append(args, prefix)...)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindFilesInRenterDirectory(renterID string, prefix string) ([]core.File, error) {
+	renter, err := db.FindRenterByID(renterID)
+	if err != nil {
+		return nil, err
+	}
+	return db.findFilesByID(renter.Files, prefix)
+}
+
+func (db *sqliteDB) FindFilesSharedWithRenter(renterID string, prefix string) ([]core.File, error) {
+	renter, err := db.FindRenterByID(renterID)
+	if err != nil {
+		return nil, err
+	}
+	return db.findFilesByID(renter.Shared, prefix)
+}
+
+func (db *sqliteDB) FindFilesByOwner(renterID string) ([]core.File, error) {
+	var result []core.File
+	err := db.findAll(&result, `SELECT Doc FROM files WHERE OwnerID=? ORDER BY rowid`, renterID)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) InsertFile(file *core.File) error {
+	doc, err := encodeDoc(file)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO files (ID, OwnerID, Name, Doc) VALUES (?, ?, ?, ?)`,
+		file.ID, file.OwnerID, file.Name, doc)
+	return err
+}
+
+func (db *sqliteDB) updateFile(file *core.File) error {
+	doc, err := encodeDoc(file)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE files SET OwnerID=?, Name=?, Doc=? WHERE ID=?`,
+		file.OwnerID, file.Name, doc, file.ID)
+}
+
+func (db *sqliteDB) UpdateFile(file *core.File) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.updateFile(file)
+}
+
+// Applies fn to the file and saves the result, unless fn returns an error.
+// The caller must hold db.mu.
+func (db *sqliteDB) modifyFile(fileID string, fn func(file *core.File) error) error {
+	file, err := db.FindFileByID(fileID)
+	if err != nil {
+		return err
+	}
+	err = fn(file)
+	if err != nil {
+		return err
+	}
+	return db.updateFile(file)
+}
+
+func (db *sqliteDB) InsertFileVersion(fileID string, version *core.Version) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Version numbers are never reused, even if the latest version is deleted.
+	_, err := db.db.Exec(`INSERT OR IGNORE INTO file_versions (FileID, Number) VALUES (?, 0)`, fileID)
+	if err != nil {
+		return err
+	}
+	_, err = db.db.Exec(`UPDATE file_versions SET Number = Number + 1 WHERE FileID=?`, fileID)
+	if err != nil {
+		return err
+	}
+	err = db.db.QueryRow(`SELECT Number FROM file_versions WHERE FileID=?`, fileID).Scan(&version.Num)
+	if err != nil {
+		return err
+	}
+	return db.modifyFile(fileID, func(file *core.File) error {
+		file.Versions = append(file.Versions, *version)
+		return nil
+	})
+}
+
+// Returns the files in the owner's folder with the given name,
+// and a regexp matching the folder's prefix of their names.
+func (db *sqliteDB) findFolderChildren(ownerID string, folderName string) ([]core.File, *regexp.Regexp, error) {
+	r, err := regexp.Compile(fmt.Sprintf("^%s/", folderName))
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := db.FindFilesByOwner(ownerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	children := []core.File{}
+	for _, file := range files {
+		if r.MatchString(file.Name) {
+			children = append(children, file)
+		}
+	}
+	return children, r, nil
+}
+
+func (db *sqliteDB) RenameFolder(fileID string, ownerID string, oldName string, newName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	children, r, err := db.findFolderChildren(ownerID, oldName)
+	if err != nil {
+		return err
+	}
+	// Rename the children in one transaction so a failure can't leave
+	// the folder split between its old and new names.
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	newPrefix := fmt.Sprintf("%s/", newName)
+	for _, item := range children {
+		item.Name = r.ReplaceAllString(item.Name, newPrefix)
+		doc, err := encodeDoc(&item)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE files SET Name=?, Doc=? WHERE ID=?`, item.Name, doc, item.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *sqliteDB) RemoveFolderChildren(folder *core.File) ([]core.File, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	children, _, err := db.findFolderChildren(folder.OwnerID, folder.Name)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, item := range children {
+		_, err = tx.Exec(`DELETE FROM files WHERE ID=?`, item.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+func (db *sqliteDB) UpdateFileVersion(fileID string, version *core.Version) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.modifyFile(fileID, func(file *core.File) error {
+		for i := range file.Versions {
+			if file.Versions[i].Num == version.Num {
+				file.Versions[i] = *version
+				return nil
+			}
+		}
+		return errNotFound
+	})
+}
+
+func (db *sqliteDB) DeleteFile(fileID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`DELETE FROM files WHERE ID=?`, fileID)
+}
+
+func (db *sqliteDB) DeleteFileVersion(fileID string, version int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.modifyFile(fileID, func(file *core.File) error {
+		versions := []core.Version{}
+		for _, v := range file.Versions {
+			if v.Num != version {
+				versions = append(versions, v)
+			}
+		}
+		file.Versions = versions
+		return nil
+	})
+}
+
+// Returns the index of the renter's permission in the file's
+// access list, or -1 if the renter has no permission.
+func findPermission(file *core.File, renterID string) int {
+	for i, p := range file.AccessList {
+		if p.RenterId == renterID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (db *sqliteDB) AddPermissionToFileACL(fileID string, permission *core.Permission) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.modifyFile(fileID, func(file *core.File) error {
+		if findPermission(file, permission.RenterId) != -1 {
+			return errPermissionExists
+		}
+		file.AccessList = append(file.AccessList, *permission)
+		return nil
+	})
+}
+
+func (db *sqliteDB) UpdateFilePermission(fileID string, permission *core.Permission) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.modifyFile(fileID, func(file *core.File) error {
+		i := findPermission(file, permission.RenterId)
+		if i == -1 {
+			return errNotFound
+		}
+		file.AccessList[i] = *permission
+		return nil
+	})
+}
+
+func (db *sqliteDB) RemoveFilePermissionFromACL(fileID string, renterID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.modifyFile(fileID, func(file *core.File) error {
+		if findPermission(file, renterID) == -1 {
+			return errNotFound
+		}
+		accessList := []core.Permission{}
+		for _, p := range file.AccessList {
+			if p.RenterId != renterID {
+				accessList = append(accessList, p)
+			}
+		}
+		file.AccessList = accessList
+		return nil
+	})
+}
+
+// Contract operations
+//====================
+
+func (db *sqliteDB) FindAllContracts() ([]core.Contract, error) {
+	var result []core.Contract
+	err := db.findAll(&result, `SELECT Doc FROM contracts ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindContractByID(contractID string) (*core.Contract, error) {
+	var result core.Contract
+	err := db.findOne(&result, `SELECT Doc FROM contracts WHERE ID=?`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (db *sqliteDB) FindContractsByRenter(renterID string) ([]core.Contract, error) {
+	var result []core.Contract
+	err := db.findAll(&result, `SELECT Doc FROM contracts WHERE RenterID=? ORDER BY rowid`, renterID)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) InsertContract(contract *core.Contract) error {
+	doc, err := encodeDoc(contract)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO contracts (ID, RenterID, Doc) VALUES (?, ?, ?)`,
+		contract.ID, contract.RenterId, doc)
+	return err
+}
+
+func (db *sqliteDB) UpdateContract(contract *core.Contract) error {
+	doc, err := encodeDoc(contract)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`UPDATE contracts SET RenterID=?, Doc=? WHERE ID=?`, contract.RenterId, doc, contract.ID)
+}
+
+func (db *sqliteDB) DeleteContract(contractID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`DELETE FROM contracts WHERE ID=?`, contractID)
+}
+
+// Payment operations
+//===================
+
+func (db *sqliteDB) FindAllPayments() ([]core.PaymentInfo, error) {
+	var result []core.PaymentInfo
+	err := db.findAll(&result, `SELECT Doc FROM payments ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindPaymentByContract(contractID string) (*core.PaymentInfo, error) {
+	var result core.PaymentInfo
+	err := db.findOne(&result, `SELECT Doc FROM payments WHERE ContractID=?`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (db *sqliteDB) InsertPayment(payment *core.PaymentInfo) error {
+	doc, err := encodeDoc(payment)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO payments (ContractID, Doc) VALUES (?, ?)`, payment.ContractID, doc)
+	return err
+}
+
+func (db *sqliteDB) UpdatePayment(payment *core.PaymentInfo) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, err := db.FindPaymentByContract(payment.ContractID)
+	if err != nil {
+		return err
+	}
+	updated := *payment
+	updated.Balance = current.Balance
+	doc, err := encodeDoc(&updated)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE payments SET Doc=? WHERE ContractID=?`, doc, payment.ContractID)
+}
+
+func (db *sqliteDB) DeletePayment(contractID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.execOne(`DELETE FROM payments WHERE ContractID=?`, contractID)
+}
+
+// Transaction operations
+//=======================
+
+func (db *sqliteDB) FindAllTransactions() ([]core.Transaction, error) {
+	return db.findTransactions(`SELECT id, Doc FROM transactions ORDER BY Date, id`)
+}
+
+func (db *sqliteDB) findTransactionsByUser(userType string, userID string,
+	since, until time.Time) ([]core.Transaction, error) {
+	query := `SELECT id, Doc FROM transactions WHERE UserType=? AND UserID=?`
+	args := []interface{}{userType, userID}
+	if !since.IsZero() {
+		query += ` AND Date>=?`
+		args = append(args, since.UnixNano())
+	}
+	if !until.IsZero() {
+		query += ` AND Date<?`
+		args = append(args, until.UnixNano())
+	}
+	return db.findTransactions(query+` ORDER BY Date, id`, args...)
+}
+
+// Runs a query selecting transactions' row IDs and documents. Each
+// transaction's ID is its row ID, zero-padded so that IDs sort in the
+// same order as the rows.
+func (db *sqliteDB) findTransactions(query string, args ...interface{}) ([]core.Transaction, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []core.Transaction{}
+	for rows.Next() {
+		var id int64
+		var doc string
+		err = rows.Scan(&id, &doc)
+		if err != nil {
+			return nil, err
+		}
+		var transaction core.Transaction
+		err = json.Unmarshal([]byte(doc), &transaction)
+		if err != nil {
+			return nil, err
+		}
+		transaction.ID = fmt.Sprintf("%016x", id)
+		result = append(result, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindTransactionsByRenter(renterID string, since, until time.Time) ([]core.Transaction, error) {
+	return db.findTransactionsByUser("renter", renterID, since, until)
+}
+
+func (db *sqliteDB) FindTransactionsByProvider(providerID string, since, until time.Time) ([]core.Transaction, error) {
+	return db.findTransactionsByUser("provider", providerID, since, until)
+}
+
+func (db *sqliteDB) InsertTransaction(transaction *core.Transaction) error {
+	doc, err := encodeDoc(transaction)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO transactions (UserType, UserID, Date, Doc) VALUES (?, ?, ?, ?)`,
+		transaction.UserType, transaction.UserID, transactionDate(transaction), doc)
+	return err
+}
+
+// Egress operations
+//==================
+
+func (db *sqliteDB) FindAllEgress() ([]core.EgressInfo, error) {
+	var result []core.EgressInfo
+	err := db.findAll(&result, `SELECT Doc FROM egress ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) AddEgress(contract *core.Contract, bytes int64, limit int64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var egress core.EgressInfo
+	err := db.findOne(&egress, `SELECT Doc FROM egress WHERE ContractID=?`, contract.ID)
+	if err == errNotFound {
+		egress = core.EgressInfo{
+			ContractID: contract.ID,
+			ProviderId: contract.ProviderId,
+			RenterId:   contract.RenterId,
+		}
+		_, err = db.db.Exec(`INSERT INTO egress (ContractID, Doc) VALUES (?, '{}')`, contract.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	bytes = egressAllowed(&egress, bytes, limit)
+	if bytes == 0 {
+		return 0, nil
+	}
+	egress.UnbilledBytes += bytes
+	doc, err := encodeDoc(&egress)
+	if err != nil {
+		return 0, err
+	}
+	err = db.execOne(`UPDATE egress SET Doc=? WHERE ContractID=?`, doc, contract.ID)
+	if err != nil {
+		return 0, err
+	}
+	return bytes, nil
+}
+
+func (db *sqliteDB) BillEgress(contractID string, bytes int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var egress core.EgressInfo
+	err := db.findOne(&egress, `SELECT Doc FROM egress WHERE ContractID=?`, contractID)
+	if err != nil {
+		return err
+	}
+	egress.UnbilledBytes -= bytes
+	egress.BilledBytes += bytes
+	doc, err := encodeDoc(&egress)
+	if err != nil {
+		return err
+	}
+	return db.execOne(`UPDATE egress SET Doc=? WHERE ContractID=?`, doc, contractID)
+}
+
+// Ledger operations
+//==================
+
+// Returns the table and key column of the record holding an account's
+// balance, or an empty table name for gateway accounts, which have no record.
+func sqliteAccountTable(account core.LedgerAccount) (string, string) {
+	switch account.Type {
+	case core.AccountRenter:
+		return "renters", "ID"
+	case core.AccountProvider:
+		return "providers", "ID"
+	case core.AccountEscrow:
+		return "payments", "ContractID"
+	}
+	return "", ""
+}
+
+// Adds amount to the account's balance within tx. Balances are never
+// taken below zero.
+func addToBalance(tx *sql.Tx, account core.LedgerAccount, amount int64) error {
+	table, key := sqliteAccountTable(account)
+	if table == "" {
+		return nil
+	}
+	var doc string
+	err := tx.QueryRow(fmt.Sprintf(`SELECT Doc FROM %s WHERE %s=?`, table, key), account.ID).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Renters, providers, and payments all keep their balance
+	// in a "balance" field, so the rest of the record is left as is.
+	var record map[string]json.RawMessage
+	err = json.Unmarshal([]byte(doc), &record)
+	if err != nil {
+		return err
+	}
+	var balance int64
+	if raw, exists := record["balance"]; exists {
+		err = json.Unmarshal(raw, &balance)
+		if err != nil {
+			return err
+		}
+	}
+	if balance+amount < 0 {
+		return errInsufficientFunds
+	}
+	record["balance"] = json.RawMessage(fmt.Sprintf("%d", balance+amount))
+	updated, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET Doc=? WHERE %s=?`, table, key), string(updated), account.ID)
+	return err
+}
+
+func (db *sqliteDB) PostLedgerEntry(entry *core.LedgerEntry) error {
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+	doc, err := encodeDoc(entry)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = addToBalance(tx, entry.From, -entry.Amount)
+	if err != nil {
+		return err
+	}
+	err = addToBalance(tx, entry.To, entry.Amount)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO ledger (Doc) VALUES (?)`, doc)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *sqliteDB) InsertOpeningBalance(entry *core.LedgerEntry) error {
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+	doc, err := encodeDoc(entry)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO ledger (Doc) VALUES (?)`, doc)
+	return err
+}
+
+func (db *sqliteDB) FindAllLedgerEntries() ([]core.LedgerEntry, error) {
+	var result []core.LedgerEntry
+	err := db.findAll(&result, `SELECT Doc FROM ledger ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Migration operations
+//=====================
+
+func (db *sqliteDB) IsMigrationDone(name string) (bool, error) {
+	var n int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM migrations WHERE Name=?`, name).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (db *sqliteDB) MarkMigrationDone(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err := db.db.Exec(`INSERT OR IGNORE INTO migrations (Name) VALUES (?)`, name)
+	return err
+}
+
+// Audit operations
+//=================
+
+// Blocks are picked out of the file documents by SQLite, so only the
+// sampled blocks are decoded.
+func (db *sqliteDB) SampleBlocks(n int) ([]sampledBlock, error) {
+	rows, err := db.db.Query(`
+		SELECT f.ID, f.OwnerID, json_extract(v.value, '$.num'), b.value
+		FROM files f, json_each(f.Doc, '$.versions') v, json_each(v.value, '$.blocks') b
+		ORDER BY RANDOM() LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []sampledBlock{}
+	for rows.Next() {
+		var sample sampledBlock
+		var block string
+		err = rows.Scan(&sample.FileID, &sample.OwnerID, &sample.Version, &block)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(block), &sample.Block)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sample)
+	}
+	return result, rows.Err()
+}
+
+// Audit times are stored as Unix nanoseconds so they can be compared in queries.
+func (db *sqliteDB) InsertAuditRecord(record *core.AuditRecord) error {
+	doc, err := encodeDoc(record)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err = db.db.Exec(`INSERT INTO audits (BlockID, ProviderID, Time, Doc) VALUES (?, ?, ?, ?)`,
+		record.BlockID, record.ProviderID, record.Time.UnixNano(), doc)
+	return err
+}
+
+func (db *sqliteDB) FindAuditsByProvider(providerID string, since time.Time) ([]core.AuditRecord, error) {
+	var result []core.AuditRecord
+	err := db.findAll(&result, `SELECT Doc FROM audits WHERE ProviderID=? AND Time>=? ORDER BY Time, id`,
+		providerID, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) FindAuditsByBlock(blockID string) ([]core.AuditRecord, error) {
+	var result []core.AuditRecord
+	err := db.findAll(&result, `SELECT Doc FROM audits WHERE BlockID=? ORDER BY Time, id`, blockID)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *sqliteDB) DeleteAuditsBefore(t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err := db.db.Exec(`DELETE FROM audits WHERE Time<?`, t.UnixNano())
+	return err
+}
@@ -0,0 +1,177 @@
+package metaserver
+
+import (
+	"errors"
+	"skybin/core"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+// Returned by MetaStore methods when the record being looked up or
+// modified doesn't exist. It's the error mgo returns, so callers can
+// compare against it whichever store is used.
+var errNotFound = mgo.ErrNotFound
+
+// Returned by AddPermissionToFileACL when the file is already shared
+// with the permission's renter.
+var errPermissionExists = errors.New("file is already shared with renter")
+
+// Returned by PostLedgerEntry when the account the money is taken
+// from doesn't hold enough.
+var errInsufficientFunds = errors.New("insufficient funds")
+
+// Returns how many of bytes can be added to a contract's egress
+// without its total egress going over limit.
+func egressAllowed(egress *core.EgressInfo, bytes int64, limit int64) int64 {
+	left := limit - egress.UnbilledBytes - egress.BilledBytes
+	if bytes > left {
+		bytes = left
+	}
+	if bytes < 0 {
+		return 0
+	}
+	return bytes
+}
+
+// A block of a file version, as returned by SampleBlocks.
+type sampledBlock struct {
+	FileID  string
+	OwnerID string
+	Version int
+	Block   core.Block
+}
+
+// MetaStore stores the metaserver's renters, providers, files, contracts,
+// payments, transactions, egress records, and ledger.
+//
+// Methods that look up, update, or delete a single record return an
+// error if the record doesn't exist. Inserting a record whose ID is
+// already used, a renter whose alias is taken, or a file whose name
+// is taken by another of its owner's files returns an error.
+//
+// The balances of renters, providers, and payments are only changed by
+// PostLedgerEntry. Their update methods leave the stored balance as is.
+// Likewise, UpdateProvider leaves the provider's reputation and liveness as is.
+type MetaStore interface {
+	CloseDB()
+
+	FindAllRenters() ([]core.RenterInfo, error)
+	FindRenterByID(renterID string) (*core.RenterInfo, error)
+	FindRenterByAlias(alias string) (*core.RenterInfo, error)
+	InsertRenter(renter *core.RenterInfo) error
+	UpdateRenter(renter *core.RenterInfo) error
+	DeleteRenter(renterID string) error
+
+	FindAllProviders() ([]core.ProviderInfo, error)
+	FindProviderByID(providerID string) (*core.ProviderInfo, error)
+	InsertProvider(provider *core.ProviderInfo) error
+	UpdateProvider(provider *core.ProviderInfo) error
+	DeleteProvider(providerID string) error
+	UpdateProviderReputation(providerID string, reputation *core.Reputation) error
+	UpdateProviderLiveness(providerID string, liveness *core.Liveness) error
+
+	FindAllFiles() ([]core.File, error)
+	FindFileByID(fileID string) (*core.File, error)
+	// Only files whose names start with prefix are returned.
+	FindFilesInRenterDirectory(renterID string, prefix string) ([]core.File, error)
+	FindFilesSharedWithRenter(renterID string, prefix string) ([]core.File, error)
+	AddFileToRenterDirectory(renterID string, fileID string) error
+	AddFileToRenterSharedDirectory(renterID string, fileID string) error
+	RemoveFileFromRenterDirectory(renterID string, fileID string) error
+	RemoveFileFromRenterSharedDirectory(renterID string, fileID string) error
+	FindFilesByOwner(renterID string) ([]core.File, error)
+	InsertFile(file *core.File) error
+	// Adds a version to the file, setting the version's number to
+	// one more than the last version added to the file.
+	InsertFileVersion(fileID string, version *core.Version) error
+	UpdateFile(file *core.File) error
+	// Renames the files in the owner's folder oldName to be in newName.
+	RenameFolder(fileID string, ownerID string, oldName string, newName string) error
+	// Deletes the files in the folder, returning the deleted files.
+	RemoveFolderChildren(folder *core.File) ([]core.File, error)
+	UpdateFileVersion(fileID string, version *core.Version) error
+	DeleteFile(fileID string) error
+	DeleteFileVersion(fileID string, version int) error
+	// Adds a permission to the file's access list.
+	// Returns errPermissionExists if the file already has a permission
+	// for the renter.
+	AddPermissionToFileACL(fileID string, permission *core.Permission) error
+	UpdateFilePermission(fileID string, permission *core.Permission) error
+	RemoveFilePermissionFromACL(fileID string, renterID string) error
+
+	FindAllContracts() ([]core.Contract, error)
+	FindContractByID(contractID string) (*core.Contract, error)
+	FindContractsByRenter(renterID string) ([]core.Contract, error)
+	InsertContract(contract *core.Contract) error
+	UpdateContract(contract *core.Contract) error
+	DeleteContract(contractID string) error
+
+	FindAllPayments() ([]core.PaymentInfo, error)
+	FindPaymentByContract(contractID string) (*core.PaymentInfo, error)
+	InsertPayment(payment *core.PaymentInfo) error
+	UpdatePayment(payment *core.PaymentInfo) error
+	DeletePayment(contractID string) error
+
+	FindAllTransactions() ([]core.Transaction, error)
+	// Only transactions made at or after since and before until are
+	// returned. Zero times don't filter.
+	FindTransactionsByRenter(renterID string, since, until time.Time) ([]core.Transaction, error)
+	FindTransactionsByProvider(providerID string, since, until time.Time) ([]core.Transaction, error)
+	InsertTransaction(transaction *core.Transaction) error
+
+	// PostLedgerEntry atomically moves the entry's amount between the
+	// balances of its accounts and records the entry. A payment holds
+	// the balance of its contract's escrow account. It returns
+	// errInsufficientFunds if the entry would leave the account it
+	// takes from with a negative balance, and errNotFound if either
+	// account's record doesn't exist. Gateway accounts have no record.
+	PostLedgerEntry(entry *core.LedgerEntry) error
+	// Records an entry without changing any balances. It's only used
+	// to record balances held before the ledger existed.
+	InsertOpeningBalance(entry *core.LedgerEntry) error
+	FindAllLedgerEntries() ([]core.LedgerEntry, error)
+
+	// Migrations are one-time changes to existing records, identified
+	// by name.
+	IsMigrationDone(name string) (bool, error)
+	MarkMigrationDone(name string) error
+
+	// Returns up to n blocks of all files' versions, chosen at random.
+	SampleBlocks(n int) ([]sampledBlock, error)
+	InsertAuditRecord(record *core.AuditRecord) error
+	// Returns the provider's audits made at or after since, oldest first.
+	FindAuditsByProvider(providerID string, since time.Time) ([]core.AuditRecord, error)
+	// Returns the block's audits, oldest first.
+	FindAuditsByBlock(blockID string) ([]core.AuditRecord, error)
+	DeleteAuditsBefore(t time.Time) error
+
+	FindAllEgress() ([]core.EgressInfo, error)
+	// Adds up to bytes to the contract's unbilled egress without taking
+	// its total egress above limit. Returns the number of bytes added.
+	AddEgress(contract *core.Contract, bytes int64, limit int64) (int64, error)
+	BillEgress(contractID string, bytes int64) error
+}
+
+// NewMongoStore connects to the MongoDB server at addr.
+func NewMongoStore(addr string) (MetaStore, error) {
+	db, err := newMongoDB(addr)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewEmbeddedStore opens the SQLite DB at path, creating it if it
+// doesn't exist. It needs no separate database server, so it suits
+// tests and small deployments.
+func NewEmbeddedStore(path string) (MetaStore, error) {
+	db, err := newSqliteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+var _ MetaStore = (*mongoDB)(nil)
+var _ MetaStore = (*sqliteDB)(nil)
@@ -0,0 +1,670 @@
+package metaserver
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"skybin/core"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Runs test against each MetaStore. The MongoDB store is only tested
+// if SKYBIN_TEST_MONGO_ADDR is set, and its skybin DB is dropped first.
+func forEachStore(t *testing.T, test func(t *testing.T, db MetaStore)) {
+	t.Run("embedded", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "skybin_metastore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		db, err := NewEmbeddedStore(path.Join(dir, "meta.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.CloseDB()
+		test(t, db)
+	})
+	t.Run("mongo", func(t *testing.T) {
+		addr := os.Getenv("SKYBIN_TEST_MONGO_ADDR")
+		if addr == "" {
+			t.Skip("SKYBIN_TEST_MONGO_ADDR not set")
+		}
+		db, err := newMongoDB(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.CloseDB()
+		err = db.session.DB(dbName).DropDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, db)
+	})
+}
+
+func TestStoreRenters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		renter := &core.RenterInfo{ID: "r1", Alias: "alice", Files: []string{}, Shared: []string{}}
+		err := db.InsertRenter(renter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.InsertRenter(&core.RenterInfo{ID: "r2", Alias: "alice"})
+		if err == nil {
+			t.Fatal("inserted renter with duplicate alias")
+		}
+		found, err := db.FindRenterByAlias("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != "r1" {
+			t.Fatalf("found renter %s by alias. expected r1", found.ID)
+		}
+
+		err = db.AddFileToRenterDirectory("r1", "f1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.AddFileToRenterDirectory("r1", "f1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err = db.FindRenterByID("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found.Files) != 1 || found.Files[0] != "f1" {
+			t.Fatalf("renter files are %v. expected [f1]", found.Files)
+		}
+		err = db.RemoveFileFromRenterDirectory("r1", "f1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err = db.FindRenterByID("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found.Files) != 0 {
+			t.Fatalf("renter files are %v after removing file", found.Files)
+		}
+
+		found.Balance = 100
+		err = db.UpdateRenter(found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.UpdateRenter(&core.RenterInfo{ID: "missing"})
+		if err == nil {
+			t.Fatal("updated missing renter")
+		}
+		err = db.DeleteRenter("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.DeleteRenter("r1")
+		if err == nil {
+			t.Fatal("deleted missing renter")
+		}
+		_, err = db.FindRenterByID("r1")
+		if err == nil {
+			t.Fatal("found deleted renter")
+		}
+		renters, err := db.FindAllRenters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renters == nil || len(renters) != 0 {
+			t.Fatalf("found renters %v. expected none", renters)
+		}
+	})
+}
+
+func TestStoreFiles(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		err := db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice", Files: []string{}, Shared: []string{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []core.File{
+			{ID: "f1", OwnerID: "r1", Name: "docs", IsDir: true},
+			{ID: "f2", OwnerID: "r1", Name: "docs/a"},
+			{ID: "f3", OwnerID: "r1", Name: "docs/sub/b"},
+			{ID: "f4", OwnerID: "r1", Name: "docsx"},
+			{ID: "f5", OwnerID: "r2", Name: "docs/c"},
+		} {
+			f.AccessList = []core.Permission{}
+			f.Versions = []core.Version{}
+			err = db.InsertFile(&f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.OwnerID == "r1" {
+				err = db.AddFileToRenterDirectory("r1", f.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		err = db.InsertFile(&core.File{ID: "f6", OwnerID: "r1", Name: "docs/a"})
+		if err == nil {
+			t.Fatal("inserted file with duplicate name")
+		}
+
+		files, err := db.FindFilesByOwner("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 4 {
+			t.Fatalf("found %d files owned by r1. expected 4", len(files))
+		}
+		files, err = db.FindFilesInRenterDirectory("r1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 4 {
+			t.Fatalf("found %d files in r1's directory. expected 4", len(files))
+		}
+		files, err = db.FindFilesInRenterDirectory("r1", "docs/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 2 {
+			t.Fatalf("found %d files in r1's docs folder. expected 2", len(files))
+		}
+
+		err = db.RenameFolder("f1", "r1", "docs", "papers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err = db.FindAllFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		expected := []string{"docs", "docs/c", "docsx", "papers/a", "papers/sub/b"}
+		for i := range expected {
+			if names[i] != expected[i] {
+				t.Fatalf("files are %v after rename. expected %v", names, expected)
+			}
+		}
+
+		removed, err := db.RemoveFolderChildren(&core.File{OwnerID: "r1", Name: "papers"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 2 {
+			t.Fatalf("removed %d folder children. expected 2", len(removed))
+		}
+		_, err = db.FindFileByID("f2")
+		if err == nil {
+			t.Fatal("found removed folder child")
+		}
+		err = db.DeleteFile("f2")
+		if err == nil {
+			t.Fatal("deleted missing file")
+		}
+	})
+}
+
+func TestEmbeddedRenameFolderIsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybin_metastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := NewEmbeddedStore(path.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDB()
+	for _, f := range []core.File{
+		{ID: "f1", OwnerID: "r1", Name: "docs/a"},
+		{ID: "f2", OwnerID: "r1", Name: "docs/b"},
+		{ID: "f3", OwnerID: "r1", Name: "papers/b"},
+	} {
+		err = db.InsertFile(&f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// docs/b can't be renamed since papers/b exists, so nothing should be.
+	err = db.RenameFolder("", "r1", "docs", "papers")
+	if err == nil {
+		t.Fatal("renamed folder over existing file")
+	}
+	file, err := db.FindFileByID("f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "docs/a" {
+		t.Fatalf("file renamed to %s by failed folder rename", file.Name)
+	}
+}
+
+func TestEmbeddedAddsTransactionDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybin_metastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "meta.db")
+
+	// Create a transactions table from before dates were stored.
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE transactions (id INTEGER PRIMARY KEY, UserType TEXT, UserID TEXT, Doc TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		doc, err := encodeDoc(&core.Transaction{UserType: "renter", UserID: "r1",
+			Amount: int64(i), Date: date.Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = old.Exec(`INSERT INTO transactions (UserType, UserID, Doc) VALUES ('renter', 'r1', ?)`, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := NewEmbeddedStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDB()
+	txns, err := db.FindTransactionsByRenter("r1", date.Add(time.Minute), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].Amount != 1 {
+		t.Fatalf("found transactions %+v since %v", txns, date.Add(time.Minute))
+	}
+}
+
+func TestStoreFileVersions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		err := db.InsertFile(&core.File{ID: "f1", OwnerID: "r1", Name: "a",
+			AccessList: []core.Permission{}, Versions: []core.Version{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i <= 2; i++ {
+			version := &core.Version{Size: int64(i), UploadTime: time.Now().UTC().Truncate(time.Millisecond)}
+			err = db.InsertFileVersion("f1", version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version.Num != i {
+				t.Fatalf("inserted version %d. expected %d", version.Num, i)
+			}
+		}
+		err = db.DeleteFileVersion("f1", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Version numbers aren't reused after deletion.
+		version := &core.Version{Size: 3}
+		err = db.InsertFileVersion("f1", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version.Num != 3 {
+			t.Fatalf("inserted version %d after deletion. expected 3", version.Num)
+		}
+
+		version.Size = 30
+		err = db.UpdateFileVersion("f1", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.UpdateFileVersion("f1", &core.Version{Num: 2})
+		if err == nil {
+			t.Fatal("updated deleted version")
+		}
+		file, err := db.FindFileByID("f1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(file.Versions) != 2 || file.Versions[0].Num != 1 || file.Versions[1].Size != 30 {
+			t.Fatalf("file versions are %+v", file.Versions)
+		}
+	})
+}
+
+func TestStoreFilePermissions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		err := db.InsertFile(&core.File{ID: "f1", OwnerID: "r1", Name: "a",
+			AccessList: []core.Permission{}, Versions: []core.Version{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.AddPermissionToFileACL("f1", &core.Permission{RenterId: "r2", AesKey: "k1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.AddPermissionToFileACL("f1", &core.Permission{RenterId: "r2", AesKey: "k2"})
+		if err != errPermissionExists {
+			t.Fatalf("expected errPermissionExists adding duplicate permission. Got %v", err)
+		}
+		err = db.AddPermissionToFileACL("missing", &core.Permission{RenterId: "r2", AesKey: "k2"})
+		if err != errNotFound {
+			t.Fatalf("expected errNotFound sharing missing file. Got %v", err)
+		}
+		err = db.UpdateFilePermission("f1", &core.Permission{RenterId: "r2", AesKey: "k3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.UpdateFilePermission("f1", &core.Permission{RenterId: "r3"})
+		if err == nil {
+			t.Fatal("updated missing permission")
+		}
+		file, err := db.FindFileByID("f1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(file.AccessList) != 1 || file.AccessList[0].AesKey != "k3" {
+			t.Fatalf("access list is %+v", file.AccessList)
+		}
+		err = db.RemoveFilePermissionFromACL("f1", "r2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.RemoveFilePermissionFromACL("f1", "r2")
+		if err == nil {
+			t.Fatal("removed missing permission")
+		}
+	})
+}
+
+func TestStoreContractsAndEgress(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		for _, c := range []core.Contract{
+			{ID: "c1", RenterId: "r1", ProviderId: "p1", StorageSpace: 10},
+			{ID: "c2", RenterId: "r2", ProviderId: "p1", StorageSpace: 20},
+			{ID: "c3", RenterId: "r1", ProviderId: "p2", StorageSpace: 30},
+		} {
+			err := db.InsertContract(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		contracts, err := db.FindContractsByRenter("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contracts) != 2 || contracts[0].ID != "c1" || contracts[1].ID != "c3" {
+			t.Fatalf("found contracts %+v for r1", contracts)
+		}
+
+		contract := contracts[0]
+		added, err := db.AddEgress(&contract, 100, 200)
+		if err != nil || added != 100 {
+			t.Fatalf("added %d bytes of egress. error: %v", added, err)
+		}
+		added, err = db.AddEgress(&contract, 50, 200)
+		if err != nil || added != 50 {
+			t.Fatalf("added %d bytes of egress. error: %v", added, err)
+		}
+		err = db.BillEgress("c1", 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		egress, err := db.FindAllEgress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(egress) != 1 {
+			t.Fatalf("found %d egress records. expected 1", len(egress))
+		}
+		e := egress[0]
+		if e.ContractID != "c1" || e.ProviderId != "p1" || e.RenterId != "r1" ||
+			e.UnbilledBytes != 30 || e.BilledBytes != 120 {
+			t.Fatalf("unexpected egress record %+v", e)
+		}
+
+		// Egress is capped at the limit, including what's been billed.
+		added, err = db.AddEgress(&contract, 100, 200)
+		if err != nil || added != 50 {
+			t.Fatalf("expected egress to be capped at 50 bytes. added %d. error: %v", added, err)
+		}
+		added, err = db.AddEgress(&contract, 100, 200)
+		if err != nil || added != 0 {
+			t.Fatalf("expected no egress over the limit. added %d. error: %v", added, err)
+		}
+
+		date := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+		for _, tx := range []core.Transaction{
+			{UserType: "renter", UserID: "r1", ContractID: "c1", Amount: 5, Date: date},
+			{UserType: "provider", UserID: "r1", ContractID: "c1", Amount: 6, Date: date},
+			{UserType: "renter", UserID: "r1", ContractID: "c3", Amount: 7, Date: date.Add(time.Hour)},
+			{UserType: "renter", UserID: "r1", ContractID: "c4", Amount: 4, Date: date},
+		} {
+			err = db.InsertTransaction(&tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		txns, err := db.FindTransactionsByRenter("r1", time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Ordered by date, then by the order they were added.
+		if len(txns) != 3 || txns[0].Amount != 5 || txns[1].Amount != 4 || txns[2].Amount != 7 {
+			t.Fatalf("found transactions %+v for r1", txns)
+		}
+		if txns[0].ID == "" || txns[0].ID >= txns[1].ID {
+			t.Fatalf("transactions with the same date given IDs %q and %q", txns[0].ID, txns[1].ID)
+		}
+		txns, err = db.FindTransactionsByRenter("r1", date.Add(time.Minute), time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txns) != 1 || txns[0].Amount != 7 {
+			t.Fatalf("found transactions %+v for r1 since %v", txns, date.Add(time.Minute))
+		}
+		txns, err = db.FindTransactionsByRenter("r1", time.Time{}, date.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txns) != 2 || txns[0].Amount != 5 || txns[1].Amount != 4 {
+			t.Fatalf("found transactions %+v for r1 until %v", txns, date.Add(time.Hour))
+		}
+	})
+}
+
+func TestStoreSampleBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		files := testFiles()
+		for i := range files {
+			err := db.InsertFile(&files[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		blocks, err := db.SampleBlocks(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 24 {
+			t.Fatalf("sampled %d blocks. expected all 24", len(blocks))
+		}
+		blocks, err = db.SampleBlocks(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 5 {
+			t.Fatalf("sampled %d blocks. expected 5", len(blocks))
+		}
+		seen := map[string]bool{}
+		for _, b := range blocks {
+			if seen[b.Block.ID] {
+				t.Fatalf("block %s sampled twice", b.Block.ID)
+			}
+			seen[b.Block.ID] = true
+
+			// Test block IDs are the file ID, version, and index.
+			if b.OwnerID != "r1" || b.Block.ID[:2] != b.FileID+string(rune('0'+b.Version)) {
+				t.Fatalf("sampled block %+v has the wrong file or version", b)
+			}
+		}
+	})
+}
+
+func TestStoreLedger(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db MetaStore) {
+		err := db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice", Files: []string{}, Shared: []string{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.InsertPayment(&core.PaymentInfo{ContractID: "c1", IsPaying: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := []core.LedgerEntry{
+			{From: core.PaypalAccount, To: core.RenterAccount("r1"), Amount: 100},
+			{From: core.RenterAccount("r1"), To: core.EscrowAccount("c1"), Amount: 60},
+		}
+		for i := range entries {
+			err = db.PostLedgerEntry(&entries[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = db.PostLedgerEntry(&core.LedgerEntry{
+			From: core.RenterAccount("r1"), To: core.EscrowAccount("c1"), Amount: 50})
+		if err != errInsufficientFunds {
+			t.Fatalf("overdraft returned %v. expected %v", err, errInsufficientFunds)
+		}
+		err = db.PostLedgerEntry(&core.LedgerEntry{
+			From: core.RenterAccount("r1"), To: core.ProviderAccount("missing"), Amount: 10})
+		if err != errNotFound {
+			t.Fatalf("entry for missing account returned %v. expected %v", err, errNotFound)
+		}
+
+		// Updates don't change balances, even if the record passed in is stale.
+		renter, err := db.FindRenterByID("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		renter.Balance = 1000
+		err = db.UpdateRenter(renter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.UpdatePayment(&core.PaymentInfo{ContractID: "c1", IsPaying: false, Balance: 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		renter, err = db.FindRenterByID("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renter.Balance != 40 {
+			t.Fatalf("renter balance is %d. expected 40", renter.Balance)
+		}
+		payment, err := db.FindPaymentByContract("c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.Balance != 60 || payment.IsPaying {
+			t.Fatalf("unexpected payment %+v", payment)
+		}
+
+		posted, err := db.FindAllLedgerEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posted) != 2 || posted[0].Amount != 100 || posted[1].Amount != 60 {
+			t.Fatalf("found ledger entries %+v", posted)
+		}
+	})
+}
+
+func TestMongoRecoverLedger(t *testing.T) {
+	addr := os.Getenv("SKYBIN_TEST_MONGO_ADDR")
+	if addr == "" {
+		t.Skip("SKYBIN_TEST_MONGO_ADDR not set")
+	}
+	db, err := newMongoDB(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDB()
+	err = db.session.DB(dbName).DropDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertRenter(&core.RenterInfo{ID: "r1", Alias: "alice", Files: []string{}, Shared: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertProvider(&core.ProviderInfo{ID: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.PostLedgerEntry(&core.LedgerEntry{From: core.PaypalAccount, To: core.RenterAccount("r1"), Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave one entry applied to one of its accounts, and another
+	// applied to both but not yet done.
+	pending := mongoLedgerEntry{ID: bson.NewObjectId(), State: ledgerPending,
+		LedgerEntry: core.LedgerEntry{From: core.RenterAccount("r1"), To: core.ProviderAccount("p1"), Amount: 30}}
+	committed := mongoLedgerEntry{ID: bson.NewObjectId(), State: ledgerCommitted,
+		LedgerEntry: core.LedgerEntry{From: core.RenterAccount("r1"), To: core.ProviderAccount("p1"), Amount: 20}}
+	for _, doc := range []*mongoLedgerEntry{&pending, &committed} {
+		err = db.insertIntoCollection("ledger", doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.applyToBalance(doc.ID, doc.From, -doc.Amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.applyToBalance(committed.ID, committed.To, committed.Amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.recoverLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	renter, err := db.FindRenterByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := db.FindProviderByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renter.Balance != 80 || provider.Balance != 20 {
+		t.Fatalf("renter balance is %d and provider balance is %d. expected 80 and 20",
+			renter.Balance, provider.Balance)
+	}
+	posted, err := db.FindAllLedgerEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 {
+		t.Fatalf("found ledger entries %+v", posted)
+	}
+}
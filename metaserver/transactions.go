@@ -28,13 +28,26 @@ func (server *MetaServer) getRenterTransactionsHandler() http.HandlerFunc {
 			writeErr(err.Error(), http.StatusNotFound, w)
 			return
 		}
+		query, err := ParseTransactionQuery(r.URL.Query())
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 		// Retrieve the renter's transactions.
-		transactions, err := server.db.FindTransactionsByRenter(renter.ID)
+		transactions, err := server.db.FindTransactionsByRenter(renter.ID, query.Since, query.Until)
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
 		}
-		json.NewEncoder(w).Encode(transactions)
+		page, next, err := listTransactions(transactions, query)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if next != "" {
+			w.Header().Set(NextCursorHeader, next)
+		}
+		json.NewEncoder(w).Encode(page)
 	})
 }
 
@@ -58,12 +71,25 @@ func (server *MetaServer) getProviderTransactionsHandler() http.HandlerFunc {
 			writeErr(err.Error(), http.StatusNotFound, w)
 			return
 		}
+		query, err := ParseTransactionQuery(r.URL.Query())
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
 		// Retrieve the provider's transactions.
-		transactions, err := server.db.FindTransactionsByProvider(provider.ID)
+		transactions, err := server.db.FindTransactionsByProvider(provider.ID, query.Since, query.Until)
 		if err != nil {
 			writeAndLogInternalError(err, w, server.logger)
 			return
 		}
-		json.NewEncoder(w).Encode(transactions)
+		page, next, err := listTransactions(transactions, query)
+		if err != nil {
+			writeErr(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if next != "" {
+			w.Header().Set(NextCursorHeader, next)
+		}
+		json.NewEncoder(w).Encode(page)
 	})
 }
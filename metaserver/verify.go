@@ -0,0 +1,315 @@
+package metaserver
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"skybin/core"
+	"skybin/util"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Timeout for each request made while verifying a provider.
+const verifyTimeout = 10 * time.Second
+
+// Largest response the metaserver reads from a provider being verified.
+const maxVerifyRespSize = 64 * 1024
+
+// Returned when a provider announces an address the metaserver won't
+// connect to, such as a loopback or private network address.
+var errPrivateAddr = errors.New("provider address must be a public IP address or host name")
+
+// Networks that aren't reachable from the public internet, besides
+// loopback, link-local, multicast, and unspecified addresses.
+var privateNetworks = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// Returns whether ip is reachable from the public internet.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a client for requests to providers being verified. Unless
+// allowPrivate is set, it refuses to connect to addresses that aren't
+// public, so providers can't use the metaserver to reach hosts on its
+// network. The check is made when connecting, so host names can't
+// resolve to a private address after being checked. Redirects aren't
+// followed, since they could lead anywhere.
+func verifyClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: verifyTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return errPrivateAddr
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   verifyTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Checks that addr is a host and port the metaserver may connect to.
+// Host names are checked when they're resolved.
+func checkProviderAddr(addr string, allowPrivate bool) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("provider address must be a host and port: %s", err)
+	}
+	if ip := net.ParseIP(host); ip != nil && !allowPrivate && !isPublicIP(ip) {
+		return errPrivateAddr
+	}
+	return nil
+}
+
+// Returned when the metaserver can't connect to a provider's address.
+// Reason explains how the provider's operator might fix it.
+type unreachableError struct {
+	Addr   string
+	Reason string
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("unable to reach provider at %s: %s", e.Addr, e.Reason)
+}
+
+// Describes why connecting to a provider failed.
+func dialFailure(addr string, err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok && opErr.Err == errPrivateAddr {
+		return errPrivateAddr
+	}
+	reason := err.Error()
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		reason = "connection timed out. The provider may be behind a NAT or firewall. " +
+			"Make sure its port is open and forwarded to it"
+	} else if strings.Contains(reason, "connection refused") {
+		reason = "connection refused. Make sure the provider daemon is running " +
+			"and listening on the announced port"
+	} else if opErr, ok := err.(*net.OpError); ok {
+		if _, ok := opErr.Err.(*net.DNSError); ok {
+			reason = "unable to resolve the announced host"
+		}
+	}
+	return &unreachableError{Addr: addr, Reason: reason}
+}
+
+type postChallengeParams struct {
+	Nonce string `json:"nonce"`
+}
+
+type postChallengeResp struct {
+	Signature string `json:"signature"`
+}
+
+// Checks that the provider is reachable at its announced address, that the
+// server there reports the provider's public key, and that it can sign a
+// challenge with the matching private key.
+func (server *MetaServer) verifyProvider(provider *core.ProviderInfo, key *rsa.PublicKey) error {
+	if provider.Addr == "" {
+		return fmt.Errorf("provider has no address")
+	}
+	err := checkProviderAddr(provider.Addr, server.allowPrivateProviders)
+	if err != nil {
+		return err
+	}
+	client := verifyClient(server.allowPrivateProviders)
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/info", provider.Addr))
+	if err != nil {
+		return dialFailure(provider.Addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server at %s is not a skybin provider: GET /info returned %s",
+			provider.Addr, resp.Status)
+	}
+	var info core.ProviderInfo
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxVerifyRespSize)).Decode(&info)
+	if err != nil {
+		return fmt.Errorf("server at %s is not a skybin provider: unable to parse /info", provider.Addr)
+	}
+	if info.PublicKey != provider.PublicKey {
+		return fmt.Errorf("provider at %s has a different public key", provider.Addr)
+	}
+
+	nonce, err := util.GenerateAuditNonce()
+	if err != nil {
+		return err
+	}
+	challenge := &core.IdentityChallenge{
+		ProviderId: provider.ID,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+	}
+	body, err := json.Marshal(&postChallengeParams{challenge.Nonce})
+	if err != nil {
+		return err
+	}
+	resp, err = client.Post(fmt.Sprintf("http://%s/challenge", provider.Addr),
+		"application/json", bytes.NewReader(body))
+	if err != nil {
+		return dialFailure(provider.Addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider at %s did not answer identity challenge: %s",
+			provider.Addr, decodeError(io.LimitReader(resp.Body, maxVerifyRespSize)))
+	}
+	var respMsg postChallengeResp
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxVerifyRespSize)).Decode(&respMsg)
+	if err != nil {
+		return fmt.Errorf("provider at %s sent an unreadable challenge response", provider.Addr)
+	}
+	challenge.Signature = respMsg.Signature
+	err = core.VerifyIdentityChallenge(challenge, *key)
+	if err != nil {
+		return fmt.Errorf("provider at %s failed identity challenge", provider.Addr)
+	}
+	return nil
+}
+
+// Handles a provider asking to have its address verified, e.g. once its
+// daemon has started. The provider is marked unverified if the check fails.
+func (server *MetaServer) postVerifyProviderHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// Make sure the person making the request is the provider.
+		claims, err := util.GetTokenClaimsFromRequest(r)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if providerID, present := claims["providerID"]; !present || providerID.(string) != params["id"] {
+			writeErr("cannot verify other providers", http.StatusUnauthorized, w)
+			return
+		}
+
+		provider, err := server.db.FindProviderByID(params["id"])
+		if err != nil {
+			writeErr(err.Error(), http.StatusNotFound, w)
+			return
+		}
+		key, err := util.UnmarshalPublicKey([]byte(provider.PublicKey))
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		verifyErr := server.verifyProvider(provider, key)
+		provider.Verified = verifyErr == nil
+		err = server.db.UpdateProvider(provider)
+		if err != nil {
+			writeAndLogInternalError(err, w, server.logger)
+			return
+		}
+		if verifyErr != nil {
+			writeErr(verifyErr.Error(), http.StatusBadRequest, w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(provider)
+	})
+}
+
+// Name of the migration that verifies the providers registered before
+// the metaserver verified providers' addresses.
+const verifyProvidersMigration = "verify-existing-providers"
+
+// Number of providers verifyExistingProviders checks at once.
+const verifyWorkers = 16
+
+// Verifies the providers registered before addresses were verified, so
+// the ones the metaserver can reach stay listed. The others are listed
+// once they ask to be verified, which provider daemons do when they
+// start. This runs once, before the server handles requests, so the
+// providers can't change while they're checked.
+func (server *MetaServer) verifyExistingProviders() error {
+	done, err := server.db.IsMigrationDone(verifyProvidersMigration)
+	if err != nil || done {
+		return err
+	}
+	providers, err := server.db.FindAllProviders()
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var updateErr error
+	providerCh := make(chan *core.ProviderInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < verifyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for provider := range providerCh {
+				key, err := util.UnmarshalPublicKey([]byte(provider.PublicKey))
+				if err == nil {
+					err = server.verifyProvider(provider, key)
+				}
+				if err != nil {
+					server.logger.Printf("Unable to verify existing provider %s: %s\n", provider.ID, err)
+					continue
+				}
+				provider.Verified = true
+				err = server.db.UpdateProvider(provider)
+				if err != nil {
+					mu.Lock()
+					updateErr = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range providers {
+		if !providers[i].Verified {
+			providerCh <- &providers[i]
+		}
+	}
+	close(providerCh)
+	wg.Wait()
+	if updateErr != nil {
+		return updateErr
+	}
+	return server.db.MarkMigrationDone(verifyProvidersMigration)
+}
@@ -0,0 +1,272 @@
+package metaserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"skybin/core"
+	"skybin/util"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Serves /info and /challenge like a provider whose /info reports
+// infoKey and which signs challenges with signingKey.
+func newFakeProvider(t *testing.T, id string, infoKey, signingKey *rsa.PrivateKey) *httptest.Server {
+	pubKey, err := util.MarshalPublicKey(&infoKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&core.ProviderInfo{ID: id, PublicKey: string(pubKey)})
+	}).Methods("GET")
+	router.HandleFunc("/challenge", func(w http.ResponseWriter, r *http.Request) {
+		var params postChallengeParams
+		json.NewDecoder(r.Body).Decode(&params)
+		c := &core.IdentityChallenge{ProviderId: id, Nonce: params.Nonce}
+		signature, err := core.SignIdentityChallenge(c, signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(&postChallengeResp{signature})
+	}).Methods("POST")
+	return httptest.NewServer(router)
+}
+
+func TestVerifyProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := util.FingerprintKey(pubKey)
+
+	cases := []struct {
+		name        string
+		infoKey     *rsa.PrivateKey
+		signingKey  *rsa.PrivateKey
+		ok          bool
+		unreachable bool
+	}{
+		{name: "valid", infoKey: key, signingKey: key, ok: true},
+		{name: "different public key", infoKey: other, signingKey: other},
+		{name: "replayed public key", infoKey: key, signingKey: other},
+		{name: "unreachable", infoKey: key, signingKey: key, unreachable: true},
+	}
+	server := &MetaServer{allowPrivateProviders: true}
+	for _, c := range cases {
+		ts := newFakeProvider(t, id, c.infoKey, c.signingKey)
+		if c.unreachable {
+			ts.Close()
+		}
+		provider := &core.ProviderInfo{
+			ID:        id,
+			PublicKey: string(pubKey),
+			Addr:      strings.TrimPrefix(ts.URL, "http://"),
+		}
+		err = server.verifyProvider(provider, &key.PublicKey)
+		ts.Close()
+		if c.ok && err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Fatalf("%s: verification should fail", c.name)
+		}
+		if _, unreachable := err.(*unreachableError); unreachable != c.unreachable {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
+
+func TestVerifyProviderRejectsPrivateAddrs(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := util.FingerprintKey(pubKey)
+	ts := newFakeProvider(t, id, key, key)
+	defer ts.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Host names are checked once they're resolved.
+	server := &MetaServer{}
+	for _, host := range []string{"127.0.0.1", "localhost", "10.1.2.3", "169.254.169.254", "[::1]"} {
+		provider := &core.ProviderInfo{ID: id, PublicKey: string(pubKey), Addr: host + ":" + port}
+		err = server.verifyProvider(provider, &key.PublicKey)
+		if err != errPrivateAddr {
+			t.Fatalf("verifying provider at %s returned %v. expected %v", provider.Addr, err, errPrivateAddr)
+		}
+	}
+}
+
+func TestRegistrationLimiter(t *testing.T) {
+	l := registrationLimiter{limit: DefaultRegistrationLimit}
+	now := time.Now()
+	for i := 0; i < DefaultRegistrationLimit; i++ {
+		if !l.allow("a", now) {
+			t.Fatalf("registration %d refused", i)
+		}
+	}
+	if l.allow("a", now) {
+		t.Fatal("registration allowed over the limit")
+	}
+	if !l.allow("b", now) {
+		t.Fatal("other client's registration refused")
+	}
+	if !l.allow("a", now.Add(registrationWindow+time.Second)) {
+		t.Fatal("registration refused after the window passed")
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	router.Handle("/providers", server.getProvidersHandler()).Methods("GET")
+	router.Handle("/providers", server.postProviderHandler()).Methods("POST")
+	server.allowPrivateProviders = true
+
+	register := func(key *rsa.PrivateKey, addr string) (int, *core.ProviderInfo) {
+		pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(&core.ProviderInfo{PublicKey: string(pubKey), Addr: addr, Verified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/providers", bytes.NewReader(b)))
+		var provider core.ProviderInfo
+		json.NewDecoder(w.Body).Decode(&provider)
+		return w.Code, &provider
+	}
+
+	reachable, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := util.MarshalPublicKey(&reachable.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newFakeProvider(t, util.FingerprintKey(pubKey), reachable, reachable)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	code, provider := register(reachable, addr)
+	if code != http.StatusCreated || !provider.Verified {
+		t.Fatalf("reachable provider registration returned %d, verified %v", code, provider.Verified)
+	}
+
+	// A provider whose daemon isn't running yet is registered unverified.
+	closed := newFakeProvider(t, "", offline, offline)
+	closed.Close()
+	code, provider = register(offline, strings.TrimPrefix(closed.URL, "http://"))
+	if code != http.StatusCreated || provider.Verified {
+		t.Fatalf("unreachable provider registration returned %d, verified %v", code, provider.Verified)
+	}
+
+	// Claiming another provider's address fails.
+	code, _ = register(impostor, addr)
+	if code != http.StatusBadRequest {
+		t.Fatalf("registration at another provider's address returned %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/providers?includeOffline=true", nil))
+	var resp getProvidersResp
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Providers) != 1 || resp.Providers[0].Addr != addr {
+		t.Fatalf("expected only the verified provider to be listed. got %+v", resp.Providers)
+	}
+}
+
+func TestVerifyExistingProviders(t *testing.T) {
+	server, cleanup := newTestServer(t)
+	defer cleanup()
+	server.allowPrivateProviders = true
+
+	reachable, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, key := range []*rsa.PrivateKey{reachable, offline} {
+		pubKey, err := util.MarshalPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := util.FingerprintKey(pubKey)
+		ts := newFakeProvider(t, id, key, key)
+		defer ts.Close()
+		if key == offline {
+			ts.Close()
+		}
+		err = server.db.InsertProvider(&core.ProviderInfo{
+			ID:        id,
+			PublicKey: string(pubKey),
+			Addr:      strings.TrimPrefix(ts.URL, "http://"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	err = server.verifyExistingProviders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{true, false} {
+		provider, err := server.db.FindProviderByID(ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if provider.Verified != expected {
+			t.Fatalf("provider %d has Verified %v. expected %v", i, provider.Verified, expected)
+		}
+	}
+	done, err := server.db.IsMigrationDone(verifyProvidersMigration)
+	if err != nil || !done {
+		t.Fatalf("migration not marked done. error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package metrics exports Prometheus metrics common to the skybin
+// daemons. Metrics specific to one daemon are defined in its package.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixed to the names of all skybin metrics.
+const Namespace = "skybin"
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Name:      "http_request_duration_seconds",
+	Help:      "Time taken to serve HTTP requests, by server, route, and response status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"server", "method", "route", "code"})
+
+// Errors counts failures in the daemons' background work and
+// transfers, by the component where they happened.
+var Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "errors_total",
+	Help:      "Errors by component.",
+}, []string{"component"})
+
+func init() {
+	prometheus.MustRegister(requestDuration, Errors)
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Instrument records the latency of every request the router serves,
+// labelled with the matched route's path template so that requests
+// for different IDs share a series.
+func Instrument(server string, router *mux.Router) {
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			route := "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+			requestDuration.WithLabelValues(server, r.Method, route, strconv.Itoa(sw.status)).
+				Observe(time.Since(start).Seconds())
+		})
+	})
+}
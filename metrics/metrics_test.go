@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestInstrument(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	router.Handle("/metrics", Handler()).Methods("GET")
+	Instrument("test", router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, id := range []string{"a", "b"} {
+		resp, err := http.Get(ts.URL + "/files/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	Errors.WithLabelValues("test_component").Inc()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`skybin_http_request_duration_seconds_count{code="404",method="GET",route="/files/{id}",server="test"} 2`,
+		`skybin_errors_total{component="test_component"} 1`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("metrics missing %q", line)
+		}
+	}
+}
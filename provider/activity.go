@@ -1,6 +1,9 @@
 package provider
 
-import "fmt"
+import (
+	"fmt"
+	"skybin/metrics"
+)
 
 type activity struct {
 	Timestamps          []string `json:"timestamps"`
@@ -33,8 +36,10 @@ const (
 )
 
 func (provider *Provider) addActivity(op string, bytes int64) error {
+	recordActivityMetrics(op, bytes)
 	err := provider.db.CycleActivity()
 	if err != nil {
+		metrics.Errors.WithLabelValues("provider_activity").Inc()
 		return fmt.Errorf("Error adding new activity to DB: %s", err)
 	}
 
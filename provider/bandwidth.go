@@ -0,0 +1,314 @@
+package provider
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Size of the pieces that rate limited block transfers are split into.
+// Renters competing for bandwidth take turns sending pieces of this size.
+const bandwidthChunkSize = 32 * 1024
+
+// How long renters' throughput is averaged over in activity stats.
+const throughputWindow = time.Minute
+
+// RenterThroughput reports how fast a renter has recently
+// transferred blocks, in bytes per second.
+type RenterThroughput struct {
+	RenterID     string `json:"renterId"`
+	UploadRate   int64  `json:"uploadRate"`
+	DownloadRate int64  `json:"downloadRate"`
+}
+
+// bandwidthLimiter limits the rate of block transfers in one direction,
+// both overall and for each renter. When renters compete for bandwidth
+// it's shared between them fairly, no matter how many blocks each is
+// transferring at once. The zero value imposes no limits.
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// Limits in bytes per second. Zero means unlimited.
+	rate       int64
+	renterRate int64
+
+	// When the link is next free, given the overall limit.
+	linkFree time.Time
+
+	// Waiting transfers are tagged with the virtual time they'd finish
+	// if each renter had its own equal share of the link, and go in
+	// order of their tags.
+	virtualTime float64
+	waiting     []*bandwidthRequest
+
+	renters map[string]*renterBandwidth
+}
+
+type bandwidthRequest struct {
+	tag float64
+}
+
+type renterBandwidth struct {
+	// Tag of the renter's latest transfer
+	finishTag float64
+
+	// When the renter is next free to transfer, given the per-renter limit.
+	linkFree time.Time
+
+	// Bytes transferred in the current and previous throughput windows.
+	windowStart time.Time
+	windowBytes int64
+	prevBytes   int64
+}
+
+func (r *renterBandwidth) roll(now time.Time) {
+	elapsed := now.Sub(r.windowStart)
+	if elapsed < throughputWindow {
+		return
+	}
+	if elapsed < 2*throughputWindow {
+		r.prevBytes = r.windowBytes
+		r.windowStart = r.windowStart.Add(throughputWindow)
+	} else {
+		r.prevBytes = 0
+		r.windowStart = now
+	}
+	r.windowBytes = 0
+}
+
+// Returns the renter's throughput over the last complete window.
+func (r *renterBandwidth) throughput(now time.Time) int64 {
+	r.roll(now)
+	return r.prevBytes / int64(throughputWindow/time.Second)
+}
+
+func transferTime(n int, rate int64) time.Duration {
+	return time.Duration(int64(n) * int64(time.Second) / rate)
+}
+
+func laterOf(t1, t2 time.Time) time.Time {
+	if t1.After(t2) {
+		return t1
+	}
+	return t2
+}
+
+func (l *bandwidthLimiter) setLimits(rate, renterRate int64) {
+	l.mu.Lock()
+	l.rate = rate
+	l.renterRate = renterRate
+	l.mu.Unlock()
+}
+
+// The caller must hold l.mu.
+func (l *bandwidthLimiter) renter(renterID string) *renterBandwidth {
+	if l.renters == nil {
+		l.renters = map[string]*renterBandwidth{}
+	}
+	r, exists := l.renters[renterID]
+	if !exists {
+		r = &renterBandwidth{}
+		l.renters[renterID] = r
+	}
+	return r
+}
+
+// Returns the waiting transfer that should go next.
+// The caller must hold l.mu.
+func (l *bandwidthLimiter) next() *bandwidthRequest {
+	var next *bandwidthRequest
+	for _, req := range l.waiting {
+		if next == nil || req.tag < next.tag {
+			next = req
+		}
+	}
+	return next
+}
+
+// The caller must hold l.mu.
+func (l *bandwidthLimiter) remove(req *bandwidthRequest) {
+	for i, r := range l.waiting {
+		if r == req {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// Blocks until the renter may transfer n bytes.
+// n should be at most bandwidthChunkSize.
+func (l *bandwidthLimiter) wait(renterID string, n int) {
+	l.mu.Lock()
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+	r := l.renter(renterID)
+	now := time.Now()
+	r.roll(now)
+	r.windowBytes += int64(n)
+
+	// The per-renter limit is applied before the renter waits for
+	// the link so that renters at their limit don't hold up others.
+	if l.renterRate > 0 {
+		start := laterOf(now, r.linkFree)
+		r.linkFree = start.Add(transferTime(n, l.renterRate))
+		if delay := start.Sub(now); delay > 0 {
+			l.mu.Unlock()
+			time.Sleep(delay)
+			l.mu.Lock()
+		}
+	}
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	req := &bandwidthRequest{
+		tag: math.Max(l.virtualTime, r.finishTag) + float64(n),
+	}
+	r.finishTag = req.tag
+	l.waiting = append(l.waiting, req)
+	for {
+		if l.next() != req {
+			l.cond.Wait()
+			continue
+		}
+
+		// Wait for the link to be free, giving up our turn if a
+		// transfer with an earlier tag arrives in the meantime.
+		now = time.Now()
+		if delay := l.linkFree.Sub(now); delay > 0 {
+			l.mu.Unlock()
+			time.Sleep(delay)
+			l.mu.Lock()
+			continue
+		}
+		break
+	}
+	l.remove(req)
+	l.virtualTime = req.tag
+	l.linkFree = now.Add(transferTime(n, l.rate))
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// Returns each renter's recent throughput in bytes per second.
+func (l *bandwidthLimiter) throughput() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	rates := map[string]int64{}
+	for renterID, r := range l.renters {
+		rates[renterID] = r.throughput(now)
+	}
+	return rates
+}
+
+// Sets the bandwidth limits from the provider's config.
+func (p *Provider) updateBandwidthLimits() {
+	p.uploadBandwidth.setLimits(p.Config.MaxUploadRate, p.Config.MaxRenterUploadRate)
+	p.downloadBandwidth.setLimits(p.Config.MaxDownloadRate, p.Config.MaxRenterDownloadRate)
+}
+
+// Returns the recent throughput of each renter that has transferred blocks.
+func (p *Provider) renterThroughput() []RenterThroughput {
+	uploads := p.uploadBandwidth.throughput()
+	downloads := p.downloadBandwidth.throughput()
+	renters := []RenterThroughput{}
+	for renterID, rate := range uploads {
+		renters = append(renters, RenterThroughput{
+			RenterID:     renterID,
+			UploadRate:   rate,
+			DownloadRate: downloads[renterID],
+		})
+	}
+	for renterID, rate := range downloads {
+		if _, exists := uploads[renterID]; !exists {
+			renters = append(renters, RenterThroughput{
+				RenterID:     renterID,
+				DownloadRate: rate,
+			})
+		}
+	}
+	sort.Slice(renters, func(i, j int) bool {
+		return renters[i].RenterID < renters[j].RenterID
+	})
+	return renters
+}
+
+// Reads a block a renter is uploading, limited to the renter's share
+// of the upload bandwidth.
+type limitedReader struct {
+	r        io.Reader
+	limiter  *bandwidthLimiter
+	renterID string
+}
+
+func (p *Provider) limitUpload(renterID string, r io.Reader) io.Reader {
+	return &limitedReader{
+		r:        r,
+		limiter:  &p.uploadBandwidth,
+		renterID: renterID,
+	}
+}
+
+func (lr *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > bandwidthChunkSize {
+		b = b[:bandwidthChunkSize]
+	}
+	n, err := lr.r.Read(b)
+	if n > 0 {
+		lr.limiter.wait(lr.renterID, n)
+	}
+	return n, err
+}
+
+// Sends a block to a renter, limited to the renter's share of the
+// download bandwidth. Records the response status and the number of
+// bytes of the response body sent.
+type blockResponseWriter struct {
+	http.ResponseWriter
+	limiter  *bandwidthLimiter
+	renterID string
+	status   int
+	n        int64
+}
+
+func (p *Provider) limitDownload(renterID string, w http.ResponseWriter) *blockResponseWriter {
+	return &blockResponseWriter{
+		ResponseWriter: w,
+		limiter:        &p.downloadBandwidth,
+		renterID:       renterID,
+	}
+}
+
+func (w *blockResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *blockResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > bandwidthChunkSize {
+			chunk = chunk[:bandwidthChunkSize]
+		}
+		w.limiter.wait(w.renterID, len(chunk))
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		w.n += int64(n)
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
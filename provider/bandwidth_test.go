@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterRate(t *testing.T) {
+	var l bandwidthLimiter
+	l.setLimits(1e6, 0)
+	start := time.Now()
+	for i := 0; i < 9; i++ {
+		l.wait("r1", 25000)
+	}
+	// The first piece goes immediately.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("transfers were not rate limited. Took %s", elapsed)
+	}
+}
+
+func TestBandwidthLimiterFairness(t *testing.T) {
+	var l bandwidthLimiter
+	l.setLimits(2e6, 0)
+	sent := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(500 * time.Millisecond)
+
+	// r1 transfers four blocks at once while r2 transfers one,
+	// but they should get about the same bandwidth.
+	for _, renterID := range []string{"r1", "r1", "r1", "r1", "r2"} {
+		wg.Add(1)
+		go func(renterID string) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				l.wait(renterID, 10000)
+				mu.Lock()
+				sent[renterID] += 10000
+				mu.Unlock()
+			}
+		}(renterID)
+	}
+	wg.Wait()
+	share := float64(sent["r2"]) / float64(sent["r1"]+sent["r2"])
+	if share < 0.35 {
+		t.Fatalf("renter with one transfer got %.2f of bandwidth", share)
+	}
+}
+
+func TestBandwidthLimiterPerRenter(t *testing.T) {
+	var l bandwidthLimiter
+	l.setLimits(0, 100000)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			l.wait("r1", 10000)
+		}
+		close(done)
+	}()
+
+	// r1 is held to its own limit without slowing down r2.
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	l.wait("r2", 10000)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("renter was held up by another renter's limit. Took %s", elapsed)
+	}
+	select {
+	case <-done:
+		t.Fatal("renter exceeded its limit")
+	default:
+	}
+	<-done
+}
+
+func TestRenterThroughput(t *testing.T) {
+	r := &renterBandwidth{}
+	now := time.Now()
+	r.roll(now)
+	r.windowBytes += 120000
+	if r.throughput(now.Add(30*time.Second)) != 0 {
+		t.Fatal("throughput should only count complete windows")
+	}
+	if rate := r.throughput(now.Add(90 * time.Second)); rate != 2000 {
+		t.Fatalf("expected 2000 bytes/s. Got %d", rate)
+	}
+	if r.throughput(now.Add(5*time.Minute)) != 0 {
+		t.Fatal("throughput should drop to zero when renter is idle")
+	}
+}
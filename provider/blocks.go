@@ -6,11 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path"
+	"skybin/core"
+	"time"
 )
 
-func (provider *Provider) StoreBlock(renterID string, blockID string, block io.Reader, blockSize int64) error {
+// Stores a block under one of the renter's contracts. The block must fit
+// in the space left in the contract.
+func (provider *Provider) StoreBlock(renterID string, contractID string, blockID string,
+	block io.Reader, blockSize int64) error {
 	provider.mu.RLock()
 	renter, exists := provider.renters[renterID]
 	provider.mu.RUnlock()
@@ -23,35 +26,37 @@ func (provider *Provider) StoreBlock(renterID string, blockID string, block io.R
 		return fmt.Errorf("Block of size %d exceeds available storage %d", blockSize, spaceAvail)
 	}
 
-	// create directory for renter's blocks if necessary
-	renterDir := path.Join(provider.Homedir, "blocks", renterID)
-	if _, err := os.Stat(renterDir); os.IsNotExist(err) {
-		err := os.MkdirAll(renterDir, 0700)
-		if err != nil {
-			return errors.New("Unable to save block")
-		}
+	contract, err := provider.reserveContractSpace(renterID, contractID, blockSize)
+	if err != nil {
+		return err
 	}
-
-	path := path.Join(renterDir, blockID)
-	f, err := os.Create(path)
+	d, err := provider.reserveDiskSpace(blockSize)
 	if err != nil {
-		return errors.New("Unable to save block")
+		provider.releaseContractSpace(contract, blockSize)
+		return err
 	}
-	defer f.Close()
-
-	_, err = io.CopyN(f, block, blockSize)
+	h := sha256.New()
+	merkleHash := core.NewMerkleHasher()
+	err = d.store.Put(renterID, blockID, io.TeeReader(block, io.MultiWriter(h, merkleHash)), blockSize)
 	if err != nil {
-		os.Remove(path)
+		provider.releaseContractSpace(contract, blockSize)
+		provider.releaseDiskSpace(d, blockSize)
+		go provider.checkDisk(d)
 		return errors.New("Unable to save block")
 	}
 
-	err = provider.db.InsertBlock(renterID, blockID, blockSize)
+	blockHash := base64.URLEncoding.EncodeToString(h.Sum(nil))
+	merkleNodes := encodeMerkleNodes(merkleHash.ChunkRoots())
+	err = provider.db.InsertBlock(renterID, contractID, blockID, blockSize, d.path, blockHash, merkleNodes)
 	if err != nil {
-		os.Remove(path)
+		d.store.Delete(renterID, blockID)
+		provider.releaseContractSpace(contract, blockSize)
+		provider.releaseDiskSpace(d, blockSize)
 		return fmt.Errorf("Failed to insert block into DB. error: %s", err)
 	}
 
 	provider.mu.Lock()
+	d.totalBlocks++
 	renter.StorageUsed += blockSize
 	provider.TotalBlocks++
 	provider.StorageUsed += blockSize
@@ -65,31 +70,49 @@ func (provider *Provider) StoreBlock(renterID string, blockID string, block io.R
 	return nil
 }
 
-// Returns an io.ReadCloser containing the block's contents.
-// If err != nil, the caller has responsibility for closing the reader.
-func (provider *Provider) GetBlock(renterID, blockID string) (io.ReadCloser, error) {
-	path := path.Join(provider.Homedir, "blocks", renterID, blockID)
-	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
-		return nil, fmt.Errorf("Cannot find block with ID %s", blockID)
+// Takes space for a block out of what's left in the renter's contract,
+// so that concurrent uploads can't overfill the contract.
+func (provider *Provider) reserveContractSpace(renterID, contractID string, blockSize int64) (*contractInfo, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	contract, exists := provider.contracts[contractID]
+	if !exists || contract.RenterId != renterID {
+		return nil, fmt.Errorf("Cannot find contract %s", contractID)
 	}
-
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("IOError: unable to retrieve block")
+	if !time.Now().Before(contract.EndDate) {
+		return nil, fmt.Errorf("Contract %s has ended", contractID)
+	}
+	spaceAvail := contract.StorageSpace - contract.StorageUsed
+	if blockSize > spaceAvail {
+		return nil, fmt.Errorf("Block of size %d exceeds storage %d left in contract %s",
+			blockSize, spaceAvail, contractID)
 	}
+	contract.StorageUsed += blockSize
+	return contract, nil
+}
 
-	fi, err := f.Stat()
+func (provider *Provider) releaseContractSpace(contract *contractInfo, blockSize int64) {
+	provider.mu.Lock()
+	contract.StorageUsed -= blockSize
+	provider.mu.Unlock()
+}
+
+// Returns a reader for the block's contents. Download activity isn't
+// recorded here since callers may read only part of the block.
+// If err == nil, the caller has responsibility for closing the reader.
+func (provider *Provider) GetBlock(renterID, blockID string) (BlockReader, error) {
+	d, err := provider.findBlockDisk(blockID)
 	if err != nil {
-		f.Close()
-		return nil, fmt.Errorf("IOError: unable to retrieve block")
+		return nil, fmt.Errorf("Cannot find block with ID %s", blockID)
+	}
+	f, _, err := d.store.Get(renterID, blockID)
+	if err == ErrBlockNotFound {
+		return nil, fmt.Errorf("Cannot find block with ID %s", blockID)
 	}
-
-	err = provider.addActivity(activityOpDownload, fi.Size())
 	if err != nil {
-		// non-fatal
-		//provider.logger.Println("Failed to update activity on download:", err)
+		go provider.checkDisk(d)
+		return nil, fmt.Errorf("IOError: unable to retrieve block")
 	}
-
 	return f, nil
 }
 
@@ -98,12 +121,16 @@ func (p *Provider) AuditBlock(renterID, blockID, nonce string) (hash string, err
 	if err != nil {
 		return "", fmt.Errorf("Unable to decode nonce. Error: %s", err)
 	}
-	blockPath := path.Join(p.Homedir, "blocks", renterID, blockID)
-	if _, err := os.Stat(blockPath); err != nil && os.IsNotExist(err) {
+	d, err := p.findBlockDisk(blockID)
+	if err != nil {
+		return "", errors.New("Cannot find block")
+	}
+	f, _, err := d.store.Get(renterID, blockID)
+	if err == ErrBlockNotFound {
 		return "", errors.New("Cannot find block")
 	}
-	f, err := os.Open(blockPath)
 	if err != nil {
+		go p.checkDisk(d)
 		return "", errors.New("IOError: Unable to retrieve block")
 	}
 	defer f.Close()
@@ -125,18 +152,24 @@ func (provider *Provider) DeleteBlock(renterID, blockID string) error {
 		return errors.New("No contracts found for given renter")
 	}
 
-	path := path.Join(provider.Homedir, "blocks", renterID, blockID)
-	fi, err := os.Stat(path)
+	d, err := provider.findBlockDisk(blockID)
+	if err != nil {
+		return fmt.Errorf("Block %s does not exist", blockID)
+	}
+	blockSize, err := d.store.Stat(renterID, blockID)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if err == ErrBlockNotFound {
 			return fmt.Errorf("Block %s does not exist", blockID)
 		}
 		return fmt.Errorf("IOError removing block")
 	}
 
-	blockSize := fi.Size()
+	contractID, err := provider.db.GetBlockContract(blockID)
+	if err != nil {
+		return fmt.Errorf("Failed to find block %s in DB. error: %s", blockID, err)
+	}
 
-	err = os.Remove(path)
+	err = d.store.Delete(renterID, blockID)
 	if err != nil {
 		return fmt.Errorf("Failed to delete block %s. error: %s", blockID, err)
 	}
@@ -147,7 +180,12 @@ func (provider *Provider) DeleteBlock(renterID, blockID string) error {
 	}
 
 	provider.mu.Lock()
+	d.used -= blockSize
+	d.totalBlocks--
 	renter.StorageUsed -= blockSize
+	if contract, exists := provider.contracts[contractID]; exists {
+		contract.StorageUsed -= blockSize
+	}
 	provider.TotalBlocks--
 	provider.StorageUsed -= blockSize
 	provider.mu.Unlock()
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreBlockContractQuota(t *testing.T) {
+	p, homedir := newTestProvider(t, 10000)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 1000)
+	addTestContract(p, "r1", "c2", 1000)
+	addTestContract(p, "r2", "c3", 1000)
+
+	data := make([]byte, 600)
+	err := p.StoreBlock("r1", "c1", "b1", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The renter has space left in c2, but not in c1.
+	err = p.StoreBlock("r1", "c1", "b2", bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected block to exceed the contract's storage")
+	}
+	err = p.StoreBlock("r1", "c2", "b2", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.StoreBlock("r1", "c4", "b3", bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error storing block under unknown contract")
+	}
+	err = p.StoreBlock("r1", "c3", "b3", bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error storing block under another renter's contract")
+	}
+
+	p.contracts["c3"].EndDate = time.Now().Add(-time.Hour)
+	err = p.StoreBlock("r2", "c3", "b3", bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error storing block under ended contract")
+	}
+
+	contractID, err := p.db.GetBlockContract("b2")
+	if err != nil || contractID != "c2" {
+		t.Fatalf("expected b2 to be stored under c2. Got %q, error: %v", contractID, err)
+	}
+
+	// Deleting a block frees space in its contract.
+	err = p.DeleteBlock("r1", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.contracts["c1"].StorageUsed != 0 || p.contracts["c2"].StorageUsed != int64(len(data)) {
+		t.Fatalf("wrong contract usage after delete. c1: %d, c2: %d",
+			p.contracts["c1"].StorageUsed, p.contracts["c2"].StorageUsed)
+	}
+	err = p.StoreBlock("r1", "c1", "b1", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,658 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BlockStore stores the contents of renters' blocks.
+type BlockStore interface {
+	// Stores size bytes read from r as the given block,
+	// replacing any existing copy of the block.
+	Put(renterID, blockID string, r io.Reader, size int64) error
+
+	// Returns a reader for the block's contents along with its size.
+	// If err == nil, the caller must close the reader.
+	Get(renterID, blockID string) (BlockReader, int64, error)
+
+	// Returns the size of the block in bytes.
+	Stat(renterID, blockID string) (int64, error)
+
+	Delete(renterID, blockID string) error
+
+	// Calls fn with each block in the store, stopping
+	// if fn returns an error.
+	Walk(fn func(renterID, blockID string, size int64) error) error
+
+	Close() error
+}
+
+// BlockReader reads a block's contents. It can seek so that
+// ranges of a block can be read without reading the whole block.
+type BlockReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// Returned by BlockStore methods when the requested block doesn't exist.
+var ErrBlockNotFound = errors.New("Block not found")
+
+type BlockStoreBackend string
+
+const (
+	// One file per block under blocks/<renterID>/
+	FlatBlockStoreBackend BlockStoreBackend = "flat"
+
+	// One file per block, spread across two levels of subdirectories
+	// so that no directory holds too many entries.
+	ShardedBlockStoreBackend BlockStoreBackend = "sharded"
+
+	// All blocks appended to a single log file which is compacted
+	// as deleted blocks accumulate.
+	PackedBlockStoreBackend BlockStoreBackend = "packed"
+
+	DefaultBlockStoreBackend = FlatBlockStoreBackend
+)
+
+func validBlockStoreBackend(backend BlockStoreBackend) bool {
+	switch backend {
+	case FlatBlockStoreBackend:
+	case ShardedBlockStoreBackend:
+	case PackedBlockStoreBackend:
+	default:
+		return false
+	}
+	return true
+}
+
+// Returns the directory a backend keeps its blocks in. Each backend
+// uses its own directory so that blocks can be migrated between them.
+func blockStoreDir(homedir string, backend BlockStoreBackend) string {
+	switch backend {
+	case ShardedBlockStoreBackend:
+		return path.Join(homedir, "blocks-sharded")
+	case PackedBlockStoreBackend:
+		return path.Join(homedir, "blocks-packed")
+	default:
+		return path.Join(homedir, "blocks")
+	}
+}
+
+// Opens the block store for the given backend. An empty backend
+// opens the flat store used by providers created before backends
+// were configurable.
+func openBlockStore(homedir string, backend BlockStoreBackend) (BlockStore, error) {
+	if backend == "" {
+		backend = DefaultBlockStoreBackend
+	}
+	dir := blockStoreDir(homedir, backend)
+	switch backend {
+	case FlatBlockStoreBackend:
+		return newFlatBlockStore(dir)
+	case ShardedBlockStoreBackend:
+		return newShardedBlockStore(dir)
+	case PackedBlockStoreBackend:
+		return newPackedBlockStore(dir)
+	}
+	return nil, fmt.Errorf("Unrecognized block store backend %s", backend)
+}
+
+// fileBlockStore stores each block in its own file.
+type fileBlockStore struct {
+	dir string
+
+	// Returns the path of the file holding a block
+	blockPath func(renterID, blockID string) string
+}
+
+func newFlatBlockStore(dir string) (*fileBlockStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBlockStore{
+		dir: dir,
+		blockPath: func(renterID, blockID string) string {
+			return path.Join(dir, renterID, blockID)
+		},
+	}, nil
+}
+
+func newShardedBlockStore(dir string) (*fileBlockStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBlockStore{
+		dir: dir,
+		blockPath: func(renterID, blockID string) string {
+			// Shard on a hash of the block ID so blocks are spread
+			// evenly regardless of how IDs are generated.
+			h := sha256.Sum256([]byte(blockID))
+			shard := hex.EncodeToString(h[:2])
+			return path.Join(dir, renterID, shard[:2], shard[2:], blockID)
+		},
+	}, nil
+}
+
+func (s *fileBlockStore) Put(renterID, blockID string, r io.Reader, size int64) error {
+	blockPath := s.blockPath(renterID, blockID)
+	err := os.MkdirAll(path.Dir(blockPath), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(blockPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, r, size)
+	if err != nil {
+		f.Close()
+		os.Remove(blockPath)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(blockPath)
+		return err
+	}
+	return nil
+}
+
+func (s *fileBlockStore) Get(renterID, blockID string) (BlockReader, int64, error) {
+	f, err := os.Open(s.blockPath(renterID, blockID))
+	if os.IsNotExist(err) {
+		return nil, 0, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+func (s *fileBlockStore) Stat(renterID, blockID string) (int64, error) {
+	fi, err := os.Stat(s.blockPath(renterID, blockID))
+	if os.IsNotExist(err) {
+		return 0, ErrBlockNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *fileBlockStore) Delete(renterID, blockID string) error {
+	err := os.Remove(s.blockPath(renterID, blockID))
+	if os.IsNotExist(err) {
+		return ErrBlockNotFound
+	}
+	return err
+}
+
+// Blocks are files named by their block ID somewhere
+// under a directory named by their renter's ID.
+func (s *fileBlockStore) Walk(fn func(renterID, blockID string, size int64) error) error {
+	return filepath.Walk(s.dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) < 2 {
+			return nil
+		}
+		return fn(parts[0], fi.Name(), fi.Size())
+	})
+}
+
+func (s *fileBlockStore) Close() error {
+	return nil
+}
+
+const (
+	packedLogName = "blocks.log"
+
+	packedOpPut    = 1
+	packedOpDelete = 2
+
+	// Record header: op (1 byte), renter ID length (2 bytes),
+	// block ID length (2 bytes), data size (8 bytes).
+	packedHeaderLen = 13
+
+	// The log is compacted once deleted records take up at least
+	// this many bytes and half of the log.
+	packedCompactMinGarbage = 64 * 1024 * 1024
+)
+
+// Location of a block's data in the packed log.
+type packedEntry struct {
+	recordOffset int64
+	dataOffset   int64
+	size         int64
+}
+
+func (e *packedEntry) recordLen() int64 {
+	return e.dataOffset - e.recordOffset + e.size
+}
+
+// packedBlockStore appends blocks to a single log file. Deletions append
+// a tombstone record, and the space used by deleted or replaced blocks
+// is reclaimed by compacting the log. The index of live blocks is kept
+// in memory and rebuilt by scanning the log on startup.
+type packedBlockStore struct {
+	dir     string
+	log     *os.File
+	logSize int64
+	garbage int64
+	index   map[string]*packedEntry
+	mu      sync.Mutex
+
+	// Held while compacting, so only one compaction runs at a time.
+	compactMu  sync.Mutex
+	compacting bool
+	closed     bool
+}
+
+func packedKey(renterID, blockID string) string {
+	return renterID + "/" + blockID
+}
+
+func newPackedBlockStore(dir string) (*packedBlockStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &packedBlockStore{
+		dir:   dir,
+		index: map[string]*packedEntry{},
+	}
+	s.log, err = os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadIndex()
+	if err != nil {
+		s.log.Close()
+		return nil, fmt.Errorf("Unable to read block log. Error: %s", err)
+	}
+	return s, nil
+}
+
+func (s *packedBlockStore) logPath() string {
+	return path.Join(s.dir, packedLogName)
+}
+
+// Rebuilds the index by scanning the log. A partially written record
+// at the end of the log, left by a crash, is truncated.
+func (s *packedBlockStore) loadIndex() error {
+	fi, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := fi.Size()
+	var offset int64
+	for offset < fileSize {
+		op, renterID, blockID, size, err := readPackedHeader(s.log, offset)
+		if err == nil && op != packedOpPut && op != packedOpDelete {
+			err = fmt.Errorf("unknown record type %d", op)
+		}
+		dataOffset := offset + packedHeaderLen + int64(len(renterID)+len(blockID))
+		if err == io.ErrUnexpectedEOF || err == io.EOF || dataOffset+size > fileSize {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		key := packedKey(renterID, blockID)
+		if old, exists := s.index[key]; exists {
+			s.garbage += old.recordLen()
+			delete(s.index, key)
+		}
+		if op == packedOpPut {
+			s.index[key] = &packedEntry{
+				recordOffset: offset,
+				dataOffset:   dataOffset,
+				size:         size,
+			}
+		} else {
+			s.garbage += dataOffset - offset
+		}
+		offset = dataOffset + size
+	}
+	if offset < fileSize {
+		err = s.log.Truncate(offset)
+		if err != nil {
+			return err
+		}
+	}
+	s.logSize = offset
+	return nil
+}
+
+func readPackedHeader(f io.ReaderAt, offset int64) (op byte, renterID, blockID string, size int64, err error) {
+	var header [packedHeaderLen]byte
+	_, err = f.ReadAt(header[:], offset)
+	if err != nil {
+		return 0, "", "", 0, err
+	}
+	op = header[0]
+	renterLen := int(binary.BigEndian.Uint16(header[1:3]))
+	blockLen := int(binary.BigEndian.Uint16(header[3:5]))
+	size = int64(binary.BigEndian.Uint64(header[5:13]))
+	ids := make([]byte, renterLen+blockLen)
+	_, err = f.ReadAt(ids, offset+packedHeaderLen)
+	if err != nil {
+		return 0, "", "", 0, err
+	}
+	return op, string(ids[:renterLen]), string(ids[renterLen:]), size, nil
+}
+
+func packedHeader(op byte, renterID, blockID string, size int64) []byte {
+	buf := make([]byte, packedHeaderLen, packedHeaderLen+len(renterID)+len(blockID))
+	buf[0] = op
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(renterID)))
+	binary.BigEndian.PutUint16(buf[3:5], uint16(len(blockID)))
+	binary.BigEndian.PutUint64(buf[5:13], uint64(size))
+	buf = append(buf, renterID...)
+	buf = append(buf, blockID...)
+	return buf
+}
+
+// Appends a record to the end of the log and syncs it to disk, so a
+// block is never acknowledged before it's durable. On failure the log
+// is truncated back to its previous size.
+func (s *packedBlockStore) appendRecord(header []byte, data io.Reader, size int64) (*packedEntry, error) {
+	entry := &packedEntry{
+		recordOffset: s.logSize,
+		dataOffset:   s.logSize + int64(len(header)),
+		size:         size,
+	}
+	_, err := s.log.Seek(entry.recordOffset, os.SEEK_SET)
+	if err == nil {
+		_, err = s.log.Write(header)
+	}
+	if err == nil && size > 0 {
+		_, err = io.CopyN(s.log, data, size)
+	}
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		s.log.Truncate(s.logSize)
+		return nil, err
+	}
+	s.logSize = entry.dataOffset + size
+	return entry, nil
+}
+
+func (s *packedBlockStore) Put(renterID, blockID string, r io.Reader, size int64) error {
+	if len(renterID) > 0xffff || len(blockID) > 0xffff {
+		return errors.New("Block ID too long")
+	}
+
+	// Spool the block to a temp file first so that a slow upload
+	// doesn't hold up other operations on the log.
+	temp, err := ioutil.TempFile(s.dir, "put")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	_, err = io.CopyN(temp, r, size)
+	if err != nil {
+		return err
+	}
+	_, err = temp.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, err := s.appendRecord(packedHeader(packedOpPut, renterID, blockID, size), temp, size)
+	if err != nil {
+		return err
+	}
+	key := packedKey(renterID, blockID)
+	if old, exists := s.index[key]; exists {
+		s.garbage += old.recordLen()
+	}
+	s.index[key] = entry
+	return nil
+}
+
+type packedBlockReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r *packedBlockReader) Close() error {
+	return r.f.Close()
+}
+
+func (s *packedBlockStore) Get(renterID, blockID string) (BlockReader, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, exists := s.index[packedKey(renterID, blockID)]
+	if !exists {
+		return nil, 0, ErrBlockNotFound
+	}
+
+	// Open a separate handle so the reader stays valid
+	// if the log is compacted while it's in use.
+	f, err := os.Open(s.logPath())
+	if err != nil {
+		return nil, 0, err
+	}
+	return &packedBlockReader{
+		SectionReader: io.NewSectionReader(f, entry.dataOffset, entry.size),
+		f:             f,
+	}, entry.size, nil
+}
+
+func (s *packedBlockStore) Stat(renterID, blockID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, exists := s.index[packedKey(renterID, blockID)]
+	if !exists {
+		return 0, ErrBlockNotFound
+	}
+	return entry.size, nil
+}
+
+func (s *packedBlockStore) Walk(fn func(renterID, blockID string, size int64) error) error {
+	// Copy the index so fn can use the store.
+	s.mu.Lock()
+	sizes := make(map[string]int64, len(s.index))
+	for key, entry := range s.index {
+		sizes[key] = entry.size
+	}
+	s.mu.Unlock()
+	for key, size := range sizes {
+		ids := strings.SplitN(key, "/", 2)
+		err := fn(ids[0], ids[1], size)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *packedBlockStore) Delete(renterID, blockID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := packedKey(renterID, blockID)
+	entry, exists := s.index[key]
+	if !exists {
+		return ErrBlockNotFound
+	}
+	header := packedHeader(packedOpDelete, renterID, blockID, 0)
+	_, err := s.appendRecord(header, nil, 0)
+	if err != nil {
+		return err
+	}
+	delete(s.index, key)
+	s.garbage += entry.recordLen() + int64(len(header))
+	if s.garbage >= packedCompactMinGarbage && s.garbage*2 >= s.logSize && !s.compacting {
+		// Compacting copies every live block, so do it in the
+		// background rather than holding up this deletion. If it fails
+		// we'll try again after the next one.
+		s.compacting = true
+		go func() {
+			s.Compact()
+			s.mu.Lock()
+			s.compacting = false
+			s.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+// Compacts the log, rewriting it without deleted or replaced blocks.
+// Blocks are copied from a snapshot of the index without holding the
+// store's lock, so puts and deletes can continue in the meantime. The
+// lock is only taken at the end to copy records appended since the
+// snapshot and swap in the new log.
+func (s *packedBlockStore) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("Block store is closed")
+	}
+	log := s.log
+	snapshotSize := s.logSize
+	entries := make(map[string]packedEntry, len(s.index))
+	keys := make([]string, 0, len(s.index))
+	for key, entry := range s.index {
+		entries[key] = *entry
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	// Copy blocks in log order to keep reads from the old log sequential.
+	sort.Slice(keys, func(i, j int) bool {
+		return entries[keys[i]].recordOffset < entries[keys[j]].recordOffset
+	})
+
+	compactPath := s.logPath() + ".compact"
+	out, err := os.OpenFile(compactPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		out.Close()
+		os.Remove(compactPath)
+		return err
+	}
+	newIndex := make(map[string]*packedEntry, len(entries))
+	var offset int64
+	for _, key := range keys {
+		entry := entries[key]
+		record := io.NewSectionReader(log, entry.recordOffset, entry.recordLen())
+		_, err = io.Copy(out, record)
+		if err != nil {
+			return abort(err)
+		}
+		newIndex[key] = &packedEntry{
+			recordOffset: offset,
+			dataOffset:   offset + entry.dataOffset - entry.recordOffset,
+			size:         entry.size,
+		}
+		offset += entry.recordLen()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return abort(errors.New("Block store is closed"))
+	}
+
+	// Replay the puts and deletes made while we were copying.
+	var garbage int64
+	for pos := snapshotSize; pos < s.logSize; {
+		op, renterID, blockID, size, err := readPackedHeader(s.log, pos)
+		if err != nil {
+			return abort(err)
+		}
+		recordLen := packedHeaderLen + int64(len(renterID)+len(blockID)) + size
+		_, err = io.Copy(out, io.NewSectionReader(s.log, pos, recordLen))
+		if err != nil {
+			return abort(err)
+		}
+		key := packedKey(renterID, blockID)
+		if old, exists := newIndex[key]; exists {
+			garbage += old.recordLen()
+			delete(newIndex, key)
+		}
+		if op == packedOpPut {
+			newIndex[key] = &packedEntry{
+				recordOffset: offset,
+				dataOffset:   offset + recordLen - size,
+				size:         size,
+			}
+		} else {
+			garbage += recordLen
+		}
+		offset += recordLen
+		pos += recordLen
+	}
+
+	err = out.Sync()
+	if err == nil {
+		err = os.Rename(compactPath, s.logPath())
+	}
+	if err != nil {
+		return abort(err)
+	}
+	s.log.Close()
+	s.log = out
+	s.logSize = offset
+	s.garbage = garbage
+	s.index = newIndex
+
+	// Sync the directory so the rename survives a crash. The new log is
+	// already in place, so there's nothing to undo if this fails.
+	return syncDir(s.dir)
+}
+
+// Flushes a directory's entries, such as a renamed file, to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (s *packedBlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.log.Close()
+}
@@ -0,0 +1,273 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+)
+
+func readBlock(t *testing.T, store BlockStore, renterID, blockID string) []byte {
+	r, size, err := store.Get(renterID, blockID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size {
+		t.Fatalf("block size %d does not match contents length %d", size, len(data))
+	}
+	return data
+}
+
+func TestBlockStores(t *testing.T) {
+	for _, backend := range []BlockStoreBackend{
+		FlatBlockStoreBackend,
+		ShardedBlockStoreBackend,
+		PackedBlockStoreBackend,
+	} {
+		homedir, err := ioutil.TempDir("", "skybin_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(homedir)
+		store, err := openBlockStore(homedir, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data1 := make([]byte, 1000)
+		rand.Read(data1)
+		data2 := make([]byte, 500)
+		rand.Read(data2)
+		err = store.Put("r1", "b1", bytes.NewReader(data1), int64(len(data1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Put("r1", "b2", bytes.NewReader(data2), int64(len(data2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readBlock(t, store, "r1", "b1"), data1) {
+			t.Fatalf("%s: wrong contents for b1", backend)
+		}
+		size, err := store.Stat("r1", "b2")
+		if err != nil || size != int64(len(data2)) {
+			t.Fatalf("%s: wrong size for b2", backend)
+		}
+		err = store.Delete("r1", "b1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = store.Get("r1", "b1")
+		if err != ErrBlockNotFound {
+			t.Fatalf("%s: expected ErrBlockNotFound for deleted block. Got %v", backend, err)
+		}
+		if store.Delete("r1", "b1") != ErrBlockNotFound {
+			t.Fatalf("%s: expected ErrBlockNotFound deleting missing block", backend)
+		}
+		walked := map[string]int64{}
+		err = store.Walk(func(renterID, blockID string, size int64) error {
+			walked[renterID+"/"+blockID] = size
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(walked) != 1 || walked["r1/b2"] != int64(len(data2)) {
+			t.Fatalf("%s: walk returned wrong blocks %v", backend, walked)
+		}
+
+		// Blocks should survive reopening the store.
+		store.Close()
+		store, err = openBlockStore(homedir, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readBlock(t, store, "r1", "b2"), data2) {
+			t.Fatalf("%s: wrong contents for b2 after reopening", backend)
+		}
+		if _, err := store.Stat("r1", "b1"); err != ErrBlockNotFound {
+			t.Fatalf("%s: deleted block reappeared after reopening", backend)
+		}
+		store.Close()
+	}
+}
+
+func TestPackedBlockStoreTruncatesPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := newPackedBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("block contents")
+	err = store.Put("r1", "b1", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Simulate a crash partway through writing a second record.
+	f, err := os.OpenFile(path.Join(dir, packedLogName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(packedHeader(packedOpPut, "r1", "b2", 100))
+	f.Write([]byte("partial"))
+	f.Close()
+
+	store, err = newPackedBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Stat("r1", "b2"); err != ErrBlockNotFound {
+		t.Fatal("partially written block should not be found")
+	}
+	if !bytes.Equal(readBlock(t, store, "r1", "b1"), data) {
+		t.Fatal("wrong contents for b1")
+	}
+	err = store.Put("r1", "b3", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readBlock(t, store, "r1", "b3"), data) {
+		t.Fatal("wrong contents for block written after recovery")
+	}
+}
+
+func TestPackedBlockStoreCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := newPackedBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	blocks := map[string][]byte{}
+	for _, id := range []string{"b1", "b2", "b3", "b4"} {
+		data := make([]byte, 1000)
+		rand.Read(data)
+		blocks[id] = data
+		err = store.Put("r1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Delete("r1", "b1")
+	store.Delete("r1", "b3")
+
+	// Readers opened before compaction should remain valid.
+	r, _, err := store.Get("r1", "b4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sizeBefore := store.logSize
+	err = store.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.logSize >= sizeBefore/2 {
+		t.Fatalf("compaction did not shrink log. Before: %d, after: %d", sizeBefore, store.logSize)
+	}
+	old, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(old, blocks["b4"]) {
+		t.Fatal("reader opened before compaction returned wrong contents")
+	}
+	for _, id := range []string{"b2", "b4"} {
+		if !bytes.Equal(readBlock(t, store, "r1", id), blocks[id]) {
+			t.Fatalf("wrong contents for %s after compaction", id)
+		}
+	}
+}
+
+func TestPackedBlockStoreCompactConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := newPackedBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 1000)
+		rand.Read(data)
+		id := fmt.Sprintf("b%d", i)
+		blocks[id] = data
+		err = store.Put("r1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Puts and deletes made while compacting must survive it.
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 20; i++ {
+			err := store.Delete("r1", fmt.Sprintf("b%d", i))
+			if err != nil {
+				done <- err
+				return
+			}
+			data := make([]byte, 1000)
+			rand.Read(data)
+			id := fmt.Sprintf("c%d", i)
+			blocks[id] = data
+			err = store.Put("r1", id, bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 5; i++ {
+		err = store.Compact()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = newPackedBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 20; i++ {
+		_, err = store.Stat("r1", fmt.Sprintf("b%d", i))
+		if err != ErrBlockNotFound {
+			t.Fatalf("b%d still present after being deleted during compaction", i)
+		}
+		id := fmt.Sprintf("c%d", i)
+		if !bytes.Equal(readBlock(t, store, "r1", id), blocks[id]) {
+			t.Fatalf("wrong contents for %s after compaction", id)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package provider
 import (
 	"bytes"
 	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"skybin/authorization"
 	"skybin/core"
@@ -75,12 +77,37 @@ func (client *Client) RenewContract(contract *core.Contract) (*core.Contract, er
 	return respMsg.Contract, nil
 }
 
-func (client *Client) PutBlock(renterID string, blockID string, data io.Reader, size int64) error {
+func (client *Client) CancelContract(termination *core.ContractTermination) (*core.ContractTermination, error) {
+	url := fmt.Sprintf("http://%s/contracts/cancel", client.addr)
+	body, err := json.Marshal(&cancelContractParams{Termination: termination})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.client.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+	var respMsg cancelContractResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return nil, err
+	}
+	return respMsg.Termination, nil
+}
+
+// PutBlock stores a block under one of the renter's contracts
+// with the provider.
+func (client *Client) PutBlock(renterID string, contractID string, blockID string, data io.Reader, size int64) error {
 	if client.token == "" {
 		return errors.New("Must authorize before calling PUT /block")
 	}
 
-	url := fmt.Sprintf("http://%s/blocks?renterID=%s&blockID=%s&size=%d", client.addr, renterID, blockID, size)
+	url := fmt.Sprintf("http://%s/blocks?renterID=%s&contractID=%s&blockID=%s&size=%d",
+		client.addr, renterID, contractID, blockID, size)
 	req, err := http.NewRequest(http.MethodPost, url, data)
 	if err != nil {
 		return err
@@ -100,18 +127,76 @@ func (client *Client) PutBlock(renterID string, blockID string, data io.Reader,
 	return nil
 }
 
-// GetBlock retrieves the block with the given owner and ID.
-// The caller has the responsibility of closing the returned
-// ReadCloser if no error is returned.
+// GetBlock retrieves the block with the given owner and ID. The client
+// must be authorized as the block's owner. The caller has the
+// responsibility of closing the returned ReadCloser if no error is returned.
 func (client *Client) GetBlock(renterID string, blockID string) (io.ReadCloser, error) {
+	return client.GetBlockRange(renterID, blockID, 0, -1)
+}
+
+// GetBlockRange is like GetBlock, but retrieves only length bytes of the
+// block starting at offset. If length is negative, the rest of the block
+// is retrieved.
+func (client *Client) GetBlockRange(renterID string, blockID string, offset int64, length int64) (io.ReadCloser, error) {
+	if client.token == "" {
+		return nil, errors.New("Must authorize before calling GET /blocks")
+	}
+	return client.getBlock(renterID, blockID, nil, offset, length)
+}
+
+// GetSharedBlock retrieves another renter's block using a read
+// capability they issued.
+func (client *Client) GetSharedBlock(capability *core.ReadCapability, blockID string) (io.ReadCloser, error) {
+	return client.GetSharedBlockRange(capability, blockID, 0, -1)
+}
+
+// GetSharedBlockRange is like GetBlockRange, but uses a read capability.
+func (client *Client) GetSharedBlockRange(capability *core.ReadCapability, blockID string,
+	offset int64, length int64) (io.ReadCloser, error) {
+	return client.getBlock(capability.RenterId, blockID, capability, offset, length)
+}
+
+func (client *Client) getBlock(renterID string, blockID string, capability *core.ReadCapability,
+	offset int64, length int64) (io.ReadCloser, error) {
 	url := fmt.Sprintf("http://%s/blocks?renterID=%s&blockID=%s", client.addr, renterID, blockID)
-	resp, err := client.client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	if capability != nil {
+		data, err := json.Marshal(capability)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add(ReadCapabilityHeader, base64.StdEncoding.EncodeToString(data))
+	} else {
+		token := fmt.Sprintf("Bearer %s", client.token)
+		req.Header.Add("Authorization", token)
+	}
 
-	if resp.StatusCode != http.StatusOK {
+	isRange := offset != 0 || length >= 0
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	} else if length > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset != 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedStatus := http.StatusOK
+	if isRange {
+		expectedStatus = http.StatusPartialContent
+	}
+	if resp.StatusCode != expectedStatus {
 		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return nil, fmt.Errorf("Range %d+%d is outside of block", offset, length)
+		}
 		return nil, decodeError(resp.Body)
 	}
 	return resp.Body, nil
@@ -141,6 +226,31 @@ func (client *Client) AuditBlock(renterID string, blockID string, nonce string)
 	return respMsg.Hash, nil
 }
 
+// ProveBlock asks the provider to prove that it stores the segments
+// of a block given in the request, which the metaserver must have signed.
+func (client *Client) ProveBlock(req *core.ProofRequest) ([]*core.MerkleProof, error) {
+	url := fmt.Sprintf("http://%s/blocks/proof?renterID=%s&blockID=%s", client.addr, req.RenterId, req.BlockId)
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.client.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+	var respMsg postProofResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return nil, err
+	}
+	return respMsg.Proofs, nil
+}
+
 func (client *Client) RemoveBlock(renterID string, blockID string) error {
 	if client.token == "" {
 		return errors.New("Must authorize before calling DELETE /block")
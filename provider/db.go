@@ -13,106 +13,51 @@ type providerDB struct {
 	*sql.DB
 }
 
-// Initialize DB
+// Initialize DB, migrating it to the latest schema
 func setupDB(path string) (*providerDB, error) {
 	// Open DB
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open DB. error: %s", err)
 	}
-
-	// Create contracts table
-	stmt, err := db.Prepare(`CREATE TABLE IF NOT EXISTS contracts ( id INTEGER PRIMARY KEY, 
-		ContractId TEXT, 
-		RenterId TEXT, 
-		ProviderId TEXT, 
-		StorageSpace INTEGER, 
-		StartDate TEXT, 
-		EndDate TEXT, 
-		RenterSignature TEXT, 
-		ProviderSignature TEXT,
-		StorageFee INTEGER)
-		`)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare contracts table. error: %s", err)
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create contracts table. error: %s", err)
-	}
-
-	// Create blocks table
-	stmt, err = db.Prepare(`CREATE TABLE IF NOT EXISTS blocks ( id INTEGER PRIMARY KEY, 
-		RenterId TEXT, 
-		BlockId TEXT, 
-		Size INTEGER)`)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare blocks table. error: %s", err)
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create blocks table. error: %s", err)
-	}
-
-	// Create activity table
-	stmt, err = db.Prepare(`CREATE TABLE IF NOT EXISTS activity ( id INTEGER PRIMARY KEY, 
-		Period TEXT, 
-		Timestamp TEXT, 
-		BlockUploads INTEGER DEFAULT 0, 
-		BlockDownloads INTEGER DEFAULT 0, 
-		BlockDeletions INTEGER DEFAULT 0, 
-		BytesUploaded INTEGER DEFAULT 0, 
-		BytesDownloaded INTEGER DEFAULT 0, 
-		StorageReservations INTEGER DEFAULT 0 )`)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare activity table. error: %s", err)
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create activity table. error: %s", err)
-	}
-
-	// Add blockid index on blocks
-	stmt, err = db.Prepare(`CREATE INDEX IF NOT EXISTS blockid_blocks ON blocks (BlockId)`)
-
+	_, _, err = migrateDB(db, path)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare Index for BlockId on Blocks table. error: %s", err)
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create Index for BlockId on Blocks table. error: %s", err)
-	}
-
-	// Add renterid index on blocks
-	stmt, err = db.Prepare(`CREATE INDEX IF NOT EXISTS renterid_blocks ON blocks (RenterId)`)
-
-	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare Index for RenterId on Blocks table. error: %s", err)
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create Index for RenterId on Blocks table. error: %s", err)
+		db.Close()
+		return nil, err
 	}
+	pdb := &providerDB{db}
+	return pdb, nil
+}
 
-	// Add crenterid index on contracts
-	stmt, err = db.Prepare(`CREATE INDEX IF NOT EXISTS renterid_idx ON contracts (RenterId)`)
-
+// Adds a column to a table if the table doesn't have it yet.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to prepare Index for contracts. error: %s", err)
+		return err
 	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create Index for contracts. error: %s", err)
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
 	}
-	pdb := &providerDB{db}
-	return pdb, nil
+	rows.Close()
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
 }
 
 func (db *providerDB) InsertContract(contract *core.Contract) error {
 	stmt, err := db.Prepare(`INSERT INTO contracts
 		(ContractId, RenterId, ProviderId, StorageSpace,
-		StartDate, EndDate, RenterSignature, ProviderSignature, StorageFee)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		StartDate, EndDate, RenterSignature, ProviderSignature, StorageFee, DownloadRate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -126,6 +71,7 @@ func (db *providerDB) InsertContract(contract *core.Contract) error {
 		contract.RenterSignature,
 		contract.ProviderSignature,
 		contract.StorageFee,
+		contract.DownloadRate,
 	)
 	if err != nil {
 		return err
@@ -149,7 +95,7 @@ func (db *providerDB) DeleteContractById(contractId string) error {
 // This is used in GET /renter-info
 func (db *providerDB) GetContractsByRenter(renterId string) ([]*core.Contract, error) {
 	query := fmt.Sprintf(`SELECT ContractId, RenterId, ProviderId, StorageSpace,
-		RenterSignature, ProviderSignature, StorageFee, StartDate, EndDate
+		RenterSignature, ProviderSignature, StorageFee, StartDate, EndDate, DownloadRate
 		FROM contracts where RenterId='%s'`, renterId)
 	rows, err := db.Query(query)
 	if err != nil {
@@ -163,7 +109,7 @@ func (db *providerDB) GetContractsByRenter(renterId string) ([]*core.Contract, e
 		var startDate string
 		var endDate string
 		err = rows.Scan(&c.ID, &c.RenterId, &c.ProviderId, &c.StorageSpace, &c.RenterSignature,
-			&c.ProviderSignature, &c.StorageFee, &startDate, &endDate)
+			&c.ProviderSignature, &c.StorageFee, &startDate, &endDate, &c.DownloadRate)
 		if err != nil {
 			return nil, err
 		}
@@ -177,7 +123,7 @@ func (db *providerDB) GetContractsByRenter(renterId string) ([]*core.Contract, e
 // This is used in the local GET /contracts and loadDbintoMemory
 func (db *providerDB) GetAllContracts() ([]*core.Contract, error) {
 	rows, err := db.Query(`SELECT ContractId, RenterId, ProviderId, StorageSpace,
-		RenterSignature, ProviderSignature, StorageFee, StartDate, EndDate FROM contracts`)
+		RenterSignature, ProviderSignature, StorageFee, StartDate, EndDate, DownloadRate FROM contracts`)
 	if err != nil {
 		return nil, err
 	}
@@ -189,7 +135,7 @@ func (db *providerDB) GetAllContracts() ([]*core.Contract, error) {
 		var startDate string
 		var endDate string
 		err = rows.Scan(&c.ID, &c.RenterId, &c.ProviderId, &c.StorageSpace, &c.RenterSignature,
-			&c.ProviderSignature, &c.StorageFee, &startDate, &endDate)
+			&c.ProviderSignature, &c.StorageFee, &startDate, &endDate, &c.DownloadRate)
 		if err != nil {
 			return nil, err
 		}
@@ -200,12 +146,17 @@ func (db *providerDB) GetAllContracts() ([]*core.Contract, error) {
 	return contracts, nil
 }
 
-func (db *providerDB) InsertBlock(renterId string, blockId string, size int64) error {
-	stmt, err := db.Prepare(`INSERT INTO blocks (RenterId, BlockId, Size) VALUES (?, ?, ?)`)
+// Inserts a block stored under the given contract. sha256 should be the
+// base64 encoded hash of the block, which counts as the block's first scrub.
+func (db *providerDB) InsertBlock(renterId string, contractId string, blockId string, size int64, disk string,
+	sha256 string, merkleNodes string) error {
+	stmt, err := db.Prepare(`INSERT INTO blocks (RenterId, ContractId, BlockId, Size, Disk, Sha256, ScrubTime, MerkleNodes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(renterId, blockId, size)
+	_, err = stmt.Exec(renterId, contractId, blockId, size, disk, sha256,
+		time.Now().UTC().Format(time.RFC3339), merkleNodes)
 	if err != nil {
 		return err
 	}
@@ -226,7 +177,7 @@ func (db *providerDB) DeleteBlockById(blockId string) error {
 
 // This is used in GET /renter-info
 func (db *providerDB) GetBlocksByRenter(renterId string) ([]*blockInfo, error) {
-	query := fmt.Sprintf(`SELECT BlockId, Size FROM blocks where RenterId='%s'`, renterId)
+	query := fmt.Sprintf(`SELECT BlockId, ContractId, Size FROM blocks where RenterId='%s'`, renterId)
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -235,7 +186,7 @@ func (db *providerDB) GetBlocksByRenter(renterId string) ([]*blockInfo, error) {
 
 	for rows.Next() {
 		b := &blockInfo{}
-		err = rows.Scan(&b.BlockId, &b.Size)
+		err = rows.Scan(&b.BlockId, &b.ContractId, &b.Size)
 		if err != nil {
 			return nil, err
 		}
@@ -246,7 +197,122 @@ func (db *providerDB) GetBlocksByRenter(renterId string) ([]*blockInfo, error) {
 
 // This is only used in LoadDbintoMemory
 func (db *providerDB) GetAllBlocks() ([]*blockInfo, error) {
-	rows, err := db.Query(`SELECT RenterId, BlockId, Size FROM blocks`)
+	rows, err := db.Query(`SELECT RenterId, ContractId, BlockId, Size, Disk, Sha256, Suspect FROM blocks`)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []*blockInfo
+
+	for rows.Next() {
+		b := &blockInfo{}
+		err = rows.Scan(&b.RenterId, &b.ContractId, &b.BlockId, &b.Size, &b.Disk, &b.Sha256, &b.Suspect)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// Returns the ID of the contract the block is stored under, or
+// sql.ErrNoRows if the block doesn't exist. Blocks stored before
+// blocks were associated with contracts have an empty contract ID.
+func (db *providerDB) GetBlockContract(blockId string) (string, error) {
+	var contractId string
+	err := db.QueryRow(`SELECT ContractId FROM blocks WHERE BlockId=?`, blockId).Scan(&contractId)
+	if err != nil {
+		return "", err
+	}
+	return contractId, nil
+}
+
+// Returns the path of the disk storing the given block,
+// or sql.ErrNoRows if the block doesn't exist.
+func (db *providerDB) GetBlockDisk(blockId string) (string, error) {
+	var disk string
+	err := db.QueryRow(`SELECT Disk FROM blocks WHERE BlockId=?`, blockId).Scan(&disk)
+	if err != nil {
+		return "", err
+	}
+	return disk, nil
+}
+
+// Returns the cached nodes of a block's Merkle tree, or an empty
+// string if they haven't been computed.
+func (db *providerDB) GetBlockMerkleNodes(blockId string) (string, error) {
+	var nodes string
+	err := db.QueryRow(`SELECT MerkleNodes FROM blocks WHERE BlockId=?`, blockId).Scan(&nodes)
+	if err != nil {
+		return "", err
+	}
+	return nodes, nil
+}
+
+func (db *providerDB) UpdateBlockMerkleNodes(blockId string, nodes string) error {
+	_, err := db.Exec(`UPDATE blocks SET MerkleNodes=? WHERE BlockId=?`, nodes, blockId)
+	return err
+}
+
+func (db *providerDB) GetBlocksByDisk(disk string) ([]*blockInfo, error) {
+	rows, err := db.Query(`SELECT RenterId, ContractId, BlockId, Size, Disk FROM blocks WHERE Disk=?`, disk)
+	if err != nil {
+		return nil, err
+	}
+	return scanDiskBlocks(rows)
+}
+
+// Marks the blocks on a disk as suspect and returns those that weren't already.
+func (db *providerDB) MarkDiskBlocksSuspect(disk string) ([]*blockInfo, error) {
+	return db.updateDiskSuspect(disk, true)
+}
+
+// Clears the suspect mark from the blocks on a disk and returns those that had it.
+func (db *providerDB) ClearDiskBlocksSuspect(disk string) ([]*blockInfo, error) {
+	return db.updateDiskSuspect(disk, false)
+}
+
+func (db *providerDB) updateDiskSuspect(disk string, suspect bool) ([]*blockInfo, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT RenterId, ContractId, BlockId, Size, Disk FROM blocks
+		WHERE Disk=? AND Suspect=?`, disk, !suspect)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := scanDiskBlocks(rows)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE blocks SET Suspect=? WHERE Disk=?`, suspect, disk)
+	if err != nil {
+		return nil, err
+	}
+	return blocks, tx.Commit()
+}
+
+func scanDiskBlocks(rows *sql.Rows) ([]*blockInfo, error) {
+	defer rows.Close()
+	var blocks []*blockInfo
+
+	for rows.Next() {
+		b := &blockInfo{}
+		err := rows.Scan(&b.RenterId, &b.ContractId, &b.BlockId, &b.Size, &b.Disk)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// Returns blocks which haven't been scrubbed since the given time,
+// least recently scrubbed first.
+func (db *providerDB) GetBlocksToScrub(since time.Time) ([]*blockInfo, error) {
+	rows, err := db.Query(`SELECT RenterId, ContractId, BlockId, Size, Disk, Sha256 FROM blocks
+		WHERE ScrubTime < ? ORDER BY ScrubTime`, since.UTC().Format(time.RFC3339))
 	if err != nil {
 		return nil, err
 	}
@@ -254,7 +320,7 @@ func (db *providerDB) GetAllBlocks() ([]*blockInfo, error) {
 
 	for rows.Next() {
 		b := &blockInfo{}
-		err = rows.Scan(&b.RenterId, &b.BlockId, &b.Size)
+		err = rows.Scan(&b.RenterId, &b.ContractId, &b.BlockId, &b.Size, &b.Disk, &b.Sha256)
 		if err != nil {
 			return nil, err
 		}
@@ -263,6 +329,57 @@ func (db *providerDB) GetAllBlocks() ([]*blockInfo, error) {
 	return blocks, nil
 }
 
+// Records that a block was scrubbed, along with its hash.
+func (db *providerDB) UpdateBlockScrub(blockId string, sha256 string, scrubTime time.Time) error {
+	_, err := db.Exec(`UPDATE blocks SET Sha256=?, ScrubTime=? WHERE BlockId=?`,
+		sha256, scrubTime.UTC().Format(time.RFC3339), blockId)
+	return err
+}
+
+// Assigns blocks stored before providers supported multiple disks
+// to the given disk.
+func (db *providerDB) AssignLegacyBlocks(disk string) error {
+	_, err := db.Exec(`UPDATE blocks SET Disk=? WHERE Disk=''`, disk)
+	return err
+}
+
+// Adds to the bytes of a renter's blocks sent since egress was last
+// reported to the metaserver.
+func (db *providerDB) AddEgress(renterId string, bytes int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO egress (RenterId, Bytes) VALUES (?, 0)`, renterId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE egress SET Bytes = Bytes + ? WHERE RenterId=?`, bytes, renterId)
+	return err
+}
+
+// Returns the unreported bytes sent to each renter, by renter ID.
+func (db *providerDB) GetEgress() (map[string]int64, error) {
+	rows, err := db.Query(`SELECT RenterId, Bytes FROM egress WHERE Bytes > 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	egress := map[string]int64{}
+	for rows.Next() {
+		var renterId string
+		var bytes int64
+		err = rows.Scan(&renterId, &bytes)
+		if err != nil {
+			return nil, err
+		}
+		egress[renterId] = bytes
+	}
+	return egress, rows.Err()
+}
+
+// Removes bytes reported to the metaserver from a renter's egress.
+func (db *providerDB) SubtractEgress(renterId string, bytes int64) error {
+	_, err := db.Exec(`UPDATE egress SET Bytes = Bytes - ? WHERE RenterId=?`, bytes, renterId)
+	return err
+}
+
 // Increment activity corresponding to interval and operation by value
 func (db *providerDB) UpdateActivity(op string, value int64) error {
 	query := fmt.Sprintf(`UPDATE activity SET %s = %s + ? 
@@ -372,8 +489,37 @@ func (db *providerDB) deleteOldActivity() error {
 }
 
 type activityStats struct {
-	RecentSummary   *recents  `json:"recentSummary"`
-	ActivityCounter *activity `json:"activityCounters"`
+	RecentSummary   *recents   `json:"recentSummary"`
+	ActivityCounter *activity  `json:"activityCounters"`
+	Disks           []DiskInfo `json:"disks"`
+	// Recent throughput of renters transferring blocks
+	Renters []RenterThroughput `json:"renters"`
+	// The pricing policy's latest decision and why it was made
+	Pricing *PricingDecision `json:"pricing"`
+}
+
+// Returns the number of storage reservations made in the last day and week.
+func (db *providerDB) GetRecentReservations() (day int64, week int64, err error) {
+	rows, err := db.Query(`SELECT Period, SUM(StorageReservations) FROM activity
+		WHERE Period = 'day' or Period = 'week' GROUP BY Period`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var period string
+		var total int64
+		err = rows.Scan(&period, &total)
+		if err != nil {
+			return 0, 0, err
+		}
+		if period == "day" {
+			day = total
+		} else {
+			week = total
+		}
+	}
+	return day, week, rows.Err()
 }
 
 // This is called by the local provider server on GET /stats
@@ -0,0 +1,403 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"skybin/metaserver"
+	"skybin/metrics"
+	"syscall"
+	"time"
+)
+
+// StoragePath is a directory, usually on its own disk, where
+// the provider stores blocks.
+type StoragePath struct {
+	Path string `json:"path"`
+	// Bytes of storage to use at this path
+	Capacity int64 `json:"capacity"`
+}
+
+// DiskInfo reports a disk's usage.
+type DiskInfo struct {
+	Path        string `json:"path"`
+	Capacity    int64  `json:"capacity"`
+	Used        int64  `json:"used"`
+	Free        int64  `json:"free"`
+	TotalBlocks int    `json:"totalBlocks"`
+	Failed      bool   `json:"failed"`
+	// Whether the disk's filesystem ran out of space on its last check.
+	Full bool `json:"full"`
+}
+
+// How often the disk check thread makes sure each disk is still usable.
+const diskCheckInterval = 5 * time.Minute
+
+// Name of the file written to check that a disk is usable.
+const diskProbeFile = ".skybin_probe"
+
+// Consecutive failed checks after which a disk is considered failed.
+// A single failure may be a transient IO error or a briefly unmounted disk.
+const diskFailureThreshold = 3
+
+// A disk the provider stores blocks on. Fields other than path are
+// protected by the provider's mutex. The store is only set after the
+// disk is created if it failed to open, and only while it's failed.
+type disk struct {
+	path        string
+	capacity    int64
+	used        int64
+	totalBlocks int
+	failed      bool
+	// Set if the disk's filesystem was out of space when last checked.
+	// New blocks aren't placed on full disks.
+	full bool
+	// Number of consecutive checks the disk has failed.
+	probeFailures int
+	store         BlockStore
+}
+
+func (d *disk) info() DiskInfo {
+	free := d.capacity - d.used
+	if d.failed || free < 0 {
+		free = 0
+	}
+	return DiskInfo{
+		Path:        d.path,
+		Capacity:    d.capacity,
+		Used:        d.used,
+		Free:        free,
+		TotalBlocks: d.totalBlocks,
+		Failed:      d.failed,
+		Full:        d.full,
+	}
+}
+
+// Returns the provider's storage paths. Providers which don't list
+// any store blocks in their home directory.
+func (p *Provider) storagePaths() []StoragePath {
+	if len(p.Config.StoragePaths) == 0 {
+		return []StoragePath{{Path: p.Homedir, Capacity: p.Config.SpaceAvail}}
+	}
+	return p.Config.StoragePaths
+}
+
+// Opens a block store on each of the provider's storage paths.
+// Disks which can't be opened are marked as failed.
+func (p *Provider) openDisks() {
+	p.disks = nil
+	for _, sp := range p.storagePaths() {
+		d := &disk{
+			path:     sp.Path,
+			capacity: sp.Capacity,
+		}
+		store, err := openBlockStore(sp.Path, p.Config.BlockStore)
+		if err != nil {
+			d.failed = true
+		} else {
+			d.store = store
+		}
+		p.disks = append(p.disks, d)
+	}
+	p.updateSpaceAvail()
+}
+
+// Returns the disk with the given path. If blocks reference a disk that
+// is no longer configured, it's opened with zero capacity so that its
+// blocks can still be read and deleted, or marked failed if it's gone.
+// The caller must hold the provider's lock if background threads are running.
+func (p *Provider) diskByPath(diskPath string) *disk {
+	for _, d := range p.disks {
+		if d.path == diskPath {
+			return d
+		}
+	}
+	d := &disk{path: diskPath, failed: true}
+	if _, err := os.Stat(blockStoreDir(diskPath, p.Config.BlockStore)); err == nil {
+		store, err := openBlockStore(diskPath, p.Config.BlockStore)
+		if err == nil {
+			d.store = store
+			d.failed = false
+		}
+	}
+	p.disks = append(p.disks, d)
+	return d
+}
+
+// If the provider is configured with storage paths, sets the space it
+// offers to the total capacity of its working disks.
+// The caller must hold the provider's lock if background threads are running.
+func (p *Provider) updateSpaceAvail() {
+	if len(p.Config.StoragePaths) == 0 {
+		return
+	}
+	var total int64
+	for _, d := range p.disks {
+		if !d.failed {
+			total += d.capacity
+		}
+	}
+	p.Config.SpaceAvail = total
+}
+
+// Picks the working disk with the most free space for a block of the
+// given size and reserves the space for it.
+func (p *Provider) reserveDiskSpace(size int64) (*disk, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *disk
+	for _, d := range p.disks {
+		if d.failed || d.full || d.capacity-d.used < size {
+			continue
+		}
+		if best == nil || d.capacity-d.used > best.capacity-best.used {
+			best = d
+		}
+	}
+	if best == nil {
+		return nil, errors.New("No disk has enough free space for block")
+	}
+	best.used += size
+	return best, nil
+}
+
+func (p *Provider) releaseDiskSpace(d *disk, size int64) {
+	p.mu.Lock()
+	d.used -= size
+	p.mu.Unlock()
+}
+
+// Returns the disk storing the given block.
+func (p *Provider) findBlockDisk(blockID string) (*disk, error) {
+	diskPath, err := p.db.GetBlockDisk(blockID)
+	if err != nil {
+		return nil, ErrBlockNotFound
+	}
+	p.mu.Lock()
+	d := p.diskByPath(diskPath)
+	failed := d.failed
+	p.mu.Unlock()
+	if failed {
+		return nil, ErrBlockNotFound
+	}
+	return d, nil
+}
+
+// Returns usage information for each of the provider's disks.
+func (p *Provider) diskInfo() []DiskInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	disks := []DiskInfo{}
+	for _, d := range p.disks {
+		disks = append(disks, d.info())
+	}
+	return disks
+}
+
+// Periodically checks that the provider's disks are still usable.
+func (p *Provider) diskCheckThread() {
+	p.logger.Println("starting disk check thread")
+	p.checkDisks()
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkDisks()
+		case <-p.doneCh:
+			p.logger.Println("disk check thread shutting down")
+			return
+		}
+	}
+}
+
+func (p *Provider) checkDisks() {
+	p.mu.RLock()
+	disks := make([]*disk, len(p.disks))
+	copy(disks, p.disks)
+	p.mu.RUnlock()
+	for _, d := range disks {
+		p.checkDisk(d)
+	}
+}
+
+// Makes sure a disk can still be written to and read from. A disk is
+// failed after diskFailureThreshold consecutive failed checks, and a
+// failed disk which passes a check again is recovered. A disk whose
+// filesystem is out of space is marked full rather than failed.
+func (p *Provider) checkDisk(d *disk) {
+	p.diskCheckMu.Lock()
+	defer p.diskCheckMu.Unlock()
+
+	// Disks which couldn't be opened are retried, since they
+	// may have only been unmounted.
+	p.mu.RLock()
+	opened := d.store != nil
+	p.mu.RUnlock()
+	if !opened {
+		store, err := openBlockStore(d.path, p.Config.BlockStore)
+		if err != nil {
+			p.failDisk(d)
+			return
+		}
+		p.mu.Lock()
+		d.store = store
+		p.mu.Unlock()
+	}
+
+	err := probeDisk(d.path)
+	if err != nil && isNoSpace(err) {
+		p.mu.Lock()
+		d.full = true
+		d.probeFailures = 0
+		p.mu.Unlock()
+		p.logger.Printf("disk %s is out of space\n", d.path)
+		return
+	}
+	if err != nil {
+		p.mu.Lock()
+		d.probeFailures++
+		failures := d.probeFailures
+		p.mu.Unlock()
+		p.logger.Printf("disk %s failed check. %d consecutive failures. error: %s\n",
+			d.path, failures, err)
+		if failures >= diskFailureThreshold {
+			p.failDisk(d)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	d.full = false
+	d.probeFailures = 0
+	p.mu.Unlock()
+	p.recoverDisk(d)
+}
+
+// Returns whether an error is due to a filesystem being out of space.
+func isNoSpace(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.ENOSPC
+}
+
+// Writes and reads back a small file on the disk.
+func probeDisk(diskPath string) error {
+	probePath := path.Join(diskPath, diskProbeFile)
+	data := []byte(time.Now().String())
+	err := ioutil.WriteFile(probePath, data, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(probePath)
+	readData, err := ioutil.ReadFile(probePath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, readData) {
+		return errors.New("probe file contents changed")
+	}
+	return nil
+}
+
+// Marks a disk as failed and its blocks as suspect, and tells the
+// metaserver they're lost so renters can repair them. The blocks are
+// kept in the DB in case the disk recovers. The caller must hold diskCheckMu.
+func (p *Provider) failDisk(d *disk) {
+	p.mu.Lock()
+	if !d.failed {
+		metrics.Errors.WithLabelValues("provider_disk").Inc()
+	}
+	d.failed = true
+	p.updateSpaceAvail()
+	p.mu.Unlock()
+
+	blocks, err := p.db.MarkDiskBlocksSuspect(d.path)
+	if err != nil {
+		p.logger.Printf("unable to mark blocks on failed disk %s as suspect. error: %s\n", d.path, err)
+		return
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, b := range blocks {
+		if renter, exists := p.renters[b.RenterId]; exists {
+			renter.StorageUsed -= b.Size
+		}
+		if contract, exists := p.contracts[b.ContractId]; exists {
+			contract.StorageUsed -= b.Size
+		}
+		p.StorageUsed -= b.Size
+		p.TotalBlocks--
+	}
+	d.used = 0
+	d.totalBlocks = 0
+	p.mu.Unlock()
+	p.logger.Printf("disk %s failed. %d blocks lost\n", d.path, len(blocks))
+
+	err = p.reportLostBlocks(blocks)
+	if err != nil {
+		// Audits will find the blocks missing eventually.
+		p.logger.Println("unable to report lost blocks to metaserver. error: ", err)
+	}
+	err = p.UpdateMeta()
+	if err != nil {
+		p.logger.Println("unable to update metaserver after disk failure. error: ", err)
+	}
+}
+
+// Puts a disk which passed its check back into use, along with any
+// of its blocks marked suspect when it failed. Audits of the blocks
+// will pass again once they're served. The caller must hold diskCheckMu.
+func (p *Provider) recoverDisk(d *disk) {
+	blocks, err := p.db.ClearDiskBlocksSuspect(d.path)
+	if err != nil {
+		p.logger.Printf("unable to restore blocks on disk %s. error: %s\n", d.path, err)
+		return
+	}
+	p.mu.Lock()
+	wasFailed := d.failed
+	d.failed = false
+	p.updateSpaceAvail()
+	for _, b := range blocks {
+		if _, exists := p.renters[b.RenterId]; !exists {
+			p.renters[b.RenterId] = &renterInfo{}
+		}
+		p.renters[b.RenterId].StorageUsed += b.Size
+		if contract, exists := p.contracts[b.ContractId]; exists {
+			contract.StorageUsed += b.Size
+		}
+		p.StorageUsed += b.Size
+		p.TotalBlocks++
+		d.used += b.Size
+		d.totalBlocks++
+	}
+	p.mu.Unlock()
+	if !wasFailed && len(blocks) == 0 {
+		return
+	}
+	p.logger.Printf("disk %s recovered. %d blocks restored\n", d.path, len(blocks))
+	err = p.UpdateMeta()
+	if err != nil {
+		p.logger.Println("unable to update metaserver after disk recovery. error: ", err)
+	}
+}
+
+func (p *Provider) reportLostBlocks(blocks []*blockInfo) error {
+	lost := map[string][]string{}
+	for _, b := range blocks {
+		lost[b.RenterId] = append(lost[b.RenterId], b.BlockId)
+	}
+	client := metaserver.NewClient(p.Config.MetaAddr, &http.Client{})
+	err := client.AuthorizeProvider(p.privKey, p.Config.ProviderID)
+	if err != nil {
+		return fmt.Errorf("Error authenticating with metaserver: %s", err)
+	}
+	return client.ReportLostBlocks(p.Config.ProviderID, lost)
+}
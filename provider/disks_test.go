@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestProvider(t *testing.T, capacities ...int64) (*Provider, string) {
+	homedir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		// Nothing listens here, so metaserver updates fail quickly.
+		MetaAddr: "127.0.0.1:1",
+	}
+	for i, capacity := range capacities {
+		config.StoragePaths = append(config.StoragePaths, StoragePath{
+			Path:     path.Join(homedir, fmt.Sprintf("disk%d", i)),
+			Capacity: capacity,
+		})
+	}
+	p := &Provider{
+		Homedir:   homedir,
+		Config:    config,
+		renters:   map[string]*renterInfo{},
+		contracts: map[string]*contractInfo{},
+		logger:    log.New(ioutil.Discard, "", log.LstdFlags),
+		doneCh:    make(chan struct{}),
+	}
+	p.db, err = setupDB(path.Join(homedir, "provider.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.openDisks()
+	return p, homedir
+}
+
+// Reserves space for a renter under a contract lasting a day.
+// The contract is only added to the provider's memory, not its DB.
+func addTestContract(p *Provider, renterID string, contractID string, space int64) {
+	renter, exists := p.renters[renterID]
+	if !exists {
+		renter = &renterInfo{}
+		p.renters[renterID] = renter
+	}
+	renter.StorageReserved += space
+	p.contracts[contractID] = &contractInfo{
+		RenterId:     renterID,
+		StorageSpace: space,
+		EndDate:      time.Now().Add(24 * time.Hour),
+	}
+}
+
+func TestStoreBlockFollowsFreeSpace(t *testing.T) {
+	p, homedir := newTestProvider(t, 1000, 2000)
+	defer os.RemoveAll(homedir)
+	if p.Config.SpaceAvail != 3000 {
+		t.Fatalf("expected space available to be total disk capacity. Got %d", p.Config.SpaceAvail)
+	}
+	addTestContract(p, "r1", "c1", 10000)
+
+	data := make([]byte, 800)
+	for _, id := range []string{"b1", "b2", "b3"} {
+		err := p.StoreBlock("r1", "c1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The larger disk has the most free space for the first two
+	// blocks, after which the smaller disk does.
+	disks := p.diskInfo()
+	if disks[0].Used != 800 || disks[0].TotalBlocks != 1 {
+		t.Fatalf("expected one block on first disk. Got %+v", disks[0])
+	}
+	if disks[1].Used != 1600 || disks[1].TotalBlocks != 2 {
+		t.Fatalf("expected two blocks on second disk. Got %+v", disks[1])
+	}
+
+	err := p.StoreBlock("r1", "c1", "b4", bytes.NewReader(data[:300]), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.StoreBlock("r1", "c1", "b5", bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error storing block larger than any disk's free space")
+	}
+
+	r, err := p.GetBlock("r1", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	err = p.DeleteBlock("r1", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.diskInfo()[1].Used != 1100 {
+		t.Fatal("deleting block did not free disk space")
+	}
+}
+
+func TestFailDisk(t *testing.T) {
+	p, homedir := newTestProvider(t, 1000, 1000)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 2000)
+
+	data := make([]byte, 100)
+	for _, id := range []string{"b1", "b2"} {
+		err := p.StoreBlock("r1", "c1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := p.disks[0]
+	if failed.totalBlocks != 1 {
+		t.Fatal("expected a block on each disk")
+	}
+
+	p.diskCheckMu.Lock()
+	p.failDisk(failed)
+	p.diskCheckMu.Unlock()
+
+	if p.TotalBlocks != 1 || p.StorageUsed != 100 || p.renters["r1"].StorageUsed != 100 {
+		t.Fatal("lost blocks were not removed from provider's usage")
+	}
+	if p.Config.SpaceAvail != 1000 {
+		t.Fatal("failed disk's capacity should not be offered")
+	}
+	blocks, err := p.db.GetAllBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if b.Suspect != (b.Disk == failed.path) {
+			t.Fatalf("expected only blocks on failed disk to be suspect. Got %+v", b)
+		}
+	}
+	if len(blocks) != 2 {
+		t.Fatal("lost block should be kept in DB in case the disk recovers")
+	}
+
+	// New blocks should go to the working disk.
+	err = p.StoreBlock("r1", "c1", "b3", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.disks[1].totalBlocks != 2 || !p.diskInfo()[0].Failed {
+		t.Fatal("block stored on failed disk")
+	}
+}
+
+func TestDiskRecoversFromFailedCheck(t *testing.T) {
+	p, homedir := newTestProvider(t, 1000, 1000)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 2000)
+
+	data := make([]byte, 100)
+	for _, id := range []string{"b1", "b2"} {
+		err := p.StoreBlock("r1", "c1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := p.disks[0]
+
+	// A directory in place of the probe file makes checks fail
+	// until it's removed.
+	probePath := path.Join(d.path, diskProbeFile)
+	err := os.Mkdir(probePath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.checkDisk(d)
+	if p.diskInfo()[0].Failed || p.TotalBlocks != 2 {
+		t.Fatal("disk failed after a single failed check")
+	}
+	os.Remove(probePath)
+	p.checkDisk(d)
+	if d.probeFailures != 0 {
+		t.Fatal("passing check did not reset failure count")
+	}
+
+	os.Mkdir(probePath, 0700)
+	for i := 0; i < diskFailureThreshold; i++ {
+		p.checkDisk(d)
+	}
+	if !p.diskInfo()[0].Failed || p.TotalBlocks != 1 || p.Config.SpaceAvail != 1000 {
+		t.Fatal("expected disk to fail after consecutive failed checks")
+	}
+	_, err = p.GetBlock("r1", "b1")
+	if err == nil {
+		t.Fatal("expected error reading block on failed disk")
+	}
+
+	// Once the disk passes a check, its blocks are back.
+	os.Remove(probePath)
+	p.checkDisk(d)
+	info := p.diskInfo()[0]
+	if info.Failed || info.TotalBlocks != 1 || info.Used != 100 {
+		t.Fatalf("expected disk to recover. Got %+v", info)
+	}
+	if p.TotalBlocks != 2 || p.StorageUsed != 200 || p.Config.SpaceAvail != 2000 {
+		t.Fatal("recovered blocks were not added back to provider's usage")
+	}
+	r, err := p.GetBlock("r1", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"skybin/metaserver"
+	"skybin/metrics"
+	"time"
+)
+
+// How often the bytes sent to renters are reported to the metaserver,
+// which charges renters for them under contracts with a download rate.
+const egressReportInterval = 5 * time.Minute
+
+// Records bytes of a renter's blocks sent by the provider. Blocks read
+// with a read capability are counted against the renter who owns them.
+func (p *Provider) addEgress(renterID string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	err := p.db.AddEgress(renterID, bytes)
+	if err != nil {
+		metrics.Errors.WithLabelValues("provider_egress").Inc()
+		p.logger.Println("unable to record egress for renter", renterID, "error:", err)
+	}
+}
+
+func (p *Provider) egressReportThread() {
+	p.logger.Println("starting egress report thread")
+	ticker := time.NewTicker(egressReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := p.reportEgress()
+			if err != nil {
+				metrics.Errors.WithLabelValues("provider_egress").Inc()
+				p.logger.Println("unable to report egress to metaserver. error: ", err)
+			}
+		case <-p.doneCh:
+			p.logger.Println("egress report thread shutting down")
+			return
+		}
+	}
+}
+
+// Reports the bytes sent to each renter since the last report to the
+// metaserver. Bytes are only removed from the DB once the metaserver
+// has accepted them, so a failed report is retried with the next one.
+func (p *Provider) reportEgress() error {
+	egress, err := p.db.GetEgress()
+	if err != nil {
+		return fmt.Errorf("Unable to read egress. error: %s", err)
+	}
+	if len(egress) == 0 {
+		return nil
+	}
+	client := metaserver.NewClient(p.Config.MetaAddr, &http.Client{})
+	err = client.AuthorizeProvider(p.privKey, p.Config.ProviderID)
+	if err != nil {
+		return fmt.Errorf("Error authenticating with metaserver: %s", err)
+	}
+	err = client.ReportEgress(p.Config.ProviderID, egress)
+	if err != nil {
+		return err
+	}
+	for renterID, bytes := range egress {
+		err = p.db.SubtractEgress(renterID, bytes)
+		if err != nil {
+			return fmt.Errorf("Unable to update egress for renter %s. error: %s", renterID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEgress(t *testing.T) {
+	p, homedir := newTestProvider(t, 10000)
+	defer os.RemoveAll(homedir)
+
+	p.addEgress("r1", 100)
+	p.addEgress("r1", 50)
+	p.addEgress("r2", 10)
+	p.addEgress("r3", 0)
+	egress, err := p.db.GetEgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(egress) != 2 || egress["r1"] != 150 || egress["r2"] != 10 {
+		t.Fatalf("wrong egress recorded. Got %v", egress)
+	}
+
+	// Nothing listens at the metaserver address, so reports fail
+	// and the egress is kept for the next report.
+	if err := p.reportEgress(); err == nil {
+		t.Fatal("expected report to fail without a metaserver")
+	}
+
+	// Bytes sent while a report is in flight aren't lost when
+	// the reported bytes are removed.
+	p.addEgress("r1", 25)
+	for renterID, bytes := range egress {
+		err = p.db.SubtractEgress(renterID, bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	egress, err = p.db.GetEgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(egress) != 1 || egress["r1"] != 25 {
+		t.Fatalf("expected 25 unreported bytes for r1. Got %v", egress)
+	}
+}
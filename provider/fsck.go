@@ -0,0 +1,216 @@
+package provider
+
+import (
+	"fmt"
+)
+
+// Kinds of inconsistencies found between the provider's DB and disks.
+const (
+	// A block file with no entry in the blocks table
+	FsckOrphanedFile = "orphaned file"
+
+	// A block in the blocks table whose file is gone
+	FsckMissingFile = "missing file"
+
+	// A block whose file is a different size than the blocks table records
+	FsckSizeMismatch = "size mismatch"
+
+	// A block whose renter has no contract with the provider, or
+	// whose contract has been removed
+	FsckNoContract = "no contract"
+)
+
+// FsckProblem is an inconsistency between the provider's DB and disks.
+type FsckProblem struct {
+	Kind     string `json:"kind"`
+	RenterID string `json:"renterId"`
+	BlockID  string `json:"blockId"`
+	Disk     string `json:"disk"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport lists the problems found by a consistency check.
+type FsckReport struct {
+	BlocksChecked int           `json:"blocksChecked"`
+	FilesChecked  int           `json:"filesChecked"`
+	Problems      []FsckProblem `json:"problems"`
+}
+
+// CheckConsistency cross-checks the blocks table against the blocks
+// stored on each disk and against the contracts table. Blocks on
+// failed disks are skipped, since the disk check handles them.
+//
+// If repair is set, the problems are fixed:
+//   - Orphaned files are moved to the quarantine directory.
+//   - Blocks with missing files are removed from the DB.
+//   - Blocks with the wrong size or without a contract are moved to
+//     the quarantine directory and removed from the DB.
+//
+// Blocks removed from the DB are reported to the metaserver as lost.
+//
+// Blocks must not be stored or removed while the check runs, so it
+// should only be run before the provider starts serving renters.
+func (p *Provider) CheckConsistency(repair bool) (*FsckReport, error) {
+	contracts, err := p.db.GetAllContracts()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load contracts. error: %s", err)
+	}
+	hasContract := map[string]bool{}
+	contractExists := map[string]bool{}
+	for _, c := range contracts {
+		hasContract[c.RenterId] = true
+		contractExists[c.ID] = true
+	}
+	blocks, err := p.db.GetAllBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load blocks. error: %s", err)
+	}
+
+	report := &FsckReport{Problems: []FsckProblem{}}
+	addProblem := func(kind string, d *disk, renterID, blockID, detail string) {
+		report.Problems = append(report.Problems, FsckProblem{
+			Kind:     kind,
+			RenterID: renterID,
+			BlockID:  blockID,
+			Disk:     d.path,
+			Detail:   detail,
+		})
+	}
+
+	// Blocks in the DB on each disk, by renter and block ID
+	known := map[*disk]map[string]bool{}
+
+	// A block to remove, along with the problems removing it repairs
+	// and whether its file should be quarantined.
+	type removal struct {
+		d          *disk
+		b          *blockInfo
+		quarantine bool
+		problems   []int
+	}
+	removals := []*removal{}
+
+	for _, b := range blocks {
+		p.mu.Lock()
+		d := p.diskByPath(b.Disk)
+		p.mu.Unlock()
+		if d.failed || d.store == nil {
+			continue
+		}
+		report.BlocksChecked++
+		if known[d] == nil {
+			known[d] = map[string]bool{}
+		}
+		known[d][packedKey(b.RenterId, b.BlockId)] = true
+
+		r := &removal{d: d, b: b}
+		size, err := d.store.Stat(b.RenterId, b.BlockId)
+		fileExists := err == nil
+		if err == ErrBlockNotFound {
+			r.problems = append(r.problems, len(report.Problems))
+			addProblem(FsckMissingFile, d, b.RenterId, b.BlockId, "block is in the DB but not on disk")
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to check block %s. error: %s", b.BlockId, err)
+		} else if size != b.Size {
+			r.quarantine = true
+			r.problems = append(r.problems, len(report.Problems))
+			addProblem(FsckSizeMismatch, d, b.RenterId, b.BlockId,
+				fmt.Sprintf("file is %d bytes but the DB records %d", size, b.Size))
+		}
+		if !hasContract[b.RenterId] {
+			r.quarantine = fileExists
+			r.problems = append(r.problems, len(report.Problems))
+			addProblem(FsckNoContract, d, b.RenterId, b.BlockId, "renter has no contract with the provider")
+		} else if b.ContractId != "" && !contractExists[b.ContractId] {
+			r.quarantine = fileExists
+			r.problems = append(r.problems, len(report.Problems))
+			addProblem(FsckNoContract, d, b.RenterId, b.BlockId,
+				fmt.Sprintf("block's contract %s no longer exists", b.ContractId))
+		}
+		if len(r.problems) > 0 {
+			removals = append(removals, r)
+		}
+	}
+
+	orphans := []*removal{}
+	for _, d := range p.disks {
+		if d.failed || d.store == nil {
+			continue
+		}
+		err = d.store.Walk(func(renterID, blockID string, size int64) error {
+			report.FilesChecked++
+			if !known[d][packedKey(renterID, blockID)] {
+				orphans = append(orphans, &removal{
+					d:        d,
+					b:        &blockInfo{RenterId: renterID, BlockId: blockID, Size: size},
+					problems: []int{len(report.Problems)},
+				})
+				addProblem(FsckOrphanedFile, d, renterID, blockID,
+					fmt.Sprintf("%d byte file is not in the DB", size))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list blocks on disk %s. error: %s", d.path, err)
+		}
+	}
+	if !repair {
+		return report, nil
+	}
+
+	markRepaired := func(r *removal) {
+		for _, i := range r.problems {
+			report.Problems[i].Repaired = true
+		}
+	}
+	for _, r := range orphans {
+		err = p.removeBlockFile(r.d, r.b)
+		if err != nil {
+			p.logger.Printf("fsck: unable to remove orphaned block %s. error: %s\n", r.b.BlockId, err)
+			continue
+		}
+		markRepaired(r)
+	}
+	lost := []*blockInfo{}
+	for _, r := range removals {
+		if r.quarantine {
+			err = p.removeBlockFile(r.d, r.b)
+			if err != nil {
+				p.logger.Printf("fsck: unable to remove block %s. error: %s\n", r.b.BlockId, err)
+				continue
+			}
+		}
+		err = p.db.DeleteBlockById(r.b.BlockId)
+		if err != nil {
+			p.logger.Printf("fsck: unable to remove block %s from DB. error: %s\n", r.b.BlockId, err)
+			continue
+		}
+		markRepaired(r)
+		lost = append(lost, r.b)
+	}
+
+	p.mu.Lock()
+	err = p.loadInfoFromDB()
+	p.mu.Unlock()
+	if err != nil {
+		return report, fmt.Errorf("Unable to reload provider DB. error: %s", err)
+	}
+	if len(lost) > 0 {
+		err = p.reportLostBlocks(lost)
+		if err != nil {
+			// Audits will find the blocks missing eventually.
+			p.logger.Println("fsck: unable to report lost blocks to metaserver. error: ", err)
+		}
+	}
+	return report, nil
+}
+
+// Moves a block's file to the quarantine directory.
+func (p *Provider) removeBlockFile(d *disk, b *blockInfo) error {
+	err := p.quarantineBlock(d, b)
+	if err != nil {
+		return err
+	}
+	return d.store.Delete(b.RenterId, b.BlockId)
+}
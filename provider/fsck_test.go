@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"skybin/core"
+	"testing"
+	"time"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	p, homedir := newTestProvider(t, 10000)
+	defer os.RemoveAll(homedir)
+	err := p.db.InsertContract(&core.Contract{
+		ID:           "c1",
+		RenterId:     "r1",
+		StorageSpace: 5000,
+		StartDate:    time.Now(),
+		EndDate:      time.Now().Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestContract(p, "r1", "c1", 5000)
+	addTestContract(p, "r2", "c2", 5000)
+
+	// Only c1 is in the DB, so blocks stored under c2 and c3 have no contract.
+	addTestContract(p, "r1", "c3", 5000)
+
+	data := []byte("some block contents")
+	blocks := [][3]string{{"r1", "c1", "b1"}, {"r1", "c1", "b2"}, {"r1", "c1", "b3"}, {"r2", "c2", "b4"}, {"r1", "c3", "b6"}}
+	for _, ids := range blocks {
+		err := p.StoreBlock(ids[0], ids[1], ids[2], bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := blockStoreDir(p.disks[0].path, FlatBlockStoreBackend)
+	os.Remove(path.Join(dir, "r1", "b2"))
+	ioutil.WriteFile(path.Join(dir, "r1", "b3"), []byte("truncated"), 0600)
+	ioutil.WriteFile(path.Join(dir, "r1", "b5"), []byte("orphan"), 0600)
+
+	expected := map[string]string{
+		"b2": FsckMissingFile,
+		"b3": FsckSizeMismatch,
+		"b4": FsckNoContract,
+		"b5": FsckOrphanedFile,
+		"b6": FsckNoContract,
+	}
+	report, err := p.CheckConsistency(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BlocksChecked != 5 || report.FilesChecked != 5 {
+		t.Fatalf("expected 5 blocks and 5 files checked. Got %d and %d",
+			report.BlocksChecked, report.FilesChecked)
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems. Got %v", len(expected), report.Problems)
+	}
+	for _, problem := range report.Problems {
+		if expected[problem.BlockID] != problem.Kind {
+			t.Fatalf("block %s: expected %q. Got %q", problem.BlockID, expected[problem.BlockID], problem.Kind)
+		}
+		if problem.Repaired {
+			t.Fatal("problem repaired without repair set")
+		}
+	}
+
+	report, err = p.CheckConsistency(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range report.Problems {
+		if !problem.Repaired {
+			t.Fatalf("%s of block %s was not repaired", problem.Kind, problem.BlockID)
+		}
+	}
+	for _, blockID := range []string{"b3", "b5"} {
+		if _, err := os.Stat(path.Join(homedir, quarantineDir, "r1", blockID)); err != nil {
+			t.Fatalf("block %s should be quarantined", blockID)
+		}
+	}
+	if p.TotalBlocks != 1 || p.StorageUsed != int64(len(data)) {
+		t.Fatalf("usage not reloaded after repair. %d blocks using %d bytes", p.TotalBlocks, p.StorageUsed)
+	}
+
+	report, err = p.CheckConsistency(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems after repair. Got %v", report.Problems)
+	}
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"skybin/core"
+	"skybin/metaserver"
+	"skybin/metrics"
+	"time"
+)
+
+// How often the provider sends a heartbeat to the metaserver. The
+// metaserver marks providers offline after missing a few heartbeats.
+const heartbeatInterval = time.Minute
+
+// Sends heartbeats to the metaserver. Before the first, the provider asks
+// the metaserver to verify its address, retrying with each heartbeat until
+// the metaserver can reach it. The public API must be listening first.
+func (p *Provider) heartbeatThread() {
+	p.logger.Println("starting heartbeat thread")
+	verified := p.verifyAddr()
+	p.sendHeartbeat()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !verified {
+				verified = p.verifyAddr()
+			}
+			p.sendHeartbeat()
+		case <-p.doneCh:
+			p.logger.Println("heartbeat thread shutting down")
+			return
+		}
+	}
+}
+
+// Asks the metaserver to check that it can reach the provider at its
+// public address. Returns whether it could.
+func (p *Provider) verifyAddr() bool {
+	client := metaserver.NewClient(p.Config.MetaAddr, &http.Client{})
+	err := client.AuthorizeProvider(p.privKey, p.Config.ProviderID)
+	if err == nil {
+		err = client.VerifyProvider(p.Config.ProviderID)
+	}
+	if err != nil {
+		metrics.Errors.WithLabelValues("provider_verify").Inc()
+		p.logger.Println("metaserver unable to verify provider address. "+
+			"Renters won't be sent to the provider until it can. error: ", err)
+		return false
+	}
+	return true
+}
+
+func (p *Provider) sendHeartbeat() {
+	err := p.heartbeat()
+	if err != nil {
+		metrics.Errors.WithLabelValues("provider_heartbeat").Inc()
+		p.logger.Println("unable to send heartbeat to metaserver. error: ", err)
+	}
+}
+
+func (p *Provider) heartbeat() error {
+	hb := &core.Heartbeat{
+		ProviderId: p.Config.ProviderID,
+		Time:       time.Now(),
+	}
+	var err error
+	hb.Signature, err = core.SignHeartbeat(hb, p.privKey)
+	if err != nil {
+		return fmt.Errorf("Unable to sign heartbeat. error: %s", err)
+	}
+	client := metaserver.NewClient(p.Config.MetaAddr, &http.Client{Timeout: 30 * time.Second})
+	return client.SendHeartbeat(hb)
+}
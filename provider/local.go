@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"skybin/core"
 	"skybin/metaserver"
+	"skybin/metrics"
 	"github.com/gorilla/mux"
 )
 
@@ -34,6 +35,8 @@ func NewLocalServer(provider *Provider, logger *log.Logger) http.Handler {
 	router.HandleFunc("/stats", server.getStats).Methods("GET")
 	router.HandleFunc("/paypal/withdraw", server.withdraw).Methods("POST")
 	router.HandleFunc("/transactions", server.getTransactions).Methods("GET")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	metrics.Instrument("provider_local", router)
 
 	return &server
 }
@@ -82,6 +85,9 @@ func (server *localServer) getStats(w http.ResponseWriter, r *http.Request) {
 		server.writeResp(w, http.StatusInternalServerError, &errorResp{msg})
 		return
 	}
+	resp.Disks = server.provider.diskInfo()
+	resp.Renters = server.provider.renterThroughput()
+	resp.Pricing = server.provider.pricingDecision()
 	server.writeResp(w, http.StatusOK, resp)
 }
 
@@ -127,16 +133,23 @@ func (server *localServer) withdraw(w http.ResponseWriter, r *http.Request) {
 
 type getTransactionsResp struct {
 	Transactions []core.Transaction `json:"transactions"`
+	// Cursor for the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 func (server *localServer) getTransactions(w http.ResponseWriter, r *http.Request) {
-	transactions, err := server.provider.ListTransactions()
+	query, err := metaserver.ParseTransactionQuery(r.URL.Query())
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest, &errorResp{Error: err.Error()})
+		return
+	}
+	transactions, next, err := server.provider.ListTransactions(query)
 	if err != nil {
 		server.writeResp(w, http.StatusInternalServerError,
 			&errorResp{Error: err.Error()})
 		return
 	}
-	server.writeResp(w, http.StatusOK, &getTransactionsResp{transactions})
+	server.writeResp(w, http.StatusOK, &getTransactionsResp{transactions, next})
 }
 
 func (server *localServer) writeResp(w http.ResponseWriter, status int, body interface{}) {
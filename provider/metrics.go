@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"skybin/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	blockBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "provider",
+		Name:      "block_bytes_total",
+		Help:      "Bytes of blocks received from (in) and sent to (out) renters.",
+	}, []string{"direction"})
+
+	activityOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "provider",
+		Name:      "operations_total",
+		Help:      "Block uploads, downloads, and deletions, and storage reservations.",
+	}, []string{"op"})
+)
+
+func init() {
+	prometheus.MustRegister(blockBytes, activityOps)
+}
+
+func recordActivityMetrics(op string, bytes int64) {
+	activityOps.WithLabelValues(op).Inc()
+	switch op {
+	case activityOpUpload:
+		blockBytes.WithLabelValues("in").Add(float64(bytes))
+	case activityOpDownload:
+		blockBytes.WithLabelValues("out").Add(float64(bytes))
+	}
+}
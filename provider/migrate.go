@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"skybin/util"
+)
+
+// Moves every block in the provider at homedir to the given block store
+// backend and updates the provider's config to use it. The provider
+// daemon must not be running. If the migration is interrupted, the
+// provider keeps using its old backend and the migration can be rerun.
+func MigrateBlockStore(homedir string, backend BlockStoreBackend) error {
+	if !validBlockStoreBackend(backend) {
+		return fmt.Errorf("Unrecognized block store backend %s", backend)
+	}
+	configPath := path.Join(homedir, "config.json")
+	config := &Config{}
+	err := util.LoadJson(configPath, config)
+	if err != nil {
+		return fmt.Errorf("Failed to load config file. error: %s", err)
+	}
+	oldBackend := config.BlockStore
+	if oldBackend == "" {
+		oldBackend = DefaultBlockStoreBackend
+	}
+	if oldBackend == backend {
+		return fmt.Errorf("Provider already uses the %s block store", backend)
+	}
+
+	db, err := setupDB(path.Join(homedir, "provider.db"))
+	if err != nil {
+		return fmt.Errorf("Failed to initialize DB. error: %s", err)
+	}
+	defer db.Close()
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		return fmt.Errorf("Failed to load blocks from DB. error: %s", err)
+	}
+
+	// Migrate each disk's blocks to the new backend on the same disk.
+	byDisk := map[string][]*blockInfo{}
+	for _, b := range blocks {
+		diskPath := b.Disk
+		if diskPath == "" {
+			diskPath = homedir
+		}
+		byDisk[diskPath] = append(byDisk[diskPath], b)
+	}
+	for diskPath, diskBlocks := range byDisk {
+		err = migrateDisk(diskPath, oldBackend, backend, diskBlocks)
+		if err != nil {
+			return err
+		}
+	}
+
+	config.BlockStore = backend
+	err = util.SaveJson(configPath, config)
+	if err != nil {
+		return fmt.Errorf("Unable to save config update. Error: %s", err)
+	}
+
+	// Every block has been copied, so the old stores can go.
+	for diskPath := range byDisk {
+		err = os.RemoveAll(blockStoreDir(diskPath, oldBackend))
+		if err != nil {
+			return fmt.Errorf("Blocks migrated, but unable to remove old block store. error: %s", err)
+		}
+	}
+	return nil
+}
+
+func migrateDisk(diskPath string, from, to BlockStoreBackend, blocks []*blockInfo) error {
+	src, err := openBlockStore(diskPath, from)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s block store on %s. error: %s", from, diskPath, err)
+	}
+	defer src.Close()
+	dst, err := openBlockStore(diskPath, to)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s block store on %s. error: %s", to, diskPath, err)
+	}
+	for _, b := range blocks {
+		err = copyBlock(src, dst, b)
+		if err != nil {
+			dst.Close()
+			return fmt.Errorf("Failed to copy block %s. error: %s", b.BlockId, err)
+		}
+	}
+	return dst.Close()
+}
+
+func copyBlock(src, dst BlockStore, b *blockInfo) error {
+	r, size, err := src.Get(b.RenterId, b.BlockId)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if size != b.Size {
+		return fmt.Errorf("block is %d bytes, expected %d", size, b.Size)
+	}
+	return dst.Put(b.RenterId, b.BlockId, r, size)
+}
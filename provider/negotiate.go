@@ -17,10 +17,15 @@ func (provider *Provider) NegotiateContract(contract *core.Contract) (*core.Cont
 	// Determine if provider has sufficient space available for the contract
 	provider.mu.RLock()
 	spaceAvail := provider.Config.SpaceAvail - provider.StorageReserved
+	downloadRate := provider.Config.DownloadRate
 	provider.mu.RUnlock()
 	if contract.StorageSpace > spaceAvail {
 		return nil, errors.New("Provider does not have sufficient storage available")
 	}
+	if contract.DownloadRate < downloadRate {
+		return nil, fmt.Errorf("Contract download rate %d is below the provider's rate of %d",
+			contract.DownloadRate, downloadRate)
+	}
 
 	err = core.VerifyContractSignature(contract, contract.RenterSignature, *renterKey)
 	if err != nil {
@@ -45,7 +50,9 @@ func (provider *Provider) NegotiateContract(contract *core.Contract) (*core.Cont
 		provider.renters[contract.RenterId] = renter
 	}
 	renter.StorageReserved += contract.StorageSpace
+	provider.contracts[contract.ID] = newContractInfo(contract)
 	provider.StorageReserved += contract.StorageSpace
+	provider.TotalContracts++
 	provider.mu.Unlock()
 
 	// this could potentially be non-fatal too
@@ -62,3 +69,71 @@ func (provider *Provider) NegotiateContract(contract *core.Contract) (*core.Cont
 
 	return contract, nil
 }
+
+// CancelContract co-signs a renter's request to terminate one of its contracts
+// early and releases the storage reserved by the contract. No blocks may be
+// stored under the contract, and the renter's remaining contracts must
+// still cover the storage its blocks are using.
+func (provider *Provider) CancelContract(termination *core.ContractTermination) (*core.ContractTermination, error) {
+	if termination.ProviderId != provider.Config.ProviderID {
+		return nil, errors.New("Termination is for a different provider")
+	}
+	renterKey, err := provider.getRenterPublicKey(termination.RenterId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Renters pubkey from metaserver. error: %s", err)
+	}
+	err = core.VerifyTerminationSignature(termination, termination.RenterSignature, *renterKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Renter signature: %s", err)
+	}
+
+	contracts, err := provider.db.GetContractsByRenter(termination.RenterId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get renter's contracts. error: %s", err)
+	}
+	var contract *core.Contract
+	for _, c := range contracts {
+		if c.ID == termination.ContractID {
+			contract = c
+			break
+		}
+	}
+	if contract == nil {
+		return nil, errors.New("Cannot find contract")
+	}
+
+	provSig, err := core.SignTermination(termination, provider.privKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign termination. error: %s", err)
+	}
+
+	provider.mu.Lock()
+	if info, exists := provider.contracts[contract.ID]; exists && info.StorageUsed > 0 {
+		provider.mu.Unlock()
+		return nil, fmt.Errorf("Contract still has %d bytes of blocks stored", info.StorageUsed)
+	}
+	renter, exists := provider.renters[termination.RenterId]
+	if !exists || renter.StorageUsed > renter.StorageReserved-contract.StorageSpace {
+		provider.mu.Unlock()
+		return nil, errors.New("Renter's remaining contracts cannot cover its stored blocks")
+	}
+	err = provider.db.DeleteContractById(contract.ID)
+	if err != nil {
+		provider.mu.Unlock()
+		return nil, fmt.Errorf("Failed to delete contract from DB. error: %s", err)
+	}
+	renter.StorageReserved -= contract.StorageSpace
+	delete(provider.contracts, contract.ID)
+	provider.StorageReserved -= contract.StorageSpace
+	provider.TotalContracts--
+	provider.mu.Unlock()
+
+	termination.ProviderSignature = provSig
+
+	// Non-fatal, since the contract is already cancelled.
+	err = provider.UpdateMeta()
+	if err != nil {
+		provider.logger.Println("Error updating metaserver after cancelling contract: ", err)
+	}
+	return termination, nil
+}
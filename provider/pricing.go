@@ -1,13 +1,222 @@
 package provider
 
 import (
+	"fmt"
 	"math/rand"
 	"net/http"
 	"skybin/metaserver"
+	"skybin/metrics"
 	"sort"
 	"time"
 )
 
+// PricingState is what a pricing policy knows about the provider
+// when choosing its storage rate.
+type PricingState struct {
+	CurrentRate     int64
+	MinRate         int64
+	MaxRate         int64
+	SpaceAvail      int64
+	StorageReserved int64
+
+	// Storage reservations made in the last day and week
+	DayReservations  int64
+	WeekReservations int64
+
+	// Returns the storage rates of other providers in ascending order.
+	// Rates are fetched from the metaserver, so policies that don't
+	// need them shouldn't call this.
+	MarketRates func() ([]int64, error)
+}
+
+// Returns the rate limited to the provider's min and max rates.
+func (s *PricingState) clamp(rate int64) int64 {
+	if rate < 0 {
+		rate = 0
+	}
+	if rate < s.MinRate {
+		rate = s.MinRate
+	}
+	if rate > s.MaxRate {
+		rate = s.MaxRate
+	}
+	return rate
+}
+
+// PricingPolicy chooses the provider's storage rate.
+type PricingPolicy interface {
+	// Returns the new storage rate and a short explanation of why
+	// it was chosen. The rate is used as is, so policies should keep
+	// it between the state's min and max rates.
+	Rate(state *PricingState) (int64, string, error)
+}
+
+// PricingDecision records a change in storage rate made by a pricing policy.
+type PricingDecision struct {
+	Policy       PricingPolicyName `json:"policy"`
+	Rate         int64             `json:"rate"`
+	PreviousRate int64             `json:"previousRate"`
+	Reason       string            `json:"reason"`
+	Time         time.Time         `json:"time"`
+}
+
+var pricingPolicies = map[PricingPolicyName]PricingPolicy{
+	FixedPricingPolicy:      fixedPricing{},
+	PassivePricingPolicy:    passivePricing{},
+	AggressivePricingPolicy: aggressivePricing{},
+	DemandPricingPolicy:     demandPricing{},
+}
+
+// RegisterPricingPolicy makes a pricing policy available under the
+// given name. It should be called before any providers are loaded.
+func RegisterPricingPolicy(name PricingPolicyName, policy PricingPolicy) {
+	pricingPolicies[name] = policy
+}
+
+// ValidPricingPolicy returns whether a pricing policy is registered under name.
+func ValidPricingPolicy(name PricingPolicyName) bool {
+	_, exists := pricingPolicies[name]
+	return exists
+}
+
+// Keeps the rate set by the provider.
+type fixedPricing struct{}
+
+func (fixedPricing) Rate(state *PricingState) (int64, string, error) {
+	return state.CurrentRate, "rate is fixed by the provider", nil
+}
+
+// Returns the average of rates[lo:hi], plus some noise so that
+// providers following the same policy don't all charge the same rate.
+func averageRate(rates []int64, lo, hi int) int64 {
+	tot := int64(0)
+	for i := lo; i < hi; i++ {
+		tot += rates[i]
+	}
+	avg := tot
+	if l := int64(hi - lo); l > 0 {
+		avg /= l
+	}
+	noise := 3 - rand.Intn(7)
+	return avg + int64(noise)
+}
+
+// Sets the price to the average of the middle fifty percent of providers.
+type passivePricing struct{}
+
+func (passivePricing) Rate(state *PricingState) (int64, string, error) {
+	rates, err := state.MarketRates()
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rates) == 0 {
+		return state.CurrentRate, "no other providers to compare rates with", nil
+	}
+	cheapestQuartile := len(rates) / 4
+	mostExpensiveQuartile := len(rates) - cheapestQuartile
+	rate := state.clamp(averageRate(rates, cheapestQuartile, mostExpensiveQuartile))
+	reason := fmt.Sprintf("following the middle half of %d other providers", len(rates))
+	return rate, reason, nil
+}
+
+// Sets the price to the average of the most expensive quartile of providers.
+type aggressivePricing struct{}
+
+func (aggressivePricing) Rate(state *PricingState) (int64, string, error) {
+	rates, err := state.MarketRates()
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rates) == 0 {
+		return state.CurrentRate, "no other providers to compare rates with", nil
+	}
+	mostExpensiveQuartile := len(rates) - len(rates)/4
+	if mostExpensiveQuartile == len(rates) {
+		mostExpensiveQuartile -= 1
+	}
+	rate := state.clamp(averageRate(rates, mostExpensiveQuartile, len(rates)))
+	reason := fmt.Sprintf("following the most expensive quarter of %d other providers", len(rates))
+	return rate, reason, nil
+}
+
+const (
+	// The demand policy raises its rate when more than this fraction
+	// of the provider's space is reserved, and lowers it below the
+	// low utilization.
+	demandHighUtilization = 0.8
+	demandLowUtilization  = 0.5
+
+	// Percent the demand policy changes its rate by because of
+	// utilization and because of reservation velocity.
+	demandUtilizationStep = 10
+	demandVelocityStep    = 5
+
+	// Reservation velocity is the number of reservations in the last
+	// day relative to the daily average over the last week. Above the
+	// high velocity demand is growing, and below the low velocity it's
+	// falling off.
+	demandHighVelocity = 2.0
+	demandLowVelocity  = 0.5
+)
+
+// Raises or lowers the rate based on how much of the provider's space
+// is reserved and how quickly renters have been reserving it.
+type demandPricing struct{}
+
+func (demandPricing) Rate(state *PricingState) (int64, string, error) {
+	utilization := 1.0
+	if state.SpaceAvail > 0 {
+		utilization = float64(state.StorageReserved) / float64(state.SpaceAvail)
+	}
+	dailyAvg := float64(state.WeekReservations) / 7
+
+	percent := 0
+	utilizationDesc := fmt.Sprintf("%.0f%% of space reserved", utilization*100)
+	if utilization > demandHighUtilization {
+		percent += demandUtilizationStep
+		utilizationDesc += fmt.Sprintf(" (above %.0f%%)", demandHighUtilization*100)
+	} else if utilization < demandLowUtilization {
+		percent -= demandUtilizationStep
+		utilizationDesc += fmt.Sprintf(" (below %.0f%%)", demandLowUtilization*100)
+	}
+
+	velocityDesc := fmt.Sprintf("%d reservations in the last day against %.1f per day this week",
+		state.DayReservations, dailyAvg)
+	if state.DayReservations > 0 && float64(state.DayReservations) > demandHighVelocity*dailyAvg {
+		percent += demandVelocityStep
+		velocityDesc += " (rising)"
+	} else if float64(state.DayReservations) < demandLowVelocity*dailyAvg &&
+		utilization <= demandHighUtilization {
+
+		// Falling demand only lowers the rate while there's
+		// still plenty of space left to reserve.
+		percent -= demandVelocityStep
+		velocityDesc += " (falling)"
+	}
+
+	change := state.CurrentRate * int64(percent) / 100
+	if change == 0 && percent > 0 {
+		change = 1
+	} else if change == 0 && percent < 0 {
+		change = -1
+	}
+	rate := state.clamp(state.CurrentRate + change)
+
+	var action string
+	switch {
+	case rate > state.CurrentRate:
+		action = "raising rate"
+	case rate < state.CurrentRate:
+		action = "lowering rate"
+	case percent != 0:
+		action = "keeping rate at its limit"
+	default:
+		action = "keeping rate"
+	}
+	reason := fmt.Sprintf("%s; %s; %s", utilizationDesc, velocityDesc, action)
+	return rate, reason, nil
+}
+
 func (provider *Provider) pricingUpdateThread() {
 	provider.logger.Println("starting pricing update thread with update frequency", PricingUpdateFreq.String())
 	ticker := time.NewTicker(PricingUpdateFreq)
@@ -22,90 +231,80 @@ func (provider *Provider) pricingUpdateThread() {
 	}
 }
 
-func (provider *Provider) updatePricing() {
-	if provider.Config.PricingPolicy == FixedPricingPolicy {
-		return
-	}
+// Returns the storage rates of the other providers in ascending order.
+func (provider *Provider) marketRates() ([]int64, error) {
 	metaService := metaserver.NewClient(provider.Config.MetaAddr, &http.Client{})
-	providers, err := metaService.GetProviders()
+	providers, err := metaService.GetProviders(nil)
 	if err != nil {
-		provider.logger.Println("unable to update pricing policy. cannot fetch providers. error: ", err)
-		return
+		return nil, fmt.Errorf("cannot fetch providers. error: %s", err)
 	}
-	if len(providers) == 0 {
-		return
-	}
-
 	rates := []int64{}
 	for _, pinfo := range providers {
 		if pinfo.ID != provider.Config.ProviderID {
 			rates = append(rates, pinfo.StorageRate)
 		}
 	}
-
 	sort.Slice(rates, func(i, j int) bool {
 		return rates[i] < rates[j]
 	})
+	return rates, nil
+}
 
-	var rate int64
-	var avg int64
-
-	switch provider.Config.PricingPolicy {
-	case AggressivePricingPolicy:
-		// Set the price to the average of the most expensive quartile of providers.
-		mostExpensiveQuartile := len(rates) - len(rates)/4
-		if mostExpensiveQuartile == len(rates) {
-			mostExpensiveQuartile -= 1
-		}
-		if mostExpensiveQuartile < 0 {
-			mostExpensiveQuartile = 0
-		}
+// Returns the pricing policy's latest decision, or nil if
+// it hasn't made one since the provider started.
+func (provider *Provider) pricingDecision() *PricingDecision {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+	return provider.lastPricing
+}
 
-		tot := int64(0)
-		for i := mostExpensiveQuartile; i < len(rates); i++ {
-			tot += rates[i]
-		}
-		avg = tot
-		if l := int64(len(rates) - mostExpensiveQuartile); l > 0 {
-			avg /= l
-		}
-		noise := 3 - rand.Intn(7)
-		rate = avg + int64(noise)
-	case PassivePricingPolicy:
-		// Set the price to the average of the middle fifty percent of providers.
-		cheapestQuartile := len(rates) / 4
-		mostExpensiveQuartile := len(rates) - cheapestQuartile
-
-		tot := int64(0)
-		for i := cheapestQuartile; i < mostExpensiveQuartile; i++ {
-			tot += rates[i]
-		}
-		avg = tot
-		if l := int64(mostExpensiveQuartile - cheapestQuartile); l > 0 {
-			avg /= l
-		}
-		noise := 3 - rand.Intn(7)
-		rate = avg + int64(noise)
-	default:
-		provider.logger.Println("unrecognized pricing policy", provider.Config.PricingPolicy)
+func (provider *Provider) updatePricing() {
+	provider.mu.RLock()
+	name := provider.Config.PricingPolicy
+	state := &PricingState{
+		CurrentRate:     provider.Config.StorageRate,
+		MinRate:         provider.Config.MinStorageRate,
+		MaxRate:         provider.Config.MaxStorageRate,
+		SpaceAvail:      provider.Config.SpaceAvail,
+		StorageReserved: provider.StorageReserved,
+		MarketRates:     provider.marketRates,
 	}
+	provider.mu.RUnlock()
 
-	if rate < 0 {
-		rate = 0
+	policy, exists := pricingPolicies[name]
+	if !exists {
+		provider.logger.Println("unrecognized pricing policy", name)
+		return
 	}
-	if rate < provider.Config.MinStorageRate {
-		rate = provider.Config.MinStorageRate
+	var err error
+	state.DayReservations, state.WeekReservations, err = provider.db.GetRecentReservations()
+	if err != nil {
+		provider.logger.Println("unable to update pricing policy. cannot read reservations. error: ", err)
+		return
 	}
-	if rate > provider.Config.MaxStorageRate {
-		rate = provider.Config.MaxStorageRate
+	rate, reason, err := policy.Rate(state)
+	if err != nil {
+		metrics.Errors.WithLabelValues("provider_pricing").Inc()
+		provider.logger.Println("unable to update pricing policy.", err)
+		return
 	}
 
-	provider.logger.Println("average storage rate:", avg)
+	provider.logger.Println("pricing policy:", reason)
 	provider.logger.Println("updating storage rate to:", rate)
 
 	provider.mu.Lock()
 	provider.Config.StorageRate = rate
+	provider.lastPricing = &PricingDecision{
+		Policy:       name,
+		Rate:         rate,
+		PreviousRate: state.CurrentRate,
+		Reason:       reason,
+		Time:         time.Now(),
+	}
 	provider.mu.Unlock()
+	if rate == state.CurrentRate {
+		return
+	}
 	err = provider.UpdateMeta()
 	if err != nil {
 		provider.logger.Println("Error updating price:", err)
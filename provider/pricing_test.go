@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDemandPricing(t *testing.T) {
+	newState := func(reserved, day, week int64) *PricingState {
+		return &PricingState{
+			CurrentRate:      100,
+			MinRate:          50,
+			MaxRate:          150,
+			SpaceAvail:       1000,
+			StorageReserved:  reserved,
+			DayReservations:  day,
+			WeekReservations: week,
+		}
+	}
+	cases := []struct {
+		state *PricingState
+		rate  int64
+	}{
+		// Steady demand with utilization between the thresholds
+		{newState(600, 1, 7), 100},
+		// High utilization
+		{newState(900, 1, 7), 110},
+		// High utilization and rising demand
+		{newState(900, 5, 14), 115},
+		// Low utilization and falling demand
+		{newState(100, 0, 14), 85},
+		// Falling demand doesn't lower the rate when space is scarce
+		{newState(900, 0, 14), 110},
+	}
+	for i, c := range cases {
+		rate, reason, err := demandPricing{}.Rate(c.state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate != c.rate {
+			t.Errorf("case %d: expected rate %d. Got %d (%s)", i, c.rate, rate, reason)
+		}
+	}
+
+	state := newState(1000, 10, 10)
+	state.CurrentRate = 145
+	rate, _, _ := demandPricing{}.Rate(state)
+	if rate != state.MaxRate {
+		t.Errorf("rate should be limited to max rate. Got %d", rate)
+	}
+}
+
+func TestUpdatePricingRecordsDecision(t *testing.T) {
+	p, homedir := newTestProvider(t)
+	defer os.RemoveAll(homedir)
+	p.Config.PricingPolicy = DemandPricingPolicy
+	p.Config.SpaceAvail = 1000
+	p.Config.StorageRate = 100
+	p.Config.MinStorageRate = 1
+	p.Config.MaxStorageRate = 1000
+	p.StorageReserved = 900
+	err := p.addActivity(activityOpContract, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.updatePricing()
+	decision := p.pricingDecision()
+	if decision == nil {
+		t.Fatal("pricing decision was not recorded")
+	}
+	if decision.PreviousRate != 100 || decision.Rate <= 100 {
+		t.Fatalf("expected rate to rise from 100. Got %d -> %d", decision.PreviousRate, decision.Rate)
+	}
+	if p.Config.StorageRate != decision.Rate {
+		t.Fatal("storage rate not updated")
+	}
+	if decision.Reason == "" {
+		t.Fatal("decision has no reason")
+	}
+}
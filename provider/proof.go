@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"skybin/core"
+	"skybin/metaserver"
+	"skybin/util"
+	"time"
+)
+
+// Most segments that can be proven in a single request. Proofs reveal
+// the segments' contents, so this keeps anyone from quickly reading
+// a block by asking for proofs.
+const maxProofSegments = 16
+
+// Proof requests made longer ago than this are rejected.
+const proofRequestMaxAge = 5 * time.Minute
+
+// Checks that a proof request was recently signed by the metaserver.
+// Only the metaserver may ask for proofs, since they reveal block data.
+func (p *Provider) verifyProofRequest(req *core.ProofRequest) error {
+	if req.Signature == "" {
+		return errors.New("Proof request must be signed by the metaserver")
+	}
+	age := time.Since(req.Time)
+	if age > proofRequestMaxAge || age < -proofRequestMaxAge {
+		return errors.New("Proof request has expired")
+	}
+	key, err := p.getMetaAuditKey()
+	if err != nil {
+		return fmt.Errorf("Unable to get metaserver's audit key. error: %s", err)
+	}
+	err = core.VerifyProofRequest(req, *key)
+	if err != nil {
+		return errors.New("Invalid proof request signature")
+	}
+	return nil
+}
+
+// Returns the key the metaserver signs proof requests with.
+func (p *Provider) getMetaAuditKey() (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	key := p.metaAuditKey
+	p.mu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	client := metaserver.NewClient(p.Config.MetaAddr, &http.Client{})
+	keyStr, err := client.GetAuditKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err = util.UnmarshalPublicKey([]byte(keyStr))
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.metaAuditKey = key
+	p.mu.Unlock()
+	return key, nil
+}
+
+func encodeMerkleNodes(nodes [][]byte) string {
+	data := make([]byte, 0, len(nodes)*sha256.Size)
+	for _, node := range nodes {
+		data = append(data, node...)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func decodeMerkleNodes(encoded string) ([][]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%sha256.Size != 0 {
+		return nil, errors.New("Merkle nodes have wrong length")
+	}
+	nodes := [][]byte{}
+	for i := 0; i < len(data); i += sha256.Size {
+		nodes = append(nodes, data[i:i+sha256.Size])
+	}
+	return nodes, nil
+}
+
+// Returns the cached nodes of a block's Merkle tree. If they aren't
+// cached, as for blocks stored before providers kept them, they're
+// computed from the block.
+func (p *Provider) blockMerkleNodes(d *disk, renterID, blockID string) ([][]byte, error) {
+	encoded, err := p.db.GetBlockMerkleNodes(blockID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up block. Error: %s", err)
+	}
+	if encoded != "" {
+		return decodeMerkleNodes(encoded)
+	}
+	f, _, err := d.store.Get(renterID, blockID)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := core.NewMerkleHasher()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	nodes := h.ChunkRoots()
+	err = p.db.UpdateBlockMerkleNodes(blockID, encodeMerkleNodes(nodes))
+	if err != nil {
+		p.logger.Printf("Unable to cache Merkle nodes for block %s. Error: %s\n", blockID, err)
+	}
+	return nodes, nil
+}
+
+// ProveBlock returns proofs that the given segments of a block are
+// stored by the provider. Only the parts of the block containing the
+// segments are read.
+func (p *Provider) ProveBlock(renterID, blockID string, segments []int) ([]*core.MerkleProof, error) {
+	if len(segments) > maxProofSegments {
+		return nil, fmt.Errorf("Cannot prove more than %d segments at once", maxProofSegments)
+	}
+	d, err := p.findBlockDisk(blockID)
+	if err != nil {
+		return nil, errors.New("Cannot find block")
+	}
+	nodes, err := p.blockMerkleNodes(d, renterID, blockID)
+	if err == ErrBlockNotFound {
+		return nil, errors.New("Cannot find block")
+	}
+	if err != nil {
+		go p.checkDisk(d)
+		return nil, errors.New("IOError: Unable to retrieve block")
+	}
+	f, size, err := d.store.Get(renterID, blockID)
+	if err == ErrBlockNotFound {
+		return nil, errors.New("Cannot find block")
+	}
+	if err != nil {
+		go p.checkDisk(d)
+		return nil, errors.New("IOError: Unable to retrieve block")
+	}
+	defer f.Close()
+
+	proofs := []*core.MerkleProof{}
+	chunk := make([]byte, core.MerkleChunkSize)
+	for _, segment := range segments {
+		if segment < 0 || segment >= core.NumMerkleSegments(size) {
+			return nil, fmt.Errorf("Segment %d is outside of block", segment)
+		}
+		offset := int64(segment/core.MerkleChunkSegments) * core.MerkleChunkSize
+		chunkSize := size - offset
+		if chunkSize > core.MerkleChunkSize {
+			chunkSize = core.MerkleChunkSize
+		}
+		_, err = f.Seek(offset, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(f, chunk[:chunkSize])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading block. Error: %s", err)
+		}
+		proof, err := core.BuildMerkleProof(chunk[:chunkSize], nodes, segment)
+		if err != nil {
+			return nil, err
+		}
+
+		// The proof refers to the chunk buffer, which is reused.
+		proof.Data = append([]byte{}, proof.Data...)
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
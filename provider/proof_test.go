@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"skybin/core"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProveBlock(t *testing.T) {
+	p, homedir := newTestProvider(t, 10*core.MerkleChunkSize)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 10*core.MerkleChunkSize)
+
+	size := 2*core.MerkleChunkSize + 1000
+	data := make([]byte, size)
+	rand.Read(data)
+	h := core.NewMerkleHasher()
+	h.Write(data)
+	root := h.Root()
+	err := p.StoreBlock("r1", "c1", "b1", bytes.NewReader(data), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := core.NumMerkleSegments(int64(size))
+	segments := []int{0, core.MerkleChunkSegments + 7, n - 1}
+	checkProofs := func() {
+		proofs, err := p.ProveBlock("r1", "b1", segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proofs) != len(segments) {
+			t.Fatalf("expected %d proofs. Got %d", len(segments), len(proofs))
+		}
+		for i, proof := range proofs {
+			if proof.Segment != segments[i] {
+				t.Fatal("proofs returned out of order")
+			}
+			err = core.VerifyMerkleProof(root, int64(size), proof)
+			if err != nil {
+				t.Fatalf("segment %d: %s", segments[i], err)
+			}
+		}
+	}
+	checkProofs()
+
+	// Blocks stored before Merkle nodes were cached should
+	// have them computed the first time they're proven.
+	err = p.db.UpdateBlockMerkleNodes("b1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProofs()
+	nodes, err := p.db.GetBlockMerkleNodes("b1")
+	if err != nil || nodes == "" {
+		t.Fatal("Merkle nodes were not cached")
+	}
+
+	if _, err := p.ProveBlock("r1", "b1", []int{n}); err == nil {
+		t.Fatal("expected error proving segment past end of block")
+	}
+	if _, err := p.ProveBlock("r1", "b1", make([]int, maxProofSegments+1)); err == nil {
+		t.Fatal("expected error proving too many segments")
+	}
+}
+
+func TestPostProofRequiresMetaserverSignature(t *testing.T) {
+	p, homedir := newTestProvider(t, 10*core.MerkleChunkSize)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 10*core.MerkleChunkSize)
+	var err error
+	p.privKey, err = rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.metaAuditKey = &metaKey.PublicKey
+
+	data := make([]byte, 1000)
+	rand.Read(data)
+	err = p.StoreBlock("r1", "c1", "b1", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(p, log.New(ioutil.Discard, "", log.LstdFlags)))
+	defer ts.Close()
+	client := NewClient(strings.TrimPrefix(ts.URL, "http://"), &http.Client{})
+
+	newRequest := func(key *rsa.PrivateKey, at time.Time) *core.ProofRequest {
+		req := &core.ProofRequest{RenterId: "r1", BlockId: "b1", Segments: []int{0, 1}, Time: at}
+		if key != nil {
+			req.Signature, err = core.SignProofRequest(req, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return req
+	}
+
+	// Requests without credentials are rejected.
+	body, err := json.Marshal(newRequest(nil, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL+"/blocks/proof?renterID=r1&blockID=b1", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned proof request to be unauthorized. Got status %d", resp.StatusCode)
+	}
+
+	otherKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ProveBlock(newRequest(otherKey, time.Now()))
+	if err == nil {
+		t.Fatal("expected error for proof request signed with another key")
+	}
+	_, err = client.ProveBlock(newRequest(metaKey, time.Now().Add(-time.Hour)))
+	if err == nil {
+		t.Fatal("expected error for expired proof request")
+	}
+
+	proofs, err := client.ProveBlock(newRequest(metaKey, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs) != 2 {
+		t.Fatalf("expected 2 proofs. Got %d", len(proofs))
+	}
+}
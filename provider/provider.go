@@ -17,32 +17,61 @@ import (
 )
 
 type Config struct {
-	ProviderID     string        `json:"providerId"`
-	PublicApiAddr  string        `json:"publicApiAddress"`
-	MetaAddr       string        `json:"metaServerAddress"`
-	LocalApiAddr   string        `json:"localApiAddress"`
-	PrivateKeyFile string        `json:"privateKeyFile"`
-	PublicKeyFile  string        `json:"publicKeyFile"`
-	SpaceAvail     int64         `json:"spaceAvail"`
-	StorageRate    int64         `json:"storageRate"`
-	MinStorageRate int64         `json:"minStorageRate"`
-	MaxStorageRate int64         `json:"maxStorageRate"`
-	PricingPolicy  PricingPolicy `json:"pricingPolicy"`
+	ProviderID     string            `json:"providerId"`
+	PublicApiAddr  string            `json:"publicApiAddress"`
+	MetaAddr       string            `json:"metaServerAddress"`
+	LocalApiAddr   string            `json:"localApiAddress"`
+	PrivateKeyFile string            `json:"privateKeyFile"`
+	PublicKeyFile  string            `json:"publicKeyFile"`
+	SpaceAvail     int64             `json:"spaceAvail"`
+	StorageRate    int64             `json:"storageRate"`
+	MinStorageRate int64             `json:"minStorageRate"`
+	MaxStorageRate int64             `json:"maxStorageRate"`
+	PricingPolicy  PricingPolicyName `json:"pricingPolicy"`
+
+	// Rate charged for sending blocks to renters, in tenths of
+	// cents/1e9 bytes. Zero means downloads are free.
+	DownloadRate int64 `json:"downloadRate,omitempty"`
+
+	// Region announced to the metaserver, e.g. "us-west".
+	Region string `json:"region,omitempty"`
+
+	// Backend used to store blocks. Defaults to flat if unset.
+	BlockStore BlockStoreBackend `json:"blockStore,omitempty"`
+
+	// Directories to store blocks in. If set, SpaceAvail is the total
+	// capacity of the working paths. Otherwise, blocks are stored in
+	// the provider's home directory.
+	StoragePaths []StoragePath `json:"storagePaths,omitempty"`
+
+	// Max bytes per second read while scrubbing blocks for corruption.
+	// Zero uses DefaultScrubRate, and a negative rate disables scrubbing.
+	ScrubRate int64 `json:"scrubRate,omitempty"`
+
+	// Bandwidth limits for block transfers in bytes per second, both
+	// overall and for each renter. Uploads are blocks renters send to
+	// the provider. Zero means unlimited.
+	MaxUploadRate         int64 `json:"maxUploadRate,omitempty"`
+	MaxDownloadRate       int64 `json:"maxDownloadRate,omitempty"`
+	MaxRenterUploadRate   int64 `json:"maxRenterUploadRate,omitempty"`
+	MaxRenterDownloadRate int64 `json:"maxRenterDownloadRate,omitempty"`
 }
 
 type Info struct {
-	ProviderId       string `json:"providerId"`
-	StorageAllocated int64  `json:"storageAllocated"`
-	StorageReserved  int64  `json:"storageReserved"`
-	StorageUsed      int64  `json:"storageUsed"`
-	StorageFree      int64  `json:"storageFree"`
-	StorageRate      int64  `json:"storageRate"`
-	MinStorageRate   int64  `json:"minStorageRate"`
-	MaxStorageRate   int64  `json:"maxStorageRate"`
-	PricingPolicy    string `json:"pricingPolicy"`
-	TotalContracts   int    `json:"totalContracts"`
-	TotalBlocks      int    `json:"totalBlocks"`
-	TotalRenters     int    `json:"totalRenters"`
+	ProviderId       string     `json:"providerId"`
+	StorageAllocated int64      `json:"storageAllocated"`
+	StorageReserved  int64      `json:"storageReserved"`
+	StorageUsed      int64      `json:"storageUsed"`
+	StorageFree      int64      `json:"storageFree"`
+	StorageRate      int64      `json:"storageRate"`
+	MinStorageRate   int64      `json:"minStorageRate"`
+	MaxStorageRate   int64      `json:"maxStorageRate"`
+	PricingPolicy    string     `json:"pricingPolicy"`
+	DownloadRate     int64      `json:"downloadRate"`
+	TotalContracts   int        `json:"totalContracts"`
+	TotalBlocks      int        `json:"totalBlocks"`
+	TotalRenters     int        `json:"totalRenters"`
+	Disks            []DiskInfo `json:"disks"`
 }
 
 type Provider struct {
@@ -50,12 +79,19 @@ type Provider struct {
 	Config  *Config
 	privKey *rsa.PrivateKey
 	db      *providerDB
+	disks   []*disk
 
 	// Maps renter IDs to renter information
 	renters map[string]*renterInfo
 
+	// Maps contract IDs to the storage used under each contract
+	contracts map[string]*contractInfo
+
 	// Maps renter IDs to their public keys
-	renterKeys      map[string]string
+	renterKeys map[string]string
+	// Key the metaserver signs proof requests with.
+	// Fetched from the metaserver when it's first needed.
+	metaAuditKey    *rsa.PublicKey
 	StorageReserved int64
 	StorageUsed     int64
 	TotalBlocks     int
@@ -63,12 +99,31 @@ type Provider struct {
 	logger          *log.Logger
 	doneCh          chan struct{}
 	mu              sync.RWMutex
+
+	// Serializes disk checks so a failed disk is only handled once
+	diskCheckMu sync.Mutex
+
+	// The pricing policy's latest decision
+	lastPricing *PricingDecision
+
+	uploadBandwidth   bandwidthLimiter
+	downloadBandwidth bandwidthLimiter
 }
 
 type blockInfo struct {
 	RenterId string `json:"renterId"`
 	BlockId  string `json:"blockId"`
 	Size     int64  `json:"blockSize"`
+	// Contract the block is stored under. Empty for blocks stored
+	// before blocks were associated with contracts.
+	ContractId string `json:"contractId,omitempty"`
+	// Path of the disk the block is stored on
+	Disk string `json:"-"`
+	// Base64 encoded sha256 hash of the block
+	Sha256 string `json:"-"`
+	// Whether the block's disk failed. Suspect blocks aren't counted in
+	// the provider's usage unless the disk recovers.
+	Suspect bool `json:"-"`
 }
 
 type renterInfo struct {
@@ -76,12 +131,30 @@ type renterInfo struct {
 	StorageUsed     int64 `json:"storageUsed"`
 }
 
-type PricingPolicy string
+// Storage a renter may use under one contract. Blocks can only be
+// stored under a contract until it ends, and only up to its storage space.
+type contractInfo struct {
+	RenterId     string
+	StorageSpace int64
+	StorageUsed  int64
+	EndDate      time.Time
+}
+
+func newContractInfo(c *core.Contract) *contractInfo {
+	return &contractInfo{
+		RenterId:     c.RenterId,
+		StorageSpace: c.StorageSpace,
+		EndDate:      c.EndDate,
+	}
+}
+
+type PricingPolicyName string
 
 const (
-	FixedPricingPolicy      PricingPolicy = "fixed"
-	PassivePricingPolicy    PricingPolicy = "passive"
-	AggressivePricingPolicy PricingPolicy = "aggressive"
+	FixedPricingPolicy      PricingPolicyName = "fixed"
+	PassivePricingPolicy    PricingPolicyName = "passive"
+	AggressivePricingPolicy PricingPolicyName = "aggressive"
+	DemandPricingPolicy     PricingPolicyName = "demand"
 )
 
 const (
@@ -113,6 +186,7 @@ func LoadFromDisk(homedir string) (*Provider, error) {
 		return nil, fmt.Errorf("Failed to load config file. error: %s", err)
 	}
 	provider.Config = config
+	provider.updateBandwidthLimits()
 
 	dbPath := path.Join(homedir, "provider.db")
 	provider.db, err = setupDB(dbPath)
@@ -120,6 +194,12 @@ func LoadFromDisk(homedir string) (*Provider, error) {
 		return nil, fmt.Errorf("Failed to initialize DB. error: %s", err)
 	}
 
+	provider.openDisks()
+	err = provider.db.AssignLegacyBlocks(homedir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to assign blocks to disk. error: %s", err)
+	}
+
 	err = provider.loadInfoFromDB()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load provider DB into mem. error: %s", err)
@@ -154,12 +234,18 @@ func LoadFromDisk(homedir string) (*Provider, error) {
 // 	   - StorageUsed
 //     - StorageReserved
 //   }
+// - provider.contracts
 func (p *Provider) loadInfoFromDB() error {
 	p.StorageReserved = 0
 	p.StorageUsed = 0
 	p.TotalBlocks = 0
 	p.TotalContracts = 0
 	p.renters = make(map[string]*renterInfo, 0)
+	p.contracts = make(map[string]*contractInfo, 0)
+	for _, d := range p.disks {
+		d.used = 0
+		d.totalBlocks = 0
+	}
 
 	contracts, err := p.db.GetAllContracts()
 	if err != nil {
@@ -172,6 +258,7 @@ func (p *Provider) loadInfoFromDB() error {
 			p.renters[c.RenterId] = &renterInfo{}
 		}
 		p.renters[c.RenterId].StorageReserved += c.StorageSpace
+		p.contracts[c.ID] = newContractInfo(c)
 		p.StorageReserved += c.StorageSpace
 		p.TotalContracts++
 	}
@@ -181,20 +268,35 @@ func (p *Provider) loadInfoFromDB() error {
 		return err
 	}
 	for _, b := range blocks {
+		if b.Suspect {
+			continue
+		}
 		_, ok := p.renters[b.RenterId]
 		if !ok {
-			// TODO: block with no associated contract?
-			return nil
+			// The block's renter has no contract. Its usage is still
+			// counted so that it matches what's on disk until the
+			// consistency check removes the block.
+			p.renters[b.RenterId] = &renterInfo{}
 		}
 		p.renters[b.RenterId].StorageUsed += b.Size
+		if c, ok := p.contracts[b.ContractId]; ok {
+			c.StorageUsed += b.Size
+		}
 		p.StorageUsed += b.Size
 		p.TotalBlocks++
+		d := p.diskByPath(b.Disk)
+		d.used += b.Size
+		d.totalBlocks++
 	}
 	return nil
 }
 
 func (provider *Provider) StartBackgroundThreads() {
 	go provider.pricingUpdateThread()
+	go provider.diskCheckThread()
+	go provider.scrubThread()
+	go provider.egressReportThread()
+	go provider.heartbeatThread()
 }
 
 func (provider *Provider) StopBackgroundThreads() {
@@ -206,16 +308,19 @@ func (provider *Provider) SetLogger(logger *log.Logger) {
 }
 
 func (provider *Provider) UpdateConfig(config *Config) error {
-	switch config.PricingPolicy {
-	case FixedPricingPolicy:
-	case PassivePricingPolicy:
-	case AggressivePricingPolicy:
-	default:
+	if !ValidPricingPolicy(config.PricingPolicy) {
 		return errors.New("Unrecognized pricing policy.")
 	}
 	if config.MinStorageRate > config.MaxStorageRate {
 		return errors.New("min storage rate cannot exceed max storage rate")
 	}
+	if config.MaxUploadRate < 0 || config.MaxDownloadRate < 0 ||
+		config.MaxRenterUploadRate < 0 || config.MaxRenterDownloadRate < 0 {
+		return errors.New("bandwidth limits cannot be negative")
+	}
+	if config.DownloadRate < 0 {
+		return errors.New("download rate cannot be negative")
+	}
 
 	provider.mu.Lock()
 	provider.Config.SpaceAvail = config.SpaceAvail
@@ -230,6 +335,13 @@ func (provider *Provider) UpdateConfig(config *Config) error {
 		// algorithm should determine the rate.
 		provider.Config.StorageRate = config.StorageRate
 	}
+	provider.Config.MaxUploadRate = config.MaxUploadRate
+	provider.Config.MaxDownloadRate = config.MaxDownloadRate
+	provider.Config.MaxRenterUploadRate = config.MaxRenterUploadRate
+	provider.Config.MaxRenterDownloadRate = config.MaxRenterDownloadRate
+	provider.Config.DownloadRate = config.DownloadRate
+	provider.updateSpaceAvail()
+	provider.updateBandwidthLimits()
 	provider.mu.Unlock()
 
 	provider.updatePricing()
@@ -246,6 +358,7 @@ func (provider *Provider) UpdateConfig(config *Config) error {
 }
 
 func (provider *Provider) GetPublicInfo() *Info {
+	disks := provider.diskInfo()
 	provider.mu.RLock()
 	defer provider.mu.RUnlock()
 	return &Info{
@@ -258,9 +371,11 @@ func (provider *Provider) GetPublicInfo() *Info {
 		MinStorageRate:   provider.Config.MinStorageRate,
 		MaxStorageRate:   provider.Config.MaxStorageRate,
 		PricingPolicy:    string(provider.Config.PricingPolicy),
+		DownloadRate:     provider.Config.DownloadRate,
 		TotalContracts:   provider.TotalContracts,
 		TotalRenters:     len(provider.renters),
 		TotalBlocks:      provider.TotalBlocks,
+		Disks:            disks,
 	}
 }
 
@@ -319,11 +434,13 @@ func (provider *Provider) UpdateMeta() error {
 
 	provider.mu.RLock()
 	info := core.ProviderInfo{
-		ID:          provider.Config.ProviderID,
-		PublicKey:   string(pubKeyBytes),
-		Addr:        provider.Config.PublicApiAddr,
-		SpaceAvail:  provider.Config.SpaceAvail - provider.StorageReserved,
-		StorageRate: provider.Config.StorageRate,
+		ID:           provider.Config.ProviderID,
+		PublicKey:    string(pubKeyBytes),
+		Addr:         provider.Config.PublicApiAddr,
+		SpaceAvail:   provider.Config.SpaceAvail - provider.StorageReserved,
+		StorageRate:  provider.Config.StorageRate,
+		DownloadRate: provider.Config.DownloadRate,
+		Region:       provider.Config.Region,
 	}
 	provider.mu.RUnlock()
 	metaService := metaserver.NewClient(provider.Config.MetaAddr, &http.Client{})
@@ -361,18 +478,15 @@ func (provider *Provider) Withdraw(email string, amount int64) error {
 	return nil
 }
 
-func (provider *Provider) ListTransactions() ([]core.Transaction, error) {
+// Lists a page of the provider's transactions. Returns the cursor
+// for the next page, or an empty string on the last page.
+func (provider *Provider) ListTransactions(query *metaserver.TransactionQuery) ([]core.Transaction, string, error) {
 	client := metaserver.NewClient(provider.Config.MetaAddr, &http.Client{})
 	err := client.AuthorizeProvider(provider.privKey, provider.Config.ProviderID)
 	if err != nil {
-		return nil, err
-	}
-
-	transactions, err := client.GetProviderTransactions(provider.Config.ProviderID)
-	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return transactions, nil
+	return client.GetProviderTransactionsPage(provider.Config.ProviderID, query)
 }
 
 func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
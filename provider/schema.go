@@ -0,0 +1,310 @@
+package provider
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// A schemaMigration changes the provider DB's schema from the
+// previous version to its version.
+type schemaMigration struct {
+	Version     int
+	Description string
+	Apply       func(tx *sql.Tx) error
+}
+
+// Migrations to bring the provider DB to the latest schema, in order.
+// Migrations must never be changed or removed once released. Instead,
+// add a new migration to the end of the list.
+//
+// DBs created before schema versions were tracked may already have
+// some of the columns added by the first few migrations, so those
+// migrations only add what's missing.
+var schemaMigrations = []schemaMigration{
+	{1, "Create contracts, blocks, and activity tables", createInitialTables},
+	{2, "Add Disk column to blocks", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "blocks", "Disk", "TEXT DEFAULT ''")
+	}},
+	{3, "Add Sha256 and ScrubTime columns to blocks", func(tx *sql.Tx) error {
+		err := addColumnIfMissing(tx, "blocks", "Sha256", "TEXT DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "blocks", "ScrubTime", "TEXT DEFAULT ''")
+	}},
+	{4, "Add MerkleNodes column to blocks", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "blocks", "MerkleNodes", "TEXT DEFAULT ''")
+	}},
+	{5, "Add DownloadRate column to contracts and create egress table", func(tx *sql.Tx) error {
+		err := addColumnIfMissing(tx, "contracts", "DownloadRate", "INTEGER DEFAULT 0")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS egress (
+			RenterId TEXT PRIMARY KEY,
+			Bytes INTEGER DEFAULT 0)`)
+		return err
+	}},
+	{6, "Add ContractId column to blocks", func(tx *sql.Tx) error {
+		err := addColumnIfMissing(tx, "blocks", "ContractId", "TEXT DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS contractid_blocks ON blocks (ContractId)`)
+		return err
+	}},
+	{7, "Add Suspect column to blocks", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "blocks", "Suspect", "INTEGER DEFAULT 0")
+	}},
+}
+
+// Version of the schema created by the latest migration.
+func latestSchemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].Version
+}
+
+func createInitialTables(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS contracts ( id INTEGER PRIMARY KEY,
+			ContractId TEXT,
+			RenterId TEXT,
+			ProviderId TEXT,
+			StorageSpace INTEGER,
+			StartDate TEXT,
+			EndDate TEXT,
+			RenterSignature TEXT,
+			ProviderSignature TEXT,
+			StorageFee INTEGER)`,
+		`CREATE TABLE IF NOT EXISTS blocks ( id INTEGER PRIMARY KEY,
+			RenterId TEXT,
+			BlockId TEXT,
+			Size INTEGER)`,
+		`CREATE TABLE IF NOT EXISTS activity ( id INTEGER PRIMARY KEY,
+			Period TEXT,
+			Timestamp TEXT,
+			BlockUploads INTEGER DEFAULT 0,
+			BlockDownloads INTEGER DEFAULT 0,
+			BlockDeletions INTEGER DEFAULT 0,
+			BytesUploaded INTEGER DEFAULT 0,
+			BytesDownloaded INTEGER DEFAULT 0,
+			StorageReservations INTEGER DEFAULT 0 )`,
+		`CREATE INDEX IF NOT EXISTS blockid_blocks ON blocks (BlockId)`,
+		`CREATE INDEX IF NOT EXISTS renterid_blocks ON blocks (RenterId)`,
+		`CREATE INDEX IF NOT EXISTS renterid_idx ON contracts (RenterId)`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationStatus describes a schema migration and whether
+// it has been applied to a provider's DB.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+func createSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		Version INTEGER PRIMARY KEY,
+		Description TEXT,
+		AppliedAt TEXT)`)
+	return err
+}
+
+// Returns the version of the DB's schema, or zero for DBs that
+// have never been migrated.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(Version) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Brings the DB at dbPath to the latest schema. If any migrations
+// need to be applied to an existing DB, it's first copied to a backup
+// file, whose path is returned. Each migration is applied in its own
+// transaction, so a failed migration leaves the DB at the previous
+// version. Returns the number of migrations applied.
+func migrateDB(db *sql.DB, dbPath string) (int, string, error) {
+	err := createSchemaVersionTable(db)
+	if err != nil {
+		return 0, "", fmt.Errorf("Failed to create schema_version table. error: %s", err)
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, "", fmt.Errorf("Failed to read schema version. error: %s", err)
+	}
+	if version > latestSchemaVersion() {
+		return 0, "", fmt.Errorf("DB schema version %d is newer than this provider supports (%d)",
+			version, latestSchemaVersion())
+	}
+	if version == latestSchemaVersion() {
+		return 0, "", nil
+	}
+
+	backupPath, err := backupDB(db, dbPath, version)
+	if err != nil {
+		return 0, "", fmt.Errorf("Failed to back up DB before migrating. error: %s", err)
+	}
+
+	applied := 0
+	for _, m := range schemaMigrations {
+		if m.Version <= version {
+			continue
+		}
+		err = applyMigration(db, m)
+		if err != nil {
+			return applied, backupPath, fmt.Errorf("Migration %d (%s) failed. error: %s",
+				m.Version, m.Description, err)
+		}
+		applied++
+	}
+	return applied, backupPath, nil
+}
+
+func applyMigration(db *sql.DB, m schemaMigration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = m.Apply(tx)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO schema_version (Version, Description, AppliedAt) VALUES (?, ?, ?)`,
+			m.Version, m.Description, time.Now().Format(time.RFC3339))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Copies the DB file to a backup next to it. Returns an empty path
+// without making a backup if the DB has no tables besides the
+// schema_version table, as when it's just been created.
+func backupDB(db *sql.DB, dbPath string, version int) (string, error) {
+	var numTables int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' and name != 'schema_version'`).Scan(&numTables)
+	if err != nil {
+		return "", err
+	}
+	if numTables == 0 {
+		return "", nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d.%s.bak", dbPath, version, time.Now().Format("20060102150405"))
+	src, err := os.Open(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// Returns the status of each of the DB's migrations.
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	err := createSchemaVersionTable(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT Version, AppliedAt FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var timestamp string
+		err = rows.Scan(&version, &timestamp)
+		if err != nil {
+			return nil, err
+		}
+		appliedAt[version], err = time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range schemaMigrations {
+		t, applied := appliedAt[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     applied,
+			AppliedAt:   t,
+		})
+	}
+	return statuses, nil
+}
+
+// Opens a DB without creating it if it doesn't exist.
+func openExistingDB(dbPath string) (*sql.DB, error) {
+	_, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find provider DB. error: %s", err)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open DB. error: %s", err)
+	}
+	return db, nil
+}
+
+// MigrateDB brings the DB in the provider's home directory to the
+// latest schema. The provider daemon should be stopped first.
+// Returns the number of migrations applied and the path of the
+// backup made before migrating, if any.
+func MigrateDB(homedir string) (int, string, error) {
+	dbPath := path.Join(homedir, "provider.db")
+	db, err := openExistingDB(dbPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer db.Close()
+	return migrateDB(db, dbPath)
+}
+
+// DBMigrationStatus returns the status of each migration of the DB
+// in the provider's home directory.
+func DBMigrationStatus(homedir string) ([]MigrationStatus, error) {
+	db, err := openExistingDB(path.Join(homedir, "provider.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return migrationStatus(db)
+}
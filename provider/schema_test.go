@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacyDB(t *testing.T) {
+	homedir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(homedir)
+	dbPath := path.Join(homedir, "provider.db")
+
+	// Create a DB like those made before schema versions were
+	// tracked, with the Disk column but none added after it.
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE blocks ( id INTEGER PRIMARY KEY,
+		RenterId TEXT, BlockId TEXT, Size INTEGER, Disk TEXT DEFAULT '')`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO blocks (RenterId, BlockId, Size, Disk) VALUES ('r1', 'b1', 10, 'd1')`)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	applied, backupPath, err := MigrateDB(homedir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(schemaMigrations) {
+		t.Fatalf("expected %d migrations applied. Got %d", len(schemaMigrations), applied)
+	}
+	if backupPath == "" {
+		t.Fatal("DB was not backed up before migrating")
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	pdb, err := setupDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	nodes, err := pdb.GetBlockMerkleNodes("b1")
+	if err != nil || nodes != "" {
+		t.Fatalf("expected block to survive migration with new columns. Got %q, %v", nodes, err)
+	}
+
+	statuses, err := DBMigrationStatus(homedir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Fatalf("migration %d was not applied", s.Version)
+		}
+	}
+	applied, backupPath, err = MigrateDB(homedir)
+	if err != nil || applied != 0 || backupPath != "" {
+		t.Fatal("migrating an up to date DB should do nothing")
+	}
+	backups, _ := filepath.Glob(dbPath + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup. Got %d", len(backups))
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	homedir, err := ioutil.TempDir("", "skybin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(homedir)
+	dbPath := path.Join(homedir, "provider.db")
+	pdb, err := setupDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdb.Close()
+
+	latest := latestSchemaVersion()
+	defer func(migrations []schemaMigration) {
+		schemaMigrations = migrations
+	}(schemaMigrations)
+	schemaMigrations = append(schemaMigrations, schemaMigration{
+		latest + 1, "Broken migration", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE partial (id INTEGER)`)
+			if err != nil {
+				return err
+			}
+			return errors.New("migration failed")
+		},
+	})
+
+	_, _, err = MigrateDB(homedir)
+	if err == nil {
+		t.Fatal("expected migration to fail")
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Fatalf("expected schema version %d. Got %d", latest, version)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'partial'`).Scan(&n)
+	if n != 0 {
+		t.Fatal("failed migration was not rolled back")
+	}
+}
@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// By default, the scrubber reads at most 10 MB of blocks per second.
+	DefaultScrubRate = 10 * 1e6
+
+	// How often the scrubber checks for blocks that are due to be scrubbed.
+	scrubCheckInterval = time.Hour
+
+	// How often each block is scrubbed.
+	scrubPeriod = 7 * 24 * time.Hour
+
+	// Directory in the provider's home directory where
+	// corrupted blocks are moved.
+	quarantineDir = "quarantine"
+)
+
+// Periodically re-hashes stored blocks to detect corruption before
+// an audit or download does.
+func (p *Provider) scrubThread() {
+	p.logger.Println("starting scrub thread")
+	ticker := time.NewTicker(scrubCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.scrubBlocks()
+		case <-p.doneCh:
+			p.logger.Println("scrub thread shutting down")
+			return
+		}
+	}
+}
+
+func (p *Provider) scrubRate() int64 {
+	if p.Config.ScrubRate == 0 {
+		return DefaultScrubRate
+	}
+	return p.Config.ScrubRate
+}
+
+// Scrubs every block which is due, throttled to the configured scrub rate.
+func (p *Provider) scrubBlocks() {
+	rate := p.scrubRate()
+	if rate < 0 {
+		return
+	}
+	blocks, err := p.db.GetBlocksToScrub(time.Now().Add(-scrubPeriod))
+	if err != nil {
+		p.logger.Println("scrub thread: unable to find blocks to scrub. error: ", err)
+		return
+	}
+	corrupted := []*blockInfo{}
+	for _, b := range blocks {
+		start := time.Now()
+		ok := p.scrubBlock(b)
+		if !ok {
+			corrupted = append(corrupted, b)
+		}
+
+		// Sleep long enough to keep to the scrub rate.
+		delay := time.Duration(b.Size*int64(time.Second)/rate) - time.Since(start)
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-p.doneCh:
+				return
+			}
+		}
+	}
+	if len(corrupted) == 0 {
+		return
+	}
+	p.logger.Printf("scrub thread: quarantined %d corrupted blocks\n", len(corrupted))
+	err = p.reportLostBlocks(corrupted)
+	if err != nil {
+		// Audits will find the blocks missing eventually.
+		p.logger.Println("scrub thread: unable to report corrupted blocks. error: ", err)
+	}
+}
+
+// Re-hashes a block and compares it against the hash recorded when it
+// was stored. Corrupted blocks are quarantined. Returns false if the
+// block was corrupted or missing.
+func (p *Provider) scrubBlock(b *blockInfo) bool {
+	d, err := p.findBlockDisk(b.BlockId)
+	if err != nil {
+		// The block was deleted or its disk failed since we started.
+		return true
+	}
+	f, _, err := d.store.Get(b.RenterId, b.BlockId)
+	if err == ErrBlockNotFound {
+		p.logger.Printf("scrub thread: block %s is missing\n", b.BlockId)
+		p.removeCorruptedBlock(d, b)
+		return false
+	}
+	if err != nil {
+		go p.checkDisk(d)
+		return true
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		go p.checkDisk(d)
+		return true
+	}
+	blockHash := base64.URLEncoding.EncodeToString(h.Sum(nil))
+
+	// Blocks stored before providers recorded hashes are trusted
+	// the first time they're scrubbed.
+	if b.Sha256 == "" || blockHash == b.Sha256 {
+		err = p.db.UpdateBlockScrub(b.BlockId, blockHash, time.Now())
+		if err != nil {
+			p.logger.Println("scrub thread: unable to record scrub. error: ", err)
+		}
+		return true
+	}
+
+	p.logger.Printf("scrub thread: block %s is corrupted. expected hash %s, got %s\n",
+		b.BlockId, b.Sha256, blockHash)
+	err = p.quarantineBlock(d, b)
+	if err != nil {
+		p.logger.Printf("scrub thread: unable to quarantine block %s. error: %s\n", b.BlockId, err)
+	}
+	p.removeCorruptedBlock(d, b)
+	return false
+}
+
+// Moves a corrupted block's contents to the quarantine directory
+// for inspection.
+func (p *Provider) quarantineBlock(d *disk, b *blockInfo) error {
+	dir := path.Join(p.Homedir, quarantineDir, b.RenterId)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, _, err := d.store.Get(b.RenterId, b.BlockId)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := os.Create(path.Join(dir, b.BlockId))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+// Removes a corrupted or missing block from the provider.
+func (p *Provider) removeCorruptedBlock(d *disk, b *blockInfo) {
+	err := d.store.Delete(b.RenterId, b.BlockId)
+	if err != nil && err != ErrBlockNotFound {
+		p.logger.Printf("scrub thread: unable to remove block %s. error: %s\n", b.BlockId, err)
+	}
+	err = p.db.DeleteBlockById(b.BlockId)
+	if err != nil {
+		p.logger.Printf("scrub thread: unable to remove block %s from DB. error: %s\n", b.BlockId, err)
+		return
+	}
+	p.mu.Lock()
+	if renter, exists := p.renters[b.RenterId]; exists {
+		renter.StorageUsed -= b.Size
+	}
+	if contract, exists := p.contracts[b.ContractId]; exists {
+		contract.StorageUsed -= b.Size
+	}
+	d.used -= b.Size
+	d.totalBlocks--
+	p.StorageUsed -= b.Size
+	p.TotalBlocks--
+	p.mu.Unlock()
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestScrubBlocks(t *testing.T) {
+	p, homedir := newTestProvider(t, 10000)
+	defer os.RemoveAll(homedir)
+	p.Config.ScrubRate = 1e12
+	addTestContract(p, "r1", "c1", 10000)
+
+	data := []byte("some block contents")
+	for _, id := range []string{"b1", "b2"} {
+		err := p.StoreBlock("r1", "c1", id, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Newly stored blocks shouldn't be due for scrubbing.
+	due, err := p.db.GetBlocksToScrub(time.Now().Add(-scrubPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Fatal("new blocks should not need scrubbing")
+	}
+
+	// Corrupt b2 and make both blocks due.
+	blockPath := path.Join(blockStoreDir(p.disks[0].path, FlatBlockStoreBackend), "r1", "b2")
+	err = ioutil.WriteFile(blockPath, []byte("rotten block contents"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longAgo := time.Now().Add(-2 * scrubPeriod)
+	blocks, err := p.db.GetAllBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		err = p.db.UpdateBlockScrub(b.BlockId, b.Sha256, longAgo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p.scrubBlocks()
+
+	if _, err := p.GetBlock("r1", "b2"); err == nil {
+		t.Fatal("corrupted block should be removed")
+	}
+	if _, err := os.Stat(path.Join(homedir, quarantineDir, "r1", "b2")); err != nil {
+		t.Fatal("corrupted block should be quarantined")
+	}
+	if p.TotalBlocks != 1 || p.renters["r1"].StorageUsed != int64(len(data)) ||
+		p.contracts["c1"].StorageUsed != int64(len(data)) {
+		t.Fatal("corrupted block still counted in usage")
+	}
+	r, err := p.GetBlock("r1", "b1")
+	if err != nil {
+		t.Fatal("healthy block should not be removed")
+	}
+	r.Close()
+	due, err = p.db.GetBlocksToScrub(time.Now().Add(-scrubPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Fatal("scrubbed block should not be due again")
+	}
+}
@@ -1,19 +1,26 @@
 package provider
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"skybin/authorization"
 	"skybin/core"
+	"skybin/metrics"
 	"skybin/util"
 
 	"github.com/gorilla/mux"
-	"io"
 	"strconv"
+	"time"
 )
 
+// Header in which a renter downloading another renter's blocks presents
+// the base64 encoded JSON read capability the owner issued them.
+const ReadCapabilityHeader = "Skybin-Read-Capability"
+
 type providerServer struct {
 	provider   *Provider
 	logger     *log.Logger
@@ -34,6 +41,7 @@ func NewServer(provider *Provider, logger *log.Logger) http.Handler {
 	}
 
 	authMiddleware := authorization.GetAuthMiddleware(util.MarshalPrivateKey(server.provider.privKey))
+	optionalAuthMiddleware := authorization.GetOptionalAuthMiddleware(util.MarshalPrivateKey(server.provider.privKey))
 	router.Handle("/auth/renter", server.authorizer.GetAuthChallengeHandler("renterID")).Methods("GET")
 	router.Handle("/auth/renter", server.authorizer.GetRespondAuthChallengeHandler(
 		"renterID",
@@ -41,12 +49,16 @@ func NewServer(provider *Provider, logger *log.Logger) http.Handler {
 		server.provider.getRenterPublicKey)).Methods("POST")
 
 	router.HandleFunc("/contracts", server.postContract).Methods("POST")
-	router.HandleFunc("/blocks", server.getBlock).Methods("GET")
+	router.HandleFunc("/contracts/cancel", server.cancelContract).Methods("POST")
+	router.Handle("/blocks", optionalAuthMiddleware.Handler(http.HandlerFunc(server.getBlock))).Methods("GET")
 	router.Handle("/blocks", authMiddleware.Handler(http.HandlerFunc(server.postBlock))).Methods("POST")
 	router.Handle("/blocks", authMiddleware.Handler(http.HandlerFunc(server.deleteBlock))).Methods("DELETE")
 	router.HandleFunc("/blocks/audit", server.postAudit).Methods("POST")
+	router.HandleFunc("/blocks/proof", server.postProof).Methods("POST")
 	router.Handle("/renter-info", authMiddleware.Handler(http.HandlerFunc(server.getRenter))).Methods("GET")
 	router.HandleFunc("/info", server.getInfo).Methods("GET")
+	router.HandleFunc("/challenge", server.postChallenge).Methods("POST")
+	metrics.Instrument("provider", router)
 
 	return &server
 }
@@ -90,6 +102,38 @@ func (server *providerServer) postContract(w http.ResponseWriter, r *http.Reques
 	server.writeResp(w, http.StatusCreated, &postContractResp{Contract: signedContract})
 }
 
+type cancelContractParams struct {
+	Termination *core.ContractTermination `json:"termination"`
+}
+
+type cancelContractResp struct {
+	Termination *core.ContractTermination `json:"termination"`
+}
+
+func (server *providerServer) cancelContract(w http.ResponseWriter, r *http.Request) {
+	var params cancelContractParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"Bad json"})
+		return
+	}
+	if params.Termination == nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"No termination given"})
+		return
+	}
+
+	signed, err := server.provider.CancelContract(params.Termination)
+	if err != nil {
+		server.logger.Println(err)
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{err.Error()})
+		return
+	}
+	server.writeResp(w, http.StatusOK, &cancelContractResp{Termination: signed})
+}
+
 func (server *providerServer) postBlock(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
@@ -107,6 +151,13 @@ func (server *providerServer) postBlock(w http.ResponseWriter, r *http.Request)
 	}
 	blockID := blockquery[0]
 
+	contractquery, exists := query["contractID"]
+	if !exists {
+		server.writeResp(w, http.StatusBadRequest, errorResp{"No contract ID given"})
+		return
+	}
+	contractID := contractquery[0]
+
 	sizequery, exists := query["size"]
 	if !exists {
 		server.writeResp(w, http.StatusBadRequest, errorResp{"No block size given"})
@@ -132,7 +183,8 @@ func (server *providerServer) postBlock(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = server.provider.StoreBlock(renterID, blockID, r.Body, size)
+	err = server.provider.StoreBlock(renterID, contractID, blockID,
+		server.provider.limitUpload(renterID, r.Body), size)
 	if err != nil {
 		server.logger.Println(err)
 		server.writeResp(w, http.StatusBadRequest, &errorResp{err.Error()})
@@ -159,18 +211,77 @@ func (server *providerServer) getBlock(w http.ResponseWriter, r *http.Request) {
 	}
 	blockID := blockquery[0]
 
+	err := server.authorizeBlockRead(r, renterID, blockID)
+	if err != nil {
+		server.writeResp(w, http.StatusForbidden, &errorResp{err.Error()})
+		return
+	}
+
 	block, err := server.provider.GetBlock(renterID, blockID)
 	if err != nil {
 		server.logger.Println(err)
 		server.writeResp(w, http.StatusBadRequest, errorResp{err.Error()})
+		return
 	}
 	defer block.Close()
 
-	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, block)
+	// ServeContent handles range requests, answering with the
+	// requested part of the block and the matching headers.
+	bw := server.provider.limitDownload(renterID, w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(bw, r, "", time.Time{}, block)
+
+	// Only count the bytes of the block actually sent.
+	if bw.status != http.StatusOK && bw.status != http.StatusPartialContent {
+		return
+	}
+	err = server.provider.addActivity(activityOpDownload, bw.n)
 	if err != nil {
-		server.logger.Println("Unable to read block. Error: ", err)
+		// non-fatal
+		// server.logger.Println("Failed to update activity on download:", err)
 	}
+	server.provider.addEgress(renterID, bw.n)
+}
+
+// Blocks may be read by the renter who owns them, or by anyone holding
+// an unexpired read capability for the block signed by its owner.
+func (server *providerServer) authorizeBlockRead(r *http.Request, renterID string, blockID string) error {
+	claims, err := util.GetTokenClaimsFromRequest(r)
+	if err == nil {
+		if claimID, present := claims["renterID"]; present && claimID.(string) == renterID {
+			return nil
+		}
+	}
+
+	encoded := r.Header.Get(ReadCapabilityHeader)
+	if encoded == "" {
+		return errors.New("Must authenticate as block owner or present read capability")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("Malformed read capability")
+	}
+	var capability core.ReadCapability
+	err = json.Unmarshal(data, &capability)
+	if err != nil {
+		return errors.New("Malformed read capability")
+	}
+	if capability.RenterId != renterID || !capability.Covers(blockID) {
+		return errors.New("Read capability does not cover block")
+	}
+	if time.Now().After(capability.Expires) {
+		return errors.New("Read capability has expired")
+	}
+	ownerKey, err := server.provider.getRenterPublicKey(renterID)
+	if err != nil {
+		server.logger.Println(err)
+		return errors.New("Unable to verify read capability")
+	}
+	err = core.VerifyReadCapability(&capability, *ownerKey)
+	if err != nil {
+		return errors.New("Invalid read capability signature")
+	}
+	return nil
 }
 
 func (server *providerServer) deleteBlock(w http.ResponseWriter, r *http.Request) {
@@ -225,17 +336,56 @@ func (server *providerServer) getInfo(w http.ResponseWriter, r *http.Request) {
 	// TODO: Call the appropriate method to retrieve this.
 	server.provider.mu.RLock()
 	info := core.ProviderInfo{
-		ID:          server.provider.Config.ProviderID,
-		PublicKey:   string(pubKeyBytes),
-		Addr:        server.provider.Config.PublicApiAddr,
-		SpaceAvail:  server.provider.Config.SpaceAvail - server.provider.StorageReserved,
-		StorageRate: server.provider.Config.StorageRate,
+		ID:           server.provider.Config.ProviderID,
+		PublicKey:    string(pubKeyBytes),
+		Addr:         server.provider.Config.PublicApiAddr,
+		SpaceAvail:   server.provider.Config.SpaceAvail - server.provider.StorageReserved,
+		StorageRate:  server.provider.Config.StorageRate,
+		DownloadRate: server.provider.Config.DownloadRate,
+		Region:       server.provider.Config.Region,
 	}
 	server.provider.mu.RUnlock()
 
 	server.writeResp(w, http.StatusOK, &info)
 }
 
+type postChallengeParams struct {
+	Nonce string `json:"nonce"`
+}
+
+type postChallengeResp struct {
+	Signature string `json:"signature"`
+}
+
+// Signs a nonce from the metaserver, proving the provider
+// holds its private key.
+func (server *providerServer) postChallenge(w http.ResponseWriter, r *http.Request) {
+	var params postChallengeParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"Bad request json"})
+		return
+	}
+	if params.Nonce == "" {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"No nonce given"})
+		return
+	}
+	challenge := &core.IdentityChallenge{
+		ProviderId: server.provider.Config.ProviderID,
+		Nonce:      params.Nonce,
+	}
+	signature, err := core.SignIdentityChallenge(challenge, server.provider.privKey)
+	if err != nil {
+		server.logger.Println("Unable to sign identity challenge. Error: ", err)
+		server.writeResp(w, http.StatusInternalServerError,
+			&errorResp{"Unable to sign challenge"})
+		return
+	}
+	server.writeResp(w, http.StatusOK, &postChallengeResp{signature})
+}
+
 type postAuditParams struct {
 	Nonce string `json:"nonce"`
 }
@@ -276,6 +426,52 @@ func (server *providerServer) postAudit(w http.ResponseWriter, r *http.Request)
 	server.writeResp(w, http.StatusOK, &postAuditResp{hash})
 }
 
+type postProofResp struct {
+	Proofs []*core.MerkleProof `json:"proofs"`
+}
+
+func (server *providerServer) postProof(w http.ResponseWriter, r *http.Request) {
+	renterQuery, exists := r.URL.Query()["renterID"]
+	if !exists {
+		server.writeResp(w, http.StatusBadRequest,
+			errorResp{"No renter ID given"})
+		return
+	}
+	renterID := renterQuery[0]
+	blockQuery, exists := r.URL.Query()["blockID"]
+	if !exists {
+		server.writeResp(w, http.StatusBadRequest,
+			errorResp{"No block ID given"})
+		return
+	}
+	blockID := blockQuery[0]
+	var params core.ProofRequest
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"Bad request json"})
+		return
+	}
+	if params.RenterId != renterID || params.BlockId != blockID {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{"Proof request is for a different block"})
+		return
+	}
+	err = server.provider.verifyProofRequest(&params)
+	if err != nil {
+		server.writeResp(w, http.StatusUnauthorized, &errorResp{err.Error()})
+		return
+	}
+	proofs, err := server.provider.ProveBlock(renterID, blockID, params.Segments)
+	if err != nil {
+		server.logger.Println(err)
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{err.Error()})
+		return
+	}
+	server.writeResp(w, http.StatusOK, &postProofResp{proofs})
+}
+
 type getRenterResp struct {
 	StorageReserved int64            `json:"storageReserved"`
 	StorageUsed     int64            `json:"storageUsed"`
@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"skybin/core"
+	"skybin/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetBlockWithReadCapability(t *testing.T) {
+	p, homedir := newTestProvider(t, 10000)
+	defer os.RemoveAll(homedir)
+	addTestContract(p, "r1", "c1", 10000)
+	var err error
+	p.privKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerPubKey, err := util.MarshalPublicKey(&ownerKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.renterKeys = map[string]string{"r1": string(ownerPubKey)}
+
+	data := make([]byte, 1000)
+	rand.Read(data)
+	err = p.StoreBlock("r1", "c1", "b1", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.StoreBlock("r1", "c1", "b2", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(p, log.New(ioutil.Discard, "", log.LstdFlags)))
+	defer ts.Close()
+	client := NewClient(strings.TrimPrefix(ts.URL, "http://"), &http.Client{})
+
+	capability := &core.ReadCapability{
+		RenterId: "r1",
+		BlockIds: []string{"b1"},
+		Expires:  time.Now().Add(time.Hour),
+	}
+	capability.Signature, err = core.SignReadCapability(capability, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := client.GetSharedBlock(capability, "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(full, data) {
+		t.Fatal("wrong contents for block")
+	}
+
+	r, err = client.GetSharedBlockRange(capability, "b1", 100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(part, data[100:150]) {
+		t.Fatal("wrong contents for block range")
+	}
+	r, err = client.GetSharedBlockRange(capability, "b1", 900, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err = ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(part, data[900:]) {
+		t.Fatal("wrong contents for open ended block range")
+	}
+	_, err = client.GetSharedBlockRange(capability, "b1", 2000, 10)
+	if err == nil {
+		t.Fatal("expected error reading range past end of block")
+	}
+
+	// The capability doesn't cover b2.
+	_, err = client.GetSharedBlock(capability, "b2")
+	if err == nil {
+		t.Fatal("expected error reading block not covered by capability")
+	}
+
+	// Capabilities can't be modified or used after they expire.
+	forged := *capability
+	forged.BlockIds = []string{"b1", "b2"}
+	_, err = client.GetSharedBlock(&forged, "b2")
+	if err == nil {
+		t.Fatal("expected error reading block with forged capability")
+	}
+	expired := *capability
+	expired.Expires = time.Now().Add(-time.Hour)
+	expired.Signature, err = core.SignReadCapability(&expired, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetSharedBlock(&expired, "b1")
+	if err == nil {
+		t.Fatal("expected error reading block with expired capability")
+	}
+
+	// Reads without a token or capability are rejected.
+	resp, err := http.Get(ts.URL + "/blocks?renterID=r1&blockID=b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected unauthorized read to be forbidden. Got status %d", resp.StatusCode)
+	}
+
+	// Only the bytes sent should count as downloaded. Closing the
+	// server waits for handlers to record their activity.
+	ts.Close()
+	stats, err := p.db.GetActivityStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloaded int64
+	for _, n := range stats.ActivityCounter.BytesDownloaded {
+		downloaded += n
+	}
+	if downloaded != 1000+50+100 {
+		t.Fatalf("expected 1150 bytes downloaded. Got %d", downloaded)
+	}
+
+	// Shared reads are metered against the block's owner.
+	egress, err := p.db.GetEgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if egress["r1"] != downloaded {
+		t.Fatalf("expected %d bytes of egress for r1. Got %d", downloaded, egress["r1"])
+	}
+}
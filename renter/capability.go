@@ -0,0 +1,150 @@
+package renter
+
+import (
+	"errors"
+	"fmt"
+	"skybin/core"
+	"time"
+)
+
+const (
+	// How long read capabilities issued to the users a file is shared with last.
+	kReadCapabilityLifetime = 7 * 24 * time.Hour
+
+	// Read capabilities are reissued once they're this close to expiring.
+	kReadCapabilityRenewWindow = 2 * 24 * time.Hour
+
+	// How often the capability thread checks for read capabilities to reissue.
+	capabilityCheckInterval = time.Hour
+)
+
+// Periodically reissues the read capabilities of users the renter's
+// files are shared with before they expire.
+func (r *Renter) capabilityThread() {
+	r.logger.Println("starting capability thread")
+	ticker := time.NewTicker(capabilityCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.refreshAllReadCapabilities()
+		case <-r.doneCh:
+			r.logger.Println("capability thread shutting down")
+			return
+		}
+	}
+}
+
+func (r *Renter) refreshAllReadCapabilities() {
+	r.mu.RLock()
+	files := make([]*core.File, len(r.files))
+	copy(files, r.files)
+	r.mu.RUnlock()
+
+	refreshed := false
+	for _, cached := range files {
+		if cached.IsDir || cached.OwnerID != r.Config.RenterId || len(cached.AccessList) == 0 {
+			continue
+		}
+		// Capabilities cover the file's blocks, which aren't cached.
+		file, err := r.GetFile(cached.ID)
+		if err != nil {
+			r.logger.Printf("capability thread: unable to fetch file %s. error: %s\n", cached.Name, err)
+			continue
+		}
+		n, err := r.refreshReadCapabilities(file)
+		if err != nil {
+			r.logger.Printf("capability thread: unable to refresh read capabilities for file %s. error: %s\n",
+				file.Name, err)
+		}
+		if n > 0 {
+			r.cacheFile(file)
+			refreshed = true
+		}
+	}
+	if refreshed {
+		err := r.saveSnapshot()
+		if err != nil {
+			r.logger.Println("capability thread: error saving snapshot:", err)
+		}
+	}
+}
+
+// Creates a read capability covering the blocks of every version of a file.
+func (r *Renter) issueReadCapability(f *core.File) (*core.ReadCapability, error) {
+	capability := &core.ReadCapability{
+		RenterId: r.Config.RenterId,
+		BlockIds: []string{},
+		Expires:  time.Now().Add(kReadCapabilityLifetime).UTC(),
+	}
+	for _, version := range f.Versions {
+		for _, block := range version.Blocks {
+			capability.BlockIds = append(capability.BlockIds, block.ID)
+		}
+	}
+	signature, err := core.SignReadCapability(capability, r.privKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to sign read capability. Error: %s", err)
+	}
+	capability.Signature = signature
+	return capability, nil
+}
+
+// Returns whether a read capability needs to be reissued because it is
+// missing, close to expiring, or doesn't cover all of a file's blocks.
+func readCapabilityStale(capability *core.ReadCapability, f *core.File) bool {
+	if capability == nil || time.Until(capability.Expires) < kReadCapabilityRenewWindow {
+		return true
+	}
+	for _, version := range f.Versions {
+		for _, block := range version.Blocks {
+			if !capability.Covers(block.ID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reissues any stale read capabilities in a file's access list.
+// Returns the number of permissions updated.
+func (r *Renter) refreshReadCapabilities(f *core.File) (int, error) {
+	var capability *core.ReadCapability
+	updated := 0
+	for i := range f.AccessList {
+		permission := &f.AccessList[i]
+		if !readCapabilityStale(permission.ReadCapability, f) {
+			continue
+		}
+		if capability == nil {
+			var err error
+			capability, err = r.issueReadCapability(f)
+			if err != nil {
+				return updated, err
+			}
+			err = r.authorizeMeta()
+			if err != nil {
+				return updated, err
+			}
+		}
+		newPermission := *permission
+		newPermission.ReadCapability = capability
+		err := r.metaClient.UpdateFilePermission(r.Config.RenterId, f.ID, &newPermission)
+		if err != nil {
+			return updated, err
+		}
+		permission.ReadCapability = capability
+		updated++
+	}
+	return updated, nil
+}
+
+// Returns the read capability the owner of a shared file issued to the renter.
+func (r *Renter) sharedReadCapability(f *core.File) (*core.ReadCapability, error) {
+	for _, permission := range f.AccessList {
+		if permission.RenterId == r.Config.RenterId && permission.ReadCapability != nil {
+			return permission.ReadCapability, nil
+		}
+	}
+	return nil, errors.New("No read capability for shared file. Ask its owner to share it again")
+}
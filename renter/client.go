@@ -70,6 +70,31 @@ func (client *Client) ReserveStorage(amount int64) ([]*core.Contract, error) {
 	return respMsg.Contracts, nil
 }
 
+func (client *Client) CancelContract(contractId string) (int64, error) {
+	url := fmt.Sprintf("http://%s/contracts/cancel", client.addr)
+
+	req := cancelContractReq{
+		ContractId: contractId,
+	}
+	data, _ := json.Marshal(&req)
+	resp, err := client.client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeError(resp.Body)
+	}
+
+	var respMsg cancelContractResp
+	err = json.NewDecoder(resp.Body).Decode(&respMsg)
+	if err != nil {
+		return 0, err
+	}
+	return respMsg.Refund, nil
+}
+
 func (client *Client) Upload(srcPath, destPath string) (*core.File, error) {
 	url := fmt.Sprintf("http://%s/files/upload", client.addr)
 
@@ -97,6 +122,32 @@ func (client *Client) Upload(srcPath, destPath string) (*core.File, error) {
 	return file, nil
 }
 
+func (client *Client) PreviewUpload(srcPath string) (*UploadPreview, error) {
+	url := fmt.Sprintf("http://%s/files/upload/preview", client.addr)
+
+	req := previewUploadReq{
+		SourcePath: srcPath,
+	}
+	data, _ := json.Marshal(&req)
+	resp, err := client.client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+
+	var preview UploadPreview
+	err = json.NewDecoder(resp.Body).Decode(&preview)
+	if err != nil {
+		return nil, err
+	}
+
+	return &preview, nil
+}
+
 func (client *Client) Download(fileId string, destpath string) error {
 	url := fmt.Sprintf("http://%s/files/download", client.addr)
 	req := downloadFileReq{
@@ -222,6 +273,48 @@ func (client *Client) Remove(fileId string, recursive *bool) error {
 	return nil
 }
 
+func (client *Client) GetBudget() (*core.Budget, error) {
+	url := fmt.Sprintf("http://%s/budget", client.addr)
+
+	resp, err := client.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp.Body)
+	}
+
+	var budget core.Budget
+	err = json.NewDecoder(resp.Body).Decode(&budget)
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+func (client *Client) SetBudget(budget *core.Budget) error {
+	url := fmt.Sprintf("http://%s/budget", client.addr)
+
+	data, _ := json.Marshal(budget)
+	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body)
+	}
+	return nil
+}
+
 func decodeError(r io.Reader) error {
 	var respMsg errorResp
 	err := json.NewDecoder(r).Decode(&respMsg)
@@ -1,5 +1,7 @@
 package renter
 
+import "skybin/core"
+
 type Config struct {
 	RenterId                    string `json:"renterId"`
 	Alias                       string `json:"alias"`
@@ -12,8 +14,19 @@ type Config struct {
 	DefaultDataBlocks           int    `json:"defaultDataBlocks"`
 	DefaultParityBlocks         int    `json:"defaultParityBlocks"`
 	DefaultContractDurationDays int    `json:"defaultContractDurationDays"`
-	// Block audits to create per block
-	NumBlockAudits              int    `json:"numBlockAudits"`
+	// Spending limits for new contracts
+	Budget                      core.Budget `json:"budget"`
+	// Fraction of a file version's blocks that must be healthy.
+	// Versions below this are repaired in the background. Zero disables repair.
+	RepairThreshold             float64 `json:"repairThreshold"`
+	// Minimum audit reputation score, from 0 to 1, of providers to form
+	// contracts with. Zero accepts any provider.
+	MinProviderReputation       float64 `json:"minProviderReputation"`
+	// Minimum uptime, from 0 to 1, of providers to form contracts with.
+	// Zero accepts any provider.
+	MinProviderUptime           float64 `json:"minProviderUptime"`
+	// Region to form contracts with providers in. Empty accepts any region.
+	ProviderRegion              string `json:"providerRegion"`
 }
 
 const (
@@ -38,8 +51,8 @@ const (
 	// Default contract duration - 6 months
 	kDefaultContractDurationDays = 30 * 6
 
-	// Number of block audits to generate for each uploaded block
-	kDefaultBlockAudits = 2
+	// Repair file versions once fewer than 90% of their blocks are healthy
+	kDefaultRepairThreshold = 0.9
 )
 
 func DefaultConfig() *Config {
@@ -49,6 +62,6 @@ func DefaultConfig() *Config {
 		DefaultDataBlocks:           kDefaultDataBlocks,
 		DefaultParityBlocks:         kDefaultParityBlocks,
 		DefaultContractDurationDays: kDefaultContractDurationDays,
-		NumBlockAudits:              kDefaultBlockAudits,
+		RepairThreshold:             kDefaultRepairThreshold,
 	}
 }
@@ -0,0 +1,153 @@
+package renter
+
+import (
+	"skybin/core"
+	"time"
+)
+
+const (
+	// How often the delete thread checks for deletions to retry.
+	deleteCheckInterval = time.Minute
+
+	// Backoff bounds for retrying a failed block deletion.
+	deleteRetryMinDelay = time.Minute
+	deleteRetryMaxDelay = 24 * time.Hour
+)
+
+// Retry state for a block in blocksToDelete.
+type deleteRetry struct {
+	attempts    int
+	nextAttempt time.Time
+}
+
+// Returns how long to wait before retrying a deletion which
+// has failed the given number of times.
+func deleteRetryDelay(attempts int) time.Duration {
+	delay := deleteRetryMinDelay
+	for i := 1; i < attempts && delay < deleteRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > deleteRetryMaxDelay {
+		delay = deleteRetryMaxDelay
+	}
+	return delay
+}
+
+// Retries the deletion of blocks that couldn't be removed from their
+// providers when their file was deleted, backing off exponentially
+// while a provider remains unreachable.
+func (r *Renter) deleteThread() {
+	r.logger.Println("starting delete thread")
+	ticker := time.NewTicker(deleteCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.retryDeletions()
+		case <-r.doneCh:
+			r.logger.Println("delete thread shutting down")
+			return
+		}
+	}
+}
+
+func (r *Renter) retryDeletions() {
+	r.deleteMu.Lock()
+	pending := len(r.blocksToDelete)
+	r.deleteMu.Unlock()
+	if pending == 0 {
+		return
+	}
+
+	// Look up contract end dates so we know when to give up on a block.
+	// If the metaserver can't be reached, keep retrying everything.
+	var contractEnds map[string]time.Time
+	contracts, err := r.ListContracts()
+	if err != nil {
+		r.logger.Println("delete thread: unable to fetch contracts. error: ", err)
+	} else {
+		contractEnds = make(map[string]time.Time)
+		for _, contract := range contracts {
+			contractEnds[contract.ID] = contract.EndDate
+		}
+	}
+
+	changed := r.doRetryDeletions(time.Now(), contractEnds, r.deleteBlock)
+	if changed {
+		err := r.saveSnapshot()
+		if err != nil {
+			r.logger.Println("delete thread: unable to save snapshot. error: ", err)
+		}
+	}
+}
+
+// Attempts every deletion whose retry time has passed, using deleteFn
+// to delete each block. Blocks whose contracts have ended, according to
+// contractEnds, are abandoned; a nil contractEnds abandons nothing.
+// Returns whether blocksToDelete changed.
+func (r *Renter) doRetryDeletions(now time.Time, contractEnds map[string]time.Time,
+	deleteFn func(*core.Block) error) bool {
+
+	r.deleteMu.Lock()
+	due := []*core.Block{}
+	for _, block := range r.blocksToDelete {
+		retry, exists := r.deleteRetries[block.ID]
+		if !exists || !now.Before(retry.nextAttempt) {
+			due = append(due, block)
+		}
+	}
+	r.deleteMu.Unlock()
+
+	finished := map[*core.Block]bool{}
+	for _, block := range due {
+		err := deleteFn(block)
+		if err == nil {
+			r.logger.Printf("delete thread: removed block %s from provider %s\n",
+				block.ID, block.Location.ProviderId)
+			finished[block] = true
+			r.deleteMu.Lock()
+			r.completedDeletions++
+			r.deleteMu.Unlock()
+			continue
+		}
+		if contractEnds != nil {
+			endDate, exists := contractEnds[block.Location.ContractId]
+			if !exists || now.After(endDate) {
+
+				// The provider is no longer storing the block for us.
+				r.logger.Printf("delete thread: contract %s for block %s has ended. Giving up.\n",
+					block.Location.ContractId, block.ID)
+				finished[block] = true
+				r.deleteMu.Lock()
+				r.abandonedDeletions++
+				r.deleteMu.Unlock()
+				continue
+			}
+		}
+		r.deleteMu.Lock()
+		retry, exists := r.deleteRetries[block.ID]
+		if !exists {
+			retry = &deleteRetry{}
+			r.deleteRetries[block.ID] = retry
+		}
+		retry.attempts++
+		retry.nextAttempt = now.Add(deleteRetryDelay(retry.attempts))
+		r.deleteMu.Unlock()
+	}
+	if len(finished) == 0 {
+		return false
+	}
+
+	r.deleteMu.Lock()
+	defer r.deleteMu.Unlock()
+	remaining := []*core.Block{}
+	for _, block := range r.blocksToDelete {
+		if finished[block] {
+			delete(r.deleteRetries, block.ID)
+			continue
+		}
+		remaining = append(remaining, block)
+	}
+	r.blocksToDelete = remaining
+	return true
+}
@@ -0,0 +1,75 @@
+package renter
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"skybin/core"
+	"testing"
+	"time"
+)
+
+func TestDeleteRetryDelay(t *testing.T) {
+	if deleteRetryDelay(1) != deleteRetryMinDelay {
+		t.Fatal("first retry should use the minimum delay")
+	}
+	if deleteRetryDelay(3) != 4*deleteRetryMinDelay {
+		t.Fatal("retry delay should double with each attempt")
+	}
+	if deleteRetryDelay(1000) != deleteRetryMaxDelay {
+		t.Fatal("retry delay should not exceed the maximum delay")
+	}
+}
+
+func TestRetryDeletions(t *testing.T) {
+	now := time.Now()
+	r := &Renter{
+		blocksToDelete: []*core.Block{
+			{ID: "b1", Location: core.BlockLocation{ProviderId: "online", ContractId: "c1"}},
+			{ID: "b2", Location: core.BlockLocation{ProviderId: "offline", ContractId: "c1"}},
+			{ID: "b3", Location: core.BlockLocation{ProviderId: "offline", ContractId: "c2"}},
+		},
+		deleteRetries: make(map[string]*deleteRetry),
+		logger:        log.New(ioutil.Discard, "", log.LstdFlags),
+	}
+	contractEnds := map[string]time.Time{
+		"c1": now.Add(time.Hour),
+		"c2": now.Add(-time.Hour),
+	}
+	attempts := 0
+	deleteFn := func(block *core.Block) error {
+		attempts++
+		if block.Location.ProviderId == "offline" {
+			return errors.New("provider offline")
+		}
+		return nil
+	}
+
+	changed := r.doRetryDeletions(now, contractEnds, deleteFn)
+	if !changed {
+		t.Fatal("expected blocksToDelete to change")
+	}
+	if len(r.blocksToDelete) != 1 || r.blocksToDelete[0].ID != "b2" {
+		t.Fatal("expected only b2 to remain")
+	}
+	if r.completedDeletions != 1 || r.abandonedDeletions != 1 {
+		t.Fatal("wrong deletion stats")
+	}
+
+	// b2 shouldn't be retried until its backoff expires.
+	attempts = 0
+	r.doRetryDeletions(now, contractEnds, deleteFn)
+	if attempts != 0 {
+		t.Fatal("retried deletion before backoff expired")
+	}
+	r.doRetryDeletions(now.Add(deleteRetryMinDelay), contractEnds, deleteFn)
+	if attempts != 1 {
+		t.Fatal("expected deletion to be retried after backoff")
+	}
+
+	// Without contract info, nothing should be abandoned.
+	r.doRetryDeletions(now.Add(2*time.Hour), nil, deleteFn)
+	if len(r.blocksToDelete) != 1 {
+		t.Fatal("abandoned deletion without contract info")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"os/user"
 	"path"
 	"skybin/core"
+	"skybin/metrics"
 	"skybin/provider"
 	"strings"
 	"time"
@@ -189,9 +190,12 @@ func (r *Renter) downloadFile(file *core.File, version *core.Version, destPath s
 		return nil, err
 	}
 	download := newFileDownload(file, version, destPath, aesKey, aesIV)
+	downloadQueueDepth.Inc()
 	r.downloadQ <- []*fileDownload{download}
 	<-download.doneCh
+	downloadQueueDepth.Dec()
 	if download.err != nil {
+		metrics.Errors.WithLabelValues("renter_download").Inc()
 		return nil, download.err
 	}
 	return &DownloadStats{
@@ -220,22 +224,30 @@ func (r *Renter) performDirDownload(dir *core.File, destPath string) ([]*FileDow
 			}
 			allFileStats = append(allFileStats, dirStats)
 		} else {
-			version := &child.Versions[len(child.Versions)-1]
-			aesKey, aesIV, err := r.decryptEncryptionKeys(child)
+			// The cached child doesn't have its blocks.
+			file, err := r.GetFile(child.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to fetch metadata of %s. Error: %s", child.Name, err)
+			}
+			version := &file.Versions[len(file.Versions)-1]
+			aesKey, aesIV, err := r.decryptEncryptionKeys(file)
 			if err != nil {
 				return nil, fmt.Errorf("Unable to decrypt encryption keys for file %s\n", child.Name)
 			}
-			fd := newFileDownload(child, version, fullPath, aesKey, aesIV)
+			fd := newFileDownload(file, version, fullPath, aesKey, aesIV)
 			fileDownloads = append(fileDownloads, fd)
 			allFileStats = append(allFileStats, fd.stats)
 		}
 	}
+	downloadQueueDepth.Add(float64(len(fileDownloads)))
 	r.downloadQ <- fileDownloads
 	for _, download := range fileDownloads {
 		<-download.doneCh
+		downloadQueueDepth.Dec()
 	}
 	for _, download := range fileDownloads {
 		if download.err != nil {
+			metrics.Errors.WithLabelValues("renter_download").Inc()
 			return nil, err
 		}
 	}
@@ -253,7 +265,7 @@ func (r *Renter) downloadThread() {
 	activeBlocks := 0
 	finishedDownloads := make(chan *fileDownload)
 
-	go blockScheduleThread(blockQ)
+	go r.blockScheduleThread(blockQ)
 
 	batch, ok := <-r.downloadQ
 	if !ok {
@@ -303,7 +315,7 @@ L:
 			// connections with all providers.
 			close(blockQ)
 			blockQ = make(chan *blockDownload, 32)
-			go blockScheduleThread(blockQ)
+			go r.blockScheduleThread(blockQ)
 
 			// Then wait for the next batch.
 			batch, ok = <-r.downloadQ
@@ -323,7 +335,7 @@ L:
 // by their location (i.e. the provider at which they're stored).
 // This allows network connections with the provider to be shared
 // across block downloads.
-func blockScheduleThread(blockQ chan *blockDownload) {
+func (r *Renter) blockScheduleThread(blockQ chan *blockDownload) {
 	blockQueues := map[string]chan *blockDownload{}
 	for download := range blockQ {
 		providerID := download.block.Location.ProviderId
@@ -331,7 +343,7 @@ func blockScheduleThread(blockQ chan *blockDownload) {
 		if !exists {
 			q = make(chan *blockDownload, 16)
 			blockQueues[providerID] = q
-			go downloadWorker(download.block.Location.Addr, q)
+			go r.downloadWorker(download.block.Location.Addr, q)
 		}
 		q <- download
 	}
@@ -340,16 +352,32 @@ func blockScheduleThread(blockQ chan *blockDownload) {
 	}
 }
 
-func downloadWorker(providerAddr string, inputQueue chan *blockDownload) {
+func (r *Renter) downloadWorker(providerAddr string, inputQueue chan *blockDownload) {
 	client := provider.NewClient(providerAddr, &http.Client{})
+	authorized := false
 	for download := range inputQueue {
 		if download.block.Location.Addr != providerAddr {
 			panic("downloadWorker: Received block to download from incorrect provider")
 		}
 
 		startTime := time.Now()
-		ownerID := download.fileDownload.file.OwnerID
-		err := downloadBlock(client, ownerID, download.block, download.destFile)
+
+		// Our own blocks are read by authenticating with the provider.
+		// Blocks of files shared with us need the owner's read capability.
+		file := download.fileDownload.file
+		var capability *core.ReadCapability
+		var err error
+		if file.OwnerID == r.Config.RenterId {
+			if !authorized {
+				err = client.AuthorizeRenter(r.privKey, r.Config.RenterId)
+				authorized = err == nil
+			}
+		} else {
+			capability, err = r.sharedReadCapability(file)
+		}
+		if err == nil {
+			err = downloadBlock(client, file.OwnerID, capability, download.block, download.destFile)
+		}
 		if err != nil {
 			download.err = err
 			download.stats.Error = err.Error()
@@ -367,9 +395,17 @@ func downloadWorker(providerAddr string, inputQueue chan *blockDownload) {
 	}
 }
 
-// Downloads a block and checks that its hash is correct.
-func downloadBlock(client *provider.Client, ownerID string, block *core.Block, destFile *os.File) error {
-	blockReader, err := client.GetBlock(ownerID, block.ID)
+// Downloads a block and checks that its hash is correct. If capability
+// is nil, the client must be authorized as the block's owner.
+func downloadBlock(client *provider.Client, ownerID string, capability *core.ReadCapability,
+	block *core.Block, destFile *os.File) error {
+	var blockReader io.ReadCloser
+	var err error
+	if capability != nil {
+		blockReader, err = client.GetSharedBlock(capability, block.ID)
+	} else {
+		blockReader, err = client.GetBlock(ownerID, block.ID)
+	}
 	if err != nil {
 		return err
 	}
@@ -377,6 +413,7 @@ func downloadBlock(client *provider.Client, ownerID string, block *core.Block, d
 	h := sha256.New()
 	mw := io.MultiWriter(destFile, h)
 	n, err := io.Copy(mw, blockReader)
+	blockBytes.WithLabelValues("in").Add(float64(n))
 	if err != nil {
 		return fmt.Errorf("Cannot write block to local file. Error: %s", err)
 	}
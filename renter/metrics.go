@@ -0,0 +1,34 @@
+package renter
+
+import (
+	"skybin/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	uploadQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "renter",
+		Name:      "upload_queue_depth",
+		Help:      "Files queued for upload or being uploaded.",
+	})
+
+	downloadQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "renter",
+		Name:      "download_queue_depth",
+		Help:      "Files queued for download or being downloaded.",
+	})
+
+	blockBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "renter",
+		Name:      "block_bytes_total",
+		Help:      "Bytes of blocks downloaded from (in) and uploaded to (out) providers.",
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(uploadQueueDepth, downloadQueueDepth, blockBytes)
+}
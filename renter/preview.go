@@ -0,0 +1,110 @@
+package renter
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// UploadPreview describes the contract space an upload would use
+// without actually performing it.
+type UploadPreview struct {
+	// Number of files and folders that would be uploaded
+	NumFiles   int `json:"numFiles"`
+	NumFolders int `json:"numFolders"`
+	// Total size of the source files in bytes
+	SourceSize int64 `json:"sourceSize"`
+	// Total contract space the upload would use, including
+	// compression, padding, and parity blocks
+	UploadSize int64 `json:"uploadSize"`
+	// Free contract space currently available to the renter
+	AvailableStorage int64 `json:"availableStorage"`
+	// Additional space needed before the upload can succeed
+	Shortfall int64 `json:"shortfall"`
+	// An estimate for reserving enough storage to cover the shortfall.
+	// Only set if there is a shortfall.
+	Estimate *StorageEstimate `json:"estimate,omitempty"`
+	// Why an estimate couldn't be created, if there is a shortfall
+	// but no estimate.
+	EstimateError string `json:"estimateError,omitempty"`
+}
+
+// Computes how much storage uploading sourcePath would use with the
+// renter's current erasure coding settings. If there isn't enough
+// storage available, the preview includes a storage estimate to make
+// up the difference.
+func (r *Renter) PreviewUpload(sourcePath string) (*UploadPreview, error) {
+	preview := &UploadPreview{}
+	err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			preview.NumFolders++
+			return nil
+		}
+		size, err := estimateUploadSize(path, r.Config)
+		if err != nil {
+			return err
+		}
+		preview.NumFiles++
+		preview.SourceSize += info.Size()
+		preview.UploadSize += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	preview.AvailableStorage = r.storageManager.AvailableStorage()
+	if preview.UploadSize <= preview.AvailableStorage {
+		return preview, nil
+	}
+	preview.Shortfall = preview.UploadSize - preview.AvailableStorage
+	amount := preview.Shortfall
+	if amount < kMinContractSize {
+		amount = kMinContractSize
+	}
+	estimate, err := r.CreateStorageEstimate(amount)
+	if err != nil {
+		preview.EstimateError = err.Error()
+	} else {
+		preview.Estimate = estimate
+	}
+	return preview, nil
+}
+
+// Returns the number of bytes of contract space needed to upload the
+// file at path. Encryption doesn't change the size of the compressed
+// file, so compressing the file is enough to find its exact layout.
+func estimateUploadSize(path string, conf *Config) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to open source file. Error: %s", err)
+	}
+	defer f.Close()
+	counter := &countingWriter{}
+	cw := zlib.NewWriter(counter)
+	_, err = io.Copy(cw, f)
+	if err != nil {
+		return 0, fmt.Errorf("Compression error. Error: %s", err)
+	}
+	err = cw.Close()
+	if err != nil {
+		return 0, fmt.Errorf("Compression error. Error: %s", err)
+	}
+	blockSize, nDataBlocks, nParityBlocks, _ := erasureLayout(counter.n, conf)
+	return blockSize * int64(nDataBlocks+nParityBlocks), nil
+}
+
+// countingWriter discards its input, counting the bytes written.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
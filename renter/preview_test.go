@@ -0,0 +1,44 @@
+package renter
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestEstimateUploadSize(t *testing.T) {
+	conf := DefaultConfig()
+	for _, size := range []int{0, 1, 1000, 1 << 20} {
+		f, err := ioutil.TempFile("", "skybin_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		data := make([]byte, size)
+		rand.Read(data)
+		f.Write(data)
+		f.Close()
+
+		estimate, err := estimateUploadSize(f.Name(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finfo, err := os.Stat(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		up := &fileUpload{
+			sourcePath: f.Name(),
+			finfo:      finfo,
+		}
+		err = prepareUpload(up, conf)
+		up.cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate != up.version.UploadSize {
+			t.Fatalf("size %d: estimated %d bytes, upload used %d", size, estimate, up.version.UploadSize)
+		}
+	}
+}
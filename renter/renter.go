@@ -21,6 +21,14 @@ import (
 	"time"
 )
 
+// Files fetched per request when refreshing the file cache.
+const metaFilePageSize = 500
+
+// Fields of the renter's files kept in the local cache. Blocks are left
+// out, since they make up most of a file's metadata and are only needed
+// to transfer, share, repair, or remove a file. GetFile fetches them.
+var cachedFileFields = []string{"ownerId", "ownerAlias", "name", "isDir", "accessList", "aesKey", "aesIV", "versions"}
+
 // Info is information about a renter
 type Info struct {
 	ID              string `json:"id"`
@@ -33,6 +41,13 @@ type Info struct {
 	TotalContracts  int    `json:"totalContracts"`
 	TotalFiles      int    `json:"totalFiles"`
 	Balance         int64  `json:"balance"`
+
+	// Progress of the background deleter in removing blocks
+	// which couldn't be removed immediately.
+	PendingDeletions     int   `json:"pendingDeletions"`
+	PendingDeletionBytes int64 `json:"pendingDeletionBytes"`
+	CompletedDeletions   int   `json:"completedDeletions"`
+	AbandonedDeletions   int   `json:"abandonedDeletions"`
 }
 
 type Renter struct {
@@ -58,10 +73,24 @@ type Renter struct {
 	// deleted because the provider storing them was offline.
 	blocksToDelete []*core.Block
 
+	// Retry state and stats for blocksToDelete. Protected by deleteMu.
+	deleteRetries      map[string]*deleteRetry
+	completedDeletions int
+	abandonedDeletions int
+	deleteMu           sync.Mutex
+
+	// Cancellations the provider signed but the metaserver hasn't
+	// processed yet. Protected by terminationMu.
+	pendingTerminations []*core.ContractTermination
+	terminationMu       sync.Mutex
+
 	// Queue for batches of downloads to be performed by the download thread.
 	downloadQ      chan []*fileDownload
 	uploadQ        chan *fileUpload
 	restoreQ       chan *recoveredBlockBatch
+
+	// Closed to stop the renter's periodic background threads.
+	doneCh         chan struct{}
 	logger         *log.Logger
 	mu             sync.RWMutex
 }
@@ -72,6 +101,8 @@ type snapshot struct {
 	Contracts      []*core.Contract `json:"contracts"`
 	FreeStorage    []*storageBlob   `json:"freeStorage"`
 	BlocksToDelete []*core.Block    `json:"blocksToDelete"`
+
+	PendingTerminations []*core.ContractTermination `json:"pendingTerminations"`
 }
 
 // storageBlob is a chunk of free storage we've already rented
@@ -94,9 +125,11 @@ func LoadFromDisk(homedir string) (*Renter, error) {
 		Homedir:        homedir,
 		files:          make([]*core.File, 0),
 		blocksToDelete: make([]*core.Block, 0),
+		deleteRetries:  make(map[string]*deleteRetry),
 		downloadQ:      make(chan []*fileDownload),
 		uploadQ:        make(chan *fileUpload),
 		restoreQ:      make(chan *recoveredBlockBatch),
+		doneCh:         make(chan struct{}),
 		logger:         log.New(ioutil.Discard, "", log.LstdFlags),
 	}
 
@@ -122,8 +155,12 @@ func LoadFromDisk(homedir string) (*Renter, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Unable to load snapshot. Error: %s", err)
 		}
-		renter.files = s.Files
+		// Snapshots saved by older versions include blocks.
+		for _, f := range s.Files {
+			renter.files = append(renter.files, withoutBlocks(f))
+		}
 		renter.blocksToDelete = s.BlocksToDelete
+		renter.pendingTerminations = s.PendingTerminations
 
 		renter.storageManager.AddBlobs(s.FreeStorage)
 	}
@@ -141,12 +178,17 @@ func (r *Renter) StartBackgroundThreads() {
 	go r.downloadThread()
 	go r.uploadThread()
 	go r.blockRestoreThread()
+	go r.repairThread()
+	go r.deleteThread()
+	go r.capabilityThread()
+	go r.terminationThread()
 }
 
 func (r *Renter) ShutdownThreads() {
 	close(r.downloadQ)
 	close(r.uploadQ)
 	close(r.restoreQ)
+	close(r.doneCh)
 }
 
 func (r *Renter) SetLogger(logger *log.Logger) {
@@ -154,13 +196,23 @@ func (r *Renter) SetLogger(logger *log.Logger) {
 }
 
 func (r *Renter) saveSnapshot() error {
+	r.deleteMu.Lock()
+	blocksToDelete := make([]*core.Block, len(r.blocksToDelete))
+	copy(blocksToDelete, r.blocksToDelete)
+	r.deleteMu.Unlock()
+	r.terminationMu.Lock()
+	pendingTerminations := make([]*core.ContractTermination, len(r.pendingTerminations))
+	copy(pendingTerminations, r.pendingTerminations)
+	r.terminationMu.Unlock()
 	s := snapshot{
 		Files: r.files,
 		//Contracts: r.contracts,
 
 		// TODO: remove this from snapshot
 		FreeStorage:    r.storageManager.freelist,
-		BlocksToDelete: r.blocksToDelete,
+		BlocksToDelete: blocksToDelete,
+
+		PendingTerminations: pendingTerminations,
 	}
 	return util.SaveJson(path.Join(r.Homedir, "snapshot.json"), &s)
 }
@@ -186,6 +238,15 @@ func (r *Renter) Info() (*Info, error) {
 	r.mu.RLock()
 	numFiles := len(r.files)
 	r.mu.RUnlock()
+	r.deleteMu.Lock()
+	var pendingBytes int64
+	for _, block := range r.blocksToDelete {
+		pendingBytes += block.Size
+	}
+	pendingDeletions := len(r.blocksToDelete)
+	completedDeletions := r.completedDeletions
+	abandonedDeletions := r.abandonedDeletions
+	r.deleteMu.Unlock()
 	return &Info{
 		ID:              r.Config.RenterId,
 		Alias:           r.Config.Alias,
@@ -197,6 +258,11 @@ func (r *Renter) Info() (*Info, error) {
 		TotalContracts:  len(contracts),
 		TotalFiles:      numFiles,
 		Balance:         renterInfo.Balance,
+
+		PendingDeletions:     pendingDeletions,
+		PendingDeletionBytes: pendingBytes,
+		CompletedDeletions:   completedDeletions,
+		AbandonedDeletions:   abandonedDeletions,
 	}, nil
 }
 
@@ -241,7 +307,10 @@ func (r *Renter) pullFiles() error {
 		return err
 	}
 
-	files, err := r.metaClient.GetFiles(r.Config.RenterId)
+	files, err := r.metaClient.GetFiles(r.Config.RenterId, &metaserver.FileQuery{
+		Fields: cachedFileFields,
+		Limit:  metaFilePageSize,
+	})
 	if err != nil {
 		return err
 	}
@@ -252,20 +321,20 @@ func (r *Renter) pullFiles() error {
 	return r.saveSnapshot()
 }
 
-func (r *Renter) GetFile(fileId string) (*core.File, error) {
-
-	// Check if it exists locally
-	r.mu.RLock()
-	for _, file := range r.files {
-		if file.ID == fileId {
-			r.mu.RUnlock()
-			return file, nil
-		}
+// Lists a page of the renter's files straight from the metaserver, leaving
+// out fields the caller doesn't need instead of using the local cache.
+func (r *Renter) ListFilesPage(query *metaserver.FileQuery) ([]*core.File, string, error) {
+	err := r.authorizeMeta()
+	if err != nil {
+		return nil, "", err
 	}
-	r.mu.RUnlock()
+	return r.metaClient.GetFilesPage(r.Config.RenterId, query)
+}
 
-	// It might not exist because the local cache is out of date.
-	// Check with the metaserver.
+// Returns the file with its versions' blocks. Since the local cache
+// leaves blocks out, the file is fetched from the metaserver, and the
+// cached copy is refreshed.
+func (r *Renter) GetFile(fileId string) (*core.File, error) {
 	err := r.authorizeMeta()
 	if err != nil {
 		return nil, err
@@ -274,23 +343,49 @@ func (r *Renter) GetFile(fileId string) (*core.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	r.cacheFile(file)
+	return file, nil
+}
 
-	r.mu.Lock()
-	r.files = append(r.files, file)
-	r.mu.Unlock()
+// Returns a copy of the file without its versions' blocks.
+func withoutBlocks(f *core.File) *core.File {
+	cached := *f
+	if f.Versions != nil {
+		cached.Versions = make([]core.Version, len(f.Versions))
+		for i, v := range f.Versions {
+			v.Blocks = nil
+			cached.Versions[i] = v
+		}
+	}
+	return &cached
+}
 
-	return file, nil
+// Puts the file in the local cache without its blocks,
+// replacing any cached copy.
+func (r *Renter) cacheFile(f *core.File) {
+	cached := withoutBlocks(f)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, file := range r.files {
+		if file.ID == f.ID {
+			r.files[i] = cached
+			return
+		}
+	}
+	r.files = append(r.files, cached)
 }
 
-func (r *Renter) ListSharedFiles() ([]*core.File, error) {
+// Lists a page of the files shared with the renter. Returns the cursor
+// for the next page, or an empty string on the last page.
+func (r *Renter) ListSharedFiles(query *metaserver.FileQuery) ([]*core.File, string, error) {
 	err := r.authorizeMeta()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	files, err := r.metaClient.GetSharedFiles(r.Config.RenterId)
+	files, next, err := r.metaClient.GetSharedFilesPage(r.Config.RenterId, query)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Do this to match ListFile's signature, for now.
@@ -300,7 +395,7 @@ func (r *Renter) ListSharedFiles() ([]*core.File, error) {
 		returnList[i] = &newFile
 	}
 
-	return returnList, nil
+	return returnList, next, nil
 }
 
 func (r *Renter) ShareFile(fileId string, renterAlias string) error {
@@ -334,11 +429,17 @@ func (r *Renter) ShareFile(fileId string, renterAlias string) error {
 	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rng, pubKey, decryptedKey, nil)
 	encryptedIV, err := rsa.EncryptOAEP(sha256.New(), rng, pubKey, decryptedIV, nil)
 
+	capability, err := r.issueReadCapability(file)
+	if err != nil {
+		return err
+	}
+
 	permission := core.Permission{
-		RenterId:    renterInfo.ID,
-		RenterAlias: renterAlias,
-		AesKey:      base64.URLEncoding.EncodeToString(encryptedKey),
-		AesIV:       base64.URLEncoding.EncodeToString(encryptedIV),
+		RenterId:       renterInfo.ID,
+		RenterAlias:    renterAlias,
+		AesKey:         base64.URLEncoding.EncodeToString(encryptedKey),
+		AesIV:          base64.URLEncoding.EncodeToString(encryptedIV),
+		ReadCapability: capability,
 	}
 
 	err = r.authorizeMeta()
@@ -352,6 +453,7 @@ func (r *Renter) ShareFile(fileId string, renterAlias string) error {
 	}
 
 	file.AccessList = append(file.AccessList, permission)
+	r.cacheFile(file)
 	err = r.saveSnapshot()
 	if err != nil {
 		return fmt.Errorf("Unable to save snapshot. Error %s", err)
@@ -416,30 +518,24 @@ func (r *Renter) RenameFile(fileId string, name string) (*core.File, error) {
 		return nil, err
 	}
 
-	// If it's a folder, rename it's children.
+	// The metaserver renames a folder's children along with it.
+	// The cached children are renamed to match.
+	var children []*core.File
 	if file.IsDir {
-		children := r.findChildren(file)
-		for _, child := range children {
-			suffix := strings.TrimPrefix(child.Name, file.Name)
-			child.Name = name + suffix
-		}
-
-		// TODO(kincaid): We need to make these renames atomic.
-		// Perhaps move the rename logic to the metaserver to ensure consistency.
-		for _, child := range children {
-			err := r.metaClient.UpdateFile(r.Config.RenterId, child)
-			if err != nil {
-				r.logger.Println("RenameFile: Error updating child's name with metaserver:", err)
-				return nil, err
-			}
-		}
+		children = r.findChildren(file)
 	}
-
+	oldName := file.Name
 	file.Name = name
 	err = r.metaClient.UpdateFile(r.Config.RenterId, file)
 	if err != nil {
 		return nil, err
 	}
+	r.mu.Lock()
+	for _, child := range children {
+		child.Name = name + strings.TrimPrefix(child.Name, oldName)
+	}
+	r.mu.Unlock()
+	r.cacheFile(file)
 
 	err = r.saveSnapshot()
 	if err != nil {
@@ -492,15 +588,25 @@ func (r *Renter) removeDir(dir *core.File, recursive bool) error {
 		return errors.New("Cannot remove non-empty folder without recursive option")
 
 	}
+	// Fetch the children's blocks before their metadata is deleted.
+	files := []*core.File{}
+	for _, child := range children {
+		if child.IsDir {
+			continue
+		}
+		file, err := r.GetFile(child.ID)
+		if err != nil {
+			return fmt.Errorf("Unable to fetch metadata of %s. Error: %s", child.Name, err)
+		}
+		files = append(files, file)
+	}
 	// Delete the file metadata. This will delete the children's metadata as well.
 	err := r.metaClient.DeleteFile(r.Config.RenterId, dir.ID)
 	if err != nil {
 		return fmt.Errorf("Unable to delete folder metadata. Error: %s", err)
 	}
-	for _, child := range children {
-		if !child.IsDir {
-			r.removeFileContents(child)
-		}
+	for _, file := range files {
+		r.removeFileContents(file)
 	}
 	// Update the local file cache
 	err = r.pullFiles()
@@ -529,6 +635,7 @@ func (r *Renter) removeFileVersion(file *core.File, versionNum int) error {
 	}
 	r.removeVersionContents(version)
 	file.Versions = append(file.Versions[:versionIdx], file.Versions[versionIdx+1:]...)
+	r.cacheFile(file)
 	err = r.saveSnapshot()
 	if err != nil {
 		r.logger.Println("Error saving snapshot:", err)
@@ -569,25 +676,36 @@ func (r *Renter) removeFileContents(file *core.File) {
 // Deletes the blocks for a file version from the providers
 // where they are stored, reclaiming the freed storage space.
 func (r *Renter) removeVersionContents(version *core.Version) {
-	for _, block := range version.Blocks {
+	for i := 0; i < len(version.Blocks); i++ {
+		block := version.Blocks[i]
 		r.removeBlock(&block)
 	}
 }
 
 // Removes a block from the provider where it is stored, reclaiming
-// the block's storage for the freelist.
+// the block's storage for the freelist. If the provider can't be reached,
+// the block is queued to be removed later by the delete thread.
 func (r *Renter) removeBlock(block *core.Block) {
+	err := r.deleteBlock(block)
+	if err != nil {
+		r.logger.Printf("Unable to remove block %s from provider %s\n",
+			block.ID, block.Location.ProviderId)
+		r.logger.Printf("Error: %s\n", err)
+		r.deleteMu.Lock()
+		r.blocksToDelete = append(r.blocksToDelete, block)
+		r.deleteMu.Unlock()
+	}
+}
+
+// Deletes a block from its provider and reclaims its storage.
+func (r *Renter) deleteBlock(block *core.Block) error {
 	pvdr := provider.NewClient(block.Location.Addr, &http.Client{})
 	err := pvdr.AuthorizeRenter(r.privKey, r.Config.RenterId)
 	if err == nil {
 		err = pvdr.RemoveBlock(r.Config.RenterId, block.ID)
 	}
 	if err != nil {
-		r.logger.Printf("Unable to remove block %s from provider %s\n",
-			block.ID, block.Location.ProviderId)
-		r.logger.Printf("Error: %s\n", err)
-		r.blocksToDelete = append(r.blocksToDelete, block)
-		return
+		return err
 	}
 
 	// Reclaim the storage used by the block.
@@ -598,6 +716,7 @@ func (r *Renter) removeBlock(block *core.Block) {
 		ContractId: block.Location.ContractId,
 	}
 	r.storageManager.AddBlob(blob)
+	return nil
 }
 
 func (r *Renter) CreatePaypalPayment(amount int64, returnURL, cancelURL string) (string, error) {
@@ -642,17 +761,14 @@ func (r *Renter) Withdraw(email string, amount int64) error {
 	return nil
 }
 
-func (r *Renter) ListTransactions() ([]core.Transaction, error) {
+// Lists a page of the renter's transactions. Returns the cursor
+// for the next page, or an empty string on the last page.
+func (r *Renter) ListTransactions(query *metaserver.TransactionQuery) ([]core.Transaction, string, error) {
 	err := r.authorizeMeta()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	transactions, err := r.metaClient.GetRenterTransactions(r.Config.RenterId)
-	if err != nil {
-		return nil, err
-	}
-	return transactions, nil
+	return r.metaClient.GetRenterTransactionsPage(r.Config.RenterId, query)
 }
 
 func (r *Renter) ListContracts() ([]*core.Contract, error) {
@@ -685,6 +801,7 @@ func (r *Renter) updateFileVersion(fileId string, versionNum int, newVersion *co
 				file.Versions[idx] = oldCpy
 				return err
 			}
+			r.cacheFile(file)
 			return nil
 		}
 	}
@@ -700,9 +817,7 @@ func (r *Renter) saveFile(f *core.File) error {
 	if err != nil {
 		return err
 	}
-	r.mu.Lock()
-	r.files = append(r.files, f)
-	r.mu.Unlock()
+	r.cacheFile(f)
 	return r.saveSnapshot()
 }
 
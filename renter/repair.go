@@ -0,0 +1,263 @@
+package renter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"skybin/core"
+	"skybin/metaserver"
+	"skybin/provider"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// How often the repair thread scans the renter's files.
+const repairScanInterval = 30 * time.Minute
+
+// Limits on the repair thread's requests to providers, so one that
+// stops responding can't stall repairs. Fetching a block takes longer
+// than checking whether a provider is up.
+const (
+	repairPingTimeout     = 30 * time.Second
+	repairDownloadTimeout = 10 * time.Minute
+)
+
+var (
+	repairPingClient     = &http.Client{Timeout: repairPingTimeout}
+	repairDownloadClient = &http.Client{Timeout: repairDownloadTimeout}
+)
+
+// Periodically scans the renter's files for unhealthy blocks (blocks
+// that failed their latest audit or are stored with providers that
+// have gone away) and repairs any file version whose health falls
+// below the configured threshold.
+func (r *Renter) repairThread() {
+	r.logger.Println("starting repair thread")
+	ticker := time.NewTicker(repairScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.repairFiles()
+		case <-r.doneCh:
+			r.logger.Println("repair thread shutting down")
+			return
+		}
+	}
+}
+
+func (r *Renter) repairFiles() {
+	r.mu.RLock()
+	threshold := r.Config.RepairThreshold
+	r.mu.RUnlock()
+	if threshold <= 0 {
+		return
+	}
+
+	err := r.authorizeMeta()
+	if err != nil {
+		r.logger.Println("repair thread: unable to authorize with metaserver. error: ", err)
+		return
+	}
+	registered, err := r.registeredProviders()
+	if err != nil {
+		r.logger.Println("repair thread: unable to check providers. error: ", err)
+		return
+	}
+
+	// The local cache doesn't have blocks, so files are pulled from the
+	// metaserver a page at a time. This also gets the latest audit results.
+	lostProviders := map[string]bool{}
+	query := &metaserver.FileQuery{Limit: metaFilePageSize}
+	for {
+		files, next, err := r.metaClient.GetFilesPage(r.Config.RenterId, query)
+		if err != nil {
+			r.logger.Println("repair thread: unable to fetch files. error: ", err)
+			return
+		}
+		findLostProviders(files, registered, lostProviders)
+		for _, file := range files {
+			if file.IsDir || file.OwnerID != r.Config.RenterId {
+				continue
+			}
+			r.repairFile(file, threshold, lostProviders)
+		}
+		if next == "" {
+			return
+		}
+		query.Cursor = next
+	}
+}
+
+// Repairs each version of the file whose health is below threshold.
+func (r *Renter) repairFile(file *core.File, threshold float64, lostProviders map[string]bool) {
+	for i := 0; i < len(file.Versions); i++ {
+		version := &file.Versions[i]
+		unhealthy := findUnhealthyBlocks(version, lostProviders)
+		if versionHealth(version, len(unhealthy)) >= threshold {
+			continue
+		}
+		r.logger.Printf("repair thread: repairing %d blocks of version %d of file %s\n",
+			len(unhealthy), version.Num, file.Name)
+		err := r.repairVersion(file, version, unhealthy)
+		if err != nil {
+			r.logger.Printf("repair thread: unable to repair version %d of file %s. error: %s\n",
+				version.Num, file.Name, err)
+		}
+	}
+}
+
+// Returns the set of providers registered with the metaserver.
+func (r *Renter) registeredProviders() (map[string]bool, error) {
+	// Offline and unverified providers are checked by findLostProviders,
+	// since they may be back.
+	providers, err := r.metaClient.GetProviders(&metaserver.ProviderQuery{
+		IncludeOffline:    true,
+		IncludeUnverified: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	registered := map[string]bool{}
+	for _, pinfo := range providers {
+		registered[pinfo.ID] = true
+	}
+	return registered, nil
+}
+
+// Checks each provider storing the given files' blocks which isn't in lost
+// yet, recording whether it is no longer registered or can't be reached.
+func findLostProviders(files []*core.File, registered map[string]bool, lost map[string]bool) {
+	for _, file := range files {
+		for _, version := range file.Versions {
+			for _, block := range version.Blocks {
+				pvdrID := block.Location.ProviderId
+				if _, checked := lost[pvdrID]; checked {
+					continue
+				}
+				if !registered[pvdrID] {
+					lost[pvdrID] = true
+					continue
+				}
+				client := provider.NewClient(block.Location.Addr, repairPingClient)
+				_, err := client.GetInfo()
+				lost[pvdrID] = err != nil
+			}
+		}
+	}
+}
+
+// Returns the indices of blocks in the version which failed their latest
+// audit or are stored with a lost provider.
+func findUnhealthyBlocks(version *core.Version, lostProviders map[string]bool) []int {
+	unhealthy := []int{}
+	for i, block := range version.Blocks {
+		if !block.AuditPassed || lostProviders[block.Location.ProviderId] {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	return unhealthy
+}
+
+// Returns the fraction of a version's blocks which are healthy.
+func versionHealth(version *core.Version, numUnhealthy int) float64 {
+	if len(version.Blocks) == 0 {
+		return 1
+	}
+	return float64(len(version.Blocks)-numUnhealthy) / float64(len(version.Blocks))
+}
+
+// Rebuilds a version's unhealthy blocks from its healthy ones and
+// re-uploads them to new providers.
+func (r *Renter) repairVersion(file *core.File, version *core.Version, unhealthy []int) error {
+	numBlocks := version.NumDataBlocks + version.NumParityBlocks
+	if len(version.Blocks) != numBlocks {
+		return fmt.Errorf("version has %d blocks, expected %d", len(version.Blocks), numBlocks)
+	}
+	isUnhealthy := make([]bool, numBlocks)
+	for _, idx := range unhealthy {
+		isUnhealthy[idx] = true
+	}
+
+	// Fetch enough healthy blocks to reconstruct the others.
+	shards := make([]*os.File, numBlocks)
+	defer func() {
+		for _, f := range shards {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	numFetched := 0
+	for i := 0; i < numBlocks && numFetched < version.NumDataBlocks; i++ {
+		if isUnhealthy[i] {
+			continue
+		}
+		block := &version.Blocks[i]
+		f, err := ioutil.TempFile("", "skybin_repair")
+		if err != nil {
+			return fmt.Errorf("Unable to create temp file. Error: %s", err)
+		}
+		client := provider.NewClient(block.Location.Addr, repairDownloadClient)
+		err = client.AuthorizeRenter(r.privKey, r.Config.RenterId)
+		if err == nil {
+			err = downloadBlock(client, file.OwnerID, nil, block, f)
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			isUnhealthy[i] = true
+			continue
+		}
+		shards[i] = f
+		numFetched++
+	}
+	if numFetched < version.NumDataBlocks {
+		return fmt.Errorf("only %d of %d blocks needed for repair are available",
+			numFetched, version.NumDataBlocks)
+	}
+
+	// Reconstruct the unhealthy blocks.
+	batch := &recoveredBlockBatch{
+		file:    *file,
+		version: *version,
+	}
+	valid := make([]io.Reader, numBlocks)
+	fill := make([]io.Writer, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		if shards[i] != nil {
+			_, err := shards[i].Seek(0, os.SEEK_SET)
+			if err != nil {
+				batch.cleanup()
+				return fmt.Errorf("Unable to seek block file. Error: %s", err)
+			}
+			valid[i] = shards[i]
+		} else if isUnhealthy[i] {
+			f, err := ioutil.TempFile("", "skybin_repair")
+			if err != nil {
+				batch.cleanup()
+				return fmt.Errorf("Unable to create temp file. Error: %s", err)
+			}
+			batch.blocks = append(batch.blocks, &recoveredBlock{
+				block:    version.Blocks[i],
+				contents: f,
+			})
+			fill[i] = f
+		}
+	}
+	defer batch.cleanup()
+	decoder, err := reedsolomon.NewStream(version.NumDataBlocks, version.NumParityBlocks)
+	if err != nil {
+		return fmt.Errorf("Unable to construct decoder. Error: %s", err)
+	}
+	err = decoder.Reconstruct(valid, fill)
+	if err != nil {
+		return fmt.Errorf("Failed to reconstruct blocks. Error: %s", err)
+	}
+	r.restoreBlockBatch(batch)
+	return nil
+}
@@ -0,0 +1,29 @@
+package renter
+
+import (
+	"skybin/core"
+	"testing"
+)
+
+func TestFindUnhealthyBlocks(t *testing.T) {
+	version := &core.Version{
+		NumDataBlocks:   2,
+		NumParityBlocks: 2,
+		Blocks: []core.Block{
+			{AuditPassed: true, Location: core.BlockLocation{ProviderId: "p1"}},
+			{AuditPassed: false, Location: core.BlockLocation{ProviderId: "p1"}},
+			{AuditPassed: true, Location: core.BlockLocation{ProviderId: "p2"}},
+			{AuditPassed: true, Location: core.BlockLocation{ProviderId: "p3"}},
+		},
+	}
+	unhealthy := findUnhealthyBlocks(version, map[string]bool{"p2": true})
+	if len(unhealthy) != 2 || unhealthy[0] != 1 || unhealthy[1] != 2 {
+		t.Fatal("wrong unhealthy blocks. got", unhealthy)
+	}
+	if health := versionHealth(version, len(unhealthy)); health != 0.5 {
+		t.Fatal("wrong version health. got", health, "expected", 0.5)
+	}
+	if health := versionHealth(&core.Version{}, 0); health != 1 {
+		t.Fatal("version without blocks should be healthy")
+	}
+}
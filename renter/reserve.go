@@ -6,13 +6,18 @@ import (
 	"math/rand"
 	"net/http"
 	"skybin/core"
+	"skybin/metaserver"
 	"skybin/provider"
 	"skybin/util"
 	"time"
 	"crypto/rsa"
 	"math"
+	"path"
 )
 
+// How often cancellations the metaserver didn't process are resent.
+const terminationRetryInterval = 5 * time.Minute
+
 type StorageEstimate struct {
 	// Total space the estimate reserves in bytes
 	TotalSpace int64 `json:"totalSpace"`
@@ -43,7 +48,7 @@ func (r *Renter) CreateStorageEstimate(totalSpace int64) (*StorageEstimate, erro
 	if err != nil {
 		return nil, err
 	}
-	providers, err := r.metaClient.GetProviders()
+	providers, err := r.metaClient.GetProviders(providerQuery(r.Config))
 	if err != nil {
 		return nil, fmt.Errorf("Cannot fetch providers. Error: %v", err)
 	}
@@ -52,13 +57,23 @@ func (r *Renter) CreateStorageEstimate(totalSpace int64) (*StorageEstimate, erro
 	}
 	estimate, err := createStorageEstimate(totalSpace, r.Config, providers, dialProvider)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to find enough storage space.")
+		return nil, fmt.Errorf("Cannot create storage estimate. Error: %v", err)
+	}
+	err = r.checkBudget(estimate)
+	if err != nil {
+		return nil, err
 	}
 	return estimate, nil
 }
 
 func (r *Renter) ConfirmStorageEstimate(estimate *StorageEstimate) ([]*core.Contract, error) {
-	err := confirmStorageEstimate(estimate, r.privKey, dialProvider)
+
+	// The estimate may have been created by a client, so check it again.
+	err := r.checkBudget(estimate)
+	if err != nil {
+		return nil, err
+	}
+	err = confirmStorageEstimate(estimate, r.privKey, dialProvider)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +106,146 @@ func (r *Renter) ConfirmStorageEstimate(estimate *StorageEstimate) ([]*core.Cont
 	return estimate.Contracts, nil
 }
 
+// CancelContract terminates an empty contract early, returning the
+// amount refunded to the renter.
+func (r *Renter) CancelContract(contractId string) (int64, error) {
+	err := r.authorizeMeta()
+	if err != nil {
+		return 0, err
+	}
+	contract, err := r.metaClient.GetContract(r.Config.RenterId, contractId)
+	if err != nil {
+		return 0, err
+	}
+	pinfo, err := r.metaClient.GetProvider(contract.ProviderId)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot fetch provider. Error: %v", err)
+	}
+	pvdrKey, err := util.UnmarshalPublicKey([]byte(pinfo.PublicKey))
+	if err != nil {
+		return 0, errors.New("Unable to unmarshal provider's key")
+	}
+
+	// Take the contract's storage out of the freelist so it
+	// isn't used for uploads while we cancel.
+	blob, err := r.storageManager.ReleaseContract(contract.ID, contract.StorageSpace)
+	if err != nil {
+		return 0, err
+	}
+
+	termination := &core.ContractTermination{
+		ContractID: contract.ID,
+		RenterId:   contract.RenterId,
+		ProviderId: contract.ProviderId,
+		Date:       time.Now().UTC().Round(0),
+	}
+	termination.RenterSignature, err = core.SignTermination(termination, r.privKey)
+	if err != nil {
+		r.storageManager.AddBlob(blob)
+		return 0, fmt.Errorf("Unable to sign termination. Error: %v", err)
+	}
+	client := provider.NewClient(pinfo.Addr, &http.Client{})
+	signed, err := client.CancelContract(termination)
+	if err != nil {
+		r.storageManager.AddBlob(blob)
+		return 0, fmt.Errorf("Provider did not agree to cancel contract. Error: %v", err)
+	}
+	err = core.VerifyTerminationSignature(signed, signed.ProviderSignature, *pvdrKey)
+	if err != nil {
+		r.storageManager.AddBlob(blob)
+		return 0, errors.New("Provider's signature does not match termination")
+	}
+
+	// The provider has released the storage, so don't return the blob
+	// to the freelist from here on. The provider won't sign the
+	// termination again, so keep it until the metaserver has it.
+	r.terminationMu.Lock()
+	r.pendingTerminations = append(r.pendingTerminations, signed)
+	r.terminationMu.Unlock()
+	err = r.saveSnapshot()
+	if err != nil {
+		r.logger.Println("Unable to save snapshot. Error: ", err)
+	}
+	refund, err := r.metaClient.CancelContract(r.Config.RenterId, signed)
+	if err != nil {
+		return 0, fmt.Errorf("Provider cancelled contract %s but the metaserver did not refund it. "+
+			"The refund will be retried. Error: %v", contract.ID, err)
+	}
+	r.removePendingTermination(contract.ID)
+	err = r.saveSnapshot()
+	if err != nil {
+		r.logger.Println("Unable to save snapshot. Error: ", err)
+	}
+	return refund, nil
+}
+
+// Periodically resends the metaserver cancellations it didn't process
+// when they were made, so the renter still gets its refunds.
+func (r *Renter) terminationThread() {
+	r.logger.Println("starting termination thread")
+	ticker := time.NewTicker(terminationRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.retryTerminations()
+		case <-r.doneCh:
+			r.logger.Println("termination thread shutting down")
+			return
+		}
+	}
+}
+
+func (r *Renter) retryTerminations() {
+	r.terminationMu.Lock()
+	pending := make([]*core.ContractTermination, len(r.pendingTerminations))
+	copy(pending, r.pendingTerminations)
+	r.terminationMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	// The metaserver deletes contracts once they're cancelled, so only
+	// the terminations of contracts it still has need to be resent.
+	contracts, err := r.ListContracts()
+	if err != nil {
+		r.logger.Println("termination thread: unable to fetch contracts. error: ", err)
+		return
+	}
+	active := map[string]bool{}
+	for _, contract := range contracts {
+		active[contract.ID] = true
+	}
+	for _, termination := range pending {
+		if active[termination.ContractID] {
+			refund, err := r.metaClient.CancelContract(r.Config.RenterId, termination)
+			if err != nil {
+				r.logger.Printf("termination thread: unable to cancel contract %s. error: %s\n",
+					termination.ContractID, err)
+				continue
+			}
+			r.logger.Printf("termination thread: cancelled contract %s. refunded %d\n",
+				termination.ContractID, refund)
+		}
+		r.removePendingTermination(termination.ContractID)
+	}
+	err = r.saveSnapshot()
+	if err != nil {
+		r.logger.Println("termination thread: unable to save snapshot. error: ", err)
+	}
+}
+
+func (r *Renter) removePendingTermination(contractID string) {
+	r.terminationMu.Lock()
+	defer r.terminationMu.Unlock()
+	for i, termination := range r.pendingTerminations {
+		if termination.ContractID == contractID {
+			r.pendingTerminations = append(r.pendingTerminations[:i], r.pendingTerminations[i+1:]...)
+			return
+		}
+	}
+}
+
 func (r *Renter) ReserveStorage(totalSpace int64) ([]*core.Contract, error) {
 	estimate, err := r.CreateStorageEstimate(totalSpace)
 	if err != nil {
@@ -99,6 +254,69 @@ func (r *Renter) ReserveStorage(totalSpace int64) ([]*core.Contract, error) {
 	return r.ConfirmStorageEstimate(estimate)
 }
 
+// Checks that the contracts in an estimate don't exceed the renter's budget.
+func (r *Renter) checkBudget(estimate *StorageEstimate) error {
+	r.mu.RLock()
+	budget := r.Config.Budget
+	r.mu.RUnlock()
+
+	var spent int64
+	if budget.MonthlyLimit > 0 {
+		err := r.authorizeMeta()
+		if err != nil {
+			return err
+		}
+		// Only this month's transactions count against the monthly limit.
+		now := time.Now()
+		transactions, err := r.metaClient.GetRenterTransactions(r.Config.RenterId,
+			&metaserver.TransactionQuery{Since: core.MonthStart(now)})
+		if err != nil {
+			return fmt.Errorf("Unable to fetch transactions. Error: %v", err)
+		}
+		spent = core.MonthlySpending(transactions, now)
+	}
+	for _, contract := range estimate.Contracts {
+		err := budget.CheckContract(contract, spent)
+		if err != nil {
+			return fmt.Errorf("Storage estimate exceeds budget: %v", err)
+		}
+		spent += contract.StorageFee
+	}
+	return nil
+}
+
+func (r *Renter) GetBudget() *core.Budget {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	budget := r.Config.Budget
+	return &budget
+}
+
+// SetBudget updates the renter's spending limits, both locally
+// and with the metaserver.
+func (r *Renter) SetBudget(budget *core.Budget) error {
+	if budget.MonthlyLimit < 0 || budget.MaxContractFee < 0 || budget.MaxStorageRate < 0 ||
+		budget.MaxDownloadRate < 0 {
+		return errors.New("Budget limits must not be negative.")
+	}
+	err := r.authorizeMeta()
+	if err != nil {
+		return err
+	}
+	err = r.metaClient.UpdateRenterBudget(r.Config.RenterId, budget)
+	if err != nil {
+		return fmt.Errorf("Unable to update metaserver. Error: %v", err)
+	}
+	r.mu.Lock()
+	r.Config.Budget = *budget
+	r.mu.Unlock()
+	err = util.SaveJson(path.Join(r.Homedir, "config.json"), r.Config)
+	if err != nil {
+		return fmt.Errorf("Unable to save config update. Error: %v", err)
+	}
+	return nil
+}
+
 func createStorageEstimate(totalSpace int64, config *Config,
 	providers []core.ProviderInfo,
 	dialFn pvdrDialFn) (*StorageEstimate, error) {
@@ -110,6 +328,8 @@ func createStorageEstimate(totalSpace int64, config *Config,
 	spaceLeft := make([]int64, len(providers))
 	visited := make([]bool, len(providers))
 	pvdrsLeft := len(providers)
+	// Whether any provider was ruled out only by the budget.
+	overBudget := false
 
 	for estimate.TotalSpace < totalSpace && pvdrsLeft > 0 {
 		space := totalSpace - estimate.TotalSpace
@@ -122,7 +342,8 @@ func createStorageEstimate(totalSpace int64, config *Config,
 				continue
 			}
 			pinfo := &providers[idx]
-			if pinfo.SpaceAvail < space {
+			if pinfo.SpaceAvail < space || !reputable(pinfo, config.MinProviderReputation) ||
+				!reliable(pinfo, config.MinProviderUptime) {
 				badPvdrs[idx] = true
 				pvdrsLeft--
 				continue
@@ -135,12 +356,16 @@ func createStorageEstimate(totalSpace int64, config *Config,
 				//   2) It ensures we have up-to-date information on the provider's space and fees
 				client := dialFn(pinfo)
 				var err error
+				reputation, liveness := pinfo.Reputation, pinfo.Liveness
 				pinfo, err = client.GetInfo()
 				if err != nil {
 					badPvdrs[idx] = true
 					pvdrsLeft--
 					continue
 				}
+				// Only the metaserver knows the provider's reputation and uptime.
+				pinfo.Reputation = reputation
+				pinfo.Liveness = liveness
 				spaceLeft[idx] = pinfo.SpaceAvail
 				providers[idx] = *pinfo
 				visited[idx] = true
@@ -150,8 +375,14 @@ func createStorageEstimate(totalSpace int64, config *Config,
 				pvdrsLeft--
 				continue
 			}
-			spaceLeft[idx] -= space
 			fee := calcStorageFee(space, int64(config.DefaultContractDurationDays), pinfo.StorageRate)
+			if !withinBudget(&config.Budget, pinfo, fee) {
+				badPvdrs[idx] = true
+				pvdrsLeft--
+				overBudget = true
+				continue
+			}
+			spaceLeft[idx] -= space
 			cid, err := util.GenerateID()
 			if err != nil {
 				return nil, err
@@ -164,6 +395,7 @@ func createStorageEstimate(totalSpace int64, config *Config,
 				ProviderId:   pinfo.ID,
 				StorageSpace: space,
 				StorageFee:   fee,
+				DownloadRate: pinfo.DownloadRate,
 			}
 			estimate.Contracts = append(estimate.Contracts, proposal)
 			estimate.Providers = append(estimate.Providers, pinfo)
@@ -172,12 +404,53 @@ func createStorageEstimate(totalSpace int64, config *Config,
 			break
 		}
 	}
+	if estimate.TotalSpace != totalSpace && overBudget {
+		return nil, errors.New("Unable to find enough storage space within budget.")
+	}
 	if estimate.TotalSpace != totalSpace {
 		return nil, errors.New("Unable to find enough storage space.")
 	}
 	return estimate, nil
 }
 
+// Returns a query for the providers the renter could form contracts
+// with, so the metaserver filters them instead of the renter.
+func providerQuery(config *Config) *metaserver.ProviderQuery {
+	return &metaserver.ProviderQuery{
+		MaxStorageRate: config.Budget.MaxStorageRate,
+		MinUptime:      config.MinProviderUptime,
+		MinReputation:  config.MinProviderReputation,
+		Region:         config.ProviderRegion,
+	}
+}
+
+// Returns whether a provider's audit reputation is at least minScore.
+// Providers that haven't been audited yet are given a chance.
+func reputable(pinfo *core.ProviderInfo, minScore float64) bool {
+	return pinfo.Reputation == nil || pinfo.Reputation.Score >= minScore
+}
+
+// Returns whether a provider's uptime is at least minUptime.
+// Providers the metaserver hasn't tracked yet are given a chance.
+func reliable(pinfo *core.ProviderInfo, minUptime float64) bool {
+	return pinfo.Liveness == nil || pinfo.Liveness.Uptime >= minUptime
+}
+
+// Checks a provider's rates and a contract fee against the budget's
+// per-contract limits. Monthly limits are checked separately.
+func withinBudget(budget *core.Budget, pinfo *core.ProviderInfo, fee int64) bool {
+	if budget.MaxStorageRate > 0 && pinfo.StorageRate > budget.MaxStorageRate {
+		return false
+	}
+	if budget.MaxDownloadRate > 0 && pinfo.DownloadRate > budget.MaxDownloadRate {
+		return false
+	}
+	if budget.MaxContractFee > 0 && fee > budget.MaxContractFee {
+		return false
+	}
+	return true
+}
+
 func calcStorageFee(spaceBytes, durationDays, rateGbMonth int64) int64 {
 	spaceGb := float64(spaceBytes) / float64(1e9)
 	durationMonths := float64(durationDays) / float64(30)
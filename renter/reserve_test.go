@@ -3,6 +3,7 @@ package renter
 import (
 	"errors"
 	"skybin/core"
+	"strings"
 	"testing"
 	"math/rand"
 )
@@ -100,6 +101,9 @@ func TestCreateStorageEstimate_NotEnoughStorage(t *testing.T) {
 	if err == nil {
 		t.Fatal("created storage estimate without enough storage")
 	}
+	if strings.Contains(err.Error(), "budget") {
+		t.Fatal("blamed the budget when no provider was over budget. error: ", err)
+	}
 }
 
 func createStorageFuzz(t *testing.T) {
@@ -146,3 +150,157 @@ func TestCalcStorageFee(t *testing.T) {
 	check(calcStorageFee(1e9, 15, 1), 1)
 	check(calcStorageFee(1e9, 45, 1), 2)
 }
+
+func TestCreateStorageEstimate_MaxStorageRate(t *testing.T) {
+	config := Config{
+		RenterId:                    "r1",
+		MaxContractSize:             1024,
+		DefaultContractDurationDays: 60,
+		Budget: core.Budget{
+			MaxStorageRate: 10,
+		},
+	}
+	providers := []core.ProviderInfo{
+		{
+			ID:          "cheap",
+			SpaceAvail:  1024,
+			StorageRate: 5,
+		},
+	}
+	for i := 0; i < 10; i++ {
+		providers = append(providers, core.ProviderInfo{
+			ID:          "expensive",
+			SpaceAvail:  1024 * 10,
+			StorageRate: 50,
+		})
+	}
+	estimate, err := createStorageEstimate(1024, &config, providers, testDialFn)
+	if err != nil {
+		t.Fatal("failed to reserve storage. error: ", err)
+	}
+	for _, pinfo := range estimate.Providers {
+		if pinfo.StorageRate > config.Budget.MaxStorageRate {
+			t.Fatal("estimate includes provider above max storage rate")
+		}
+	}
+	_, err = createStorageEstimate(2048, &config, providers, testDialFn)
+	if err == nil {
+		t.Fatal("created estimate using providers above max storage rate")
+	}
+}
+
+func TestCreateStorageEstimate_MaxDownloadRate(t *testing.T) {
+	config := Config{
+		RenterId:                    "r1",
+		MaxContractSize:             1024,
+		DefaultContractDurationDays: 60,
+		Budget: core.Budget{
+			MaxDownloadRate: 10,
+		},
+	}
+	providers := []core.ProviderInfo{
+		{
+			ID:           "cheap",
+			SpaceAvail:   1024,
+			StorageRate:  5,
+			DownloadRate: 10,
+		},
+		{
+			ID:           "expensive",
+			SpaceAvail:   1024 * 10,
+			StorageRate:  5,
+			DownloadRate: 1000,
+		},
+	}
+	estimate, err := createStorageEstimate(1024, &config, providers, testDialFn)
+	if err != nil {
+		t.Fatal("failed to reserve storage. error: ", err)
+	}
+	for _, contract := range estimate.Contracts {
+		if contract.DownloadRate > config.Budget.MaxDownloadRate {
+			t.Fatal("estimate includes contract above max download rate")
+		}
+	}
+	_, err = createStorageEstimate(2048, &config, providers, testDialFn)
+	if err == nil {
+		t.Fatal("created estimate using providers above max download rate")
+	}
+}
+
+func TestCreateStorageEstimate_MaxContractFee(t *testing.T) {
+	config := Config{
+		RenterId:                    "r1",
+		MaxContractSize:             1024,
+		DefaultContractDurationDays: 60,
+		Budget: core.Budget{
+			MaxContractFee: 10,
+		},
+	}
+	providers := []core.ProviderInfo{
+		{
+			SpaceAvail:  1024,
+			StorageRate: 20,
+		},
+	}
+	_, err := createStorageEstimate(1024, &config, providers, testDialFn)
+	if err == nil {
+		t.Fatal("created estimate with contract fee above limit")
+	}
+	if !strings.Contains(err.Error(), "budget") {
+		t.Fatal("didn't mention the budget when it ruled out every provider. error: ", err)
+	}
+}
+
+func TestCreateStorageEstimate_LowReputation(t *testing.T) {
+	config := Config{
+		RenterId:                    "r1",
+		MaxContractSize:             1024,
+		DefaultContractDurationDays: 60,
+		MinProviderReputation:       0.9,
+	}
+	providers := []core.ProviderInfo{
+		{ID: "bad", SpaceAvail: 1024, Reputation: &core.Reputation{Score: 0.5, Audits: 10, Failures: 5}},
+		{ID: "good", SpaceAvail: 1024, Reputation: &core.Reputation{Score: 0.95, Audits: 10}},
+		{ID: "new", SpaceAvail: 1024},
+	}
+	estimate, err := createStorageEstimate(2048, &config, providers, testDialFn)
+	if err != nil {
+		t.Fatal("failed to reserve storage. error: ", err)
+	}
+	for _, pinfo := range estimate.Providers {
+		if pinfo.ID == "bad" {
+			t.Fatal("formed contract with provider below minimum reputation")
+		}
+	}
+	_, err = createStorageEstimate(3072, &config, providers, testDialFn)
+	if err == nil {
+		t.Fatal("reserved space from provider below minimum reputation")
+	}
+}
+
+func TestCreateStorageEstimate_LowUptime(t *testing.T) {
+	config := Config{
+		RenterId:                    "r1",
+		MaxContractSize:             1024,
+		DefaultContractDurationDays: 60,
+		MinProviderUptime:           0.9,
+	}
+	providers := []core.ProviderInfo{
+		{ID: "flaky", SpaceAvail: 1024, Liveness: &core.Liveness{Online: true, Uptime: 0.5}},
+		{ID: "steady", SpaceAvail: 1024, Liveness: &core.Liveness{Online: true, Uptime: 0.99}},
+	}
+	estimate, err := createStorageEstimate(1024, &config, providers, testDialFn)
+	if err != nil {
+		t.Fatal("failed to reserve storage. error: ", err)
+	}
+	if estimate.Providers[0].ID != "steady" {
+		t.Fatal("formed contract with provider below minimum uptime")
+	}
+	if estimate.Providers[0].Liveness == nil {
+		t.Fatal("provider's uptime lost when refreshing its info")
+	}
+	_, err = createStorageEstimate(2048, &config, providers, testDialFn)
+	if err == nil {
+		t.Fatal("reserved space from provider below minimum uptime")
+	}
+}
@@ -144,11 +144,12 @@ func (r *Renter) restoreBlockBatch(batch *recoveredBlockBatch) {
 				failures = append(failures, idx)
 				continue
 			}
-			err = client.PutBlock(r.Config.RenterId, badBlock.block.ID, contents, blockSize)
+			err = client.PutBlock(r.Config.RenterId, blob.ContractId, badBlock.block.ID, contents, blockSize)
 			if err != nil {
 				failures = append(failures, idx)
 				continue
 			}
+			blockBytes.WithLabelValues("out").Add(float64(blockSize))
 			oldBlock := badBlock.block
 			newBlock := oldBlock
 			newBlock.Location = core.BlockLocation{
@@ -156,12 +157,13 @@ func (r *Renter) restoreBlockBatch(batch *recoveredBlockBatch) {
 				Addr: blob.Addr,
 				ContractId: blob.ContractId,
 			}
+			newBlock.AuditPassed = true
 			newVersion.Blocks[newBlock.Num] = newBlock
 			r.logger.Printf("block recovery thread: restored block %s for file %s\n",
 				badBlock.block.ID, batch.file.Name)
 		}
 		stillBadBlocks := []*recoveredBlock{}
-		for idx := range failures {
+		for _, idx := range failures {
 			stillBadBlocks = append(stillBadBlocks, badBlocks[idx])
 			blobsToReturn = append(blobsToReturn, blobs[idx])
 			badProviders[blobs[idx].ProviderId] = true
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"skybin/core"
 	"skybin/metaserver"
+	"skybin/metrics"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -27,10 +28,12 @@ func NewServer(renter *Renter, logger *log.Logger) http.Handler {
 	router.HandleFunc("/confirm-storage-estimate", server.confirmStorageEstimate).Methods("POST")
 	router.HandleFunc("/reserve-storage", server.reserveStorage).Methods("POST")
 	router.HandleFunc("/contracts", server.getContracts).Methods("GET")
+	router.HandleFunc("/contracts/cancel", server.cancelContract).Methods("POST")
 	router.HandleFunc("/files/get-metadata", server.getFileMetadata).Methods("POST")
 	router.HandleFunc("/files", server.getFiles).Methods("GET")
 	router.HandleFunc("/files/shared", server.getSharedFiles).Methods("GET")
 	router.HandleFunc("/files/upload", server.uploadFile).Methods("POST")
+	router.HandleFunc("/files/upload/preview", server.previewUpload).Methods("POST")
 	router.HandleFunc("/files/download", server.downloadFile).Methods("POST")
 	router.HandleFunc("/files/create-folder", server.createFolder).Methods("POST")
 	router.HandleFunc("/files/share", server.shareFile).Methods("POST")
@@ -42,6 +45,10 @@ func NewServer(renter *Renter, logger *log.Logger) http.Handler {
 	router.HandleFunc("/paypal/execute", server.executePaypalPayment).Methods("POST")
 	router.HandleFunc("/paypal/withdraw", server.withdraw).Methods("POST")
 	router.HandleFunc("/transactions", server.getTransactions).Methods("GET")
+	router.HandleFunc("/budget", server.getBudget).Methods("GET")
+	router.HandleFunc("/budget", server.putBudget).Methods("PUT")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	metrics.Instrument("renter", router)
 
 	return server
 }
@@ -166,6 +173,32 @@ func (server *renterServer) getContracts(w http.ResponseWriter, r *http.Request)
 	server.writeResp(w, http.StatusOK, &resp)
 }
 
+type cancelContractReq struct {
+	ContractId string `json:"contractId"`
+}
+
+type cancelContractResp struct {
+	Refund int64 `json:"refund"`
+}
+
+func (server *renterServer) cancelContract(w http.ResponseWriter, r *http.Request) {
+	var req cancelContractReq
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{Error: fmt.Sprintf("Unable to decode JSON. Error: %v", err)})
+		return
+	}
+	refund, err := server.renter.CancelContract(req.ContractId)
+	if err != nil {
+		server.logger.Println(err)
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{Error: fmt.Sprintf("Unable to cancel contract. Error: %v", err)})
+		return
+	}
+	server.writeResp(w, http.StatusOK, &cancelContractResp{Refund: refund})
+}
+
 type getFileReq struct {
 	FileId string `json:"fileId"`
 }
@@ -191,26 +224,51 @@ func (server *renterServer) getFileMetadata(w http.ResponseWriter, r *http.Reque
 
 type getFilesResp struct {
 	Files []*core.File `json:"files"`
+	// Cursor for the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
+// Lists the renter's files. Requests with the metaserver's file query
+// parameters (prefix, fields, limit, cursor) are passed on to it, and
+// others are answered from the local cache.
 func (server *renterServer) getFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := server.renter.ListFiles()
+	if len(r.URL.Query()) == 0 {
+		files, err := server.renter.ListFiles()
+		if err != nil {
+			server.writeResp(w, http.StatusInternalServerError,
+				&errorResp{Error: err.Error()})
+			return
+		}
+		server.writeResp(w, http.StatusOK, &getFilesResp{Files: files})
+		return
+	}
+	query, err := metaserver.ParseFileQuery(r.URL.Query())
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest, &errorResp{Error: err.Error()})
+		return
+	}
+	files, next, err := server.renter.ListFilesPage(query)
 	if err != nil {
 		server.writeResp(w, http.StatusInternalServerError,
 			&errorResp{Error: err.Error()})
 		return
 	}
-	server.writeResp(w, http.StatusOK, &getFilesResp{Files: files})
+	server.writeResp(w, http.StatusOK, &getFilesResp{Files: files, NextCursor: next})
 }
 
 func (server *renterServer) getSharedFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := server.renter.ListSharedFiles()
+	query, err := metaserver.ParseFileQuery(r.URL.Query())
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest, &errorResp{Error: err.Error()})
+		return
+	}
+	files, next, err := server.renter.ListSharedFiles(query)
 	if err != nil {
 		server.writeResp(w, http.StatusInternalServerError,
 			&errorResp{Error: err.Error()})
 		return
 	}
-	server.writeResp(w, http.StatusOK, &getFilesResp{Files: files})
+	server.writeResp(w, http.StatusOK, &getFilesResp{Files: files, NextCursor: next})
 }
 
 type uploadFileReq struct {
@@ -240,6 +298,27 @@ func (server *renterServer) uploadFile(w http.ResponseWriter, r *http.Request) {
 	server.writeResp(w, http.StatusCreated, f)
 }
 
+type previewUploadReq struct {
+	SourcePath string `json:"sourcePath"`
+}
+
+func (server *renterServer) previewUpload(w http.ResponseWriter, r *http.Request) {
+	var req previewUploadReq
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{Error: fmt.Sprintf("Unable to decode JSON. Error: %v", err)})
+		return
+	}
+	preview, err := server.renter.PreviewUpload(req.SourcePath)
+	if err != nil {
+		server.logger.Println(err)
+		server.writeResp(w, http.StatusBadRequest, &errorResp{Error: err.Error()})
+		return
+	}
+	server.writeResp(w, http.StatusOK, preview)
+}
+
 type downloadFileReq struct {
 	FileId     string `json:"fileId"`
 	DestPath   string `json:"destPath"`
@@ -489,16 +568,44 @@ func (server *renterServer) withdraw(w http.ResponseWriter, r *http.Request) {
 
 type getTransactionsResp struct {
 	Transactions []core.Transaction `json:"transactions"`
+	// Cursor for the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 func (server *renterServer) getTransactions(w http.ResponseWriter, r *http.Request) {
-	transactions, err := server.renter.ListTransactions()
+	query, err := metaserver.ParseTransactionQuery(r.URL.Query())
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest, &errorResp{Error: err.Error()})
+		return
+	}
+	transactions, next, err := server.renter.ListTransactions(query)
 	if err != nil {
 		server.writeResp(w, http.StatusInternalServerError,
 			&errorResp{Error: err.Error()})
 		return
 	}
-	server.writeResp(w, http.StatusOK, &getTransactionsResp{transactions})
+	server.writeResp(w, http.StatusOK, &getTransactionsResp{transactions, next})
+}
+
+func (server *renterServer) getBudget(w http.ResponseWriter, r *http.Request) {
+	server.writeResp(w, http.StatusOK, server.renter.GetBudget())
+}
+
+func (server *renterServer) putBudget(w http.ResponseWriter, r *http.Request) {
+	var budget core.Budget
+	err := json.NewDecoder(r.Body).Decode(&budget)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{Error: fmt.Sprintf("Unable to decode JSON. Error: %v", err)})
+		return
+	}
+	err = server.renter.SetBudget(&budget)
+	if err != nil {
+		server.writeResp(w, http.StatusBadRequest,
+			&errorResp{Error: err.Error()})
+		return
+	}
+	server.writeResp(w, http.StatusOK, server.renter.GetBudget())
 }
 
 func (server *renterServer) writeResp(w http.ResponseWriter, status int, body interface{}) {
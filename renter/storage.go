@@ -95,6 +95,24 @@ func (sm *storageManager) FindStorageExclude(nblobs int, blobSize int64, provide
 	return blobs, err
 }
 
+// Removes a contract's storage from the freelist so it can be cancelled.
+// Fails unless all amount bytes of the contract's storage are free.
+func (sm *storageManager) ReleaseContract(contractId string, amount int64) (*storageBlob, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for idx, blob := range sm.freelist {
+		if blob.ContractId != contractId {
+			continue
+		}
+		if blob.Amount < amount {
+			return nil, errors.New("Contract is still storing blocks.")
+		}
+		sm.freelist = append(sm.freelist[:idx], sm.freelist[idx+1:]...)
+		return blob, nil
+	}
+	return nil, errors.New("Contract is still storing blocks.")
+}
+
 func (sm *storageManager) addBlob(blob *storageBlob) {
 	for _, existingBlob := range sm.freelist {
 		if existingBlob.ContractId == blob.ContractId {
@@ -258,3 +258,25 @@ func TestExcludeProviders(t *testing.T) {
 	}
 }
 
+
+func TestReleaseContract(t *testing.T) {
+	sm := newStorageManager([]*storageBlob{}, noOpUpdateFn, time.Minute, &realClock{})
+	sm.AddBlobs([]*storageBlob{
+		{ProviderId: "p1", Amount: 100, ContractId: "c1"},
+		{ProviderId: "p2", Amount: 50, ContractId: "c2"},
+	})
+	_, err := sm.ReleaseContract("c2", 100)
+	if err == nil {
+		t.Fatal("released contract which is still storing blocks")
+	}
+	blob, err := sm.ReleaseContract("c1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.ContractId != "c1" || blob.Amount != 100 {
+		t.Fatal("released wrong blob")
+	}
+	if sm.AvailableStorage() != 50 {
+		t.Fatal("released contract's storage should be removed from the freelist")
+	}
+}
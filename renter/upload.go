@@ -17,10 +17,10 @@ import (
 	"os"
 	"path/filepath"
 	"skybin/core"
+	"skybin/metrics"
 	"skybin/provider"
 	"skybin/util"
 	"time"
-	"hash"
 )
 
 // fileUpload stores the state for an upload as it passes through
@@ -125,6 +125,12 @@ func (r *Renter) Upload(sourcePath string, destPath string, shouldOverwrite bool
 		return nil, errors.New("Not enough storage")
 	}
 	if existingFile != nil {
+		// Overwriting removes the latest version's blocks,
+		// which the cached file doesn't have.
+		existingFile, err = r.GetFile(existingFile.ID)
+		if err != nil {
+			return nil, err
+		}
 		return r.uploadVersion(sourcePath, finfo, existingFile, shouldOverwrite)
 	}
 	return r.uploadFile(sourcePath, finfo, destPath)
@@ -182,14 +188,22 @@ func (r *Renter) uploadVersion(sourcePath string, finfo os.FileInfo,
 	if err != nil {
 		r.logger.Println("Unable to pull updated version of file. Error: %s", err)
 		existingFile.Versions = append(existingFile.Versions, *newVersion)
+		r.cacheFile(existingFile)
 		return existingFile, nil
 	}
 	*existingFile = *updatedFile
+
+	// Users the file is shared with need to be able to read the new version.
+	_, err = r.refreshReadCapabilities(existingFile)
+	if err != nil {
+		r.logger.Println("Unable to refresh read capabilities. Error:", err)
+	}
+	r.cacheFile(existingFile)
 	err = r.saveSnapshot()
 	if err != nil {
 		r.logger.Println("Error saving snapshot:", err)
 	}
-	return updatedFile, nil
+	return existingFile, nil
 }
 
 // Uploads a new file from sourcePath to destPath. File size and destPath validation
@@ -344,15 +358,18 @@ func (r *Renter) doUploadDir(fileUploads []*fileUpload, folderUploads []folderUp
 
 func (r *Renter) doUploads(uploads []*fileUpload) error {
 	for _, upload := range uploads {
+		uploadQueueDepth.Inc()
 		r.uploadQ <- upload
 	}
 	for _, upload := range uploads {
 		<-upload.doneCh
+		uploadQueueDepth.Dec()
 	}
 	failedUploads := []*fileUpload{}
 	successfulUploads := []*fileUpload{}
 	for _, upload := range uploads {
 		if upload.err != nil {
+			metrics.Errors.WithLabelValues("renter_upload").Inc()
 			r.logger.Printf("Error performing upload to destpath %s: %s\n",
 				upload.destPath, upload.err)
 			failedUploads = append(failedUploads, upload)
@@ -564,9 +581,12 @@ func (r *Renter) blockUploadThread(providerAddr string, blockQ chan *blockUpload
 			close(upload.doneCh)
 			continue
 		}
-		err := client.PutBlock(r.Config.RenterId, upload.block.ID, upload.reader(), upload.size)
+		err := client.PutBlock(r.Config.RenterId, upload.block.Location.ContractId, upload.block.ID,
+			upload.reader(), upload.size)
 		if err != nil {
 			upload.err = err
+		} else {
+			blockBytes.WithLabelValues("out").Add(float64(upload.size))
 		}
 		close(upload.doneCh)
 	}
@@ -633,7 +653,7 @@ func prepareUpload(up *fileUpload, conf *Config) error {
 		return err
 	}
 
-	err = prepareMetadata(up, conf.NumBlockAudits)
+	err = prepareMetadata(up)
 	if err != nil {
 		return err
 	}
@@ -719,27 +739,15 @@ func erasureCode(up *fileUpload, conf *Config) error {
 	if err != nil {
 		return fmt.Errorf("Unable to stat temp file. Error: %s", err)
 	}
-	blockSize := (st.Size() + int64(conf.DefaultDataBlocks) - 1) / int64(conf.DefaultDataBlocks)
-	if blockSize > conf.MaxBlockSize {
-		blockSize = conf.MaxBlockSize
-	}
-	if blockSize > conf.MaxContractSize {
-		blockSize = conf.MaxContractSize
-	}
+	blockSize, nDataBlocks, nParityBlocks, paddingBytes := erasureLayout(st.Size(), conf)
 	// Pad file up to next nearest block size multiple if necessary.
 	// Its size must be a block size multiple.
-	paddingBytes := (blockSize - (st.Size() % blockSize)) % blockSize
 	if paddingBytes != 0 {
 		err := up.eTemp.Truncate(st.Size() + paddingBytes)
 		if err != nil {
 			return fmt.Errorf("Unable to pad file. Error: %s", err)
 		}
 	}
-	nDataBlocks := int((st.Size() + blockSize - 1) / blockSize)
-	nParityBlocks := conf.DefaultParityBlocks
-	if nDataBlocks > conf.DefaultDataBlocks {
-		nParityBlocks = (nDataBlocks + 1) / 2
-	}
 	encoder, err := reedsolomon.NewStream(nDataBlocks, nParityBlocks)
 	if err != nil {
 		return fmt.Errorf("Unable to create erasure encoder. Error: %s", err)
@@ -771,8 +779,27 @@ func erasureCode(up *fileUpload, conf *Config) error {
 	return nil
 }
 
+// Returns the block size, number of data and parity blocks, and padding
+// used to erasure code an encrypted file of the given size.
+func erasureLayout(size int64, conf *Config) (blockSize int64, nDataBlocks, nParityBlocks int, paddingBytes int64) {
+	blockSize = (size + int64(conf.DefaultDataBlocks) - 1) / int64(conf.DefaultDataBlocks)
+	if blockSize > conf.MaxBlockSize {
+		blockSize = conf.MaxBlockSize
+	}
+	if blockSize > conf.MaxContractSize {
+		blockSize = conf.MaxContractSize
+	}
+	paddingBytes = (blockSize - (size % blockSize)) % blockSize
+	nDataBlocks = int((size + blockSize - 1) / blockSize)
+	nParityBlocks = conf.DefaultParityBlocks
+	if nDataBlocks > conf.DefaultDataBlocks {
+		nParityBlocks = (nDataBlocks + 1) / 2
+	}
+	return blockSize, nDataBlocks, nParityBlocks, paddingBytes
+}
+
 // Preparation phase 4: create block and version metadata.
-func prepareMetadata(up *fileUpload, numAudits int) error {
+func prepareMetadata(up *fileUpload) error {
 	blockReaders := []io.Reader{}
 	for blockNum := 0; blockNum < up.numDataBlocks; blockNum++ {
 		blockReaders = append(blockReaders, io.NewSectionReader(up.eTemp, up.blockSize*int64(blockNum), up.blockSize))
@@ -788,43 +815,21 @@ func prepareMetadata(up *fileUpload, numAudits int) error {
 		if err != nil {
 			return fmt.Errorf("Unable to create block ID. Error: %s", err)
 		}
-		auditHashes := []hash.Hash{}
-		audits := []core.BlockAudit{}
-		for i := 0; i < numAudits; i++ {
-			nonceBytes, err := util.GenerateAuditNonce()
-			if err != nil {
-				return err
-			}
-			audit := core.BlockAudit{
-				Nonce: base64.URLEncoding.EncodeToString(nonceBytes),
-			}
-			h := sha256.New()
-			h.Write(nonceBytes)
-			auditHashes = append(auditHashes, h)
-			audits = append(audits, audit)
-		}
 		blockHash := sha256.New()
-		writers := []io.Writer{blockHash}
-		for _, h := range auditHashes {
-			writers = append(writers, h)
-		}
+		merkleHash := core.NewMerkleHasher()
+
 		// Generate the hashes from the block
-		n, err := io.Copy(io.MultiWriter(writers...), blockReader)
+		n, err := io.Copy(io.MultiWriter(blockHash, merkleHash), blockReader)
 		if err != nil {
 			return fmt.Errorf("Unable to calculate block hash. Error: %s", err)
 		}
-		for idx, auditHash := range auditHashes {
-			audit := &audits[idx]
-			auditHashBytes := auditHash.Sum(nil)
-			audit.ExpectedHash = base64.URLEncoding.EncodeToString(auditHashBytes)
-		}
 		blockHashStr := base64.URLEncoding.EncodeToString(blockHash.Sum(nil))
 		block := core.Block{
-			ID:         blockId,
-			Num:        blockNum,
-			Size:       n,
-			Sha256Hash: blockHashStr,
-			Audits:     audits,
+			ID:          blockId,
+			Num:         blockNum,
+			Size:        n,
+			Sha256Hash:  blockHashStr,
+			MerkleRoot:  merkleHash.Root(),
 			AuditPassed: true,
 		}
 		blocks = append(blocks, block)